@@ -1,284 +1,15829 @@
+// Personal finance tracker. The other files in this directory are separate programs, so build this one on
+// its own with Go 1.25 or later:
+//
+//	go build -o finance transaction.go
+//
+// serve routes by method and path patterns, which builds without a module would turn off.
+
+//go:debug httpmuxgo121=0
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"embed"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 type Transaction struct {
-	Date        time.Time
-	Type        string
-	Category    string
-	Amount      float64
-	Description string
+	ID           int               `json:"id"`
+	Date         time.Time         `json:"date"`
+	Type         string            `json:"type"`
+	Category     string            `json:"category"`
+	Amount       float64           `json:"amount"`
+	Description  string            `json:"description"`
+	Account      string            `json:"account,omitempty"`
+	Payee        string            `json:"payee,omitempty"`
+	Splits       []Split           `json:"splits,omitempty"`        // line items, their amounts add up to Amount
+	Attachments  []string          `json:"attachments,omitempty"`   // receipts, relative to the data file's directory
+	ExternalID   string            `json:"external_id,omitempty"`   // the bank's ID of a synced transaction
+	Modified     time.Time         `json:"modified,omitzero"`       // last change, settles sync conflicts
+	Status       string            `json:"status,omitempty"`        // Pending until reviewed, Cleared or Reconciled against a bank statement
+	Loan         string            `json:"loan,omitempty"`          // the loan a payment goes to
+	Deductible   *bool             `json:"deductible,omitempty"`    // overrides the tax treatment of its categories when set
+	Shares       []Share           `json:"shares,omitempty"`        // people an expense is shared with
+	PaidBy       string            `json:"paid_by,omitempty"`       // who paid a shared expense, the book's owner when empty
+	Reimbursable string            `json:"reimbursable,omitempty"`  // who pays the expense back, e.g. the employer
+	ReimbursedBy int               `json:"reimbursed_by,omitempty"` // ID of the income that paid it back
+	Notes        string            `json:"notes,omitempty"`         // free-form, may span several lines
+	Metadata     map[string]string `json:"metadata,omitempty"`      // e.g. invoice number, trip or warranty expiry
+	Deleted      time.Time         `json:"deleted,omitzero"`        // when it was moved to the trash
+	Batch        int               `json:"batch,omitempty"`         // the import that brought it in, see Data.Batches
+	Tags         []string          `json:"tags,omitempty"`          // labels like work or trip, entered as #work
+	Currency     string            `json:"currency,omitempty"`      // the currency of Amount when it is not the book's, e.g. EUR abroad
+}
+
+// part of a transaction booked to its own category
+type Split struct {
+	Category    string  `json:"category"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description,omitempty"`
+}
+
+const splitCategory = "Split"
+
+// category totals of a transaction, a split transaction counts towards each line's category
+func (t Transaction) categoryAmounts() []Split {
+	if len(t.Splits) == 0 {
+		return []Split{{Category: t.Category, Amount: t.Amount}}
+	}
+	return t.Splits
 }
 
 type Data struct {
-	Transactions []Transaction
+	Version      int                  `json:"version"` // schema of the file, see schemaMigrations
+	Transactions []Transaction        `json:"transactions"`
+	Trash        []Transaction        `json:"trash,omitempty"`       // deleted transactions, left out of everything until restored or purged
+	Batches      []ImportBatch        `json:"batches,omitempty"`     // imports, each can be rolled back as a whole
+	Budgets      map[string]float64   `json:"budgets,omitempty"`     // monthly budget per category
+	Rollover     map[string]string    `json:"rollover,omitempty"`    // categories whose leftover budget carries over, from this YYYY-MM on
+	Allocations  []Allocation         `json:"allocations,omitempty"` // income put into category envelopes
+	Loans        []Loan               `json:"loans,omitempty"`
+	Recurring    []Recurring          `json:"recurring,omitempty"` // scheduled bills and income
+	Holdings     []Holding            `json:"holdings,omitempty"`
+	Prices       map[string][]Quote   `json:"prices,omitempty"`   // price history per symbol, oldest first
+	CPI          []Quote              `json:"cpi,omitempty"`      // consumer price index by month, oldest first
+	Currency     string               `json:"currency,omitempty"` // base currency of the book
+	Migrations   []CurrencyMigration  `json:"migrations,omitempty"`
+	Settings     Settings             `json:"settings"`
+	Notes        map[string]string    `json:"notes,omitempty"`              // guidance attached to categories
+	Deductible   []string             `json:"deductible,omitempty"`         // tax-deductible expense categories, sorted
+	Allowed      []string             `json:"allowed_categories,omitempty"` // when set, the only categories new transactions may use
+	Settlements  []Settlement         `json:"settlements,omitempty"`        // money passed between people sharing expenses
+	Accounts     []Account            `json:"accounts,omitempty"`
+	Unarchived   map[string]time.Time `json:"unarchived,omitempty"` // when a category was brought back, counts as a use
+	Alerts       []AlertRule          `json:"alerts,omitempty"`
+	Webhooks     []Webhook            `json:"webhooks,omitempty"`
+	Rules        []CategoryRule       `json:"rules,omitempty"`
+	Banks        []BankConnection     `json:"banks,omitempty"`
+	Texts        []TextTemplate       `json:"text_templates,omitempty"` // formats of bank alert texts, see parseNotification
+	Conflicts    []SyncConflict       `json:"conflicts,omitempty"`      // versions set aside by sync, kept for review
+
+	clock    Clock         // where the book reads the time, the system clock when nil
+	asOf     time.Time     // the day a historical view stands on, zero for the live book
+	readOnly bool          // set for views that must never be written back, like consolidated books
+	lockedBy error         // why a book opened read-only may not be saved, e.g. another process holds its lock
+	books    []string      // data files merged into a consolidated view
+	lastID   int           // highest transaction ID in use
+	saved    []Transaction // transactions as last read from or written to disk, webhooks fire for the difference
+
+	classifier *Classifier   // trained on first use, learns from every transaction added after
+	byDate     *dateIndex    // built on first use, kept up to date by appendTransaction and dropped by other changes
+	totals     monthlyTotals // built on first use, kept up to date as transactions are added and trashed
+	totalsAt   int           // the generation the totals were counted at
+	generation int           // counts changes made to transactions in place, see edited
+
+	upgradedFrom *int // schema version of a file loaded in an older schema, its original is kept on the first save
+}
+
+// user preferences stored alongside the book
+type Settings struct {
+	FiscalYearStart time.Month    `json:"fiscal_year_start,omitempty"` // first month of the fiscal year, January when unset
+	Decimals        *int          `json:"decimals,omitempty"`          // decimal places in reports, 2 when unset
+	Compact         bool          `json:"compact,omitempty"`           // show large amounts as 12.4k or 1.2M
+	Locale          string        `json:"locale,omitempty"`
+	ArchiveAfter    int           `json:"archive_after,omitempty"` // months without use before a category is hidden, 0 never
+	Timezone        string        `json:"timezone,omitempty"`      // IANA zone that decides what "today" is, the system zone when unset
+	WeekStart       time.Weekday  `json:"week_start,omitempty"`    // first day of weekly periods, Sunday when unset
+	Notify          Notifications `json:"notify,omitzero"`
+	BackupKeep      int           `json:"backup_keep,omitempty"` // backups kept by rotation, 10 when unset
+	BackupGzip      bool          `json:"backup_gzip,omitempty"`
+	GitHistory      bool          `json:"git_history,omitempty"`     // commit the data file on every save
+	QuoteURL        string        `json:"quote_url,omitempty"`       // price lookup, {symbol} is replaced by the symbol
+	MileageRate     float64       `json:"mileage_rate,omitempty"`    // paid back per mile or kilometre driven
+	AutoCategorize  float64       `json:"auto_categorize,omitempty"` // confidence from which the classifier files uncategorized imports, 0 never
+}
+
+// where alerts and reports are delivered, either or both may be set
+type Notifications struct {
+	Webhook  string `json:"webhook,omitempty"`   // URL that receives a JSON POST
+	SMTPHost string `json:"smtp_host,omitempty"` // host:port of the mail server
+	SMTPUser string `json:"smtp_user,omitempty"` // the password is read from FINANCE_SMTP_PASSWORD
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+}
+
+type Account struct {
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"` // Asset, Liability or Investment
+	OpeningBalance float64   `json:"opening_balance"`
+	OpeningDate    time.Time `json:"opening_date"`
 }
+
+// audit record of a whole-book currency conversion
+type CurrencyMigration struct {
+	At          time.Time          `json:"at"`
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	RatesFile   string             `json:"rates_file"`
+	Conversions []AmountConversion `json:"conversions"`
+}
+
+type AmountConversion struct {
+	Field     string    `json:"field"`          // what the amount is, e.g. transaction, split, budget or recurring
+	ID        int       `json:"id,omitempty"`   // the transaction of transaction, trash, split and conflict amounts
+	Name      string    `json:"name,omitempty"` // the category, account, loan, symbol or bill it belongs to
+	Date      time.Time `json:"date"`           // of the rate used
+	OldAmount float64   `json:"old_amount"`
+	Rate      float64   `json:"rate"`
+	NewAmount float64   `json:"new_amount"`
+}
+
+type exchangeRate struct {
+	Date time.Time
+	Rate float64
+}
+
 const (
 	Income  = "Income"
 	Expense = "Expense"
+	Week    = "week"
 	Month   = "month"
 	Year    = "year"
 	All     = "all"
+	Fiscal  = "fiscal"
+
+	Asset      = "Asset"
+	Liability  = "Liability"
+	Investment = "Investment" // an asset valued by its holdings at market prices
+
+	Pending    = "pending" // came in from a bank, mail or text and is not confirmed yet, see review
+	Cleared    = "cleared"
+	Reconciled = "reconciled"
 )
-func parseDate(dateStr string) (time.Time, error) {
-	return time.Parse("2006-01-02", dateStr)
+
+// values of --status, posted is everything but pending and uncleared what is neither pending, cleared nor reconciled
+var statusFilters = []string{Pending, "posted", "uncleared", Cleared, Reconciled}
+
+const defaultDataFile = "finance.json"
+
+// the data file chosen during setup is remembered in the user's config directory
+func locationFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "finance", "datafile"), nil
 }
-func parseFloat(amountStr string) (float64, error) {
-	amount, err := strconv.ParseFloat(amountStr, 64)
+
+func rememberedDataFile() string {
+	filename, err := locationFile()
+	if err != nil {
+		return defaultDataFile
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil || strings.TrimSpace(string(content)) == "" {
+		return defaultDataFile
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// whether a book can be created in dir, which is made when missing
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create %s: %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".finance-probe-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func rememberDataFile(dataFile string) error {
+	filename, err := locationFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, []byte(dataFile+"\n"), 0o644)
+}
+
+// upgrade steps of the data file; step i turns a version i file into version i+1, so the schema version
+// is len(schemaMigrations). Steps work on the decoded JSON so they can rename and reshape fields.
+var schemaMigrations = []struct {
+	description string
+	migrate     func(book map[string]any) error
+}{
+	{"number transactions, files before version 1 have no IDs", func(book map[string]any) error {
+		transactions, _ := book["transactions"].([]any)
+		lastID := 0
+		for _, transaction := range transactions {
+			fields, _ := transaction.(map[string]any)
+			if number, ok := fields["id"].(json.Number); ok {
+				if id, err := number.Int64(); err == nil {
+					lastID = max(lastID, int(id))
+				}
+			}
+		}
+		for _, transaction := range transactions {
+			if fields, ok := transaction.(map[string]any); ok && fields["id"] == nil {
+				lastID++
+				fields["id"] = lastID
+			}
+		}
+		return nil
+	}},
+}
+
+func schemaVersion() int {
+	return len(schemaMigrations)
+}
+
+// bring a data file up to the current schema, files from a newer version are refused rather than
+// loaded with their unknown fields dropped and saved back without them
+func migrateBook(content []byte) ([]byte, int, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return nil, 0, err
+	}
+	if header.Version > schemaVersion() {
+		return nil, 0, fmt.Errorf("schema version %d is newer than the %d this program knows, upgrade the program before opening the file", header.Version, schemaVersion())
+	}
+	if header.Version == schemaVersion() {
+		return content, header.Version, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber() // amounts pass through exactly as written
+	var book map[string]any
+	if err := decoder.Decode(&book); err != nil {
+		return nil, 0, err
+	}
+	for version := header.Version; version < schemaVersion(); version++ {
+		if err := schemaMigrations[version].migrate(book); err != nil {
+			return nil, 0, fmt.Errorf("failed to upgrade from version %d (%s): %w", version, schemaMigrations[version].description, err)
+		}
+	}
+	book["version"] = schemaVersion()
+	migrated, err := json.Marshal(book)
+	return migrated, header.Version, err
+}
+
+// copy a data file about to be overwritten in a newer schema to <file>.v<version>, once
+func keepOriginal(filename string, version int) error {
+	original := fmt.Sprintf("%s.v%d", filename, version)
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		if _, statErr := os.Stat(original); statErr == nil {
+			return nil
+		}
+		err = os.WriteFile(original, content, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to keep a copy of the data file before upgrading it: %w", err)
+	}
+	fmt.Printf("Upgraded %s from schema version %d to %d, the original is kept as %s.\n", filename, version, schemaVersion(), original)
+	return nil
+}
+
+// load the data file, an empty book is returned when it does not exist yet
+func loadData(filename string) (*Data, error) {
+	d := &Data{}
+	content, err := openStorage(filename).Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil && !errors.Is(err, ErrCorrupt) {
+		return nil, fmt.Errorf("failed to read data file: %w", err)
+	}
+	var version int
+	if err == nil {
+		version, err = d.parse(content)
+	}
+	if errors.Is(err, ErrCorrupt) && !isRemote(filename) {
+		version, err = d.loadBackup(filename, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version < schemaVersion() {
+		d.upgradedFrom = &version
+	}
+	d.normalizeDates()
+	d.assignIDs()
+	d.saved = slices.Clone(d.Transactions)
+	return d, nil
+}
+
+// decode a stored book in any schema version into d, returns the version it was stored in
+func (d *Data) parse(content []byte) (int, error) {
+	*d = Data{}
+	if !json.Valid(content) {
+		return 0, fmt.Errorf("%w: it is not valid JSON, it may have been cut short", ErrCorrupt)
+	}
+	migrated, version, err := migrateBook(content)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load data file: %w", err)
+	}
+	if err := json.Unmarshal(migrated, d); err != nil {
+		return 0, fmt.Errorf("failed to parse data file: %w", err)
+	}
+	return version, nil
+}
+
+// load the newest backup of a damaged book that loads instead, the damaged file is copied aside for a closer look
+func (d *Data) loadBackup(filename string, damage error) (int, error) {
+	backups, _ := listBackups(filename)
+	for _, backup := range slices.Backward(backups) {
+		content, err := readBackup(backup)
+		if err != nil {
+			continue
+		}
+		version, err := d.parse(content)
+		if err != nil {
+			continue
+		}
+		damaged := filename + ".damaged"
+		if raw, err := os.ReadFile(filename); err == nil {
+			os.WriteFile(damaged, raw, 0o644)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\nLoaded the backup %s instead, the next save replaces the damaged file; a copy of it is kept as %s.\n",
+			damage, filepath.Base(backup), damaged)
+		return version, nil
+	}
+	return 0, fmt.Errorf("%w, and no backup could be loaded instead", damage)
+}
+
+// transaction dates are calendar days kept at UTC midnight, so they compare the same in every time zone
+func civilDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// books written by hand or by other tools may carry offsets, keep only the calendar day they name
+func (d *Data) normalizeDates() {
+	for i := range d.Transactions {
+		d.Transactions[i].Date = civilDate(d.Transactions[i].Date)
+	}
+	for i := range d.Accounts {
+		if !d.Accounts[i].OpeningDate.IsZero() {
+			d.Accounts[i].OpeningDate = civilDate(d.Accounts[i].OpeningDate)
+		}
+	}
+}
+
+// give transactions stored before IDs existed one, keeping the IDs already handed out
+func (d *Data) assignIDs() {
+	for _, transaction := range slices.Concat(d.Transactions, d.Trash) { // IDs in the trash stay taken for restore
+		d.lastID = max(d.lastID, transaction.ID)
+	}
+	for i := range d.Transactions {
+		if d.Transactions[i].ID == 0 {
+			d.lastID++
+			d.Transactions[i].ID = d.lastID
+		}
+	}
+}
+
+func (d *Data) findTransaction(id int) (*Transaction, error) {
+	for i := range d.Transactions {
+		if d.Transactions[i].ID == id {
+			return &d.Transactions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no transaction with ID %d", id)
+}
+
+// transaction IDs given on the command line
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction ID: %s", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// move transactions to the trash, from where they can be restored until purged
+func (d *Data) trash(ids []int, at time.Time) error {
+	for _, id := range ids {
+		i := slices.IndexFunc(d.Transactions, func(t Transaction) bool { return t.ID == id })
+		if i < 0 {
+			return fmt.Errorf("no transaction with ID %d", id)
+		}
+		transaction := d.Transactions[i]
+		transaction.Deleted = at
+		d.Trash = append(d.Trash, transaction)
+		d.Transactions = slices.Delete(d.Transactions, i, i+1)
+		d.byDate = nil
+		if d.totals != nil {
+			d.totals.add(transaction, -1)
+		}
+	}
+	return nil
+}
+
+// put transactions back from the trash in ID order among the others
+func (d *Data) untrash(ids []int) error {
+	for _, id := range ids {
+		i := slices.IndexFunc(d.Trash, func(t Transaction) bool { return t.ID == id })
+		if i < 0 {
+			return fmt.Errorf("no transaction with ID %d in the trash", id)
+		}
+		transaction := d.Trash[i]
+		transaction.Deleted = time.Time{}
+		d.Trash = slices.Delete(d.Trash, i, i+1)
+		at := slices.IndexFunc(d.Transactions, func(t Transaction) bool { return t.ID > id })
+		if at < 0 {
+			at = len(d.Transactions)
+		}
+		d.Transactions = slices.Insert(d.Transactions, at, transaction)
+		d.byDate = nil
+		if d.totals != nil {
+			d.totals.add(transaction, 1)
+		}
+	}
+	return nil
+}
+
+// drop transactions from the trash for good, the given IDs or all deleted before cutoff; returns how many went
+func (d *Data) purge(ids []int, cutoff time.Time) (int, error) {
+	for _, id := range ids {
+		if !slices.ContainsFunc(d.Trash, func(t Transaction) bool { return t.ID == id }) {
+			return 0, fmt.Errorf("no transaction with ID %d in the trash", id)
+		}
+	}
+	count := len(d.Trash)
+	d.Trash = slices.DeleteFunc(d.Trash, func(t Transaction) bool {
+		if len(ids) > 0 {
+			return slices.Contains(ids, t.ID)
+		}
+		return t.Deleted.Before(cutoff)
+	})
+	return count - len(d.Trash), nil
+}
+
+func (d *Data) displayTrash() error {
+	trash := slices.Clone(d.Trash)
+	slices.SortStableFunc(trash, func(a, b Transaction) int { return b.Deleted.Compare(a.Deleted) })
+	if structuredOutput() {
+		rows := make([][]any, len(trash))
+		for i, t := range trash {
+			rows[i] = []any{t.ID, t.Date.Format("2006-01-02"), t.Type, t.Category, t.Amount, t.Description, t.Deleted.Format(time.RFC3339)}
+		}
+		return emit(trash, []string{"id", "date", "type", "category", "amount", "description", "deleted"}, rows)
+	}
+	if len(trash) == 0 {
+		fmt.Println("The trash is empty.")
+		return nil
+	}
+	table := newTable("ID", "Date", "Type", "Category", "Amount", "Description", "Deleted").alignRight(0, 4)
+	for _, t := range trash {
+		table.addRow(plainCell(strconv.Itoa(t.ID)), plainCell(t.Date.Format("2006-01-02")), plainCell(t.Type), plainCell(t.Category),
+			d.amountCell(t.Amount, t.Type), plainCell(t.Description), plainCell(t.Deleted.In(d.Settings.location()).Format("2006-01-02 15:04")))
+	}
+	table.print()
+	return nil
+}
+
+// what a consolidated view answers, by the subcommands that only read or nil when the whole command
+// does; the books keep their own transaction IDs, so a change made through the view could land in
+// the wrong one and is refused before it is made
+var viewCommands = map[string][]string{
+	"summary": nil, "list": nil, "stats": nil, "compare": nil, "report": nil, "predict": nil, "project": nil, "anomalies": nil,
+	"envelopes": nil, "safetospend": nil, "bills": nil, "reimbursements": nil, "suggest": nil, "portfolio": nil, "check": nil,
+	"export": nil, "anonymize-export": nil, "diff": nil, "open-attachment": nil, "serve": nil, "run": nil, "completion": nil,
+	"plugins": nil, "profile": nil, "help": nil, "exit": nil,
+	"account": {"list"}, "trash": {"list"}, "batches": {"list"}, "recurring": {"list"}, "loan": {"list", "schedule", "status"},
+	"alert": {"list"}, "webhook": {"list"}, "rule": {"list"}, "bank": {"list"}, "review": {"list"}, "sms": {"list", "test"},
+	"cpi": {"list"}, "deductible": {"list"}, "backup": {"list"}, "category": {"list", "archived"}, "user": {"list"},
+}
+
+// why command must not run on this book, nil unless it is a consolidated view and the command changes it
+func (d *Data) refuseInView(command string, args []string) error {
+	if len(d.books) == 0 {
+		return nil
+	}
+	if subcommands, ok := viewCommands[command]; ok && (subcommands == nil || len(args) > 0 && slices.Contains(subcommands, args[0])) {
+		return nil
+	}
+	return fmt.Errorf("%s would change the books, the consolidated view of %s is read-only", command, strings.Join(d.books, ", "))
+}
+
+// merge several books into one read-only view, each book stays stored in its own file
+func loadConsolidated(filenames []string) (*Data, error) {
+	consolidated := &Data{readOnly: true, books: filenames}
+	for i, filename := range filenames {
+		book, err := loadData(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		if i > 0 && book.Currency != consolidated.Currency {
+			return nil, fmt.Errorf("%s is kept in %q but %s is kept in %q", filename, book.Currency, filenames[0], consolidated.Currency)
+		}
+		if i == 0 {
+			consolidated.Currency = book.Currency
+			consolidated.Settings = book.Settings
+		}
+		consolidated.Transactions = append(consolidated.Transactions, book.Transactions...)
+		consolidated.lastID = max(consolidated.lastID, book.lastID)
+		for category, amount := range book.Budgets {
+			consolidated.setBudget(category, consolidated.Budgets[category]+amount)
+		}
+		for category, note := range book.Notes {
+			if _, ok := consolidated.Notes[category]; !ok {
+				consolidated.setCategoryNote(category, note)
+			}
+		}
+	}
+	return consolidated, nil
+}
+
+// where a book is kept; Save gets a one-line description of the change for storages that keep history
+type Storage interface {
+	Load() ([]byte, error) // an error wrapping os.ErrNotExist when there is no book yet
+	Save(content []byte, message string) error
+}
+
+// a path, or an s3://bucket/key, dav:// or davs:// (WebDAV over https) URL
+func openStorage(location string) Storage {
+	switch scheme, _, _ := strings.Cut(location, "://"); scheme {
+	case "s3":
+		return s3Storage{location: location}
+	case "dav", "davs", "webdav", "webdavs":
+		return webdavStorage{location: location}
+	}
+	return fileStorage{path: location}
+}
+
+func isRemote(location string) bool {
+	_, ok := openStorage(location).(fileStorage)
+	return !ok
+}
+
+type fileStorage struct {
+	path string
+}
+
+func (f fileStorage) Load() ([]byte, error) {
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return checkFooter(content)
+}
+
+// write a temporary file next to the book and rename it over the book once it is on disk,
+// so a crash leaves either the old or the new book and never a part of one
+func (f fileStorage) Save(content []byte, message string) error {
+	dir := filepath.Dir(f.path)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write data file: %w", err)
+	}
+	defer os.Remove(temp.Name()) // gone already after a successful rename
+	_, err = temp.Write(withFooter(content))
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), f.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write data file: %w", err)
+	}
+	if directory, err := os.Open(dir); err == nil { // make the rename itself durable, not supported on Windows
+		directory.Sync()
+		directory.Close()
+	}
+	return nil
+}
+
+// the last line of a saved book, with the length and SHA-256 of everything before it
+const footerPrefix = "#finance length="
+
+func withFooter(content []byte) []byte {
+	sum := sha256.Sum256(content)
+	footer := fmt.Sprintf("\n%s%d sha256=%s\n", footerPrefix, len(content), hex.EncodeToString(sum[:]))
+	return append(slices.Clip(content), footer...)
+}
+
+// the book without its footer; books written by hand or before footers existed have none. A book cut short
+// or otherwise damaged is reported wrapping ErrCorrupt, one edited by hand that still parses only warns
+func checkFooter(content []byte) ([]byte, error) {
+	trimmed := bytes.TrimRight(content, "\r\n")
+	start := bytes.LastIndexByte(trimmed, '\n') + 1
+	if !bytes.HasPrefix(trimmed[start:], []byte(footerPrefix)) {
+		return content, nil
+	}
+	body := trimmed[:max(start-1, 0)]
+	var length int
+	var checksum string
+	if _, err := fmt.Sscanf(string(trimmed[start+len(footerPrefix):]), "%d sha256=%s", &length, &checksum); err != nil {
+		return nil, fmt.Errorf("%w: unreadable footer", ErrCorrupt)
+	}
+	sum := sha256.Sum256(body)
+	switch {
+	case hex.EncodeToString(sum[:]) == checksum:
+		return body, nil
+	case json.Valid(body): // a cut or garbled book no longer parses
+		slog.Warn("the data file was changed outside the tracker, its checksum no longer matches")
+		return body, nil
+	case length != len(body):
+		return nil, fmt.Errorf("%w: %d of %d bytes are left", ErrCorrupt, len(body), length)
+	}
+	return nil, fmt.Errorf("%w: the checksum does not match", ErrCorrupt)
+}
+
+// who holds the lock of a data file, written into the lock file
+type lockHolder struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+// an advisory lock next to a local data file, held by the process that may write the book for as long as
+// it runs; other processes still read the book but refuse to save it
+type fileLock struct {
+	path   string
+	holder lockHolder
+}
+
+// take the lock of a data file; a lock left behind by a process of this host that no longer runs is
+// taken over, one held elsewhere is reported wrapping ErrLocked
+func lockDataFile(dataFile string) (*fileLock, error) {
+	host, _ := os.Hostname()
+	lock := &fileLock{path: dataFile + ".lock", holder: lockHolder{PID: os.Getpid(), Host: host, Since: time.Now()}}
+	content, err := json.Marshal(lock.holder)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(lock.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = file.Write(content)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lock.path)
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		var holder lockHolder
+		existing, err := os.ReadFile(lock.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // released in the meantime
+		}
+		if err != nil || json.Unmarshal(existing, &holder) != nil || holder.Host == host && !processRunning(holder.PID) {
+			if attempt > 0 {
+				return nil, fmt.Errorf("failed to take over the stale lock %s, remove it if no other process uses the book", lock.path)
+			}
+			slog.Info("removed a stale lock left by a process that no longer runs", "lock", lock.path)
+			os.Remove(lock.path)
+			continue
+		}
+		return nil, fmt.Errorf("%w by process %d on %s since %s; open it with --read-only, or remove %s if that process is gone",
+			ErrLocked, holder.PID, holder.Host, holder.Since.Local().Format("2006-01-02 15:04"), lock.path)
+	}
+}
+
+// give the lock up unless another process took it over in the meantime
+func (l *fileLock) release() {
+	if l == nil {
+		return
+	}
+	var holder lockHolder
+	if content, err := os.ReadFile(l.path); err == nil && json.Unmarshal(content, &holder) == nil && holder.PID == l.holder.PID && holder.Host == l.holder.Host {
+		os.Remove(l.path)
+	}
+}
+
+// whether a process of this host still runs; Windows only finds processes that exist
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// the long running command Ctrl+C stops instead of the whole program
+var interruption = struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}{}
+
+// a context Ctrl+C cancels until done is called, Ctrl+C exits the program again after that
+func interruptible() (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interruption.Lock()
+	interruption.cancel = cancel
+	interruption.Unlock()
+	return ctx, func() {
+		interruption.Lock()
+		interruption.cancel = nil
+		interruption.Unlock()
+		cancel()
+	}
+}
+
+// called by the Ctrl+C handler in main, whether a command took the Ctrl+C and the program keeps running
+func interruptCommand() bool {
+	interruption.Lock()
+	defer interruption.Unlock()
+	if interruption.cancel == nil {
+		return false
+	}
+	interruption.cancel()
+	return true
+}
+
+// remote books are cached here, so unchanged books are not downloaded again and stay readable offline
+func remoteCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "finance")
+}
+
+// the ETag of each remote book as this process loaded it; a save only succeeds while the remote copy
+// still has it, so a change made elsewhere in the meantime is never overwritten
+var remoteETags = struct {
+	sync.Mutex
+	tags map[string]string
+}{tags: make(map[string]string)}
+
+// GET and PUT of a remote book, shared by the S3 and WebDAV storages
+type remoteBook struct {
+	location string
+	url      string
+	sign     func(request *http.Request, body []byte) error
+}
+
+func (r remoteBook) cacheFile() string {
+	digest := sha256.Sum256([]byte(r.location))
+	return filepath.Join(remoteCacheDir(), hex.EncodeToString(digest[:8])+".json")
+}
+
+func (r remoteBook) do(method string, body []byte, header map[string]string) (*http.Response, error) {
+	request, err := http.NewRequest(method, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	if err := r.sign(request, body); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: time.Minute}
+	return client.Do(request)
+}
+
+// the cached copy is revalidated with If-None-Match and used as is when the server cannot be reached
+func (r remoteBook) Load() ([]byte, error) {
+	cached, cacheErr := os.ReadFile(r.cacheFile())
+	cachedETag, _ := os.ReadFile(r.cacheFile() + ".etag")
+	header := map[string]string{}
+	if cacheErr == nil && len(cachedETag) > 0 {
+		header["If-None-Match"] = string(cachedETag)
+	}
+	response, err := r.do(http.MethodGet, nil, header)
 	if err != nil {
-		return 0, fmt.Errorf("invalid amount: %w", err)
+		if cacheErr == nil {
+			slog.Warn("remote book unreachable, using the cached copy", "location", r.location, "error", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+	var content []byte
+	etag := response.Header.Get("ETag")
+	switch {
+	case response.StatusCode == http.StatusNotModified:
+		content, etag = cached, string(cachedETag)
+	case response.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", r.location, os.ErrNotExist)
+	case response.StatusCode == http.StatusOK:
+		if content, err = io.ReadAll(response.Body); err != nil {
+			return nil, err
+		}
+		r.cache(content, etag)
+	default:
+		return nil, fmt.Errorf("%s answered %s", r.location, response.Status)
+	}
+	remoteETags.Lock()
+	if _, ok := remoteETags.tags[r.location]; !ok {
+		remoteETags.tags[r.location] = etag
+	}
+	remoteETags.Unlock()
+	return content, nil
+}
+
+func (r remoteBook) cache(content []byte, etag string) {
+	if os.MkdirAll(remoteCacheDir(), 0o700) == nil {
+		os.WriteFile(r.cacheFile(), content, 0o600)
+		os.WriteFile(r.cacheFile()+".etag", []byte(etag), 0o600)
+	}
+}
+
+// a book that was not there when loaded must still not exist, one that was must still be the same version
+func (r remoteBook) Save(content []byte, message string) error {
+	remoteETags.Lock()
+	defer remoteETags.Unlock()
+	header := map[string]string{"Content-Type": "application/json"}
+	if etag, ok := remoteETags.tags[r.location]; ok && etag != "" {
+		header["If-Match"] = etag
+	} else {
+		header["If-None-Match"] = "*"
+	}
+	response, err := r.do(http.MethodPut, content, header)
+	if err != nil {
+		return fmt.Errorf("failed to write data file: %w", err)
+	}
+	response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusPreconditionFailed || response.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s was changed elsewhere since it was loaded, nothing was saved; start again to work on the current version", r.location)
+	case response.StatusCode >= 300:
+		return fmt.Errorf("failed to write data file: %s answered %s", r.location, response.Status)
+	}
+	etag := response.Header.Get("ETag")
+	if etag == "" { // some WebDAV servers only tell on request
+		if head, err := r.do(http.MethodHead, nil, nil); err == nil {
+			head.Body.Close()
+			etag = head.Header.Get("ETag")
+		}
+	}
+	remoteETags.tags[r.location] = etag
+	r.cache(content, etag)
+	return nil
+}
+
+// s3://bucket/key on AWS or any S3 compatible service; FINANCE_S3_ENDPOINT points at the service
+// (https://s3.<region>.amazonaws.com by default) and the credentials come from the usual AWS_* variables
+type s3Storage struct {
+	location string
+}
+
+func (s s3Storage) book() (remoteBook, error) {
+	target, err := url.Parse(s.location)
+	if err != nil || target.Host == "" || strings.Trim(target.Path, "/") == "" {
+		return remoteBook{}, fmt.Errorf("invalid S3 location %q, use s3://bucket/key", s.location)
+	}
+	region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	endpoint := strings.TrimSuffix(cmp.Or(os.Getenv("FINANCE_S3_ENDPOINT"), "https://s3."+region+".amazonaws.com"), "/")
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return remoteBook{}, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for %s", s.location)
+	}
+	return remoteBook{
+		location: s.location,
+		url:      endpoint + "/" + awsEscape(target.Host) + awsEscape(target.Path), // path style, which every S3 clone supports
+		sign: func(request *http.Request, body []byte) error {
+			if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+				request.Header.Set("X-Amz-Security-Token", token)
+			}
+			signV4(request, body, region, "s3", accessKey, secretKey, time.Now().UTC())
+			return nil
+		},
+	}, nil
+}
+
+func (s s3Storage) Load() ([]byte, error) {
+	book, err := s.book()
+	if err != nil {
+		return nil, err
+	}
+	return book.Load()
+}
+
+func (s s3Storage) Save(content []byte, message string) error {
+	book, err := s.book()
+	if err != nil {
+		return err
+	}
+	return book.Save(content, message)
+}
+
+// percent-encode everything but unreserved characters, keeping slashes, as AWS signatures expect
+func awsEscape(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// sign a request with AWS Signature Version 4, covering the host, the x-amz-* headers and Range
+func signV4(request *http.Request, body []byte, region, service, accessKey, secretKey string, now time.Time) {
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	payload := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	signed := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") || name == "range" {
+			signed[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(signed))
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{request.Method, cmp.Or(request.URL.EscapedPath(), "/"), request.URL.RawQuery,
+		headers.String(), signedHeaders, hex.EncodeToString(payload[:])}, "\n")
+
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	digest := sha256.Sum256([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hmacSHA256(key, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+hex.EncodeToString(digest[:]))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x", accessKey, scope, signedHeaders, signature))
+}
+
+// dav://host/path or davs://host/path, e.g. a Nextcloud remote.php/dav/files/<user>/finance.json; the
+// user and password come from the URL or FINANCE_WEBDAV_USER and FINANCE_WEBDAV_PASSWORD
+type webdavStorage struct {
+	location string
+}
+
+func (w webdavStorage) book() (remoteBook, error) {
+	target, err := url.Parse(w.location)
+	if err != nil || target.Host == "" {
+		return remoteBook{}, fmt.Errorf("invalid WebDAV location %q, use davs://host/path", w.location)
+	}
+	user, password := os.Getenv("FINANCE_WEBDAV_USER"), os.Getenv("FINANCE_WEBDAV_PASSWORD")
+	if target.User != nil {
+		user = target.User.Username()
+		if secret, ok := target.User.Password(); ok {
+			password = secret
+		}
+		target.User = nil
+	}
+	target.Scheme = "http"
+	if strings.HasSuffix(strings.SplitN(w.location, "://", 2)[0], "s") {
+		target.Scheme = "https"
+	}
+	location := *target
+	location.Scheme = "dav"
+	return remoteBook{
+		location: location.String(), // without the password
+		url:      target.String(),
+		sign: func(request *http.Request, body []byte) error {
+			if user != "" {
+				request.SetBasicAuth(user, password)
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (w webdavStorage) Load() ([]byte, error) {
+	book, err := w.book()
+	if err != nil {
+		return nil, err
+	}
+	return book.Load()
+}
+
+func (w webdavStorage) Save(content []byte, message string) error {
+	book, err := w.book()
+	if err != nil {
+		return err
+	}
+	return book.Save(content, message)
+}
+
+// a data file in a git work tree, each save is committed so the book's history can be browsed with
+// git log and pushed to any remote
+type gitStorage struct {
+	path string
+}
+
+func (g gitStorage) git(args ...string) (string, error) {
+	command := exec.Command("git", append([]string{"-C", filepath.Dir(g.path)}, args...)...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// make the data file's directory a repository unless it already is inside one
+func (g gitStorage) init() error {
+	if _, err := g.git("rev-parse", "--is-inside-work-tree"); err == nil {
+		return nil
+	}
+	_, err := g.git("init", "--quiet")
+	return err
+}
+
+func (g gitStorage) Load() ([]byte, error) {
+	return fileStorage(g).Load()
+}
+
+func (g gitStorage) Save(content []byte, message string) error {
+	if err := fileStorage(g).Save(content, message); err != nil {
+		return err
+	}
+	if err := g.init(); err != nil {
+		return fmt.Errorf("the data file was saved but not committed: %w", err)
+	}
+	name := filepath.Base(g.path)
+	if _, err := g.git("add", "--", name); err != nil {
+		return fmt.Errorf("the data file was saved but not committed: %w", err)
+	}
+	if _, err := g.git("diff", "--cached", "--quiet", "--", name); err == nil {
+		return nil // nothing changed
+	}
+	if _, err := g.git("commit", "--quiet", "--message", message, "--", name); err != nil {
+		return fmt.Errorf("the data file was saved but not committed: %w", err)
+	}
+	return nil
+}
+
+// note the time on transactions added or changed since the book was loaded or last saved, unless the
+// change brought its own time along, as transactions taken over from another copy do
+func (d *Data) stampModified(now time.Time) {
+	before := make(map[int]Transaction, len(d.saved))
+	for _, transaction := range d.saved {
+		before[transaction.ID] = transaction
+	}
+	for i, transaction := range d.Transactions {
+		previous, ok := before[transaction.ID]
+		if !ok && transaction.Modified.IsZero() || ok && transaction.Modified.Equal(previous.Modified) && !sameTransaction(previous, transaction) {
+			d.Transactions[i].Modified = now
+		}
+	}
+}
+
+// a commit message for the changes since the book was loaded or last saved
+func (d *Data) changeSummary() string {
+	diff := diffBooks(&Data{Transactions: d.saved}, d)
+	updated := make([]Transaction, len(diff.Modified))
+	for i, change := range diff.Modified {
+		updated[i] = change.Remote
+	}
+	var parts []string
+	for _, change := range []struct {
+		verb         string
+		transactions []Transaction
+	}{{"Add", diff.Added}, {"Remove", diff.Removed}, {"Update", updated}} {
+		switch len(change.transactions) {
+		case 0:
+		case 1:
+			parts = append(parts, change.verb+" "+d.transactionLine(change.transactions[0]))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %d transactions", change.verb, len(change.transactions)))
+		}
+	}
+	if len(parts) == 0 {
+		return "Update settings"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// why the book must not be written, nil when it may
+func (d *Data) writable() error {
+	if d.lockedBy != nil {
+		return fmt.Errorf("changes were not saved: %w", d.lockedBy)
+	}
+	if !d.asOf.IsZero() {
+		return fmt.Errorf("the book is shown as of %s, changes were not saved", d.asOf.Format("2006-01-02"))
+	}
+	if d.readOnly {
+		return fmt.Errorf("this view is read-only, changes were not saved")
+	}
+	return nil
+}
+
+func (d *Data) save(filename string) error {
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if d.upgradedFrom != nil {
+		if err := keepOriginal(filename, *d.upgradedFrom); err != nil {
+			return err
+		}
+		d.upgradedFrom = nil
+	}
+	d.Version = schemaVersion()
+	if len(diffBooks(&Data{Transactions: d.saved}, d).Modified) > 0 {
+		d.edited() // changed in place by code that did not say so
+	}
+	d.stampModified(d.now().UTC())
+	content, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+	storage := openStorage(filename)
+	if d.Settings.GitHistory && !isRemote(filename) {
+		storage = gitStorage{path: filename}
+	}
+	if err := storage.Save(content, d.changeSummary()); err != nil {
+		return err
+	}
+	d.publishChanges()
+	return nil
+}
+
+const defaultBackupKeep = 10
+
+// backups live in a backups directory next to the data file
+func backupDir(dataFile string) string {
+	if isRemote(dataFile) {
+		return filepath.Join(remoteCacheDir(), "backups")
+	}
+	return filepath.Join(filepath.Dir(dataFile), "backups")
+}
+
+// backups of the data file, oldest first; their names start with the data file's name
+func listBackups(dataFile string) ([]string, error) {
+	entries, err := os.ReadDir(backupDir(dataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+	prefix := strings.TrimSuffix(filepath.Base(dataFile), filepath.Ext(dataFile)) + "-"
+	var backups []string
+	taken := make(map[string]time.Time)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			backup := filepath.Join(backupDir(dataFile), entry.Name())
+			backups, taken[backup] = append(backups, backup), info.ModTime()
+		}
+	}
+	slices.SortStableFunc(backups, func(a, b string) int { return cmp.Or(taken[a].Compare(taken[b]), strings.Compare(a, b)) })
+	return backups, nil
+}
+
+// copy the data file to a timestamped backup, the label tells automatic backups apart; only the newest
+// keep backups are kept, 0 keeps all. Nothing is done while the data file does not exist yet.
+func (d *Data) backup(dataFile, label string, compress bool, keep int) (string, error) {
+	content, err := openStorage(dataFile).Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read data file: %w", err)
+	}
+	if err := os.MkdirAll(backupDir(dataFile), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(dataFile), filepath.Ext(dataFile)) + "-" + d.now().In(d.Settings.location()).Format("20060102-150405")
+	if label != "" {
+		name += "-" + label
+	}
+	name += filepath.Ext(dataFile)
+	if compress {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		writer.Name = filepath.Base(dataFile)
+		writer.Write(content)
+		writer.Close()
+		content, name = buffer.Bytes(), name+".gz"
+	}
+	target := filepath.Join(backupDir(dataFile), name)
+	for i := 2; ; i++ { // two backups within a second
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = filepath.Join(backupDir(dataFile), strings.Replace(name, ".", fmt.Sprintf("-%d.", i), 1))
+	}
+	if err := os.WriteFile(target, content, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if keep > 0 {
+		backups, err := listBackups(dataFile)
+		if err != nil {
+			return target, err
+		}
+		for _, old := range backups[:max(len(backups)-keep, 0)] {
+			if err := os.Remove(old); err != nil {
+				return target, fmt.Errorf("failed to remove old backup: %w", err)
+			}
+		}
+	}
+	return target, nil
+}
+
+// the backup taken before a change the user may want to roll back, a failure is reported but does not stop the change
+func (d *Data) backupBefore(dataFile, label string) {
+	target, err := d.backup(dataFile, label, d.Settings.BackupGzip, cmp.Or(d.Settings.BackupKeep, defaultBackupKeep))
+	if err != nil {
+		slog.Warn("could not back up the data file", "error", err)
+	} else if target != "" {
+		fmt.Printf("Backed up the data file to %s, restore it with: restore %s\n", target, filepath.Base(target))
+	}
+}
+
+// the book kept in a backup, decompressed
+func readBackup(backup string) ([]byte, error) {
+	content, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if strings.HasSuffix(backup, ".gz") {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		if content, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %w", err)
+		}
+	}
+	return content, nil
+}
+
+// replace the data file with a backup, given by path or by its name in the backups directory; the
+// current file is backed up first so the restore itself can be undone
+func (d *Data) restore(dataFile, backup string) error {
+	if err := d.writable(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(backup); err != nil && filepath.Base(backup) == backup {
+		backup = filepath.Join(backupDir(dataFile), backup)
+	}
+	content, err := readBackup(backup)
+	if err != nil {
+		return err
+	}
+	if _, _, err := migrateBook(content); err != nil {
+		return fmt.Errorf("%s is not a usable data file: %w", backup, err)
+	}
+	d.backupBefore(dataFile, "pre-restore")
+	if err := openStorage(dataFile).Save(content, "Restore "+filepath.Base(backup)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Data) displayBackups(dataFile string) error {
+	backups, err := listBackups(dataFile)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups yet, create one with: backup")
+		return nil
+	}
+	table := newTable("Backup", "Size", "Taken").alignRight(1)
+	for _, backup := range backups {
+		info, err := os.Stat(backup)
+		if err != nil {
+			continue
+		}
+		table.addRow(plainCell(filepath.Base(backup)), plainCell(fmt.Sprintf("%d KB", (info.Size()+1023)/1024)),
+			plainCell(info.ModTime().In(d.Settings.location()).Format("2006-01-02 15:04")))
+	}
+	table.print()
+	return nil
+}
+// errors callers can tell apart with errors.Is
+var (
+	ErrInvalidType   = errors.New("invalid transaction type")
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrInvalidDate   = errors.New("invalid date")
+	ErrInvalidPeriod = errors.New("invalid period")
+	ErrLocked        = errors.New("the data file is in use")
+	ErrCorrupt       = errors.New("the data file is damaged")
+)
+
+func parseDate(dateStr string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w %q, use YYYY-MM-DD", ErrInvalidDate, dateStr)
+	}
+	return date, nil
+}
+
+// a date as people type it: YYYY-MM-DD, today, yesterday, tomorrow, 3 days ago, 2 weeks ago, last friday,
+// next monday, friday, mar 5, 5 march, mar 5 2024 or mar 5 24, read relative to now. Where a date could mean
+// more than one day the most recent one not after now is taken, so friday and mar 5 are never in the future;
+// only tomorrow and next <weekday> are. Numeric forms like 13/02/2024 are read day or month first, whichever
+// makes a date; 01/02 could be either across locales and is refused
+func ParseFlexibleDate(s string, now time.Time) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", strings.TrimSpace(s)); err == nil {
+		return date, nil
+	}
+	today := civilDate(now)
+	invalid := fmt.Errorf("%w %q, use YYYY-MM-DD, today, yesterday, 3 days ago, last friday or mar 5", ErrInvalidDate, s)
+	words := strings.Fields(strings.ToLower(strings.NewReplacer(",", " ", ".", " ").Replace(s)))
+	if len(words) == 0 {
+		return time.Time{}, invalid
+	}
+	if strings.Contains(s, "/") {
+		return numericDate(strings.TrimSpace(s), today, invalid)
+	}
+	switch strings.Join(words, " ") {
+	case "today", "now":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+
+	// 3 days ago, a week ago
+	if len(words) == 3 && words[2] == "ago" {
+		n, err := strconv.Atoi(words[0])
+		if words[0] == "a" || words[0] == "an" {
+			n, err = 1, nil
+		}
+		if err != nil || n < 0 {
+			return time.Time{}, invalid
+		}
+		// a month before march 31 is the last day of february, not march 3
+		monthsBack := func(months int) time.Time {
+			date := today.AddDate(0, -months, 0)
+			if date.Day() != today.Day() {
+				date = date.AddDate(0, 0, -date.Day())
+			}
+			return date
+		}
+		switch strings.TrimSuffix(words[1], "s") {
+		case "day":
+			return today.AddDate(0, 0, -n), nil
+		case "week":
+			return today.AddDate(0, 0, -7*n), nil
+		case "month":
+			return monthsBack(n), nil
+		case "year":
+			return monthsBack(12 * n), nil
+		}
+		return time.Time{}, invalid
+	}
+
+	// friday, last friday, next friday
+	if weekday, err := parseWeekday(words[len(words)-1]); err == nil && len(words) <= 2 {
+		back := (int(today.Weekday()) - int(weekday) + 7) % 7
+		switch {
+		case len(words) == 1:
+			return today.AddDate(0, 0, -back), nil
+		case words[0] == "last":
+			return today.AddDate(0, 0, -cmp.Or(back, 7)), nil
+		case words[0] == "next":
+			return today.AddDate(0, 0, cmp.Or((7-back)%7, 7)), nil
+		}
+		return time.Time{}, invalid
+	}
+
+	// mar 5, 5 march, mar 5 2024
+	if len(words) != 2 && len(words) != 3 {
+		return time.Time{}, invalid
+	}
+	month, ok := parseMonthName(words[0])
+	dayWord := words[1]
+	if !ok {
+		month, ok = parseMonthName(words[1])
+		dayWord = words[0]
+	}
+	day, err := strconv.Atoi(strings.TrimRight(dayWord, "stndrh")) // 5th, 1st, 2nd, 3rd
+	if !ok || err != nil || day < 1 || day > 31 {
+		return time.Time{}, invalid
+	}
+	year := 0
+	if len(words) == 3 {
+		if year, ok = parseYear(words[2], today); !ok {
+			return time.Time{}, invalid
+		}
+	}
+	if date, ok := dayOf(year, month, day, today); ok {
+		return date, nil
+	}
+	return time.Time{}, invalid
+}
+
+// 2024, or 24 for the year in this century unless that is more than ten years ahead, so 99 is 1999
+func parseYear(word string, today time.Time) (int, bool) {
+	year, err := strconv.Atoi(word)
+	switch {
+	case err != nil || year < 0:
+		return 0, false
+	case len(word) == 2:
+		year += today.Year() / 100 * 100
+		if year > today.Year()+10 {
+			year -= 100
+		}
+		return year, true
+	}
+	return year, year >= 1000
+}
+
+// the day in the given year, or with year 0 the most recent one not after today
+func dayOf(year int, month time.Month, day int, today time.Time) (time.Time, bool) {
+	valid := func(year int) (time.Time, bool) {
+		date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		return date, date.Month() == month && date.Day() == day // feb 30 would roll over into march
+	}
+	if year != 0 {
+		return valid(year)
+	}
+	for year := today.Year(); year > today.Year()-8; year-- { // feb 29 may be a few years back
+		if date, ok := valid(year); ok && !date.After(today) {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 2024/3/5, or 13/02, 2/13 and 13/02/24 where only one of day first and month first makes a date
+func numericDate(s string, today time.Time, invalid error) (time.Time, error) {
+	parts := strings.Split(s, "/")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || len(parts) < 2 || len(parts) > 3 {
+			return time.Time{}, invalid
+		}
+		numbers[i] = n
+	}
+	if len(parts) == 3 && len(parts[0]) == 4 {
+		if date, ok := dayOf(numbers[0], time.Month(numbers[1]), numbers[2], today); ok {
+			return date, nil
+		}
+		return time.Time{}, invalid
+	}
+	year := 0
+	if len(parts) == 3 {
+		var ok bool
+		if year, ok = parseYear(parts[2], today); !ok {
+			return time.Time{}, invalid
+		}
+	}
+	var readings []time.Time
+	for _, order := range [][2]int{{0, 1}, {1, 0}} { // day first, then month first
+		day, month := numbers[order[0]], numbers[order[1]]
+		if month < 1 || month > 12 || day < 1 {
+			continue
+		}
+		if date, ok := dayOf(year, time.Month(month), day, today); ok && !slices.ContainsFunc(readings, date.Equal) {
+			readings = append(readings, date)
+		}
+	}
+	switch len(readings) {
+	case 0:
+		return time.Time{}, invalid
+	case 1:
+		return readings[0], nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q is ambiguous, it could be %s or %s; use YYYY-MM-DD or a month name like mar 5",
+		ErrInvalidDate, s, readings[0].Format("Jan 2 2006"), readings[1].Format("Jan 2 2006"))
+}
+
+func parseMonthName(word string) (time.Month, bool) {
+	for month := time.January; month <= time.December; month++ {
+		name := strings.ToLower(month.String())
+		if word == name || len(word) >= 3 && strings.HasPrefix(name, word) {
+			return month, true
+		}
+	}
+	return 0, false
+}
+
+// a date typed by the user, in any form ParseFlexibleDate reads, relative to the book's today
+func (d *Data) parseDateInput(s string) (time.Time, error) {
+	return ParseFlexibleDate(s, d.today())
+}
+
+// amounts typed by hand may use thousands separators, e.g. 1,250.00, and simple arithmetic like 12.99+4.50*2
+func parseAmount(amountStr string) (float64, error) {
+	amount, currency, err := parseTypedAmount(amountStr)
+	if err == nil && currency != "" {
+		return 0, fmt.Errorf("%w %q, only transactions can be given in another currency", ErrInvalidAmount, amountStr)
+	}
+	return amount, err
+}
+
+// symbols typed before an amount, longest first so US$ is not read as $; $ alone is the book's dollar
+var amountPrefixes = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"C$", "CAD"}, {"A$", "AUD"}, {"NZ$", "NZD"}, {"R$", "BRL"}, {"$", "$"},
+	{"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"৳", "BDT"}, {"₩", "KRW"}, {"₺", "TRY"}, {"₪", "ILS"}, {"₱", "PHP"},
+}
+
+var dollarCurrencies = []string{"USD", "CAD", "AUD", "NZD", "SGD", "HKD", "TWD", "MXN"}
+
+// an amount with an optional currency symbol or code before it, $45, €30 or EUR 30; returns the code, "$" for
+// a bare dollar sign and "" when no currency was given
+func parseTypedAmount(amountStr string) (float64, string, error) {
+	text := strings.NewReplacer(",", "", " ", "").Replace(strings.TrimSpace(amountStr))
+	currency := ""
+	for _, prefix := range amountPrefixes {
+		if rest, ok := strings.CutPrefix(text, prefix.symbol); ok {
+			currency, text = prefix.code, rest
+			break
+		}
+	}
+	if currency == "" && len(text) > 3 && strings.Trim(text[:3], "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		currency, text = text[:3], text[3:]
+	}
+	amount, err := evaluateAmount(text)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w %q: %v", ErrInvalidAmount, amountStr, err)
+	}
+	return amount, currency, nil
+}
+
+// evaluate + - * / and parentheses over plain numbers, nothing else is read; results are rounded to
+// a hundredth of a cent so 12.99+9 is 21.99 and not 21.990000000000002
+func evaluateAmount(text string) (float64, error) {
+	pos := 0
+	peek := func() byte {
+		if pos < len(text) {
+			return text[pos]
+		}
+		return 0
+	}
+	var expression, term, factor func() (float64, error)
+	// binary operators of one precedence level, left to right
+	chain := func(operand func() (float64, error), operators string, apply func(byte, float64, float64) (float64, error)) func() (float64, error) {
+		return func() (float64, error) {
+			value, err := operand()
+			for err == nil && peek() != 0 && strings.IndexByte(operators, peek()) >= 0 {
+				op := peek()
+				pos++
+				var right float64
+				if right, err = operand(); err == nil {
+					value, err = apply(op, value, right)
+				}
+			}
+			return value, err
+		}
+	}
+	expression = chain(func() (float64, error) { return term() }, "+-", func(op byte, left, right float64) (float64, error) {
+		if op == '-' {
+			return left - right, nil
+		}
+		return left + right, nil
+	})
+	term = chain(func() (float64, error) { return factor() }, "*/", func(op byte, left, right float64) (float64, error) {
+		if op == '*' {
+			return left * right, nil
+		}
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	})
+	factor = func() (float64, error) {
+		switch c := peek(); {
+		case c == '-' || c == '+':
+			pos++
+			value, err := factor()
+			if c == '-' {
+				value = -value
+			}
+			return value, err
+		case c == '(':
+			pos++
+			value, err := expression()
+			if err == nil && peek() != ')' {
+				err = fmt.Errorf("missing )")
+			}
+			pos++
+			return value, err
+		}
+		start := pos
+		for c := peek(); c >= '0' && c <= '9' || c == '.'; c = peek() {
+			pos++
+		}
+		if start == pos {
+			if pos == len(text) {
+				return 0, fmt.Errorf("a number is missing")
+			}
+			return 0, fmt.Errorf("unexpected %q", text[pos:])
+		}
+		value, err := strconv.ParseFloat(text[start:pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", text[start:pos])
+		}
+		return value, nil
+	}
+	value, err := expression()
+	if err == nil && pos < len(text) {
+		err = fmt.Errorf("unexpected %q", text[pos:])
+	}
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("out of range")
+	}
+	return math.Round(value*10000) / 10000, nil
+}
+
+// a transaction amount as typed, with the currency it was given in when that is not the book's; a bare $
+// is only taken for the book's own dollar, other dollars need their code or symbol
+func (d *Data) parseTransactionAmount(amountStr string) (float64, string, error) {
+	amount, currency, err := parseTypedAmount(amountStr)
+	if err != nil {
+		return 0, "", err
+	}
+	book := cmp.Or(d.Currency, "USD")
+	if currency == "$" {
+		if !slices.Contains(dollarCurrencies, d.Currency) {
+			number := strings.TrimPrefix(strings.TrimSpace(amountStr), "$")
+			return 0, "", fmt.Errorf("%w %q: $ could be any dollar and the book is not kept in one, type the currency, e.g. US$%s or USD%[3]s",
+				ErrInvalidAmount, amountStr, number)
+		}
+		currency = book
+	}
+	if currency == book {
+		currency = ""
+	}
+	return amount, currency, nil
+}
+
+// a bank's alert text format: Pattern names its parts with groups like (?P<amount>[\d,.]+), amount is
+// required and payee, date, currency and account are read when present
+type TextTemplate struct {
+	Name       string `json:"name"`
+	Pattern    string `json:"pattern"`
+	Type       string `json:"type,omitempty"`        // Income or Expense, told from words like credited or spent when unset
+	DateLayout string `json:"date_layout,omitempty"` // Go layout of the date group, e.g. 01/02/06; day first when unset
+	Category   string `json:"category,omitempty"`    // when no rule or history applies
+	Account    string `json:"account,omitempty"`
+}
+
+func (t TextTemplate) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("a text template needs a name")
+	}
+	pattern, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return fmt.Errorf("pattern: %w", err)
+	}
+	if pattern.SubexpIndex("amount") < 0 {
+		return fmt.Errorf("the pattern needs an amount group, e.g. (?P<amount>[\\d,.]+)")
+	}
+	if t.Type != "" && t.Type != Income && t.Type != Expense {
+		return fmt.Errorf("%w %q, use Income or Expense", ErrInvalidType, t.Type)
+	}
+	return nil
+}
+
+// the generic reading of alerts from banks without a template: an amount with its currency, the first of
+// words like debited or credited for the direction, a name after at, to or from and a date
+var (
+	textAmount      = regexp.MustCompile(`(?i)(?P<currency>rs\.?|inr|tk\.?|bdt|usd|eur|gbp|[a-z]{0,2}[$€£₹৳¥])\s?(?P<amount>\d[\d,]*(?:\.\d+)?)|(?P<amount>\d[\d,]*(?:\.\d+)?)\s?(?P<currency>inr|bdt|usd|eur|gbp|tk|taka)\b`)
+	textPayee       = regexp.MustCompile(`(?i)\b(?:at|to|from|towards)\s+(?P<payee>[a-z0-9][\w&'.*@-]*(?: [a-z0-9][\w&'.*@-]*)*?)(?:\s+(?:on|via|ref|using|with|for|avl|avbl|thru|from|to|at)\b|[,;]|\.(?:\s|$)|$)`)
+	textNotPayee    = regexp.MustCompile(`(?i)^(your|my|the|acc(oun)?t|card|ac)\b`)
+	textDate        = regexp.MustCompile(`(?i)\b(\d{1,2}[- ](?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*[- ]\d{2,4}|\d{4}-\d{2}-\d{2}|\d{1,2}[-/.]\d{1,2}[-/.]\d{2,4})\b`)
+	textExpenseWord = regexp.MustCompile(`(?i)\b(debited|spent|paid|withdrawn|purchase|charged|sent|payment)\b`)
+	textIncomeWord  = regexp.MustCompile(`(?i)\b(credited|received|deposited|refund(ed)?)\b`)
+	textDateLayouts = []string{"2006-01-02", "2-Jan-2006", "2-Jan-06", "2 Jan 2006", "2 Jan 06", "2-January-2006", "2 January 2006",
+		"2-1-2006", "2-1-06", "2/1/2006", "2/1/06", "2.1.2006", "2.1.06"}
+)
+
+// currencies alerts write as words
+var textCurrencies = map[string]string{"rs": "INR", "rs.": "INR", "tk": "BDT", "tk.": "BDT", "taka": "BDT"}
+
+// a transaction from the text of a bank's SMS or app notification, read with the first of the book's
+// templates that matches it and by the generic reading otherwise; the date is today when none is found
+func (d *Data) parseNotification(text string) (Transaction, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return Transaction{}, fmt.Errorf("the text is empty")
+	}
+	sum := sha256.Sum256([]byte(text))
+	transaction := Transaction{Date: d.today(), Description: text, ExternalID: "text:" + hex.EncodeToString(sum[:8]), Status: Pending}
+	var template TextTemplate
+	groups := make(map[string]string)
+	read := func(pattern *regexp.Regexp) bool {
+		match := pattern.FindStringSubmatch(text)
+		for i, name := range pattern.SubexpNames() {
+			if name != "" && match != nil && match[i] != "" {
+				groups[name] = match[i]
+			}
+		}
+		return match != nil
+	}
+	matched := false
+	for _, candidate := range d.Texts {
+		if pattern, err := regexp.Compile(candidate.Pattern); err == nil && read(pattern) {
+			template, matched = candidate, true
+			break
+		}
+	}
+	if !matched {
+		if !read(textAmount) {
+			return Transaction{}, fmt.Errorf("no amount found in %q, add a template for this bank with: sms add", text)
+		}
+		for rest := text; ; { // to your account from ACME: the account holder is not the payee
+			match := textPayee.FindStringSubmatchIndex(rest)
+			if match == nil {
+				break
+			}
+			if payee := rest[match[2]:match[3]]; !textNotPayee.MatchString(payee) {
+				groups["payee"] = strings.TrimPrefix(strings.TrimPrefix(payee, "VPA "), "vpa ")
+				break
+			}
+			rest = rest[match[3]:]
+		}
+		if match := textDate.FindStringSubmatch(text); match != nil {
+			groups["date"] = match[1]
+		}
+	}
+
+	currency := strings.ToLower(groups["currency"])
+	currency = cmp.Or(textCurrencies[currency], strings.ToUpper(currency))
+	amount, currency, err := d.parseTransactionAmount(currency + groups["amount"])
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Amount, transaction.Currency = amount, currency
+	transaction.Payee = strings.TrimRight(strings.TrimSpace(groups["payee"]), ".")
+	transaction.Account = cmp.Or(strings.TrimSpace(groups["account"]), template.Account)
+	transaction.Type = template.Type
+	if transaction.Type == "" {
+		transaction.Type = Expense
+		expense, income := textExpenseWord.FindStringIndex(text), textIncomeWord.FindStringIndex(text)
+		if income != nil && (expense == nil || income[0] < expense[0]) {
+			transaction.Type = Income
+		}
+	}
+	if date := groups["date"]; date != "" {
+		layouts := textDateLayouts
+		if template.DateLayout != "" {
+			layouts = []string{template.DateLayout}
+		}
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, date); err == nil && !parsed.After(transaction.Date) {
+				transaction.Date = parsed
+				break
+			}
+		}
+	}
+	transaction.Category = d.categorize(transaction, template.Category)
+	return transaction, nil
+}
+
+// add a transaction read from an alert text, a text that was added before is refused
+func (d *Data) addFromText(text string, overrides func(*Transaction)) (Transaction, error) {
+	transaction, err := d.parseNotification(text)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if overrides != nil {
+		overrides(&transaction)
+	}
+	if i := slices.IndexFunc(d.Transactions, func(t Transaction) bool { return t.ExternalID == transaction.ExternalID }); i >= 0 {
+		return Transaction{}, fmt.Errorf("this text was added before as transaction %d", d.Transactions[i].ID)
+	}
+	if err := d.appendTransaction(transaction); err != nil {
+		return Transaction{}, err
+	}
+	return d.Transactions[len(d.Transactions)-1], nil
+}
+
+// amounts in another currency are not converted, say so once they are stored
+func (d *Data) noteForeignCurrency(transaction Transaction) {
+	if transaction.foreign() {
+		fmt.Printf("Note: kept in %s, which has no rate to %s, so totals, budgets and balances leave it out.\n", transaction.Currency, cmp.Or(d.Currency, "USD"))
+	}
+}
+
+// kept in another currency than the book's; with no rate to convert it, totals, budgets and balances leave it out
+func (t Transaction) foreign() bool {
+	return t.Currency != ""
+}
+
+// the currency a transaction's amount is in, the book's unless it is foreign
+func (d *Data) currencyOf(t Transaction) string {
+	return cmp.Or(t.Currency, d.Currency, "USD")
+}
+
+// the line reports print below figures that left out transactions in other currencies
+func (d *Data) noteLeftOut(filter Filter) {
+	count, currencies := 0, make(map[string]bool)
+	for transaction := range d.Query(filter) {
+		if transaction.foreign() {
+			count, currencies[transaction.Currency] = count+1, true
+		}
+	}
+	if count > 0 {
+		fmt.Printf("Left out: %d transaction(s) in %s, which have no rate to %s.\n", count, strings.Join(slices.Sorted(maps.Keys(currencies)), ", "), cmp.Or(d.Currency, "USD"))
+	}
+}
+
+func parseFloat(amountStr string) (float64, error) {
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, amountStr)
+	}
+	return amount, nil
+}
+
+// the one-line form of add: an amount, a category, then any of a description, @date and #tags in any order,
+// e.g. 12.50 Food "lunch" @2024-03-05 #work; a + before the amount makes it income, the date defaults to today
+func (d *Data) parseQuickAdd(args []string) (Transaction, error) {
+	usage := fmt.Errorf(`usage: add <amount> <category> ["description"] [@YYYY-MM-DD] [#tag]...`)
+	if len(args) == 0 {
+		return Transaction{}, usage
+	}
+	today := d.today()
+	transaction := Transaction{Date: today, Type: Expense}
+	amountStr := args[0]
+	if rest, ok := strings.CutPrefix(amountStr, "+"); ok {
+		transaction.Type, amountStr = Income, rest
+	}
+	amount, currency, err := d.parseTransactionAmount(amountStr)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Amount, transaction.Currency = amount, currency
+	if err := validateAmount(transaction); err != nil {
+		return Transaction{}, err
+	}
+	if len(args) < 2 {
+		return Transaction{}, usage
+	}
+	transaction.Category = args[1]
+	var description []string
+	for _, arg := range args[2:] {
+		switch {
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			if transaction.Date, err = ParseFlexibleDate(arg[1:], today); err != nil {
+				return Transaction{}, err
+			}
+		case strings.HasPrefix(arg, "#") && len(arg) > 1:
+			if !slices.Contains(transaction.Tags, arg[1:]) {
+				transaction.Tags = append(transaction.Tags, arg[1:])
+			}
+		default:
+			description = append(description, arg)
+		}
+	}
+	transaction.Description = strings.Join(description, " ")
+	return transaction, nil
+}
+
+// add a new transaction
+func (d *Data) addTransaction(date time.Time, transactionType, category string, amount float64, description string) error {
+	return d.appendTransaction(Transaction{Date: date, Type: transactionType, Category: category, Amount: amount, Description: description})
+}
+
+// validate and store a fully built transaction
+func (d *Data) appendTransaction(transaction Transaction) error {
+	if transaction.Type != Income && transaction.Type != Expense {
+		return fmt.Errorf("%w: %s", ErrInvalidType, transaction.Type)
+	}
+	if err := validateAmount(transaction); err != nil {
+		return err
+	}
+	if err := validateSplits(transaction); err != nil {
+		return err
+	}
+	if err := d.canonicalCategories(&transaction); err != nil {
+		return err
+	}
+	d.lastID++
+	transaction.ID = d.lastID
+	d.Transactions = append(d.Transactions, transaction)
+	if d.byDate != nil {
+		d.byDate.appended()
+	}
+	if d.totals != nil {
+		d.totals.add(transaction, 1)
+	}
+	if d.classifier != nil {
+		d.classifier.Learn(transaction)
+	}
+	return nil
+}
+
+// amounts are stored positive and the type carries the direction
+func validateAmount(transaction Transaction) error {
+	if math.IsNaN(transaction.Amount) || math.IsInf(transaction.Amount, 0) {
+		return fmt.Errorf("%w %v", ErrInvalidAmount, transaction.Amount)
+	}
+	if transaction.Amount < 0 {
+		return fmt.Errorf("%w %v: amounts are positive and the type gives the direction, did you mean an %s of %v?",
+			ErrInvalidAmount, transaction.Amount, opposite(transaction.Type), -transaction.Amount)
+	}
+	for _, split := range transaction.Splits {
+		if split.Amount < 0 {
+			return fmt.Errorf("%w %v for line item %s, amounts are positive", ErrInvalidAmount, split.Amount, split.Category)
+		}
+	}
+	return nil
+}
+
+func opposite(transactionType string) string {
+	if transactionType == Expense {
+		return Income
+	}
+	return Expense
+}
+
+// bring a signed amount from a bank export to the stored convention: without a type the sign
+// decides (negative is money out), an expense written as negative is turned around, and a negative
+// income is refused since it may be a refund or a reversal that needs a look
+func normalizeSign(transaction Transaction) (Transaction, error) {
+	switch {
+	case transaction.Type == "":
+		transaction.Type = Income
+		if transaction.Amount < 0 {
+			transaction.Type = Expense
+		}
+	case strings.EqualFold(transaction.Type, Income):
+		transaction.Type = Income
+	case strings.EqualFold(transaction.Type, Expense):
+		transaction.Type = Expense
+	}
+	if transaction.Amount >= 0 {
+		return transaction, nil
+	}
+	if transaction.Type == Income {
+		return transaction, fmt.Errorf("%w %v for an income, record it as an expense of %v if it is money going out",
+			ErrInvalidAmount, transaction.Amount, -transaction.Amount)
+	}
+	transaction.Amount = -transaction.Amount
+	for i := range transaction.Splits {
+		transaction.Splits[i].Amount = -transaction.Splits[i].Amount
+	}
+	return transaction, nil
+}
+
+func validateSplits(transaction Transaction) error {
+	if len(transaction.Splits) == 0 {
+		return nil
+	}
+	if len(transaction.Splits) < 2 {
+		return fmt.Errorf("a split transaction needs at least two line items")
+	}
+	total := 0.0
+	for _, split := range transaction.Splits {
+		if split.Category == "" {
+			return fmt.Errorf("every line item needs a category")
+		}
+		total += split.Amount
+	}
+	if math.Abs(total-transaction.Amount) >= 0.005 {
+		return fmt.Errorf("line items add up to %.2f but the transaction is %.2f", total, transaction.Amount)
+	}
+	return nil
+}
+
+// set the monthly budget for a category, a zero amount removes it
+func (d *Data) setBudget(category string, amount float64) error {
+	if amount < 0 {
+		return fmt.Errorf("budget must not be negative: %.2f", amount)
+	}
+	if amount == 0 {
+		delete(d.Budgets, category)
+		return nil
+	}
+	if d.Budgets == nil {
+		d.Budgets = make(map[string]float64)
+	}
+	d.Budgets[category] = amount
+	return nil
+}
+
+// read a historical rates CSV (date,from,to,rate) and keep the rates for one currency pair, oldest first
+func loadExchangeRates(filename, from, to string) ([]exchangeRate, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rates file: %w", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file: %w", err)
+	}
+	if len(records) <= 1 {
+		return nil, fmt.Errorf("empty or invalid rates file")
+	}
+
+	rates := make([]exchangeRate, 0)
+	for i, record := range records[1:] {
+		if len(record) != 4 {
+			return nil, fmt.Errorf("rates line %d: expected 4 fields, got %d", i+2, len(record))
+		}
+		if !strings.EqualFold(record[1], from) || !strings.EqualFold(record[2], to) {
+			continue
+		}
+		date, err := parseDate(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("rates line %d: %w", i+2, err)
+		}
+		rate, err := parseFloat(record[3])
+		if err != nil {
+			return nil, fmt.Errorf("rates line %d: %w", i+2, err)
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("rates line %d: rate must be positive", i+2)
+		}
+		rates = append(rates, exchangeRate{Date: date, Rate: rate})
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no %s to %s rates found in %s", from, to, filename)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Date.Before(rates[j].Date) })
+	return rates, nil
+}
+
+// the rate in effect on date is the latest one published on or before it
+func rateOn(rates []exchangeRate, date time.Time) (float64, bool) {
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date.After(date) })
+	if i == 0 {
+		return 0, false
+	}
+	return rates[i-1].Rate, true
+}
+
+// convert every amount in the book to another base currency, nothing is changed unless all dated amounts have a rate
+func (d *Data) migrateCurrency(from, to, ratesFile string) (*CurrencyMigration, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == "" || to == "" || from == to {
+		return nil, fmt.Errorf("source and target currencies must be set and differ")
+	}
+	if d.Currency != "" && d.Currency != from {
+		return nil, fmt.Errorf("book is kept in %s, not %s", d.Currency, from)
+	}
+	rates, err := loadExchangeRates(ratesFile, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// amounts that happened on a day use that day's rate, those that apply going forward like budgets
+	// and limits use the most recent one; nothing is written until every rate is known
+	latest := rates[len(rates)-1]
+	migration := &CurrencyMigration{At: d.now(), From: from, To: to, RatesFile: ratesFile}
+	targets := make([]*float64, 0)
+	convert := func(field string, id int, name string, date time.Time, amount *float64) error {
+		if *amount == 0 {
+			return nil
+		}
+		rate, ok := latest.Rate, true
+		if date.IsZero() {
+			date = latest.Date
+		} else if rate, ok = rateOn(rates, date); !ok {
+			label := field
+			if id != 0 {
+				label = fmt.Sprintf("%s %d", field, id)
+			} else if name != "" {
+				label = field + " " + name
+			}
+			return fmt.Errorf("no %s to %s rate on or before %s (%s)", from, to, date.Format("2006-01-02"), label)
+		}
+		migration.Conversions = append(migration.Conversions, AmountConversion{
+			Field: field, ID: id, Name: name, Date: date,
+			OldAmount: *amount, Rate: rate, NewAmount: math.Round(*amount*rate*100) / 100,
+		})
+		targets = append(targets, amount)
+		return nil
+	}
+	converted := make([]*Transaction, 0)
+	transaction := func(field string, transaction *Transaction) error {
+		if transaction.Currency != "" {
+			return nil // already in a currency of its own
+		}
+		if err := convert(field, transaction.ID, transaction.Category, transaction.Date, &transaction.Amount); err != nil {
+			return err
+		}
+		for j := range transaction.Splits {
+			if err := convert("split", transaction.ID, transaction.Splits[j].Category, transaction.Date, &transaction.Splits[j].Amount); err != nil {
+				return err
+			}
+		}
+		converted = append(converted, transaction)
+		return nil
+	}
+	for i := range d.Transactions {
+		if err := transaction("transaction", &d.Transactions[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Trash {
+		if err := transaction("trash", &d.Trash[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Conflicts {
+		if err := transaction("conflict", &d.Conflicts[i].Kept); err != nil {
+			return nil, err
+		}
+		if err := transaction("conflict", &d.Conflicts[i].Discarded); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Accounts {
+		if err := convert("opening balance", 0, d.Accounts[i].Name, d.Accounts[i].OpeningDate, &d.Accounts[i].OpeningBalance); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Allocations {
+		month, _ := time.Parse("2006-01", d.Allocations[i].Month)
+		if err := convert("allocation", 0, d.Allocations[i].Category, month, &d.Allocations[i].Amount); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Settlements {
+		if err := convert("settlement", 0, d.Settlements[i].From+" to "+d.Settlements[i].To, d.Settlements[i].Date, &d.Settlements[i].Amount); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Loans {
+		if err := convert("loan", 0, d.Loans[i].Name, d.Loans[i].Start, &d.Loans[i].Principal); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Holdings {
+		if err := convert("cost basis", 0, d.Holdings[i].Symbol, d.Holdings[i].Acquired, &d.Holdings[i].CostBasis); err != nil {
+			return nil, err
+		}
+	}
+	for _, symbol := range slices.Sorted(maps.Keys(d.Prices)) {
+		for i := range d.Prices[symbol] {
+			if err := convert("price", 0, symbol, d.Prices[symbol][i].Date, &d.Prices[symbol][i].Price); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range d.Recurring {
+		if err := convert("recurring", 0, d.Recurring[i].Name, time.Time{}, &d.Recurring[i].Amount); err != nil {
+			return nil, err
+		}
+	}
+	for i := range d.Alerts {
+		if err := convert("alert limit", 0, cmp.Or(d.Alerts[i].Category, "all expenses"), time.Time{}, &d.Alerts[i].Limit); err != nil {
+			return nil, err
+		}
+	}
+	if err := convert("mileage rate", 0, "", time.Time{}, &d.Settings.MileageRate); err != nil {
+		return nil, err
+	}
+	for _, category := range slices.Sorted(maps.Keys(d.Budgets)) {
+		amount := d.Budgets[category] // map values cannot be pointed at, written back below
+		if err := convert("budget", 0, category, time.Time{}, &amount); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, conversion := range migration.Conversions {
+		*targets[i] = conversion.NewAmount
+	}
+	for _, conversion := range migration.Conversions {
+		if conversion.Field == "budget" {
+			d.Budgets[conversion.Name] = conversion.NewAmount
+		}
+	}
+	// rounding each line on its own can leave the lines a cent off the total, the last line takes the difference
+	for _, transaction := range converted {
+		if splits := transaction.Splits; len(splits) > 0 {
+			rest := transaction.Amount
+			for _, split := range splits[:len(splits)-1] {
+				rest -= split.Amount
+			}
+			splits[len(splits)-1].Amount = math.Round(rest*100) / 100
+		}
+	}
+	for _, list := range [][]Transaction{d.Transactions, d.Trash} {
+		for i := range list {
+			if list[i].Currency == to {
+				list[i].Currency = ""
+			}
+		}
+	}
+	d.Currency = to
+	d.Migrations = append(d.Migrations, *migration)
+	d.byDate, d.totals = nil, nil
+	return migration, nil
+}
+
+func (d *Data) displayMigration(migration *CurrencyMigration) {
+	fmt.Printf("Converted book from %s to %s using %s:\n", migration.From, migration.To, migration.RatesFile)
+	for _, conversion := range migration.Conversions {
+		label := conversion.Field
+		if conversion.ID != 0 {
+			label = fmt.Sprintf("%s #%d", conversion.Field, conversion.ID)
+		}
+		fmt.Printf("  %-16s %s %-12s %14s x %.6f = %14s\n", label, conversion.Date.Format("2006-01-02"), conversion.Name,
+			d.Settings.formatAmount(conversion.OldAmount, migration.From), conversion.Rate, d.Settings.formatAmount(conversion.NewAmount, migration.To))
+	}
+}
+
+// attach a note to a category, an empty note removes it
+func (d *Data) setCategoryNote(category, note string) error {
+	if category == "" {
+		return fmt.Errorf("category must not be empty")
+	}
+	note = strings.TrimSpace(note)
+	if note == "" {
+		delete(d.Notes, category)
+		return nil
+	}
+	if d.Notes == nil {
+		d.Notes = make(map[string]string)
+	}
+	d.Notes[category] = note
+	return nil
+}
+
+// a record that could not be imported; Line 0 means the whole input was rejected,
+// Field names the column at fault when it is known
+type ParseError struct {
+	Line   int
+	Field  string
+	Record string
+	Cause  error
+}
+
+func (e ParseError) Error() string {
+	cause := e.Cause.Error()
+	if e.Field != "" {
+		cause = e.Field + ": " + cause
+	}
+	switch {
+	case e.Line == 0:
+		return cause
+	case e.Record == "":
+		return fmt.Sprintf("record %d: %s", e.Line, cause)
+	}
+	return fmt.Sprintf("record %d (%s): %s", e.Line, e.Record, cause)
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Cause
+}
+
+// a file format transactions can be imported from
+type Importer interface {
+	Detect(r io.Reader) bool // whether the input looks like this format
+	Parse(r io.Reader) ([]Transaction, []ParseError)
+}
+
+type namedImporter struct {
+	name     string
+	importer Importer
+}
+
+// import formats in detection order, a new format only needs an entry here; our own
+// CSV layout accepts nearly any CSV so it comes last
+var importers = []namedImporter{
+	{"ofx", ofxImporter{}},
+	{"qif", qifImporter{}},
+	{"json", jsonImporter{}},
+	{"mint", profileImporter{importProfiles["mint"]}},
+	{"ynab", profileImporter{importProfiles["ynab"]}},
+	{"csv", csvImporter{}},
+}
+
+// the built-in importers with those of plugins ahead of the CSV catch-all
+func availableImporters() []namedImporter {
+	available := slices.Clone(importers[:len(importers)-1])
+	for _, p := range findPlugins() {
+		if p.can("import") {
+			available = append(available, namedImporter{p.Name, pluginImporter{p}})
+		}
+	}
+	return append(available, importers[len(importers)-1])
+}
+
+// the importer named by format, or the first that recognizes content when format is empty
+func findImporter(format string, content []byte) (Importer, error) {
+	available := availableImporters()
+	names := make([]string, len(available))
+	for i, entry := range available {
+		names[i] = entry.name
+		if format == "" && entry.importer.Detect(bytes.NewReader(content)) || strings.EqualFold(format, entry.name) {
+			return entry.importer, nil
+		}
+	}
+	if format != "" {
+		return nil, fmt.Errorf("unknown import format %q, use %s", format, strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("could not recognize the file, name its format with --format %s", strings.Join(names, "|"))
+}
+
+// a row an import left out and why
+type SkipReason struct {
+	Line   int    `json:"line,omitempty"` // record number in the file, 0 when the row was parsed fine but refused later
+	Field  string `json:"field,omitempty"`
+	Record string `json:"record,omitempty"`
+	Kind   string `json:"kind"` // invalid, transfer or duplicate
+	Reason string `json:"reason"`
+}
+
+const (
+	skipInvalid   = "invalid"
+	skipTransfer  = "transfer"
+	skipDuplicate = "duplicate"
+)
+
+func (s SkipReason) String() string {
+	reason := s.Reason
+	if s.Field != "" {
+		reason = s.Field + ": " + reason
+	}
+	if s.Line == 0 {
+		return fmt.Sprintf("%s: %s", s.Record, reason)
+	}
+	return fmt.Sprintf("record %d (%s): %s", s.Line, s.Record, reason)
+}
+
+// what an import added and what it left out, reporting is up to the caller
+type ImportResult struct {
+	Batch     int          `json:"batch,omitempty"` // the batch the imported transactions are tagged with
+	Imported  int          `json:"imported"`
+	Skipped   []SkipReason `json:"skipped"`
+	Cancelled int          `json:"cancelled,omitempty"` // rows left unread when the import was stopped
+}
+
+// one run of import, its transactions carry its ID
+type ImportBatch struct {
+	ID         int       `json:"id"`
+	Source     string    `json:"source"` // the file the transactions were read from
+	Account    string    `json:"account,omitempty"`
+	At         time.Time `json:"at"`
+	Count      int       `json:"count"`                // transactions it added
+	RolledBack time.Time `json:"rolled_back,omitzero"` // when its transactions were moved to the trash
+	Checksum   string    `json:"checksum,omitempty"`   // SHA-256 of the file, so the same file is not imported twice by watch
+}
+
+// move every transaction an import added, and still in the book, to the trash; returns how many went
+func (d *Data) rollbackBatch(id int, at time.Time) (int, error) {
+	i := slices.IndexFunc(d.Batches, func(b ImportBatch) bool { return b.ID == id })
+	if i < 0 {
+		return 0, fmt.Errorf("no import batch %d, see batches list", id)
+	}
+	if batch := d.Batches[i]; !batch.RolledBack.IsZero() {
+		return 0, fmt.Errorf("import batch %d was rolled back on %s", id, batch.RolledBack.In(d.Settings.location()).Format("2006-01-02 15:04"))
+	}
+	var ids []int
+	for _, transaction := range d.Transactions {
+		if transaction.Batch == id {
+			ids = append(ids, transaction.ID)
+		}
+	}
+	if err := d.trash(ids, at); err != nil {
+		return 0, err
+	}
+	d.Batches[i].RolledBack = at
+	return len(ids), nil
+}
+
+func (d *Data) displayBatches() error {
+	left := make(map[int]int)
+	for _, transaction := range d.Transactions {
+		left[transaction.Batch]++
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(d.Batches))
+		for i, b := range d.Batches {
+			rolledBack := ""
+			if !b.RolledBack.IsZero() {
+				rolledBack = b.RolledBack.Format(time.RFC3339)
+			}
+			rows[i] = []any{b.ID, b.At.Format(time.RFC3339), b.Source, b.Account, b.Count, left[b.ID], rolledBack}
+		}
+		return emit(d.Batches, []string{"id", "at", "source", "account", "count", "in_book", "rolled_back"}, rows)
+	}
+	if len(d.Batches) == 0 {
+		fmt.Println("No imports yet.")
+		return nil
+	}
+	table := newTable("ID", "Imported", "Source", "Account", "Count", "In book", "Rolled back").alignRight(0, 4, 5)
+	for _, b := range d.Batches {
+		rolledBack := ""
+		if !b.RolledBack.IsZero() {
+			rolledBack = b.RolledBack.In(d.Settings.location()).Format("2006-01-02 15:04")
+		}
+		table.addRow(plainCell(strconv.Itoa(b.ID)), plainCell(b.At.In(d.Settings.location()).Format("2006-01-02 15:04")), plainCell(b.Source),
+			plainCell(b.Account), plainCell(strconv.Itoa(b.Count)), plainCell(strconv.Itoa(left[b.ID])), plainCell(rolledBack))
+	}
+	table.print()
+	return nil
+}
+
+func (r ImportResult) count(kind string) int {
+	count := 0
+	for _, skipped := range r.Skipped {
+		if skipped.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// import a file in the given format, detected when empty; the account given on the command line wins
+// over the file's, uncategorized transactions go through the rules and bank IDs seen before are skipped.
+// Invalid rows are skipped unless strict is set, then the first one aborts the import and nothing is added.
+func (d *Data) importTransactions(ctx context.Context, filename string, account string, format string, strict bool) (ImportResult, error) {
+	var result ImportResult
+	content, err := readImportFile(filename)
+	if err != nil {
+		return result, err
+	}
+	importer, err := findImporter(format, content)
+	if err != nil {
+		return result, err
+	}
+	transactions, problems := importer.Parse(bytes.NewReader(content))
+	if len(transactions) == 0 && len(problems) == 1 && problems[0].Line == 0 {
+		return result, problems[0]
+	}
+
+	for _, problem := range problems {
+		if errors.Is(problem, errSkipRow) {
+			result.Skipped = append(result.Skipped, SkipReason{Line: problem.Line, Record: problem.Record, Kind: skipTransfer, Reason: "transfer between accounts"})
+			continue
+		}
+		if strict {
+			return result, fmt.Errorf("import aborted, nothing was imported: %w", problem)
+		}
+		result.Skipped = append(result.Skipped, SkipReason{Line: problem.Line, Field: problem.Field, Record: problem.Record, Kind: skipInvalid, Reason: problem.Cause.Error()})
+	}
+	known := make(map[string]bool)
+	existing := make(map[string]int) // overlapping statement exports repeat rows that carry no ID
+	for _, transaction := range d.Transactions {
+		if transaction.ExternalID != "" {
+			known[transaction.ExternalID] = true
+		}
+		existing[rowFingerprint(transaction)]++
+	}
+	categories := d.categories()
+	count, lastID := len(d.Transactions), d.lastID
+	sum := sha256.Sum256(content)
+	batch := ImportBatch{Source: filename, Account: account, At: d.now().UTC(), Checksum: hex.EncodeToString(sum[:])}
+	for _, b := range d.Batches {
+		batch.ID = max(batch.ID, b.ID)
+	}
+	batch.ID++
+	if filename == "-" {
+		batch.Source = "stdin"
+	} else if path, err := filepath.Abs(filename); err == nil {
+		batch.Source = path
+	}
+	progress := newProgressBar("Importing", len(transactions), "rows")
+	defer progress.finish()
+	for i, transaction := range transactions {
+		progress.update(i)
+		if ctx.Err() != nil {
+			result.Cancelled = len(transactions) - i
+			break
+		}
+		if transaction.ExternalID != "" && known[transaction.ExternalID] {
+			result.Skipped = append(result.Skipped, SkipReason{Record: transaction.ExternalID, Kind: skipDuplicate, Reason: "imported before"})
+			continue
+		}
+		transaction, err := normalizeSign(transaction)
+		if fingerprint := rowFingerprint(transaction); err == nil && transaction.ExternalID == "" && existing[fingerprint] > 0 {
+			existing[fingerprint]-- // one stored copy answers for one row, so a file may still repeat a row
+			result.Skipped = append(result.Skipped, SkipReason{Record: transaction.Date.Format("2006-01-02") + " " + transaction.Description,
+				Kind: skipDuplicate, Reason: "matches a transaction in the book"})
+			continue
+		}
+		if err == nil {
+			transaction.Account = cmp.Or(account, transaction.Account)
+			transaction.Batch = batch.ID
+			if transaction.Category == "" {
+				transaction.Category = d.categorize(transaction, "")
+			} else if match, distance := MatchCategory(transaction.Category, categories); distance == 0 {
+				transaction.Category = match // "food" files under the existing "Food"
+			}
+			err = d.appendTransaction(transaction)
+		}
+		if err != nil {
+			record := transaction.Date.Format("2006-01-02") + " " + transaction.Description
+			if strict {
+				d.truncate(count, lastID)
+				return ImportResult{}, fmt.Errorf("import aborted, nothing was imported: %s: %w", record, err)
+			}
+			result.Skipped = append(result.Skipped, SkipReason{Record: record, Kind: skipInvalid, Reason: err.Error()})
+			continue
+		}
+		known[transaction.ExternalID] = transaction.ExternalID != ""
+		result.Imported++
+	}
+	if result.Imported > 0 {
+		batch.Count, result.Batch = result.Imported, batch.ID
+		d.Batches = append(d.Batches, batch)
+	}
+	return result, nil
+}
+
+// rows with the same day, type, amount and description, ignoring case, spacing and punctuation, are taken
+// to be the same bank entry
+func rowFingerprint(transaction Transaction) string {
+	words := strings.FieldsFunc(strings.ToLower(transaction.Description), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	return fmt.Sprintf("%s|%s|%d|%s", transaction.Date.Format("2006-01-02"), transaction.Type, int64(math.Round(transaction.Amount*100)), strings.Join(words, " "))
+}
+
+// the file to import, or what is pasted or piped in when it is "-"
+func readImportFile(filename string) ([]byte, error) {
+	if filename != "-" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		return content, nil
+	}
+	if stdinIsTerminal() {
+		fmt.Fprintln(os.Stderr, "Paste the rows to import, then press Ctrl+D on an empty line.")
+	}
+	content, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("nothing to import, stdin was empty")
+	}
+	return content, nil
+}
+
+// how watch picks up and files away downloads
+type WatchOptions struct {
+	Dir      string
+	Archive  string // where imported files go, Dir/imported when empty; files that fail go to Dir/failed
+	Account  string
+	Format   string
+	Interval time.Duration
+	Once     bool // one pass over the folder instead of polling until Ctrl+C
+}
+
+// files watch imports, anything else in the folder (like a .crdownload still being written) is left alone
+var watchedExtensions = []string{".csv", ".xlsx", ".ofx", ".qfx", ".qif", ".json"}
+
+// a file modified this recently may still be downloading and is picked up on a later pass
+const watchSettle = 2 * time.Second
+
+// import every new statement that lands in a folder, saving after each file and moving it to the archive
+// so it is never read twice; a file with the same content as an earlier import is archived unread
+func (d *Data) watchFolder(ctx context.Context, options WatchOptions, save func() error) error {
+	failed := filepath.Join(options.Dir, "failed")
+	if info, err := os.Stat(options.Dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("cannot watch %s: not a folder", options.Dir)
+	}
+	for {
+		entries, err := os.ReadDir(options.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", options.Dir, err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || !slices.Contains(watchedExtensions, strings.ToLower(filepath.Ext(entry.Name()))) ||
+				time.Since(info.ModTime()) < watchSettle {
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			filename := filepath.Join(options.Dir, entry.Name())
+			target, err := d.watchImport(ctx, filename, options, save)
+			if ctx.Err() != nil {
+				return nil // left in place, the next watch imports it
+			}
+			if err != nil {
+				slog.Error("import failed", "file", entry.Name(), "error", err)
+				target = failed
+			}
+			if err := moveInto(filename, target); err != nil {
+				return err
+			}
+			fmt.Printf("Moved %s to %s.\n", entry.Name(), target)
+		}
+		if options.Once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(options.Interval):
+		}
+	}
+}
+
+// import one file for watch, returns the folder it belongs in afterwards
+func (d *Data) watchImport(ctx context.Context, filename string, options WatchOptions, save func() error) (string, error) {
+	archive := cmp.Or(options.Archive, filepath.Join(options.Dir, "imported"))
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	for _, batch := range d.Batches {
+		if batch.Checksum == hex.EncodeToString(sum[:]) && batch.RolledBack.IsZero() {
+			slog.Info("skipped a file imported before", "file", filepath.Base(filename), "batch", batch.ID)
+			return archive, nil
+		}
+	}
+	count, lastID := len(d.Transactions), d.lastID
+	result, err := d.importTransactions(ctx, filename, options.Account, options.Format, false)
+	if err != nil {
+		return "", err
+	}
+	if result.Cancelled > 0 {
+		d.truncate(count, lastID)
+		d.Batches = slices.DeleteFunc(d.Batches, func(b ImportBatch) bool { return b.ID == result.Batch })
+		return "", nil
+	}
+	fmt.Printf("%s: ", filepath.Base(filename))
+	displayImportResult(result)
+	if err := save(); err != nil {
+		return "", err
+	}
+	d.raiseAlerts(count)
+	return archive, nil
+}
+
+// move a file into a folder, created when missing, without overwriting a file of the same name there
+func moveInto(filename, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Base(filename)
+	ext := filepath.Ext(name)
+	target := filepath.Join(dir, name)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext))
+	}
+	return os.Rename(filename, target)
+}
+
+// drop what was appended after the first count transactions and hand out the IDs after lastID again
+func (d *Data) truncate(count, lastID int) {
+	d.Transactions, d.lastID = d.Transactions[:count], lastID
+	d.reindex()
+}
+
+// import with a progress bar that Ctrl+C stops, offering to take back what was imported by then
+func (d *Data) importInteractively(filename string, account string, format string, strict bool) (ImportResult, error) {
+	ctx, done := interruptible()
+	count, lastID := len(d.Transactions), d.lastID
+	result, err := d.importTransactions(ctx, filename, account, format, strict)
+	done()
+	if err != nil || result.Cancelled == 0 {
+		return result, err
+	}
+	fmt.Printf("Import stopped, %d transactions were imported and %d rows were left.\n", result.Imported, result.Cancelled)
+	if result.Imported > 0 && !strings.HasPrefix(strings.ToLower(prompt("Roll back the transactions imported so far? (y/n)", "y")), "y") {
+		return result, nil
+	}
+	d.truncate(count, lastID)
+	d.Batches = slices.DeleteFunc(d.Batches, func(b ImportBatch) bool { return b.ID == result.Batch })
+	return ImportResult{}, fmt.Errorf("import stopped, nothing was imported")
+}
+
+// a progress line on stderr for work that takes a while, never shown when stderr is not a terminal
+type progressBar struct {
+	label string
+	unit  string
+	total int // 0 when unknown, the rate is shown alone then
+	start time.Time
+	shown time.Time
+	drawn bool
+}
+
+func newProgressBar(label string, total int, unit string) *progressBar {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	now := time.Now()
+	return &progressBar{label: label, unit: unit, total: total, start: now, shown: now}
+}
+
+// redrawn at most ten times a second, and only once the work has run for a moment
+func (p *progressBar) update(done int) {
+	if p == nil || time.Since(p.shown) < 100*time.Millisecond {
+		return
+	}
+	p.shown, p.drawn = time.Now(), true
+	rate := float64(done) / time.Since(p.start).Seconds()
+	if p.total == 0 {
+		fmt.Fprintf(os.Stderr, "\r%s %d %s, %.0f %s/s ", p.label, done, p.unit, rate, p.unit)
+		return
+	}
+	const width = 30
+	filled := width * done / p.total
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %d/%d %s, %.0f %s/s ", p.label, strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+		done, p.total, p.unit, rate, p.unit)
+}
+
+func (p *progressBar) finish() {
+	if p != nil && p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+func displayImportResult(result ImportResult) {
+	for _, skipped := range result.Skipped {
+		level := slog.LevelDebug
+		if skipped.Kind == skipInvalid {
+			level = slog.LevelInfo
+		}
+		slog.Log(context.Background(), level, "skipped import row", "kind", skipped.Kind, "line", skipped.Line, "record", skipped.Record, "field", skipped.Field, "reason", skipped.Reason)
+	}
+	if transfers := result.count(skipTransfer); transfers > 0 {
+		fmt.Printf("Skipped %d transfers between accounts.\n", transfers)
+	}
+	if duplicates := result.count(skipDuplicate); duplicates > 0 {
+		fmt.Printf("Skipped %d transactions imported before.\n", duplicates)
+	}
+	fmt.Printf("Imported %d transactions", result.Imported)
+	if result.Batch != 0 {
+		fmt.Printf(" as batch %d", result.Batch)
+	}
+	if invalid := result.count(skipInvalid); invalid > 0 {
+		fmt.Printf(", %d invalid rows were skipped, --verbose lists them and --strict stops at the first one", invalid)
+	}
+	fmt.Println(".")
+}
+
+// rows of a CSV file or of the first sheet of an Excel workbook
+func readRecords(r io.Reader) ([][]string, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+		return readXLSX(content)
+	}
+	content = bytes.TrimPrefix(content, []byte("\ufeff")) // YNAB starts its exports with a byte order mark
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1 // the payee column is optional
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV data: %w", err)
+	}
+	return records, nil
+}
+
+// our own layout: date, type, category, amount, description and an optional payee
+type csvImporter struct{}
+
+func (csvImporter) Detect(r io.Reader) bool {
+	records, err := readRecords(r)
+	if err != nil || len(records) == 0 {
+		return false
+	}
+	_, err = nativeColumns(records[:1])
+	return err == nil || len(records[0]) == 5 || len(records[0]) == 6
+}
+
+func (csvImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	records, err := readRecords(r)
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	if len(records) <= 1 {
+		return nil, []ParseError{{Cause: fmt.Errorf("empty or invalid import file")}}
+	}
+	named := false
+	if reordered, err := nativeColumns(records); err == nil { // columns are matched by their header when it names them
+		records, named = reordered, true
+	} else if len(records[0]) != 5 && len(records[0]) != 6 {
+		return nil, []ParseError{{Cause: err}}
+	}
+
+	var transactions []Transaction
+	var problems []ParseError
+	for i, record := range records[1:] {
+		fail := func(field string, err error) {
+			problems = append(problems, ParseError{Line: i + 2, Field: field, Record: strings.Join(record, ","), Cause: err})
+		}
+		if !named && len(record) != 5 && len(record) != 6 {
+			fail("", fmt.Errorf("invalid number of fields"))
+			continue
+		}
+		date, err := parseDate(record[0])
+		if err != nil {
+			fail("date", err)
+			continue
+		}
+		if record[1] != "" && !strings.EqualFold(record[1], Income) && !strings.EqualFold(record[1], Expense) {
+			fail("type", fmt.Errorf("%w %q, use Income or Expense", ErrInvalidType, record[1]))
+			continue
+		}
+		amount, err := parseFloat(record[3])
+		if err != nil {
+			fail("amount", err)
+			continue
+		}
+		payee := ""
+		if len(record) >= 6 {
+			payee = strings.TrimSpace(record[5])
+		}
+		transaction := Transaction{Date: date, Type: record[1], Category: record[2], Amount: amount, Description: record[4], Payee: payee}
+		if named {
+			transaction.Notes = strings.TrimSpace(record[6])
+			for j, column := range records[0][7:] {
+				transaction.setMetadata(strings.TrimPrefix(column, "meta:"), strings.TrimSpace(record[7+j]))
+			}
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, problems
+}
+
+// the export of another tool, recognized by its header
+type profileImporter struct {
+	profile importProfile
+}
+
+func (p profileImporter) header(records [][]string) (map[string]int, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty or invalid import file")
+	}
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[strings.TrimSpace(name)] = i
+	}
+	for _, column := range p.profile.columns {
+		if _, ok := header[column]; !ok {
+			return nil, fmt.Errorf("not a %s export, column %q is missing", p.profile.name, column)
+		}
+	}
+	return header, nil
+}
+
+func (p profileImporter) Detect(r io.Reader) bool {
+	records, err := readRecords(r)
+	if err != nil {
+		return false
+	}
+	_, err = p.header(records)
+	return err == nil
+}
+
+func (p profileImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	records, err := readRecords(r)
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	header, err := p.header(records)
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	var transactions []Transaction
+	var problems []ParseError
+	for i, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for name, index := range header {
+			if index < len(record) {
+				row[name] = strings.TrimSpace(record[index])
+			}
+		}
+		transaction, err := p.profile.convert(row)
+		if err != nil {
+			problems = append(problems, ParseError{Line: i + 2, Record: strings.Join(record, ","), Cause: err})
+			continue
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, problems
+}
+
+// Open Financial Exchange statements as banks offer them for download, in the SGML or the XML flavour
+type ofxImporter struct{}
+
+func (ofxImporter) Detect(r io.Reader) bool {
+	start := make([]byte, 1024)
+	n, _ := io.ReadFull(r, start)
+	text := strings.ToUpper(string(start[:n]))
+	return strings.Contains(text, "OFXHEADER") || strings.Contains(text, "<OFX>")
+}
+
+var ofxField = regexp.MustCompile(`(?i)<(TRNTYPE|DTPOSTED|TRNAMT|FITID|NAME|PAYEE|MEMO)>([^<\r\n]*)`)
+
+func (ofxImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	blocks := strings.Split(string(content), "<STMTTRN>")
+	if len(blocks) < 2 {
+		return nil, []ParseError{{Cause: fmt.Errorf("the OFX file holds no transactions")}}
+	}
+	var transactions []Transaction
+	var problems []ParseError
+	for i, block := range blocks[1:] {
+		block, _, _ = strings.Cut(block, "</STMTTRN>")
+		fields := make(map[string]string)
+		for _, match := range ofxField.FindAllStringSubmatch(block, -1) {
+			fields[strings.ToUpper(match[1])] = strings.TrimSpace(match[2])
+		}
+		fail := func(field string, err error) {
+			problems = append(problems, ParseError{Line: i + 1, Field: field, Record: cmp.Or(fields["FITID"], fields["NAME"]), Cause: err})
+		}
+		if len(fields["DTPOSTED"]) < 8 {
+			fail("DTPOSTED", fmt.Errorf("%w: missing", ErrInvalidDate))
+			continue
+		}
+		date, err := time.Parse("20060102", fields["DTPOSTED"][:8])
+		if err != nil {
+			fail("DTPOSTED", fmt.Errorf("%w %q", ErrInvalidDate, fields["DTPOSTED"]))
+			continue
+		}
+		amount, err := parseFloat(strings.ReplaceAll(fields["TRNAMT"], ",", "."))
+		if err != nil {
+			fail("TRNAMT", err)
+			continue
+		}
+		transaction := Transaction{Date: date, Type: Expense, Amount: math.Abs(amount), Description: fields["MEMO"],
+			Payee: html.UnescapeString(cmp.Or(fields["NAME"], fields["PAYEE"])), ExternalID: fields["FITID"]}
+		if amount > 0 {
+			transaction.Type = Income
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, problems
+}
+
+// Quicken Interchange Format: one field per line keyed by its first letter, records end with ^
+type qifImporter struct{}
+
+func (qifImporter) Detect(r io.Reader) bool {
+	start := make([]byte, 64)
+	n, _ := io.ReadFull(r, start)
+	return strings.HasPrefix(strings.TrimLeft(string(bytes.TrimPrefix(start[:n], []byte("\ufeff"))), " \r\n"), "!Type:")
+}
+
+// QIF dates come as 1/2/2006, 01/02/06 or Quicken's 1/2'06
+func parseQIFDate(value string) (time.Time, error) {
+	value = strings.NewReplacer("'", "/", " ", "").Replace(value)
+	for _, layout := range []string{"1/2/2006", "1/2/06", "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w %q", ErrInvalidDate, value)
+}
+
+func (qifImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	var transactions []Transaction
+	var problems []ParseError
+	scanner := bufio.NewScanner(r)
+	fields, start, line := make(map[byte]string), 1, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '!' {
+			start = line + 1
+			continue
+		}
+		if text != "^" {
+			fields[text[0]] = strings.TrimSpace(text[1:])
+			continue
+		}
+		transaction, err := qifTransaction(fields)
+		if err != nil {
+			problems = append(problems, ParseError{Line: start, Record: fields['P'], Cause: err})
+		} else {
+			transactions = append(transactions, transaction)
+		}
+		fields, start = make(map[byte]string), line+1
+	}
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, ParseError{Line: line, Cause: err})
+	}
+	return transactions, problems
+}
+
+func qifTransaction(fields map[byte]string) (Transaction, error) {
+	date, err := parseQIFDate(fields['D'])
+	if err != nil {
+		return Transaction{}, err
+	}
+	amount, err := parseMoney(cmp.Or(fields['T'], fields['U']))
+	if err != nil {
+		return Transaction{}, err
+	}
+	if strings.HasPrefix(fields['L'], "[") { // [Account] names the other side of a transfer
+		return Transaction{}, errSkipRow
+	}
+	category, _, _ := strings.Cut(fields['L'], ":") // subcategories are folded into their parent
+	transaction := Transaction{Date: date, Type: Expense, Category: category, Amount: math.Abs(amount), Description: fields['M'], Payee: fields['P']}
+	if amount > 0 {
+		transaction.Type = Income
+	}
+	return transaction, nil
+}
+
+// a data file of this program or a plain array of its transactions
+type jsonImporter struct{}
+
+func (jsonImporter) Detect(r io.Reader) bool {
+	start := make([]byte, 64)
+	n, _ := io.ReadFull(r, start)
+	text := strings.TrimLeft(string(start[:n]), " \t\r\n")
+	return strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[")
+}
+
+func (jsonImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	content, err := io.ReadAll(r)
+	if err == nil {
+		content, err = checkFooter(content) // another book as saved by the tracker
+	}
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	var book Data
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		err = json.Unmarshal(content, &book.Transactions)
+	} else {
+		err = json.Unmarshal(content, &book)
+	}
+	if err != nil {
+		return nil, []ParseError{{Cause: fmt.Errorf("failed to parse JSON: %w", err)}}
+	}
+	book.normalizeDates()
+	for i := range book.Transactions {
+		book.Transactions[i].ID = 0 // the book hands out its own
+	}
+	return book.Transactions, nil
+}
+
+// files synced transactions whose payee or description contains Match under Category
+type CategoryRule struct {
+	Match    string `json:"match"`
+	Category string `json:"category"`
+}
+
+func (d *Data) addRule(rule CategoryRule) error {
+	if strings.TrimSpace(rule.Match) == "" || strings.TrimSpace(rule.Category) == "" {
+		return fmt.Errorf("rule needs text to match and a category")
+	}
+	d.Rules = append(d.Rules, rule)
+	return nil
+}
+
+// remove a rule by its 1-based position in the list
+func (d *Data) removeRule(number int) error {
+	if number < 1 || number > len(d.Rules) {
+		return fmt.Errorf("no rule %d, see rule list", number)
+	}
+	d.Rules = slices.Delete(d.Rules, number-1, number)
+	return nil
+}
+
+func (d *Data) displayRules() {
+	if len(d.Rules) == 0 {
+		fmt.Println("No categorization rules, synced transactions take the category last used for their payee.")
+		return
+	}
+	fmt.Println("Rules:")
+	for i, rule := range d.Rules {
+		fmt.Printf("  %d. %q -> %s\n", i+1, rule.Match, rule.Category)
+	}
+}
+
+// the category for a transaction that came without one: the first matching rule, else the
+// category last used for the same payee, else the bank's own guess
+func (d *Data) categorize(transaction Transaction, guess string) string {
+	text := strings.ToLower(transaction.Payee + " " + transaction.Description)
+	for _, rule := range d.Rules {
+		if strings.Contains(text, strings.ToLower(rule.Match)) {
+			return rule.Category
+		}
+	}
+	if transaction.Payee != "" {
+		for _, other := range slices.Backward(d.Transactions) {
+			if strings.EqualFold(other.Payee, transaction.Payee) && other.Category != splitCategory {
+				return other.Category
+			}
+		}
+	}
+	if guess == "" && d.Settings.AutoCategorize > 0 {
+		if category, confidence := d.trainedClassifier().Suggest(transaction); confidence >= d.Settings.AutoCategorize {
+			return category
+		}
+	}
+	return cmp.Or(guess, uncategorized)
+}
+
+const uncategorized = "Uncategorized"
+
+// Classifier is a naive Bayes model of which words in a transaction's payee and description go
+// with which category; train it with TrainClassifier and ask it with Suggest
+type Classifier struct {
+	docs   map[string]int            // transactions learned per category
+	words  map[string]map[string]int // word counts per category
+	totals map[string]int            // words seen per category
+	vocab  map[string]bool
+	total  int
+}
+
+// the words a transaction is known by: letters and digits of the description in lower case, plus the
+// whole payee as one word so a payee counts more than any single description word
+func classifierTokens(transaction Transaction) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(transaction.Description+" "+transaction.Payee), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens = slices.DeleteFunc(tokens, func(token string) bool {
+		return utf8.RuneCountInString(token) < 2 || strings.IndexFunc(token, unicode.IsLetter) < 0
+	})
+	if payee := strings.ToLower(strings.TrimSpace(transaction.Payee)); payee != "" {
+		tokens = append(tokens, "payee:"+payee)
+	}
+	return tokens
+}
+
+// TrainClassifier learns from every categorized transaction
+func TrainClassifier(transactions []Transaction) *Classifier {
+	c := &Classifier{docs: make(map[string]int), words: make(map[string]map[string]int), totals: make(map[string]int), vocab: make(map[string]bool)}
+	for _, transaction := range transactions {
+		c.Learn(transaction)
+	}
+	return c
+}
+
+// Learn adds one transaction to the model, each line of a split one on its own; uncategorized ones
+// teach nothing
+func (c *Classifier) Learn(transaction Transaction) {
+	for _, split := range transaction.Splits {
+		c.Learn(Transaction{Category: split.Category, Payee: transaction.Payee, Description: cmp.Or(split.Description, transaction.Description)})
+	}
+	category := transaction.Category
+	tokens := classifierTokens(transaction)
+	if category == "" || category == uncategorized || category == splitCategory || len(tokens) == 0 {
+		return
+	}
+	if c.words[category] == nil {
+		c.words[category] = make(map[string]int)
+	}
+	c.docs[category]++
+	c.total++
+	for _, token := range tokens {
+		c.words[category][token]++
+		c.totals[category]++
+		c.vocab[token] = true
+	}
+}
+
+// a category with the model's confidence in it
+type categoryGuess struct {
+	Category   string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Rank returns every category the model knows with the probability that the transaction belongs
+// to it, most likely first
+func (c *Classifier) Rank(transaction Transaction) []categoryGuess {
+	tokens := classifierTokens(transaction)
+	if c.total == 0 || len(tokens) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(c.docs))
+	highest := math.Inf(-1)
+	for category, docs := range c.docs {
+		score := math.Log(float64(docs) / float64(c.total))
+		for _, token := range tokens {
+			// Laplace smoothing keeps an unseen word from ruling a category out
+			score += math.Log(float64(c.words[category][token]+1) / float64(c.totals[category]+len(c.vocab)))
+		}
+		scores[category] = score
+		highest = max(highest, score)
+	}
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp(score - highest)
+	}
+	guesses := make([]categoryGuess, 0, len(scores))
+	for category, score := range scores {
+		guesses = append(guesses, categoryGuess{category, math.Exp(score-highest) / sum})
+	}
+	slices.SortFunc(guesses, func(a, b categoryGuess) int {
+		return cmp.Or(cmp.Compare(b.Confidence, a.Confidence), strings.Compare(a.Category, b.Category))
+	})
+	return guesses
+}
+
+// Suggest returns the most likely category and its probability, "" and 0 when the model has nothing to go on
+func (c *Classifier) Suggest(transaction Transaction) (string, float64) {
+	guesses := c.Rank(transaction)
+	if len(guesses) == 0 {
+		return "", 0
+	}
+	return guesses[0].Category, guesses[0].Confidence
+}
+
+func (d *Data) trainedClassifier() *Classifier {
+	if d.classifier == nil {
+		d.classifier = TrainClassifier(d.Transactions)
+	}
+	return d.classifier
+}
+
+// file uncategorized transactions the classifier is at least threshold sure about, returns what changed
+func (d *Data) autoCategorize(threshold float64) map[int]categoryGuess {
+	changed := make(map[int]categoryGuess)
+	classifier := d.trainedClassifier()
+	for i := range d.Transactions {
+		transaction := &d.Transactions[i]
+		if transaction.Category != uncategorized && transaction.Category != "" {
+			continue
+		}
+		if category, confidence := classifier.Suggest(*transaction); category != "" && confidence >= threshold {
+			transaction.Category = category
+			changed[transaction.ID] = categoryGuess{category, confidence}
+			d.edited()
+		}
+	}
+	return changed
+}
+
+// an external program named finance-<name> on PATH; each call runs it once with a JSON request
+// on stdin and reads a JSON response from stdout, a response with "error" set is a failure
+type plugin struct {
+	Name         string   `json:"-"`
+	Path         string   `json:"-"`
+	Description  string   `json:"description"`
+	Capabilities []string `json:"capabilities"` // import, report and sync
+}
+
+const pluginPrefix = "finance-"
+
+// plugins on PATH, an earlier directory wins; each is asked to describe itself once per run
+var findPlugins = sync.OnceValue(func() []plugin {
+	var plugins []plugin
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, _ := os.ReadDir(cmp.Or(dir, "."))
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, ".exe")
+			}
+			if !ok || name == "" || seen[name] || entry.IsDir() {
+				continue
+			}
+			p := plugin{Name: name, Path: filepath.Join(dir, entry.Name())}
+			if info, err := os.Stat(p.Path); err != nil || (runtime.GOOS != "windows" && info.Mode()&0o111 == 0) {
+				continue
+			}
+			seen[name] = true
+			if err := p.call(map[string]any{"method": "describe"}, &p); err != nil {
+				slog.Warn("plugin failed", "plugin", name, "error", err)
+				continue
+			}
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+})
+
+func findPlugin(name, capability string) (plugin, bool) {
+	for _, p := range findPlugins() {
+		if p.Name == name && p.can(capability) {
+			return p, true
+		}
+	}
+	return plugin{}, false
+}
+
+func (p plugin) can(capability string) bool {
+	return slices.Contains(p.Capabilities, capability)
+}
+
+// run the plugin with one request and decode its response, stderr is passed through for its diagnostics
+func (p plugin) call(request any, response any) error {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	command := exec.CommandContext(ctx, p.Path)
+	command.Stdin = bytes.NewReader(input)
+	command.Stderr = os.Stderr
+	output, err := command.Output()
+	if err != nil {
+		return fmt.Errorf("plugin %s failed: %w", p.Name, err)
+	}
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(output, &failure); err == nil && failure.Error != "" {
+		return fmt.Errorf("plugin %s: %s", p.Name, failure.Error)
+	}
+	if err := json.Unmarshal(output, response); err != nil {
+		return fmt.Errorf("plugin %s answered with invalid JSON: %w", p.Name, err)
+	}
+	return nil
+}
+
+// the book and command line arguments go to the plugin, which answers with the report text
+func (p plugin) report(d *Data, args []string) error {
+	var response struct {
+		Output string `json:"output"`
+	}
+	if err := p.call(map[string]any{"method": "report", "args": args, "book": d}, &response); err != nil {
+		return err
+	}
+	fmt.Print(response.Output)
+	if !strings.HasSuffix(response.Output, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+func displayPlugins() {
+	plugins := findPlugins()
+	if len(plugins) == 0 {
+		fmt.Printf("No plugins found, they are programs named %s<name> on PATH.\n", pluginPrefix)
+		return
+	}
+	table := newTable("Name", "Provides", "Description")
+	for _, p := range plugins {
+		table.addRow(plainCell(p.Name), plainCell(strings.Join(p.Capabilities, ", ")), plainCell(p.Description))
+	}
+	table.print()
+}
+
+// file contents are sent base64 encoded
+type pluginImporter struct {
+	plugin plugin
+}
+
+func (i pluginImporter) Detect(r io.Reader) bool {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return false
+	}
+	var response struct {
+		Detected bool `json:"detected"`
+	}
+	return i.plugin.call(map[string]any{"method": "detect", "content": content}, &response) == nil && response.Detected
+}
+
+func (i pluginImporter) Parse(r io.Reader) ([]Transaction, []ParseError) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	var response struct {
+		Transactions []Transaction `json:"transactions"`
+		Errors       []struct {
+			Line    int    `json:"line"`
+			Record  string `json:"record"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := i.plugin.call(map[string]any{"method": "import", "content": content}, &response); err != nil {
+		return nil, []ParseError{{Cause: err}}
+	}
+	problems := make([]ParseError, 0, len(response.Errors))
+	for _, problem := range response.Errors {
+		problems = append(problems, ParseError{Line: max(problem.Line, 1), Record: problem.Record, Cause: errors.New(problem.Message)})
+	}
+	return response.Transactions, problems
+}
+
+// a bank link; the cursor marks what has already been synced
+type BankConnection struct {
+	Name        string            `json:"name"`
+	Provider    string            `json:"provider"`
+	Account     string            `json:"account,omitempty"`     // book account the transactions are booked to
+	AccessToken string            `json:"access_token"`          // issued by the provider when the bank was linked
+	Environment string            `json:"environment,omitempty"` // provider environment, e.g. sandbox or production
+	Cursor      string            `json:"cursor,omitempty"`
+	LastSync    time.Time         `json:"last_sync,omitzero"`
+	Templates   []ReceiptTemplate `json:"templates,omitempty"` // per sender, for imap connections
+}
+
+// a transaction as reported by a bank, positive amounts leave the account
+type bankTransaction struct {
+	ID       string    `json:"id"`
+	Date     time.Time `json:"date"`
+	Amount   float64   `json:"amount"`
+	Payee    string    `json:"payee,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Category string    `json:"category,omitempty"` // the provider's category, used when no rule or history applies
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// what changed at the bank since the cursor a fetch started from
+type bankChanges struct {
+	Added    []bankTransaction `json:"added"`
+	Modified []bankTransaction `json:"modified"`
+	Removed  []string          `json:"removed"` // IDs of transactions the bank dropped, e.g. pending ones that never posted
+	Cursor   string            `json:"cursor"`
+}
+
+// a bank data provider
+type BankConnector interface {
+	Fetch(cursor string) (bankChanges, error)
+}
+
+// providers by name, a new one only needs an entry here
+var bankConnectors = map[string]func(BankConnection) (BankConnector, error){
+	"plaid": newPlaidConnector,
+	"imap":  newIMAPConnector,
+}
+
+// the built-in provider of that name, else a plugin offering sync
+func bankConnector(provider string) (func(BankConnection) (BankConnector, error), bool) {
+	if newConnector, ok := bankConnectors[provider]; ok {
+		return newConnector, true
+	}
+	p, ok := findPlugin(provider, "sync")
+	if !ok {
+		return nil, false
+	}
+	return func(connection BankConnection) (BankConnector, error) {
+		return pluginConnector{p, connection}, nil
+	}, true
+}
+
+// the plugin gets the connection, including its access token, and answers with the changes since the cursor
+type pluginConnector struct {
+	plugin     plugin
+	connection BankConnection
+}
+
+func (c pluginConnector) Fetch(cursor string) (bankChanges, error) {
+	var changes bankChanges
+	err := c.plugin.call(map[string]any{"method": "fetch", "cursor": cursor, "connection": c.connection}, &changes)
+	for i := range changes.Added {
+		changes.Added[i].Date = civilDate(changes.Added[i].Date)
+	}
+	for i := range changes.Modified {
+		changes.Modified[i].Date = civilDate(changes.Modified[i].Date)
+	}
+	return changes, err
+}
+
+type plaidConnector struct {
+	url         string
+	clientID    string
+	secret      string
+	accessToken string
+}
+
+// the client ID and secret come from PLAID_CLIENT_ID and PLAID_SECRET, the environment may also be a URL
+func newPlaidConnector(connection BankConnection) (BankConnector, error) {
+	clientID, secret := os.Getenv("PLAID_CLIENT_ID"), os.Getenv("PLAID_SECRET")
+	if clientID == "" || secret == "" {
+		return nil, fmt.Errorf("PLAID_CLIENT_ID and PLAID_SECRET must be set")
+	}
+	url := connection.Environment
+	switch url {
+	case "", "sandbox", "production":
+		url = "https://" + cmp.Or(url, "sandbox") + ".plaid.com"
+	}
+	return &plaidConnector{url: strings.TrimSuffix(url, "/"), clientID: clientID, secret: secret, accessToken: connection.AccessToken}, nil
+}
+
+type plaidTransaction struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	Date          string  `json:"date"`
+	Name          string  `json:"name"`
+	MerchantName  string  `json:"merchant_name"`
+	Pending       bool    `json:"pending"`
+	Category      struct {
+		Primary string `json:"primary"`
+	} `json:"personal_finance_category"`
+}
+
+// page through /transactions/sync until Plaid has nothing more, pending transactions are left for later
+func (p *plaidConnector) Fetch(cursor string) (bankChanges, error) {
+	changes := bankChanges{Cursor: cursor}
+	client := &http.Client{Timeout: 30 * time.Second}
+	for {
+		request, _ := json.Marshal(map[string]any{"client_id": p.clientID, "secret": p.secret, "access_token": p.accessToken, "cursor": changes.Cursor, "count": 500})
+		response, err := client.Post(p.url+"/transactions/sync", "application/json", bytes.NewReader(request))
+		if err != nil {
+			return bankChanges{}, fmt.Errorf("plaid: %w", err)
+		}
+		var page struct {
+			Added        []plaidTransaction `json:"added"`
+			Modified     []plaidTransaction `json:"modified"`
+			Removed      []plaidTransaction `json:"removed"` // only transaction_id is set
+			NextCursor   string             `json:"next_cursor"`
+			HasMore      bool               `json:"has_more"`
+			ErrorMessage string             `json:"error_message"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return bankChanges{}, fmt.Errorf("plaid answered %s: %s", response.Status, page.ErrorMessage)
+		}
+		if err != nil {
+			return bankChanges{}, fmt.Errorf("plaid: failed to parse response: %w", err)
+		}
+		for _, list := range []struct {
+			from []plaidTransaction
+			to   *[]bankTransaction
+		}{{page.Added, &changes.Added}, {page.Modified, &changes.Modified}} {
+			for _, transaction := range list.from {
+				if transaction.Pending {
+					continue
+				}
+				date, err := parseDate(transaction.Date)
+				if err != nil {
+					return bankChanges{}, fmt.Errorf("plaid: transaction %s: %w", transaction.TransactionID, err)
+				}
+				*list.to = append(*list.to, bankTransaction{ID: transaction.TransactionID, Date: date, Amount: transaction.Amount,
+					Payee: transaction.MerchantName, Name: transaction.Name, Category: plaidCategory(transaction.Category.Primary)})
+			}
+		}
+		for _, removed := range page.Removed {
+			changes.Removed = append(changes.Removed, removed.TransactionID)
+		}
+		changes.Cursor = page.NextCursor
+		if !page.HasMore {
+			return changes, nil
+		}
+	}
+}
+
+// FOOD_AND_DRINK becomes Food And Drink
+func plaidCategory(primary string) string {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(primary, "_", " ")))
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// how to read the receipts of one sender: the first group of Amount (and of Date, when set) is taken
+// from the mail text, mails of senders without a template are read for a line mentioning a total
+type ReceiptTemplate struct {
+	From     string `json:"from"`   // part of the sender address, e.g. amazon.com
+	Amount   string `json:"amount"` // regular expression, e.g. Order Total: \$([\d,.]+)
+	Date     string `json:"date,omitempty"`
+	Merchant string `json:"merchant,omitempty"` // payee, the sender's name when unset
+	Category string `json:"category,omitempty"` // used when no rule or history applies
+}
+
+func (t ReceiptTemplate) validate() error {
+	if t.From == "" {
+		return fmt.Errorf("a receipt template needs the sender it applies to")
+	}
+	for name, pattern := range map[string]string{"amount": t.Amount, "date": t.Date} {
+		if pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s pattern: %w", name, err)
+		}
+		if compiled.NumSubexp() < 1 {
+			return fmt.Errorf("the %s pattern needs a group around the %s, e.g. Total: ([\\d.,]+)", name, name)
+		}
+	}
+	return nil
+}
+
+// e-receipts in a mail folder; the connection's environment is the folder as a URL like
+// imaps://me@mail.example.com/Receipts and its access token the (app) password; imap:// URLs
+// must upgrade with STARTTLS, the password is never sent in the clear
+type imapConnector struct {
+	address   string
+	tls       bool
+	user      string
+	password  string
+	folder    string
+	templates []ReceiptTemplate
+}
+
+func newIMAPConnector(connection BankConnection) (BankConnector, error) {
+	target, err := url.Parse(connection.Environment)
+	if err != nil || (target.Scheme != "imaps" && target.Scheme != "imap") || target.Host == "" || target.User == nil {
+		return nil, fmt.Errorf("the environment of an imap connection must be imaps://user@host/folder, or imap:// for a server with STARTTLS")
+	}
+	port := map[string]string{"imaps": "993", "imap": "143"}[target.Scheme]
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), port)
+	}
+	return &imapConnector{address: address, tls: target.Scheme == "imaps", user: target.User.Username(), password: connection.AccessToken,
+		folder: cmp.Or(strings.Trim(target.Path, "/"), "INBOX"), templates: connection.Templates}, nil
+}
+
+// the cursor is the folder's UIDVALIDITY and the last UID read, mails are added once and never modified
+func (c *imapConnector) Fetch(cursor string) (bankChanges, error) {
+	changes := bankChanges{Cursor: cursor}
+	session, err := dialIMAP(c.address, c.tls)
+	if err != nil {
+		return changes, fmt.Errorf("imap: %w", err)
+	}
+	defer session.close()
+	if _, err := session.command("LOGIN %s %s", imapQuote(c.user), imapQuote(c.password)); err != nil {
+		return changes, fmt.Errorf("imap: %w", err)
+	}
+	lines, err := session.command("EXAMINE %s", imapQuote(c.folder))
+	if err != nil {
+		return changes, fmt.Errorf("imap: %w", err)
+	}
+	validity := ""
+	for _, line := range lines {
+		if _, rest, ok := strings.Cut(line, "[UIDVALIDITY "); ok {
+			validity, _, _ = strings.Cut(rest, "]")
+		}
+	}
+	last := 0
+	if seen, uid, ok := strings.Cut(cursor, ":"); ok && seen == validity {
+		last, _ = strconv.Atoi(uid) // a new UIDVALIDITY means the folder was rebuilt, mail IDs keep it from duplicating
+	}
+	lines, err = session.command("UID SEARCH UID %d:*", last+1)
+	if err != nil {
+		return changes, fmt.Errorf("imap: %w", err)
+	}
+	var uids []int
+	for _, line := range lines {
+		if found, ok := strings.CutPrefix(line, "* SEARCH"); ok {
+			for _, field := range strings.Fields(found) {
+				if uid, err := strconv.Atoi(field); err == nil && uid > last {
+					uids = append(uids, uid) // n:* always matches the newest mail, even one read before
+				}
+			}
+		}
+	}
+	slices.Sort(uids)
+	for _, uid := range uids {
+		message, err := session.fetch(uid)
+		if err != nil {
+			return changes, fmt.Errorf("imap: mail %d: %w", uid, err)
+		}
+		if receipt, ok := c.readReceipt(message); ok {
+			changes.Added = append(changes.Added, receipt)
+		}
+		last = uid
+	}
+	changes.Cursor = validity + ":" + strconv.Itoa(last)
+	return changes, nil
+}
+
+// a purchase read from a mail, false for mails without an amount
+func (c *imapConnector) readReceipt(raw []byte) (bankTransaction, bool) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return bankTransaction{}, false
+	}
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		return bankTransaction{}, false
+	}
+	sent, err := message.Header.Date()
+	if err != nil {
+		sent = time.Now()
+	}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+	text := mailText(textproto.MIMEHeader(message.Header), message.Body)
+	receipt := bankTransaction{ID: "mail:" + cmp.Or(strings.Trim(message.Header.Get("Message-Id"), "<>"), from.Address+" "+sent.Format(time.RFC3339)),
+		Date: civilDate(sent), Name: subject, Payee: cmp.Or(from.Name, from.Address), Tags: []string{"receipt"}}
+	i := slices.IndexFunc(c.templates, func(t ReceiptTemplate) bool {
+		return strings.Contains(strings.ToLower(from.Address), strings.ToLower(t.From))
+	})
+	if i < 0 {
+		amount, ok := receiptTotal(text)
+		receipt.Amount = amount
+		return receipt, ok && amount > 0
+	}
+	template := c.templates[i]
+	match := regexp.MustCompile(template.Amount).FindStringSubmatch(text)
+	if match == nil {
+		return bankTransaction{}, false
+	}
+	amount, _, err := parseTypedAmount(match[1])
+	if err != nil || amount <= 0 {
+		return bankTransaction{}, false
+	}
+	receipt.Amount, receipt.Category, receipt.Payee = amount, template.Category, cmp.Or(template.Merchant, receipt.Payee)
+	if template.Date != "" {
+		if match := regexp.MustCompile(template.Date).FindStringSubmatch(text); match != nil {
+			if date, err := ParseFlexibleDate(strings.ReplaceAll(match[1], ",", ""), civilDate(sent)); err == nil {
+				receipt.Date = date
+			}
+		}
+	}
+	return receipt, true
+}
+
+// the readable text of a mail: the plain part when there is one, else the HTML part without its markup
+func mailText(header textproto.MIMEHeader, body io.Reader) string {
+	mediaType, params, err := mime.ParseMediaType(cmp.Or(header.Get("Content-Type"), "text/plain"))
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var plain, rich string
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				break
+			}
+			text := mailText(part.Header, part)
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "text/html" {
+				rich = cmp.Or(rich, text)
+			} else {
+				plain = cmp.Or(plain, text)
+			}
+		}
+		return cmp.Or(plain, rich)
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return ""
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &lineJoiner{r: body})
+	}
+	content, _ := io.ReadAll(body)
+	if mediaType != "text/html" {
+		return string(content)
+	}
+	text := htmlBreaks.ReplaceAllString(string(content), "\n")
+	return html.UnescapeString(htmlTags.ReplaceAllString(text, " "))
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h\d)>`)
+	htmlTags   = regexp.MustCompile(`(?s)<style.*?</style>|<script.*?</script>|<[^>]*>`)
+)
+
+// drops the line breaks of base64 bodies, which the decoder does not skip on its own
+type lineJoiner struct {
+	r io.Reader
+}
+
+func (j *lineJoiner) Read(p []byte) (int, error) {
+	n, err := j.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	if kept == 0 && n > 0 && err == nil {
+		return j.Read(p)
+	}
+	return kept, err
+}
+
+// a tagged IMAP conversation, just enough of RFC 3501 to read a folder
+type imapSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+func dialIMAP(address string, useTLS bool) (*imapSession, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	session := &imapSession{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := session.readLine()
+	if err == nil && !strings.HasPrefix(greeting, "* OK") {
+		err = fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	if err == nil && !useTLS {
+		err = session.startTLS(address)
+	}
+	if err != nil {
+		session.conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// upgrade a plain connection before anything is sent over it, a server that refuses leaves nothing to fall back to
+func (s *imapSession) startTLS(address string) error {
+	if _, err := s.command("STARTTLS"); err != nil {
+		return fmt.Errorf("%w, the server does not offer encryption on this port; use imaps:// instead", err)
+	}
+	if s.reader.Buffered() > 0 { // anything sent before the handshake could have been injected by whoever is in between
+		return fmt.Errorf("unexpected data before the TLS handshake")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	conn := tls.Client(s.conn, &tls.Config{ServerName: host})
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("STARTTLS: %w", err)
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	return nil
+}
+
+func (s *imapSession) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// send a command and collect the untagged lines answering it, literals are not expected here
+func (s *imapSession) command(format string, args ...any) ([]string, error) {
+	s.tag++
+	tag := fmt.Sprintf("a%d", s.tag)
+	if _, err := fmt.Fprintf(s.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") {
+				verb, _, _ := strings.Cut(format, " ")
+				return nil, fmt.Errorf("%s failed: %s", verb, status)
+			}
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// the whole message with the given UID, without marking it as read
+func (s *imapSession) fetch(uid int) ([]byte, error) {
+	s.tag++
+	tag := fmt.Sprintf("a%d", s.tag)
+	if _, err := fmt.Fprintf(s.conn, "%s UID FETCH %d BODY.PEEK[]\r\n", tag, uid); err != nil {
+		return nil, err
+	}
+	var message []byte
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") || message == nil {
+				return nil, fmt.Errorf("FETCH failed: %s", status)
+			}
+			return message, nil
+		}
+		if open := strings.LastIndexByte(line, '{'); open >= 0 && strings.HasSuffix(line, "}") {
+			size, err := strconv.Atoi(line[open+1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("bad literal in %q", line)
+			}
+			literal := make([]byte, size)
+			if _, err := io.ReadFull(s.reader, literal); err != nil {
+				return nil, err
+			}
+			if message == nil {
+				message = literal
+			}
+		}
+	}
+}
+
+func (s *imapSession) close() {
+	s.command("LOGOUT")
+	s.conn.Close()
+}
+
+// an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// fold a bank's changes into the book: known bank IDs are updated, a hand-entered twin (same day,
+// amount and account) is linked instead of duplicated, anything else is categorized and appended
+func (d *Data) applyBankChanges(connection BankConnection, changes bankChanges) bankSyncResult {
+	result := bankSyncResult{Name: connection.Name}
+	defer d.reindex() // dates of known bank IDs change in place
+	byID := make(map[string]int)
+	for i, transaction := range d.Transactions {
+		if transaction.ExternalID != "" {
+			byID[transaction.ExternalID] = i
+		}
+	}
+	booked := func(bank bankTransaction) Transaction {
+		transaction := Transaction{Date: bank.Date, Type: Expense, Amount: math.Abs(bank.Amount), Description: bank.Name,
+			Payee: bank.Payee, Account: connection.Account, ExternalID: bank.ID, Tags: bank.Tags, Status: Pending}
+		if bank.Amount < 0 {
+			transaction.Type = Income
+		}
+		return transaction
+	}
+	for _, bank := range slices.Concat(changes.Added, changes.Modified) {
+		transaction := booked(bank)
+		if i, ok := byID[bank.ID]; ok {
+			existing := &d.Transactions[i]
+			existing.Date, existing.Type, existing.Amount = transaction.Date, transaction.Type, transaction.Amount
+			if existing.Payee == "" {
+				existing.Payee = transaction.Payee
+			}
+			result.Updated++
+			continue
+		}
+		twin := slices.IndexFunc(d.Transactions, func(other Transaction) bool {
+			return other.ExternalID == "" && other.Date.Equal(transaction.Date) && other.Type == transaction.Type &&
+				math.Abs(other.Amount-transaction.Amount) < 0.005 && other.Account == transaction.Account
+		})
+		if twin >= 0 {
+			d.Transactions[twin].ExternalID = bank.ID
+			byID[bank.ID] = twin
+			continue
+		}
+		transaction.Category = d.categorize(transaction, bank.Category)
+		if err := d.appendTransaction(transaction); err != nil {
+			result.Skipped = append(result.Skipped, SkipReason{Record: bank.ID, Kind: skipInvalid, Reason: err.Error()})
+			continue
+		}
+		byID[bank.ID] = len(d.Transactions) - 1
+		result.Added++
+	}
+	for _, id := range changes.Removed {
+		if i := slices.IndexFunc(d.Transactions, func(t Transaction) bool { return t.ExternalID == id }); i >= 0 {
+			d.trash([]int{d.Transactions[i].ID}, d.now().UTC())
+			result.Removed++
+		}
+	}
+	return result
+}
+
+// what syncing one bank connection changed
+type bankSyncResult struct {
+	Name    string
+	Added   int
+	Updated int
+	Removed int
+	Skipped []SkipReason
+}
+
+// sync the named bank connection, or every one when name is empty
+func (d *Data) syncBanks(name string) ([]bankSyncResult, error) {
+	var results []bankSyncResult
+	for i := range d.Banks {
+		connection := &d.Banks[i]
+		if name != "" && connection.Name != name {
+			continue
+		}
+		newConnector, ok := bankConnector(connection.Provider)
+		if !ok {
+			return results, fmt.Errorf("%s: unknown provider %q", connection.Name, connection.Provider)
+		}
+		connector, err := newConnector(*connection)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", connection.Name, err)
+		}
+		changes, err := connector.Fetch(connection.Cursor)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", connection.Name, err)
+		}
+		results = append(results, d.applyBankChanges(*connection, changes))
+		connection.Cursor = changes.Cursor
+		connection.LastSync = d.now().UTC()
+	}
+	if len(results) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no bank connection %s, see bank list", name)
+		}
+		return nil, fmt.Errorf("no bank connections, add one with bank link")
+	}
+	return results, nil
+}
+
+func displayBankSync(results []bankSyncResult) {
+	for _, result := range results {
+		for _, skipped := range result.Skipped {
+			slog.Info("skipped bank transaction", "connection", result.Name, "reason", skipped)
+		}
+		fmt.Printf("Synced %s: %d added, %d updated, %d removed.\n", result.Name, result.Added, result.Updated, result.Removed)
+	}
+}
+
+func (d *Data) linkBank(connection BankConnection) error {
+	if _, ok := bankConnector(connection.Provider); !ok {
+		return fmt.Errorf("unknown provider %q, use %s or a sync plugin", connection.Provider, strings.Join(slices.Sorted(maps.Keys(bankConnectors)), ", "))
+	}
+	if connection.Name == "" || connection.AccessToken == "" {
+		return fmt.Errorf("a bank connection needs a name and an access token")
+	}
+	if slices.ContainsFunc(d.Banks, func(other BankConnection) bool { return other.Name == connection.Name }) {
+		return fmt.Errorf("bank connection %s already exists", connection.Name)
+	}
+	d.Banks = append(d.Banks, connection)
+	return nil
+}
+
+func (d *Data) displayBanks() {
+	if len(d.Banks) == 0 {
+		fmt.Println("No bank connections.")
+		return
+	}
+	table := newTable("Name", "Provider", "Account", "Last sync")
+	for _, connection := range d.Banks {
+		last := "never"
+		if !connection.LastSync.IsZero() {
+			last = connection.LastSync.In(d.Settings.location()).Format("2006-01-02 15:04")
+		}
+		table.addRow(plainCell(connection.Name), plainCell(connection.Provider), plainCell(connection.Account), plainCell(last))
+	}
+	table.print()
+}
+
+// spreadsheets are matched by their header row rather than column order, and keep dates as day serials;
+// the rows come back as date, type, category, amount, description, payee, notes and a meta:<key> column
+// for every metadata key the header names
+func nativeColumns(records [][]string) ([][]string, error) {
+	columns := []string{"date", "type", "category", "amount", "description", "payee", "notes"}
+	for _, name := range records[0] {
+		if prefix, key, ok := strings.Cut(strings.TrimSpace(name), ":"); ok && strings.EqualFold(prefix, "meta") {
+			key, err := metadataKey(key)
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, "meta:"+key)
+		}
+	}
+	positions := make([]int, len(columns))
+	for i, column := range columns {
+		positions[i] = slices.IndexFunc(records[0], func(name string) bool {
+			name = strings.TrimSpace(name)
+			if prefix, key, ok := strings.Cut(name, ":"); ok && strings.EqualFold(prefix, "meta") {
+				name = "meta:" + strings.ToLower(strings.TrimSpace(key))
+			}
+			return strings.EqualFold(name, column)
+		})
+		if positions[i] < 0 && i < 4 {
+			return nil, fmt.Errorf("the first sheet has no %q column", column)
+		}
+	}
+	reordered := [][]string{columns}
+	for _, record := range records[1:] {
+		row := make([]string, len(columns))
+		for i, position := range positions {
+			if position >= 0 && position < len(record) {
+				row[i] = record[position]
+			}
+		}
+		if serial, err := strconv.ParseFloat(row[0], 64); err == nil {
+			row[0] = excelDate(serial).Format("2006-01-02")
+		}
+		reordered = append(reordered, row)
+	}
+	return reordered, nil
+}
+
+// column layout and conventions of another tool's CSV export
+type importProfile struct {
+	name    string
+	columns []string                                         // header columns the export must have
+	convert func(row map[string]string) (Transaction, error) // errSkipRow for rows that are neither income nor expense
+}
+
+var errSkipRow = errors.New("not an income or expense")
+
+var importProfiles = map[string]importProfile{
+	// Mint: positive amounts, debit or credit in Transaction Type, the cleaned up name in Description
+	"mint": {
+		name:    "Mint",
+		columns: []string{"Date", "Description", "Original Description", "Amount", "Transaction Type", "Category", "Account Name"},
+		convert: func(row map[string]string) (Transaction, error) {
+			transactionType := Expense
+			if strings.EqualFold(row["Transaction Type"], "credit") {
+				transactionType = Income
+			}
+			if strings.EqualFold(row["Category"], "Transfer") || strings.EqualFold(row["Category"], "Credit Card Payment") {
+				return Transaction{}, errSkipRow
+			}
+			return importedTransaction(row["Date"], transactionType, row["Category"], row["Amount"], cmp.Or(row["Notes"], row["Original Description"]), row["Account Name"], row["Description"])
+		},
+	},
+	// YNAB register export: separate Outflow and Inflow columns with currency symbols, transfers name the account in Payee
+	"ynab": {
+		name:    "YNAB",
+		columns: []string{"Account", "Date", "Payee", "Category", "Memo", "Outflow", "Inflow"},
+		convert: func(row map[string]string) (Transaction, error) {
+			if strings.HasPrefix(row["Payee"], "Transfer : ") {
+				return Transaction{}, errSkipRow
+			}
+			transactionType, amount, category := Expense, row["Outflow"], row["Category"]
+			if outflow, err := parseMoney(amount); err == nil && outflow == 0 {
+				transactionType, amount = Income, row["Inflow"]
+			}
+			if row["Category Group"] == "Inflow" || category == "" { // Inflow: Ready to Assign holds all income
+				category = "Income"
+			}
+			return importedTransaction(row["Date"], transactionType, category, amount, row["Memo"], row["Account"], row["Payee"])
+		},
+	},
+}
+
+// amounts in exports carry currency symbols and separators, $1,234.56 or -€12.00
+func parseMoney(amountStr string) (float64, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, amountStr)
+	if cleaned == "" {
+		return 0, nil
+	}
+	return parseFloat(cleaned)
+}
+
+func importedTransaction(dateStr, transactionType, category, amountStr, description, account, payee string) (Transaction, error) {
+	var date time.Time
+	var err error
+	for _, layout := range []string{"1/2/2006", "2006-01-02", "01/02/2006"} {
+		if date, err = time.Parse(layout, strings.TrimSpace(dateStr)); err == nil {
+			break
+		}
+	}
+	if serial, serialErr := strconv.ParseFloat(dateStr, 64); err != nil && serialErr == nil { // exports opened and saved in Excel
+		date, err = excelDate(serial), nil
+	}
+	if err != nil {
+		return Transaction{}, fmt.Errorf("%w %q", ErrInvalidDate, dateStr)
+	}
+	amount, err := parseMoney(amountStr)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return Transaction{Date: date, Type: transactionType, Category: category, Amount: math.Abs(amount), Description: description, Account: account, Payee: payee}, nil
+}
+
+// the configured time zone, the system zone when unset
+func (s Settings) location() *time.Location {
+	if s.Timezone != "" {
+		if location, err := time.LoadLocation(s.Timezone); err == nil {
+			return location
+		}
+	}
+	return time.Local
+}
+
+// the source of the current time, swapped for a fake one to freeze "now" in reports and reproducible runs
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// a clock that only moves when told to
+type FakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = now
+}
+
+func (c *FakeClock) Advance(by time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(by)
+}
+
+// the current time by the book's clock
+func (d *Data) now() time.Time {
+	if d.clock == nil {
+		return time.Now()
+	}
+	return d.clock.Now()
+}
+
+// the current calendar day in the configured time zone
+func (d *Data) today() time.Time {
+	return civilDate(d.now().In(d.Settings.location()))
+}
+
+// turn the book into a read-only view of how it stood at the end of date: later transactions are hidden,
+// ones trashed since are back, and the clock stops on that day so reports read as they would have then
+func (d *Data) showAsOf(date time.Time) {
+	d.asOf = civilDate(date)
+	end := time.Date(d.asOf.Year(), d.asOf.Month(), d.asOf.Day(), 23, 59, 59, 0, d.Settings.location())
+	d.Transactions = slices.DeleteFunc(d.Transactions, func(t Transaction) bool { return t.Date.After(d.asOf) })
+	var since []int
+	for _, transaction := range d.Trash {
+		if transaction.Deleted.After(end) && !transaction.Date.After(d.asOf) {
+			since = append(since, transaction.ID)
+		}
+	}
+	d.untrash(since)
+	d.Trash = slices.DeleteFunc(d.Trash, func(t Transaction) bool { return t.Deleted.After(end) })
+	d.clock, d.readOnly = NewFakeClock(end), true
+	d.reindex()
+}
+
+// first day of the week containing date
+func (s Settings) weekStart(date time.Time) time.Time {
+	offset := (int(date.Weekday()) - int(s.WeekStart) + 7) % 7
+	return civilDate(date).AddDate(0, 0, -offset)
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) || strings.EqualFold(name, day.String()[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q, use a weekday name such as monday", name)
+}
+
+func (s Settings) fiscalYearStart() time.Month {
+	if s.FiscalYearStart < time.January || s.FiscalYearStart > time.December {
+		return time.January
+	}
+	return s.FiscalYearStart
+}
+
+// fiscal year N starts on the first day of the start month in year N and ends before the same day in N+1
+func (s Settings) fiscalYearRange(year int) (time.Time, time.Time) {
+	from := time.Date(year, s.fiscalYearStart(), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(1, 0, 0)
+}
+
+func (s Settings) fiscalYearOf(date time.Time) int {
+	if date.Month() < s.fiscalYearStart() {
+		return date.Year() - 1
+	}
+	return date.Year()
+}
+
+// format an amount for reports according to the display settings
+func (s Settings) formatAmount(amount float64, currency string) string {
+	format := s.numberFormat()
+	symbol, known := currencySymbols[currency]
+	if !known {
+		symbol = currency
+	}
+	var number string
+	if s.Compact && math.Abs(amount) >= 1e3 {
+		for _, unit := range []struct {
+			size   float64
+			suffix string
+		}{{1e12, "T"}, {1e9, "B"}, {1e6, "M"}, {1e3, "k"}} {
+			if math.Abs(amount) >= unit.size {
+				number = strings.Replace(strconv.FormatFloat(math.Abs(amount)/unit.size, 'f', 1, 64), ".", format.decimal, 1) + unit.suffix
+				break
+			}
+		}
+	} else {
+		decimals := 2
+		if currencyDecimals, ok := currencyDecimals[currency]; ok {
+			decimals = currencyDecimals
+		}
+		if s.Decimals != nil {
+			decimals = *s.Decimals
+		}
+		number = format.group(strconv.FormatFloat(math.Abs(amount), 'f', decimals, 64))
+	}
+	sign := ""
+	if amount < 0 && strings.ContainsAny(number, "123456789") { // no -0.00 after rounding
+		sign = "-"
+	}
+	switch {
+	case symbol == "":
+		return sign + number
+	case format.symbolAfter:
+		return sign + number + " " + symbol
+	case !known:
+		return sign + symbol + " " + number // bare currency codes are spaced out, CHF 10.00
+	default:
+		return sign + symbol + number
+	}
+}
+
+// amounts in the book's own currency
+func (d *Data) formatAmount(amount float64) string {
+	return d.Settings.formatAmount(amount, d.Currency)
+}
+
+// separators and symbol placement of a locale
+type numberFormat struct {
+	thousands   string
+	decimal     string
+	symbolAfter bool
+}
+
+var numberFormats = map[string]numberFormat{
+	"en-US": {",", ".", false},
+	"en-GB": {",", ".", false},
+	"en-IN": {",", ".", false},
+	"bn-BD": {",", ".", false},
+	"ja-JP": {",", ".", false},
+	"de-DE": {".", ",", true},
+	"es-ES": {".", ",", true},
+	"it-IT": {".", ",", true},
+	"nl-NL": {".", ",", false},
+	"pt-BR": {".", ",", false},
+	"fr-FR": {"\u202f", ",", true},
+	"de-CH": {"'", ".", false},
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"INR": "₹",
+	"BDT": "৳",
+	"BRL": "R$",
+	"CAD": "CA$",
+	"AUD": "A$",
+}
+
+// the active ISO 4217 currency codes
+var isoCurrencies = strings.Fields(`AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD
+	CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD HNL
+	HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD
+	MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR
+	SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND
+	VUV WST XAF XCD XCG XOF XPF YER ZAR ZMW ZWG`)
+
+// a book currency given as a code like eur, checked against ISO 4217
+func parseCurrencyCode(value string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(value))
+	if len(code) != 3 || !slices.Contains(isoCurrencies, code) {
+		return "", fmt.Errorf("unknown currency %q, use an ISO 4217 code such as USD, EUR or INR", value)
+	}
+	return code, nil
+}
+
+// currencies without minor units
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+}
+
+// the configured locale, en-US when unset or unknown
+func (s Settings) numberFormat() numberFormat {
+	if format, ok := numberFormats[s.Locale]; ok {
+		return format
+	}
+	return numberFormats["en-US"]
+}
+
+// insert the thousands separator and swap in the decimal separator, 1234567.50 becomes 1,234,567.50 for en-US
+func (f numberFormat) group(number string) string {
+	digits, fraction := number, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		digits, fraction = digits[:i], f.decimal+digits[i+1:]
+	}
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteString(f.thousands)
+		}
+		grouped.WriteRune(digit)
+	}
+	return grouped.String() + fraction
+}
+
+// change a single setting by its command line name
+func (d *Data) setConfig(key, value string) error {
+	switch key {
+	case "fiscal-year-start":
+		month, err := strconv.Atoi(value)
+		if err != nil || month < 1 || month > 12 {
+			return fmt.Errorf("fiscal-year-start must be a month number between 1 and 12")
+		}
+		d.Settings.FiscalYearStart = time.Month(month)
+	case "decimals":
+		decimals, err := strconv.Atoi(value)
+		if err != nil || decimals < 0 || decimals > 6 {
+			return fmt.Errorf("decimals must be a number between 0 and 6")
+		}
+		d.Settings.Decimals = &decimals
+	case "compact":
+		compact, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("compact must be true or false")
+		}
+		d.Settings.Compact = compact
+	case "locale":
+		if _, ok := numberFormats[value]; !ok {
+			return fmt.Errorf("unknown locale %q, use one of %s", value, strings.Join(slices.Sorted(maps.Keys(numberFormats)), ", "))
+		}
+		d.Settings.Locale = value
+	case "timezone":
+		if _, err := time.LoadLocation(value); err != nil || value == "" {
+			return fmt.Errorf("unknown time zone %q, use an IANA name such as Europe/Berlin", value)
+		}
+		d.Settings.Timezone = value
+	case "week-start":
+		day, err := parseWeekday(value)
+		if err != nil {
+			return err
+		}
+		d.Settings.WeekStart = day
+	case "notify-webhook":
+		if target, err := url.ParseRequestURI(value); value != "" && (err != nil || (target.Scheme != "http" && target.Scheme != "https")) {
+			return fmt.Errorf("notify-webhook must be an http or https URL")
+		}
+		d.Settings.Notify.Webhook = value
+	case "notify-smtp":
+		if _, _, err := net.SplitHostPort(value); value != "" && err != nil {
+			return fmt.Errorf("notify-smtp must be host:port")
+		}
+		d.Settings.Notify.SMTPHost = value
+	case "notify-smtp-user":
+		d.Settings.Notify.SMTPUser = value
+	case "notify-from":
+		d.Settings.Notify.From = value
+	case "notify-to":
+		d.Settings.Notify.To = value
+	case "archive-after":
+		months, err := strconv.Atoi(value)
+		if err != nil || months < 0 {
+			return fmt.Errorf("archive-after must be a number of months, 0 disables archiving")
+		}
+		d.Settings.ArchiveAfter = months
+	case "backup-keep":
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("backup-keep must be a number of backups, at least 1")
+		}
+		d.Settings.BackupKeep = keep
+	case "git":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("git must be true or false")
+		}
+		d.Settings.GitHistory = enabled
+	case "auto-categorize":
+		threshold, err := parseFloat(value)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return fmt.Errorf("auto-categorize must be a confidence between 0 (off) and 1")
+		}
+		d.Settings.AutoCategorize = threshold
+	case "mileage-rate":
+		rate, err := parseAmount(value)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("mileage-rate must be an amount of at least 0")
+		}
+		d.Settings.MileageRate = rate
+	case "quote-url":
+		if target, err := url.ParseRequestURI(value); value != "" && (err != nil || (target.Scheme != "http" && target.Scheme != "https") || !strings.Contains(value, "{symbol}")) {
+			return fmt.Errorf("quote-url must be an http or https URL containing {symbol}")
+		}
+		d.Settings.QuoteURL = value
+	case "backup-gzip":
+		compress, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("backup-gzip must be true or false")
+		}
+		d.Settings.BackupGzip = compress
+	default:
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+	return nil
+}
+
+// translate a --period flag (all, week, month, year, YYYY-MM-DD, YYYY-MM or YYYY) into the period and value used by calculateSummary
+func (d *Data) parsePeriodFlag(value string, fiscal bool, now time.Time) (string, string, error) {
+	value = strings.ToLower(value)
+	yearPeriod := Year
+	if fiscal {
+		yearPeriod = Fiscal
+	}
+	switch value {
+	case All, "":
+		return All, "", nil
+	case Week:
+		return Week, now.Format("2006-01-02"), nil
+	case Month:
+		return Month, now.Format("2006-01"), nil
+	case Year:
+		if fiscal {
+			return Fiscal, strconv.Itoa(d.Settings.fiscalYearOf(now)), nil
+		}
+		return Year, now.Format("2006"), nil
+	}
+	for _, kind := range []string{Week, Month, yearPeriod} {
+		if parsed, err := d.ParsePeriod(kind, value); err == nil {
+			return parsed.Kind, parsed.Value, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w %q, use all, week, month, year, YYYY-MM-DD (its week), YYYY-MM or YYYY", ErrInvalidPeriod, value)
+}
+
+// a span reports cover: Kind is Week, Month, Year, Fiscal or All and Value the day, YYYY-MM or YYYY
+// naming it; To is exclusive and both dates are zero for All
+type Period struct {
+	Kind  string
+	Value string
+	From  time.Time
+	To    time.Time
+}
+
+// how the value of each kind of period is written
+var periodLayouts = map[string]struct{ layout, hint string }{
+	Week:   {"2006-01-02", "YYYY-MM-DD, any day of the week"},
+	Month:  {"2006-01", "YYYY-MM"},
+	Year:   {"2006", "YYYY"},
+	Fiscal: {"2006", "YYYY, the year the fiscal year starts in"},
+}
+
+// check a kind of period and its value and work out the dates they cover
+func (d *Data) ParsePeriod(period, value string) (Period, error) {
+	kind := strings.ToLower(strings.TrimSpace(period))
+	if kind == All {
+		return Period{Kind: All}, nil
+	}
+	format, ok := periodLayouts[kind]
+	if !ok {
+		return Period{}, fmt.Errorf("%w %q, use week, month, year, fiscal or all", ErrInvalidPeriod, period)
+	}
+	value = strings.TrimSpace(value)
+	if _, err := time.Parse(format.layout, value); err != nil {
+		return Period{}, fmt.Errorf("%w: %s %q, use %s", ErrInvalidPeriod, kind, value, format.hint)
+	}
+	from, to := d.periodRange(kind, value)
+	return Period{Kind: kind, Value: value, From: from, To: to}, nil
+}
+
+// selects transactions, zero fields match everything and To is exclusive
+type Filter struct {
+	From     time.Time
+	To       time.Time
+	Type     string
+	Category string
+	Account  string
+	Payee    string
+	Text     string // found in the description, payee, notes or metadata, ignoring case
+	Meta     string // a metadata key the transaction must have, or key=value
+	Tag      string
+	Status   string // one of statusFilters
+}
+
+func (f Filter) matches(transaction Transaction) bool {
+	if !f.From.IsZero() && transaction.Date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !transaction.Date.Before(f.To) {
+		return false
+	}
+	if f.Type != "" && transaction.Type != f.Type {
+		return false
+	}
+	if f.Category != "" && !slices.ContainsFunc(transaction.categoryAmounts(), func(split Split) bool { return split.Category == f.Category }) {
+		return false
+	}
+	if f.Account != "" && transaction.Account != f.Account {
+		return false
+	}
+	if f.Payee != "" && !strings.EqualFold(transaction.Payee, f.Payee) {
+		return false
+	}
+	if f.Text != "" && !transaction.mentions(f.Text) {
+		return false
+	}
+	if f.Status != "" && !transaction.hasStatus(f.Status) {
+		return false
+	}
+	if f.Tag != "" && !slices.ContainsFunc(transaction.Tags, func(tag string) bool { return strings.EqualFold(tag, f.Tag) }) {
+		return false
+	}
+	if f.Meta != "" {
+		key, value, hasValue := strings.Cut(f.Meta, "=")
+		got, ok := transaction.Metadata[strings.ToLower(strings.TrimSpace(key))]
+		if !ok || hasValue && !strings.EqualFold(got, strings.TrimSpace(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Transaction) hasStatus(status string) bool {
+	switch status {
+	case "posted":
+		return t.Status != Pending
+	case "uncleared":
+		return t.Status == ""
+	}
+	return t.Status == status
+}
+
+// stream the matching transactions in stored order without copying them into a new slice;
+// a date range only looks at the transactions inside it
+func (d *Data) Query(filter Filter) iter.Seq[Transaction] {
+	return func(yield func(Transaction) bool) {
+		if filter.From.IsZero() && filter.To.IsZero() {
+			for _, transaction := range d.Transactions {
+				if filter.matches(transaction) && !yield(transaction) {
+					return
+				}
+			}
+			return
+		}
+		for _, i := range d.dateIndex().between(filter.From, filter.To) {
+			if filter.matches(d.Transactions[i]) && !yield(d.Transactions[i]) {
+				return
+			}
+		}
+	}
+}
+
+// stream the matching transactions newest first, walking the date index backwards instead of sorting a copy
+func (d *Data) Newest(filter Filter) iter.Seq[Transaction] {
+	return func(yield func(Transaction) bool) {
+		index := d.dateIndex()
+		start, end := index.span(filter.From, filter.To)
+		for _, i := range slices.Backward(index.positions[start:end]) {
+			if filter.matches(d.Transactions[i]) && !yield(d.Transactions[i]) {
+				return
+			}
+		}
+	}
+}
+
+// positions of the transactions ordered by date, equal dates in stored order, so a
+// period is found by binary search
+type dateIndex struct {
+	data       *Data
+	positions  []int
+	first      *Transaction // the slice it was built for, another one or length means it is stale
+	count      int
+	generation int // of the book when it was built, any edit since means it is stale
+}
+
+// the date index of the book, rebuilt when the transactions changed behind its back
+func (d *Data) dateIndex() *dateIndex {
+	if index := d.byDate; index != nil && index.generation == d.generation && index.count == len(d.Transactions) &&
+		(index.count == 0 || index.first == &d.Transactions[0]) {
+		return index
+	}
+	positions := make([]int, len(d.Transactions))
+	for i := range positions {
+		positions[i] = i
+	}
+	slices.SortStableFunc(positions, func(a, b int) int { return d.Transactions[a].Date.Compare(d.Transactions[b].Date) })
+	d.byDate = &dateIndex{data: d, positions: positions, count: len(positions), generation: d.generation}
+	if len(positions) > 0 {
+		d.byDate.first = &d.Transactions[0]
+	}
+	return d.byDate
+}
+
+// drop what is derived from the transactions after they were removed, reordered or changed in
+// place, it is built again on first use
+func (d *Data) reindex() {
+	d.byDate, d.totals = nil, nil
+}
+
+// to be called after changing the dates, amounts, types or categories of stored transactions in place,
+// the date index and the monthly totals are built again when next used
+func (d *Data) edited() {
+	d.generation++
+}
+
+// take the transaction just appended into an index that was current before
+func (x *dateIndex) appended() {
+	transactions := x.data.Transactions
+	if x.count != len(transactions)-1 {
+		return
+	}
+	last := len(transactions) - 1
+	at, _ := slices.BinarySearchFunc(x.positions, transactions[last].Date, func(i int, date time.Time) int {
+		return cmp.Or(transactions[i].Date.Compare(date), -1) // after those of the same day
+	})
+	x.positions = slices.Insert(x.positions, at, last)
+	x.first, x.count = &transactions[0], len(transactions)
+}
+
+// positions of the transactions from from up to, not including, to in stored order; zero times leave the range open
+func (x *dateIndex) between(from, to time.Time) []int {
+	start, end := x.span(from, to)
+	found := slices.Clone(x.positions[start:end])
+	slices.Sort(found)
+	return found
+}
+
+// where the transactions from from up to, not including, to start and end in the index
+func (x *dateIndex) span(from, to time.Time) (int, int) {
+	transactions := x.data.Transactions
+	search := func(date time.Time) int {
+		at, _ := slices.BinarySearchFunc(x.positions, date, func(i int, date time.Time) int { return transactions[i].Date.Compare(date) })
+		return at
+	}
+	start, end := 0, len(x.positions)
+	if !from.IsZero() {
+		start = search(from)
+	}
+	if !to.IsZero() {
+		end = max(search(to), start)
+	}
+	return start, end
+}
+
+// register the options that narrow transactions down on fs; the returned function builds the Filter
+// once fs is parsed, with the same rules as the REST API's query parameters
+func (d *Data) filterFlags(fs *flag.FlagSet) func() (Filter, error) {
+	options := []struct{ name, usage string }{
+		{"period", "week, month, year, YYYY-MM-DD (its week), YYYY-MM or YYYY"},
+		{"from", "first date to include (YYYY-MM-DD)"},
+		{"to", "day after the last date to include (YYYY-MM-DD)"},
+		{"type", "Income or Expense"},
+		{"category", "only this category, split lines included"},
+		{"account", "only this account"},
+		{"payee", "only this payee"},
+		{"search", "text found in the description, payee, notes or metadata"},
+		{"meta", "only transactions with this metadata key, or key=value"},
+		{"tag", "only transactions with this tag"},
+		{"status", "pending, posted (all but pending), uncleared, cleared or reconciled"},
+	}
+	values := make(map[string]*string, len(options))
+	for _, option := range options {
+		values[option.name] = fs.String(option.name, "", option.usage)
+	}
+	fiscal := fs.Bool("fiscal", false, "treat years as fiscal years starting at the configured month")
+	return func() (Filter, error) {
+		query := url.Values{}
+		for name, value := range values {
+			if *value != "" {
+				query.Set(name, *value)
+			}
+		}
+		if *fiscal {
+			query.Set("fiscal", "true")
+		}
+		return d.filterFromQuery(query)
+	}
+}
+
+// the transactions matching filter ordered by date, amount or category, ties keep date order
+func (d *Data) sortedTransactions(filter Filter, sortBy string, descending bool) ([]Transaction, error) {
+	transactions := slices.Collect(d.Query(filter))
+	byDate := func(a, b Transaction) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) }
+	var compare func(a, b Transaction) int
+	switch sortBy {
+	case "date":
+		compare = byDate
+	case "amount":
+		compare = func(a, b Transaction) int { return cmp.Or(cmp.Compare(a.Amount, b.Amount), byDate(a, b)) }
+	case "category":
+		compare = func(a, b Transaction) int { return cmp.Or(strings.Compare(a.Category, b.Category), byDate(a, b)) }
+	default:
+		return nil, fmt.Errorf("invalid sort %q, use date, amount or category", sortBy)
+	}
+	if descending {
+		slices.SortFunc(transactions, func(a, b Transaction) int { return compare(b, a) })
+	} else {
+		slices.SortFunc(transactions, compare)
+	}
+	return transactions, nil
+}
+
+// the balance of each transaction's account right after it, by transaction ID: the opening balance
+// plus income minus expenses in date order, liabilities counting what is owed as in displayNetWorth
+func (d *Data) runningBalances() map[int]float64 {
+	ordered := slices.Clone(d.Transactions)
+	slices.SortStableFunc(ordered, func(a, b Transaction) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) })
+	balances := make(map[string]float64)
+	for _, account := range d.Accounts {
+		balances[account.Name] = account.OpeningBalance
+	}
+	after := make(map[int]float64, len(ordered))
+	for _, transaction := range ordered {
+		account, _ := d.findAccount(transaction.Account)
+		balances[transaction.Account] += account.effect(transaction)
+		after[transaction.ID] = balances[transaction.Account]
+	}
+	return after
+}
+
+// print one page of transactions with their account's running balance, limit 0 shows them all
+func (d *Data) displayTransactions(transactions []Transaction, limit, offset int) error {
+	total := len(transactions)
+	page := transactions[min(offset, total):]
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+	balances := d.runningBalances()
+	if structuredOutput() {
+		type listedTransaction struct {
+			Transaction
+			Balance float64 `json:"balance"`
+		}
+		listed := make([]listedTransaction, len(page))
+		rows := make([][]any, len(page))
+		for i, t := range page {
+			listed[i] = listedTransaction{t, balances[t.ID]}
+			rows[i] = []any{t.ID, t.Date.Format("2006-01-02"), t.Type, t.Category, t.Amount, t.Payee, t.Description, t.Account, balances[t.ID]}
+		}
+		return emit(listed, []string{"id", "date", "type", "category", "amount", "payee", "description", "account", "balance"}, rows)
+	}
+	if total == 0 {
+		fmt.Println("No transactions found.")
+		return nil
+	}
+	table := newTable("ID", "Date", "Type", "Category", "Amount", "Payee", "Description", "Account", "Balance").alignRight(0, 4, 8)
+	for _, t := range page {
+		amount := d.amountCell(t.Amount, t.Type)
+		if t.Currency != "" {
+			amount.text = d.Settings.formatAmount(t.Amount, t.Currency)
+		}
+		table.addRow(plainCell(strconv.Itoa(t.ID)), plainCell(t.Date.Format("2006-01-02")), plainCell(t.Type), plainCell(t.Category),
+			amount, plainCell(t.Payee), plainCell(t.Description), plainCell(t.Account), d.balanceCell(balances[t.ID]))
+	}
+	table.print()
+	if len(page) < total {
+		fmt.Printf("Showing %d-%d of %d transactions", min(offset, total)+1, min(offset, total)+len(page), total)
+		if next := offset + len(page); next < total {
+			fmt.Printf(", see the next ones with --offset %d", next)
+		}
+		fmt.Println(".")
+	}
+	return nil
+}
+
+// date range covered by a period, zero times for all
+func (d *Data) periodRange(period string, periodValue string) (time.Time, time.Time) {
+	switch period {
+	case Week:
+		inputTime, _ := time.Parse("2006-01-02", periodValue)
+		start := d.Settings.weekStart(inputTime)
+		return start, start.AddDate(0, 0, 7)
+	case Month:
+		inputTime, _ := time.Parse("2006-01", periodValue)
+		return inputTime, inputTime.AddDate(0, 1, 0)
+	case Year:
+		inputTime, _ := time.Parse("2006", periodValue)
+		return inputTime, inputTime.AddDate(1, 0, 0)
+	case Fiscal:
+		inputTime, _ := time.Parse("2006", periodValue)
+		return d.Settings.fiscalYearRange(inputTime.Year())
+	}
+	return time.Time{}, time.Time{}
+}
+
+func (d *Data) periodFilter(period string, periodValue string) Filter {
+	from, to := d.periodRange(period, periodValue)
+	return Filter{From: from, To: to}
+}
+
+func (d *Data) calculateSummary(period string, periodValue string) (float64, float64, map[string]float64, map[string]float64) {
+	return d.summarize(d.periodFilter(period, periodValue))
+}
+
+// total income and expenses with income per source and expenses per category, kept apart so
+// an income and an expense category of the same name never add up
+func (d *Data) summarize(filter Filter) (float64, float64, map[string]float64, map[string]float64) {
+	totalIncome := 0.0
+	totalExpenses := 0.0
+	incomeSources := make(map[string]float64)
+	categorySummary := make(map[string]float64)
+
+	if months, totals, ok := d.wholeMonths(filter); ok {
+		var income, expenses int64
+		sources, categories := make(map[string]int64), make(map[string]int64)
+		for _, key := range months {
+			month := totals[key]
+			income, expenses = income+month.income, expenses+month.expenses
+			for source, total := range month.sources {
+				sources[source] += total.amount
+			}
+			for category, total := range month.categories {
+				categories[category] += total.amount
+			}
+		}
+		for source, amount := range sources {
+			incomeSources[source] = fromThousandths(amount)
+		}
+		for category, amount := range categories {
+			categorySummary[category] = fromThousandths(amount)
+		}
+		return fromThousandths(income), fromThousandths(expenses), incomeSources, categorySummary
+	}
+	for transaction := range d.Query(filter) {
+		if transaction.foreign() {
+			continue
+		}
+		summary := categorySummary
+		if transaction.Type == Income {
+			totalIncome += transaction.Amount
+			summary = incomeSources
+		} else if transaction.Type == Expense {
+			totalExpenses += transaction.Amount
+		}
+		for _, split := range transaction.categoryAmounts() {
+			summary[split.Category] += split.Amount
+		}
+	}
+	return totalIncome, totalExpenses, incomeSources, categorySummary
+}
+
+// what a month's transactions add up to, income per source and expenses per category kept apart
+// as in summarize; amounts are in thousandths so adding and taking away transactions never drifts
+type monthTotal struct {
+	count      int
+	income     int64
+	expenses   int64
+	sources    map[string]categoryTotal
+	categories map[string]categoryTotal
+}
+
+type categoryTotal struct {
+	amount int64
+	count  int // transactions and line items, the category goes once none are left
+}
+
+// totals of the book per month (YYYY-MM), so reports on whole months never look at single transactions
+type monthlyTotals map[string]*monthTotal
+
+func thousandths(amount float64) int64 {
+	return int64(math.Round(amount * 1000))
+}
+
+func fromThousandths(amount int64) float64 {
+	return float64(amount) / 1000
+}
+
+// count a transaction in, or out again with sign -1
+func (m monthlyTotals) add(transaction Transaction, sign int) {
+	if transaction.foreign() {
+		return
+	}
+	key := transaction.Date.Format("2006-01")
+	month := m[key]
+	if month == nil {
+		month = &monthTotal{sources: make(map[string]categoryTotal), categories: make(map[string]categoryTotal)}
+		m[key] = month
+	}
+	summary := month.categories
+	if transaction.Type == Income {
+		month.income += int64(sign) * thousandths(transaction.Amount)
+		summary = month.sources
+	} else if transaction.Type == Expense {
+		month.expenses += int64(sign) * thousandths(transaction.Amount)
+	}
+	for _, split := range transaction.categoryAmounts() {
+		total := summary[split.Category]
+		total.amount += int64(sign) * thousandths(split.Amount)
+		total.count += sign
+		if total.count == 0 {
+			delete(summary, split.Category)
+		} else {
+			summary[split.Category] = total
+		}
+	}
+	month.count += sign
+	if month.count == 0 {
+		delete(m, key)
+	}
+}
+
+// the monthly totals of the book, counted on first use
+func (d *Data) monthTotals() monthlyTotals {
+	if d.totals == nil || d.totalsAt != d.generation {
+		d.totals, d.totalsAt = make(monthlyTotals), d.generation
+		for _, transaction := range d.Transactions {
+			d.totals.add(transaction, 1)
+		}
+	}
+	return d.totals
+}
+
+// the months, in order, and their totals when a filter asks for nothing but a run of whole months
+func (d *Data) wholeMonths(filter Filter) ([]string, monthlyTotals, bool) {
+	if filter != (Filter{From: filter.From, To: filter.To}) {
+		return nil, nil, false
+	}
+	for _, date := range []time.Time{filter.From, filter.To} {
+		if !date.IsZero() && (date.Day() != 1 || date != civilDate(date)) {
+			return nil, nil, false
+		}
+	}
+	totals := d.monthTotals()
+	var months []string
+	for key := range totals {
+		if (filter.From.IsZero() || key >= filter.From.Format("2006-01")) && (filter.To.IsZero() || key < filter.To.Format("2006-01")) {
+			months = append(months, key)
+		}
+	}
+	slices.Sort(months)
+	return months, totals, true
+}
+
+type budgetLine struct {
+	Category  string  `json:"category"`
+	Budgeted  float64 `json:"budgeted"`
+	Rollover  float64 `json:"rollover"`  // carried over from earlier months, negative after overspending
+	Available float64 `json:"available"` // budgeted plus rollover
+	Actual    float64 `json:"actual"`
+	Variance  float64 `json:"variance"`     // available minus actual, negative when over budget
+	Percent   float64 `json:"percent_used"` // share of the available amount used, 0 when nothing is available
+	// spent in a category with no budget, left out of the total
+	Unbudgeted bool `json:"unbudgeted,omitempty"`
+}
+
+// compare monthly budgets with actual spending, yearly periods budget twelve months
+func (d *Data) calculateBudgetReport(period string, periodValue string) ([]budgetLine, budgetLine, error) {
+	months := 0.0
+	switch period {
+	case Week:
+		months = 12.0 / 52
+	case Month:
+		months = 1
+	case Year, Fiscal:
+		months = 12
+	default:
+		return nil, budgetLine{}, fmt.Errorf("budget reports need a week, a month or a year")
+	}
+
+	_, _, _, actual := d.summarize(d.periodFilter(period, periodValue))
+	categories := make([]string, 0, len(d.Budgets)+len(actual))
+	archived := d.archivedCategories(d.today())
+	for category := range d.Budgets {
+		if _, spent := actual[category]; spent || !archived[category] {
+			categories = append(categories, category)
+		}
+	}
+	for category := range actual {
+		if _, ok := d.Budgets[category]; !ok {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+
+	lines := make([]budgetLine, 0, len(categories))
+	total := budgetLine{Category: "Total"}
+	for _, category := range categories {
+		budget, budgeted := d.Budgets[category]
+		line := budgetLine{Category: category, Budgeted: budget * months, Actual: actual[category], Unbudgeted: !budgeted}
+		if period == Month {
+			line.Rollover = d.rolloverInto(category, periodValue)
+		}
+		lines = append(lines, line.withVariance())
+		if line.Unbudgeted {
+			continue
+		}
+		total.Budgeted += line.Budgeted
+		total.Rollover += line.Rollover
+		total.Actual += line.Actual
+	}
+	return lines, total.withVariance(), nil
+}
+
+func (l budgetLine) withVariance() budgetLine {
+	l.Available = l.Budgeted + l.Rollover
+	l.Variance = l.Available - l.Actual
+	if l.Available > 0 {
+		l.Percent = l.Actual / l.Available * 100
+	}
+	return l
+}
+
+// income set aside for a category in a month, envelope budgeting
+type Allocation struct {
+	Month    string  `json:"month"` // YYYY-MM
+	Category string  `json:"category"`
+	Amount   float64 `json:"amount"`
+}
+
+// set what goes into a category's envelope in a month, zero takes the allocation back
+func (d *Data) allocate(month, category string, amount float64) error {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("%w %q, use YYYY-MM", ErrInvalidPeriod, month)
+	}
+	if category == "" {
+		return fmt.Errorf("category must not be empty")
+	}
+	if amount < 0 {
+		return fmt.Errorf("%w %v: allocations are positive, lower an envelope by allocating less", ErrInvalidAmount, amount)
+	}
+	i := slices.IndexFunc(d.Allocations, func(a Allocation) bool { return a.Month == month && a.Category == category })
+	switch {
+	case i >= 0 && amount == 0:
+		d.Allocations = slices.Delete(d.Allocations, i, i+1)
+	case i >= 0:
+		d.Allocations[i].Amount = amount
+	case amount > 0:
+		d.Allocations = append(d.Allocations, Allocation{Month: month, Category: category, Amount: amount})
+	}
+	return nil
+}
+
+// one category's envelope as of a month
+type envelope struct {
+	Category  string  `json:"category"`
+	Allocated float64 `json:"allocated"` // in the month
+	Spent     float64 `json:"spent"`     // in the month
+	Available float64 `json:"available"` // all allocations up to the month minus all spending since the first one
+}
+
+// the envelopes as of month (YYYY-MM) and the income received since envelopes were started that is
+// not allocated yet, negative when more was allocated than came in
+func (d *Data) envelopes(month string) ([]envelope, float64) {
+	byCategory := make(map[string]*envelope)
+	first := ""
+	unallocated := 0.0
+	for _, allocation := range d.Allocations {
+		if allocation.Month > month {
+			continue
+		}
+		entry := byCategory[allocation.Category]
+		if entry == nil {
+			entry = &envelope{Category: allocation.Category}
+			byCategory[allocation.Category] = entry
+		}
+		if allocation.Month == month {
+			entry.Allocated += allocation.Amount
+		}
+		entry.Available += allocation.Amount
+		unallocated -= allocation.Amount
+		if first == "" || allocation.Month < first {
+			first = allocation.Month
+		}
+	}
+	for _, transaction := range d.Transactions {
+		current := transaction.Date.Format("2006-01")
+		if first == "" || current < first || current > month || transaction.foreign() {
+			continue
+		}
+		if transaction.Type == Income {
+			unallocated += transaction.Amount
+			continue
+		}
+		for _, split := range transaction.categoryAmounts() {
+			if entry := byCategory[split.Category]; entry != nil {
+				entry.Available -= split.Amount
+				if current == month {
+					entry.Spent += split.Amount
+				}
+			}
+		}
+	}
+	result := make([]envelope, 0, len(byCategory))
+	for _, category := range slices.Sorted(maps.Keys(byCategory)) {
+		result = append(result, *byCategory[category])
+	}
+	return result, unallocated
+}
+
+func (d *Data) displayEnvelopes(month string) error {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("%w %q, use YYYY-MM", ErrInvalidPeriod, month)
+	}
+	envelopes, unallocated := d.envelopes(month)
+	if structuredOutput() {
+		rows := make([][]any, len(envelopes))
+		for i, entry := range envelopes {
+			rows[i] = []any{entry.Category, entry.Allocated, entry.Spent, entry.Available}
+		}
+		return emit(struct {
+			Month       string     `json:"month"`
+			Unallocated float64    `json:"unallocated"`
+			Envelopes   []envelope `json:"envelopes"`
+		}{month, unallocated, envelopes}, []string{"category", "allocated", "spent", "available"}, rows)
+	}
+	if len(envelopes) == 0 {
+		fmt.Println("No envelopes yet, fill one with: allocate <YYYY-MM> <category> <amount>")
+		return nil
+	}
+	fmt.Printf("Envelopes for %s:\n", month)
+	table := newTable("Category", "Allocated", "Spent", "Available").alignRight(1, 2, 3)
+	for _, entry := range envelopes {
+		table.addRow(plainCell(entry.Category), plainCell(d.formatAmount(entry.Allocated)), d.amountCell(entry.Spent, Expense), d.balanceCell(entry.Available))
+	}
+	table.print()
+	if unallocated < 0 {
+		fmt.Println(colorize(colorRed, fmt.Sprintf("Allocated %s more than the income received.", d.formatAmount(-unallocated))))
+	} else {
+		fmt.Printf("To allocate: %s\n", d.formatAmount(unallocated))
+	}
+	return nil
+}
+
+// envelopes the added expenses left overdrawn
+func (d *Data) envelopeWarnings(added []Transaction) []string {
+	var messages []string
+	seen := make(map[string]bool)
+	for _, transaction := range added {
+		if transaction.Type != Expense {
+			continue
+		}
+		month := transaction.Date.Format("2006-01")
+		envelopes, _ := d.envelopes(month)
+		for _, split := range transaction.categoryAmounts() {
+			i := slices.IndexFunc(envelopes, func(e envelope) bool { return e.Category == split.Category })
+			if i < 0 || envelopes[i].Available >= 0 || seen[split.Category+month] {
+				continue
+			}
+			seen[split.Category+month] = true
+			category := split.Category
+			if strings.ContainsAny(category, " \t") {
+				category = strconv.Quote(category)
+			}
+			messages = append(messages, fmt.Sprintf("the %s envelope is overdrawn by %s in %s, move money into it with: allocate %s %s <amount>",
+				split.Category, d.formatAmount(-envelopes[i].Available), month, month, category))
+		}
+	}
+	return messages
+}
+
+// carry a category's leftover budget into the following months, or stop doing so
+func (d *Data) setRollover(category string, enabled bool) error {
+	if category == "" {
+		return fmt.Errorf("category must not be empty")
+	}
+	if !enabled {
+		delete(d.Rollover, category)
+		return nil
+	}
+	if _, ok := d.Rollover[category]; ok {
+		return nil
+	}
+	if d.Rollover == nil {
+		d.Rollover = make(map[string]string)
+	}
+	d.Rollover[category] = d.today().Format("2006-01")
+	return nil
+}
+
+// what is left of a rollover category's budget from the months before month (YYYY-MM), counted from
+// the month rollover was turned on; overspending carries over as a negative amount
+func (d *Data) rolloverInto(category, month string) float64 {
+	since, ok := d.Rollover[category]
+	if !ok {
+		return 0
+	}
+	start, err := time.Parse("2006-01", since)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse("2006-01", month)
+	if err != nil {
+		return 0
+	}
+	carried := 0.0
+	for current := start; current.Before(end); current = current.AddDate(0, 1, 0) {
+		carried += d.Budgets[category] - monthSpent(d.Transactions, category, current.Format("2006-01"))
+	}
+	return carried
+}
+
+func (l budgetLine) over() bool {
+	return !l.Unbudgeted && l.Actual > l.Available
+}
+
+// report output format chosen with --output
+var outputFormat = "table"
+
+func structuredOutput() bool {
+	return outputFormat != "table"
+}
+
+// write a report as JSON (value) or CSV (columns and rows) on stdout
+func emit(value any, columns []string, rows [][]any) error {
+	switch outputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write(columns)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				switch cell := cell.(type) {
+				case float64:
+					record[i] = strconv.FormatFloat(cell, 'f', -1, 64)
+				default:
+					record[i] = fmt.Sprint(cell)
+				}
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown output format: %s", outputFormat)
+}
+
+// set by --no-color
+var noColor bool
+
+// diagnostics below this level are left out: warnings by default, everything with --verbose,
+// only errors with --quiet; serve and bot log at info unless told otherwise
+var logLevel = new(slog.LevelVar)
+
+// log diagnostics to stderr as text, without the time when a person is watching, or as JSON lines
+func setupLogging(verbose, quiet bool, format string) error {
+	switch {
+	case verbose && quiet:
+		return fmt.Errorf("--verbose and --quiet cannot be used together")
+	case verbose:
+		logLevel.Set(slog.LevelDebug)
+	case quiet:
+		logLevel.Set(slog.LevelError)
+	default:
+		logLevel.Set(slog.LevelWarn)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, options)))
+	case "text":
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			options.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+				if len(groups) == 0 && attr.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return attr
+			}
+		}
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, options)))
+	default:
+		return fmt.Errorf("--log-format must be text or json")
+	}
+	return nil
+}
+
+// long-running commands report what they do unless --quiet or --verbose chose a level
+func logActivity() {
+	if logLevel.Level() == slog.LevelWarn {
+		logLevel.Set(slog.LevelInfo)
+	}
+}
+
+const (
+	colorRed   = "31"
+	colorGreen = "32"
+)
+
+// ANSI colors are only used on a terminal and never when NO_COLOR or --no-color is set
+func useColor() bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(code string, text string) string {
+	if !useColor() {
+		return text
+	}
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+type tableCell struct {
+	text  string
+	color string
+}
+
+func plainCell(text string) tableCell {
+	return tableCell{text: text}
+}
+
+// aligned plain-text table, colors are applied after padding so they never shift the columns
+type textTable struct {
+	headers []string
+	right   []bool
+	rows    [][]tableCell
+}
+
+func newTable(headers ...string) *textTable {
+	return &textTable{headers: headers, right: make([]bool, len(headers))}
+}
+
+// right align the given columns, used for amounts
+func (t *textTable) alignRight(columns ...int) *textTable {
+	for _, column := range columns {
+		t.right[column] = true
+	}
+	return t
+}
+
+func (t *textTable) addRow(cells ...tableCell) {
+	t.rows = append(t.rows, cells)
+}
+
+func (t *textTable) print() {
+	t.write(os.Stdout, useColor())
+}
+
+func (t *textTable) write(w io.Writer, color bool) {
+	widths := make([]int, len(t.headers))
+	for i, header := range t.headers {
+		widths[i] = utf8.RuneCountInString(header)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell.text))
+		}
+	}
+	line := func(cells []tableCell) {
+		var out strings.Builder
+		out.WriteString(" ")
+		for i, cell := range cells {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell.text))
+			text := cell.text
+			if color && cell.color != "" {
+				text = "\033[" + cell.color + "m" + text + "\033[0m"
+			}
+			out.WriteString(" ")
+			if t.right[i] {
+				out.WriteString(padding + text)
+			} else {
+				out.WriteString(text + padding)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(out.String(), " "))
+	}
+	// tables with only blank headers are printed without a header row
+	if strings.Join(t.headers, "") != "" {
+		headers := make([]tableCell, len(t.headers))
+		for i, header := range t.headers {
+			headers[i] = plainCell(header)
+		}
+		line(headers)
+	}
+	for _, row := range t.rows {
+		line(row)
+	}
+}
+
+// amount cell colored by direction: green for income, red for expenses
+func (d *Data) amountCell(amount float64, transactionType string) tableCell {
+	cell := plainCell(d.formatAmount(amount))
+	switch transactionType {
+	case Income:
+		cell.color = colorGreen
+	case Expense:
+		cell.color = colorRed
+	}
+	return cell
+}
+
+// balances are green when positive and red when negative
+func (d *Data) balanceCell(amount float64) tableCell {
+	if amount < 0 {
+		return d.amountCell(amount, Expense)
+	}
+	return d.amountCell(amount, Income)
+}
+
+func (d *Data) displayBudgetReport(period string, periodValue string) error {
+	lines, total, err := d.calculateBudgetReport(period, periodValue)
+	if err != nil {
+		return err
+	}
+	// spending without a budget gets its own subtotal instead of inflating the total variance
+	unbudgeted := 0.0
+	for _, line := range lines {
+		if line.Unbudgeted {
+			unbudgeted += line.Actual
+		}
+	}
+	if structuredOutput() {
+		rows := make([][]any, 0, len(lines)+2)
+		for _, line := range append(lines, total) {
+			rows = append(rows, []any{line.Category, line.Budgeted, line.Rollover, line.Available, line.Actual, line.Variance, line.Percent})
+		}
+		if unbudgeted > 0 {
+			rows = append(rows, []any{"Unbudgeted", 0.0, 0.0, 0.0, unbudgeted, 0.0, 0.0})
+		}
+		return emit(struct {
+			Period     string       `json:"period"`
+			Categories []budgetLine `json:"categories"`
+			Total      budgetLine   `json:"total"`
+			Unbudgeted float64      `json:"unbudgeted,omitempty"`
+		}{periodValue, lines, total, unbudgeted}, []string{"category", "budgeted", "rollover", "available", "actual", "variance", "percent_used"}, rows)
+	}
+	label := periodValue
+	if period == Week {
+		from, _ := d.periodRange(period, periodValue)
+		label = "the week of " + from.Format("2006-01-02")
+	}
+	fmt.Printf("Budget vs. actual for %s:\n", label)
+	if len(d.books) > 0 {
+		fmt.Println("Consolidated:", strings.Join(d.books, ", "))
+	}
+	// the rollover columns only show up once some category uses rollover
+	rollover := period == Month && len(d.Rollover) > 0
+	headers := []string{"Category", "Budgeted", "Actual", "Variance", "Used", "", "Note"}
+	if rollover {
+		headers = slices.Insert(headers, 2, "Rollover", "Available")
+	}
+	table := newTable(headers...).alignRight(1, 2, 3, 4)
+	if rollover {
+		table.alignRight(5, 6)
+	}
+	for _, line := range append(lines, total) {
+		used := "-"
+		if line.Available > 0 {
+			used = fmt.Sprintf("%.0f%%", line.Percent)
+		}
+		status, variance := plainCell(""), d.balanceCell(line.Variance)
+		if line.over() {
+			status = tableCell{text: "OVER", color: colorRed}
+		}
+		if line.Unbudgeted {
+			status, variance = plainCell("unbudgeted"), plainCell("-")
+		}
+		note := ""
+		if line.Category != total.Category {
+			note = d.Notes[line.Category]
+		}
+		cells := []tableCell{plainCell(line.Category), plainCell(d.formatAmount(line.Budgeted)), d.amountCell(line.Actual, Expense),
+			variance, plainCell(used), status, plainCell(note)}
+		if rollover {
+			cells = slices.Insert(cells, 2, d.balanceCell(line.Rollover), plainCell(d.formatAmount(line.Available)))
+		}
+		table.addRow(cells...)
+	}
+	if unbudgeted > 0 {
+		cells := []tableCell{plainCell("Unbudgeted"), plainCell(""), d.amountCell(unbudgeted, Expense), plainCell("-"), plainCell("-"), plainCell(""), plainCell("")}
+		if rollover {
+			cells = slices.Insert(cells, 2, plainCell(""), plainCell(""))
+		}
+		table.addRow(cells...)
+	}
+	table.print()
+	filter := d.periodFilter(period, periodValue)
+	filter.Type = Expense
+	d.noteLeftOut(filter)
+	return nil
+}
+// display the summary of a period, narrowed by the non-date fields of filter
+func (d *Data) displaySummary(period string, periodValue string, filter Filter) error {
+	filter.From, filter.To = d.periodRange(period, periodValue)
+	totalIncome, totalExpenses, incomeSources, categorySummary := d.summarize(filter)
+	if structuredOutput() {
+		rows := [][]any{{"total", "Income", totalIncome}, {"total", "Expenses", totalExpenses}, {"total", "Net Balance", totalIncome - totalExpenses}}
+		for _, source := range slices.Sorted(maps.Keys(incomeSources)) {
+			rows = append(rows, []any{"source", source, incomeSources[source]})
+		}
+		for _, category := range slices.Sorted(maps.Keys(categorySummary)) {
+			rows = append(rows, []any{"category", category, categorySummary[category]})
+		}
+		return emit(struct {
+			Period        string             `json:"period"`
+			Value         string             `json:"value,omitempty"`
+			Payee         string             `json:"payee,omitempty"`
+			Income        float64            `json:"income"`
+			Expenses      float64            `json:"expenses"`
+			Net           float64            `json:"net_balance"`
+			IncomeSources map[string]float64 `json:"income_sources"`
+			Categories    map[string]float64 `json:"categories"`
+		}{period, periodValue, filter.Payee, totalIncome, totalExpenses, totalIncome - totalExpenses, incomeSources, categorySummary}, []string{"section", "name", "amount"}, rows)
+	}
+	if filter.Payee != "" {
+		fmt.Println("Payee:", filter.Payee)
+	}
+	if len(d.books) > 0 {
+		fmt.Println("Consolidated:", strings.Join(d.books, ", "))
+	}
+	if period == Fiscal {
+		year, _ := strconv.Atoi(periodValue)
+		from, to := d.Settings.fiscalYearRange(year)
+		fmt.Printf("Fiscal year %d (%s to %s)\n", year, from.Format("2006-01"), to.AddDate(0, -1, 0).Format("2006-01"))
+	}
+	totals := newTable("", "").alignRight(1)
+	totals.addRow(plainCell("Income:"), d.amountCell(totalIncome, Income))
+	totals.addRow(plainCell("Expenses:"), d.amountCell(totalExpenses, Expense))
+	totals.addRow(plainCell("Net Balance:"), d.balanceCell(totalIncome-totalExpenses))
+	totals.print()
+	if len(incomeSources) > 0 {
+		fmt.Println("Income by Source:")
+		sources := newTable("Source", "Amount", "Share").alignRight(1, 2)
+		for _, source := range slices.Sorted(maps.Keys(incomeSources)) {
+			sources.addRow(plainCell(source), d.amountCell(incomeSources[source], Income),
+				plainCell(fmt.Sprintf("%.1f%%", incomeSources[source]/totalIncome*100)))
+		}
+		sources.print()
+	}
+	fmt.Println("Expense Categories:")
+	table := newTable("Category", "Amount", "Note").alignRight(1)
+	for _, category := range slices.Sorted(maps.Keys(categorySummary)) { // stable output for scripts
+		table.addRow(plainCell(category), plainCell(d.formatAmount(categorySummary[category])), plainCell(d.Notes[category]))
+	}
+	table.print()
+	d.noteLeftOut(filter)
+	return nil
+}
+
+// a what-if applied to every forecast month, e.g. "income +5%" or "add 300/month rent increase"
+type Scenario struct {
+	Text          string  `json:"text"`
+	IncomeFactor  float64 `json:"-"` // multiplies monthly income
+	ExpenseFactor float64 `json:"-"`
+	Income        float64 `json:"-"` // added to monthly income after the factor
+	Expenses      float64 `json:"-"`
+}
+
+// parse "income|expenses +5%", "income|expenses -200" or "add|cut 300[/month] [label]"
+func parseScenario(text string) (Scenario, error) {
+	scenario := Scenario{Text: strings.TrimSpace(text), IncomeFactor: 1, ExpenseFactor: 1}
+	fields := strings.Fields(strings.ToLower(text))
+	usage := fmt.Errorf("invalid scenario %q, use e.g. \"income +5%%\", \"expenses -200\" or \"add 300/month rent increase\"", text)
+	if len(fields) < 2 {
+		return Scenario{}, usage
+	}
+	change := fields[1]
+	percent := strings.HasSuffix(change, "%")
+	amount, err := parseAmount(strings.TrimSuffix(strings.TrimSuffix(change, "%"), "/month"))
+	if err != nil {
+		return Scenario{}, usage
+	}
+	switch fields[0] {
+	case "income", "expenses", "expense":
+		if len(fields) != 2 {
+			return Scenario{}, usage
+		}
+		factor, add := &scenario.IncomeFactor, &scenario.Income
+		if fields[0] != "income" {
+			factor, add = &scenario.ExpenseFactor, &scenario.Expenses
+		}
+		if percent {
+			*factor = 1 + amount/100
+		} else {
+			*add = amount
+		}
+	case "add", "cut":
+		if percent || amount < 0 {
+			return Scenario{}, usage
+		}
+		scenario.Expenses = amount
+		if fields[0] == "cut" {
+			scenario.Expenses = -amount
+		}
+	default:
+		return Scenario{}, usage
+	}
+	return scenario, nil
+}
+
+// collects repeated --scenario flags
+type scenarioFlags []Scenario
+
+func (f *scenarioFlags) String() string {
+	texts := make([]string, len(*f))
+	for i, scenario := range *f {
+		texts[i] = scenario.Text
+	}
+	return strings.Join(texts, "; ")
+}
+
+func (f *scenarioFlags) Set(text string) error {
+	scenario, err := parseScenario(text)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, scenario)
+	return nil
+}
+
+type forecastOptions struct {
+	Months      int
+	History     int // full months before this one to draw from
+	Simulations int
+	Confidence  float64 // share of the simulations between low and high, e.g. 0.8
+	Scenarios   []Scenario
+	Seasonal    bool // scale each month by what its calendar month usually brings, needs a year of history
+}
+
+var defaultForecast = forecastOptions{Months: 3, History: 12, Simulations: 1000, Confidence: 0.8}
+
+// a low to high range around the expected (median) value, in cents
+type band struct {
+	Low      float64 `json:"low"`
+	Expected float64 `json:"expected"`
+	High     float64 `json:"high"`
+}
+
+type forecastMonth struct {
+	Month    string `json:"month"`
+	Income   band   `json:"income"`
+	Expenses band   `json:"expenses"`
+	Balance  band   `json:"net_balance"` // running net balance at the end of the month
+}
+
+type forecast struct {
+	History     int                `json:"history_months"` // months that had data to draw from
+	Confidence  float64            `json:"confidence"`
+	Scenarios   []Scenario         `json:"scenarios"`
+	Seasonality map[string]float64 `json:"seasonality,omitempty"` // expense factor per calendar month, 1 is an average month
+	Months      []forecastMonth    `json:"months"`
+}
+
+// how much more or less than an average month each calendar month brings, 1 for months never seen
+// or when there is nothing to compare with
+func seasonalFactors(months []time.Month, values []float64) [13]float64 {
+	var sums, counts [13]float64
+	var total float64
+	for i, month := range months {
+		sums[month] += values[i]
+		counts[month]++
+		total += values[i]
+	}
+	var factors [13]float64
+	for month := time.January; month <= time.December; month++ {
+		factors[month] = 1
+		if counts[month] > 0 && total > 0 {
+			factors[month] = sums[month] / counts[month] / (total / float64(len(values)))
+		}
+	}
+	return factors
+}
+
+// value below which a share q of the sorted values lie, interpolating between neighbours
+func quantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	i := int(position)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (sorted[i+1]-sorted[i])*(position-float64(i))
+}
+
+func bandOf(values []float64, confidence float64) band {
+	sorted := slices.Sorted(slices.Values(values))
+	cents := func(q float64) float64 { return math.Round(quantile(sorted, q)*100) / 100 }
+	return band{Low: cents((1 - confidence) / 2), Expected: cents(0.5), High: cents((1 + confidence) / 2)}
+}
+
+// Monte Carlo forecast: every simulated month draws the income and expenses of a random past month,
+// scenarios applied, and the spread over all simulations gives the band; the seed is fixed so the
+// same book always gives the same forecast
+func (d *Data) calculateForecast(options forecastOptions) (forecast, error) {
+	type month struct {
+		income, expenses float64
+		calendar         time.Month
+	}
+	var first time.Time
+	for _, transaction := range d.Transactions {
+		if first.IsZero() || transaction.Date.Before(first) {
+			first = transaction.Date
+		}
+	}
+	thisMonth := d.today().AddDate(0, 0, 1-d.today().Day())
+	// every full month of the book, newest first; the simulation draws from the last options.History of them
+	var full []month
+	for start := thisMonth.AddDate(0, -1, 0); !first.IsZero() && start.AddDate(0, 1, 0).After(first); start = start.AddDate(0, -1, 0) {
+		if len(full) == options.History && !options.Seasonal {
+			break
+		}
+		income, expenses, _, _ := d.calculateSummary(Month, start.Format("2006-01"))
+		full = append(full, month{income, expenses, start.Month()})
+	}
+	history := full[:min(options.History, len(full))]
+	result := forecast{History: len(history), Confidence: options.Confidence, Scenarios: options.Scenarios}
+
+	// seasonal factors are learned from all of the book; with every calendar month seen at least twice
+	// a drawn month taken back to an average month still differs from the others, so the band survives
+	// scaling it to the month it stands in for
+	var incomeFactors, expenseFactors [13]float64
+	if options.Seasonal {
+		if len(full) < 24 {
+			return forecast{}, fmt.Errorf("seasonal forecasts need two years of history, there are %d full months", len(full))
+		}
+		calendar, incomes, expenses := make([]time.Month, len(full)), make([]float64, len(full)), make([]float64, len(full))
+		for i, m := range full {
+			calendar[i], incomes[i], expenses[i] = m.calendar, m.income, m.expenses
+		}
+		incomeFactors, expenseFactors = seasonalFactors(calendar, incomes), seasonalFactors(calendar, expenses)
+		result.Seasonality = make(map[string]float64)
+		for m := time.January; m <= time.December; m++ {
+			result.Seasonality[m.String()[:3]] = math.Round(expenseFactors[m]*100) / 100
+		}
+	}
+	rescale := func(value float64, factors [13]float64, from, to time.Month) float64 {
+		if !options.Seasonal || factors[from] == 0 {
+			return value
+		}
+		return value / factors[from] * factors[to]
+	}
+	if len(history) == 0 {
+		history = []month{{}}
+	}
+	totalIncome, totalExpenses, _, _ := d.calculateSummary(All, "")
+
+	rng := rand.New(rand.NewPCG(1, uint64(options.Simulations)))
+	incomes, expenses, balances := make([][]float64, options.Months), make([][]float64, options.Months), make([][]float64, options.Months)
+	for i := range options.Months {
+		incomes[i], expenses[i], balances[i] = make([]float64, options.Simulations), make([]float64, options.Simulations), make([]float64, options.Simulations)
+	}
+	for run := range options.Simulations {
+		balance := totalIncome - totalExpenses
+		for i := range options.Months {
+			drawn := history[rng.IntN(len(history))]
+			target := thisMonth.AddDate(0, i+1, 0).Month()
+			drawn.income = rescale(drawn.income, incomeFactors, drawn.calendar, target)
+			drawn.expenses = rescale(drawn.expenses, expenseFactors, drawn.calendar, target)
+			for _, scenario := range options.Scenarios {
+				drawn.income = drawn.income*scenario.IncomeFactor + scenario.Income
+				drawn.expenses = max(drawn.expenses*scenario.ExpenseFactor+scenario.Expenses, 0)
+			}
+			balance += drawn.income - drawn.expenses
+			incomes[i][run], expenses[i][run], balances[i][run] = drawn.income, drawn.expenses, balance
+		}
+	}
+	for i := range options.Months {
+		result.Months = append(result.Months, forecastMonth{
+			Month:    thisMonth.AddDate(0, i+1, 0).Format("2006-01"),
+			Income:   bandOf(incomes[i], options.Confidence),
+			Expenses: bandOf(expenses[i], options.Confidence),
+			Balance:  bandOf(balances[i], options.Confidence),
+		})
+	}
+	return result, nil
+}
+
+func (d *Data) displayPredictions(options forecastOptions) error {
+	result, err := d.calculateForecast(options)
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(result.Months))
+		for i, m := range result.Months {
+			rows[i] = []any{m.Month, m.Expenses.Low, m.Expenses.Expected, m.Expenses.High, m.Balance.Low, m.Balance.Expected, m.Balance.High}
+		}
+		return emit(result, []string{"month", "expenses_low", "expenses", "expenses_high", "net_balance_low", "net_balance", "net_balance_high"}, rows)
+	}
+	if result.History == 0 {
+		fmt.Println("No full month of history yet, the forecast only shows the scenarios.")
+	}
+	fmt.Printf("Predicted Expenses and Net Balance for the next %d months (%.0f%% band from %d months of history):\n",
+		options.Months, options.Confidence*100, result.History)
+	for _, scenario := range result.Scenarios {
+		fmt.Println("  Scenario:", scenario.Text)
+	}
+	if result.Seasonality != nil {
+		factors := make([]string, 0, 12)
+		for m := time.January; m <= time.December; m++ {
+			factors = append(factors, fmt.Sprintf("%s %.2f", m.String()[:3], result.Seasonality[m.String()[:3]]))
+		}
+		fmt.Println("  Seasonal expense factors:", strings.Join(factors, ", "))
+	}
+	table := newTable("Month", "Expenses low", "Expected", "High", "Net Balance low", "Expected", "High").alignRight(1, 2, 3, 4, 5, 6)
+	for _, m := range result.Months {
+		table.addRow(plainCell(m.Month), plainCell(d.formatAmount(m.Expenses.Low)), d.amountCell(m.Expenses.Expected, Expense), plainCell(d.formatAmount(m.Expenses.High)),
+			d.balanceCell(m.Balance.Low), d.balanceCell(m.Balance.Expected), d.balanceCell(m.Balance.High))
+	}
+	table.print()
+	return nil
+}
+
+// month-to-date spend and the budget left for the month containing now
+type widgetStats struct {
+	Month     string  `json:"month"`
+	Spent     float64 `json:"spent"`
+	Budget    float64 `json:"budget"`
+	Remaining float64 `json:"remaining"`
+}
+
+func (d *Data) calculateWidgetStats(now time.Time) widgetStats {
+	stats := widgetStats{Month: now.Format("2006-01")}
+	filter := d.periodFilter(Month, stats.Month)
+	filter.Type = Expense
+	for transaction := range d.Query(filter) {
+		if !transaction.foreign() {
+			stats.Spent += transaction.Amount
+		}
+	}
+	for _, amount := range d.Budgets {
+		stats.Budget += amount
+	}
+	stats.Remaining = stats.Budget - stats.Spent
+	return stats
+}
+
+// the widget cache is only valid while the data file is unchanged and the month has not rolled over
+type widgetCache struct {
+	ModTime  time.Time   `json:"mod_time"`
+	Size     int64       `json:"size"`
+	Timezone string      `json:"timezone,omitempty"` // the book's zone, decides when the month rolls over
+	Stats    widgetStats `json:"stats"`
+}
+
+func widgetLine(stats widgetStats) string {
+	return fmt.Sprintf("left=%.2f mtd=%.2f budget=%.2f", stats.Remaining, stats.Spent, stats.Budget)
+}
+
+// print a single status bar line, reusing the cached aggregates when the data file has not changed;
+// a line as of an earlier day is worked out from the book every time and never cached
+func displayWidget(dataFile, asOf string) error {
+	cacheFile := dataFile + ".cache"
+	info, err := os.Stat(dataFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	var cache widgetCache
+	if info != nil && asOf == "" {
+		if content, err := os.ReadFile(cacheFile); err == nil && json.Unmarshal(content, &cache) == nil {
+			month := time.Now().In(Settings{Timezone: cache.Timezone}.location()).Format("2006-01")
+			if cache.ModTime.Equal(info.ModTime()) && cache.Size == info.Size() && cache.Stats.Month == month {
+				fmt.Println(widgetLine(cache.Stats))
+				return nil
+			}
+		}
+	}
+
+	data, err := loadData(dataFile)
+	if err != nil {
+		return err
+	}
+	if asOf != "" {
+		date, err := data.parseDateInput(asOf)
+		if err != nil {
+			return fmt.Errorf("--as-of: %w", err)
+		}
+		data.showAsOf(date)
+	}
+	stats := data.calculateWidgetStats(data.today())
+	fmt.Println(widgetLine(stats))
+
+	if info != nil && asOf == "" {
+		cache = widgetCache{ModTime: info.ModTime(), Size: info.Size(), Timezone: data.Settings.Timezone, Stats: stats}
+		if content, err := json.Marshal(cache); err == nil {
+			os.WriteFile(cacheFile, content, 0o644) // best effort, the next call simply recomputes
+		}
+	}
+	return nil
+}
+
+// run a single command given on the command line instead of starting the interactive prompt
+func runCommand(data *Data, dataFile string, args []string) error {
+	command, args := strings.ToLower(args[0]), args[1:]
+	if err := data.refuseInView(command, args); err != nil {
+		return err
+	}
+	switch command {
+	case "summary":
+		fs := flag.NewFlagSet("summary", flag.ContinueOnError)
+		periodFlag := fs.String("period", All, "all, week, month, year, YYYY-MM-DD (its week), YYYY-MM or YYYY")
+		fiscal := fs.Bool("fiscal", false, "treat years as fiscal years starting at the configured month")
+		payee := fs.String("payee", "", "only include transactions with this payee")
+		status := fs.String("status", "", "posted leaves out pending transactions, see list --status for the others")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		period, periodValue, err := data.parsePeriodFlag(*periodFlag, *fiscal, data.today())
+		if err != nil {
+			return err
+		}
+		filter, err := data.filterFromQuery(url.Values{"payee": {*payee}, "status": {*status}})
+		if err != nil {
+			return err
+		}
+		return data.displaySummary(period, periodValue, filter)
+
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		sortBy := fs.String("sort", "date", "date, amount or category")
+		descending := fs.Bool("desc", false, "sort in descending order")
+		limit := fs.Int("limit", 0, "show at most this many transactions, 0 for all")
+		offset := fs.Int("offset", 0, "skip this many transactions first")
+		filter := data.filterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if *limit < 0 || *offset < 0 {
+			return fmt.Errorf("limit and offset must not be negative")
+		}
+		f, err := filter()
+		if err != nil {
+			return err
+		}
+		transactions, err := data.sortedTransactions(f, strings.ToLower(*sortBy), *descending)
+		if err != nil {
+			return err
+		}
+		return data.displayTransactions(transactions, *limit, *offset)
+
+	case "report":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: report budget|networth|top|send|pdf|html|<plugin> [flags]")
+		}
+		kind := strings.ToLower(args[0])
+		if kind == "send" {
+			fs := flag.NewFlagSet("report send", flag.ContinueOnError)
+			lastMonth := data.today().AddDate(0, 0, 1-data.today().Day()).AddDate(0, -1, 0).Format("2006-01")
+			month := fs.String("month", lastMonth, "month to report (YYYY-MM), last month by default")
+			format := fs.String("format", "text", "text or html")
+			schedule := fs.Bool("schedule", false, "print a crontab line that sends the statement every month instead of sending now")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if *format != "text" && *format != "html" {
+				return fmt.Errorf("--format must be text or html")
+			}
+			if *schedule {
+				line, err := statementSchedule(dataFile, *format == "html")
+				if err != nil {
+					return err
+				}
+				fmt.Println("# add to your crontab with crontab -e")
+				fmt.Println(line)
+				return nil
+			}
+			if _, err := time.Parse("2006-01", *month); err != nil {
+				return fmt.Errorf("invalid month %q, use YYYY-MM", *month)
+			}
+			if err := data.sendStatement(*month, *format == "html"); err != nil {
+				return err
+			}
+			fmt.Printf("Statement for %s sent.\n", *month)
+			return nil
+		}
+		if kind == "pdf" {
+			fs := flag.NewFlagSet("report pdf", flag.ContinueOnError)
+			periodFlag := fs.String("period", Month, "month to report: month or YYYY-MM")
+			out := fs.String("out", "", "PDF file to write, statement-YYYY-MM.pdf by default")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			period, month, err := data.parsePeriodFlag(*periodFlag, false, data.today())
+			if err != nil {
+				return err
+			}
+			if period != Month {
+				return fmt.Errorf("PDF statements cover a single month, use --period YYYY-MM")
+			}
+			filename := cmp.Or(*out, "statement-"+month+".pdf")
+			if err := data.writeStatementPDF(month, filename); err != nil {
+				return err
+			}
+			fmt.Printf("Statement for %s written to %s.\n", month, filename)
+			return nil
+		}
+		if kind == "html" {
+			fs := flag.NewFlagSet("report html", flag.ContinueOnError)
+			periodFlag := fs.String("period", Year, "all, week, month, year, YYYY-MM-DD, YYYY-MM or YYYY")
+			fiscal := fs.Bool("fiscal", false, "treat years as fiscal years starting at the configured month")
+			out := fs.String("out", "dashboard.html", "HTML file to write")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			period, periodValue, err := data.parsePeriodFlag(*periodFlag, *fiscal, data.today())
+			if err != nil {
+				return err
+			}
+			if err := data.writeDashboard(period, periodValue, *out); err != nil {
+				return err
+			}
+			fmt.Printf("Dashboard written to %s.\n", *out)
+			return nil
+		}
+		if kind == "tax" {
+			fs := flag.NewFlagSet("report tax", flag.ContinueOnError)
+			fiscal := fs.Bool("fiscal", false, "the tax year is the fiscal year starting at the configured month")
+			year := fs.String("year", "", "YYYY, the current year when empty")
+			out := fs.String("out", "", "also write the deductible expenses to this CSV file")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			kind, value := Year, data.today().Format("2006")
+			if *fiscal {
+				kind, value = Fiscal, strconv.Itoa(data.Settings.fiscalYearOf(data.today()))
+			}
+			period, err := data.ParsePeriod(kind, cmp.Or(*year, value))
+			if err != nil {
+				return err
+			}
+			return data.displayTaxReport(period, *out)
+		}
+		if kind == "health" {
+			return data.displayHealth()
+		}
+		if !slices.Contains([]string{"budget", "networth", "top"}, kind) {
+			if p, ok := findPlugin(kind, "report"); ok {
+				return p.report(data, args[1:])
+			}
+		}
+		fs := flag.NewFlagSet("report "+kind, flag.ContinueOnError)
+		periodFlag := fs.String("period", Month, "week, month, year, YYYY-MM-DD (its week), YYYY-MM or YYYY")
+		fiscal := fs.Bool("fiscal", false, "treat years as fiscal years starting at the configured month")
+		interval := fs.String("interval", Month, "month or year, for networth")
+		n := fs.Int("n", 10, "number of entries, for top")
+		adjust := fs.Bool("inflation-adjust", false, "express past amounts in today's money using the CPI table, for networth")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		period, periodValue, err := data.parsePeriodFlag(*periodFlag, *fiscal, data.today())
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "budget":
+			return data.displayBudgetReport(period, periodValue)
+		case "networth":
+			return data.displayNetWorth(strings.ToLower(*interval), *adjust)
+		case "top":
+			return data.displayTopReport(period, periodValue, *n)
+		default:
+			return fmt.Errorf("unknown report: %s", kind)
+		}
+
+	case "account":
+		if len(args) == 0 || args[0] == "list" {
+			data.displayAccounts()
+			return nil
+		}
+		if args[0] != "add" || len(args) < 2 {
+			return fmt.Errorf("usage: account list | account add <name> [--kind asset|liability|investment] [--opening amount] [--date YYYY-MM-DD]")
+		}
+		fs := flag.NewFlagSet("account add", flag.ContinueOnError)
+		kind := fs.String("kind", "asset", "asset, liability or investment")
+		opening := fs.Float64("opening", 0, "opening balance")
+		dateStr := fs.String("date", data.today().Format("2006-01-02"), "opening date")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		date, err := data.parseDateInput(*dateStr)
+		if err != nil {
+			return err
+		}
+		if err := data.setAccount(Account{Name: args[1], Kind: *kind, OpeningBalance: *opening, OpeningDate: date}); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "compare":
+		fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+		lastMonth := data.today().AddDate(0, 0, 1-data.today().Day()).AddDate(0, -1, 0)
+		a := fs.String("a", lastMonth.Format("2006-01"), "the earlier period: YYYY-MM, YYYY, YYYY-MM-DD (its week), month or year")
+		b := fs.String("b", data.today().Format("2006-01"), "the period compared with it")
+		fiscal := fs.Bool("fiscal", false, "treat years as fiscal years starting at the configured month")
+		highlight := fs.Int("top", 3, "how many of the biggest changes to point out")
+		adjust := fs.Bool("inflation-adjust", false, "express amounts in today's money using the CPI table")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return data.displayComparison(*a, *b, *fiscal, *adjust, *highlight)
+
+	case "stats":
+		fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+		filter := data.filterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		f, err := filter()
+		if err != nil {
+			return err
+		}
+		return data.displayStats(f)
+
+	case "reconcile":
+		usage := fmt.Errorf("usage: reconcile <account> [--balance amount] [--date YYYY-MM-DD] | reconcile clear|unclear <id>...")
+		if len(args) == 0 {
+			return usage
+		}
+		if args[0] == "clear" || args[0] == "unclear" {
+			if len(args) < 2 {
+				return usage
+			}
+			ids := make([]int, len(args)-1)
+			for i, arg := range args[1:] {
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("invalid transaction ID: %s", arg)
+				}
+				ids[i] = id
+			}
+			if err := data.setCleared(ids, args[0] == "clear"); err != nil {
+				return err
+			}
+			return data.save(dataFile)
+		}
+		fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+		balanceStr := fs.String("balance", "", "the statement's ending balance, asked for when missing")
+		dateStr := fs.String("date", data.today().Format("2006-01-02"), "the statement's closing date")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		through, err := data.parseDateInput(*dateStr)
+		if err != nil {
+			return err
+		}
+		if *balanceStr == "" {
+			*balanceStr = prompt("Statement ending balance", "")
+		}
+		statement, err := parseFloat(*balanceStr)
+		if err != nil {
+			return err
+		}
+		return data.reconcile(dataFile, args[0], statement, through)
+
+	case "share":
+		usage := fmt.Errorf("usage: share <id> [--paid-by name] <person>[:weight]... | share <id> off, the book's owner is %q", owner)
+		if len(args) < 2 {
+			return usage
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid transaction ID: %s", args[0])
+		}
+		fs := flag.NewFlagSet("share", flag.ContinueOnError)
+		paidBy := fs.String("paid-by", owner, "who paid the expense")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var shares []Share
+		if fs.NArg() != 1 || fs.Arg(0) != "off" {
+			if fs.NArg() == 0 {
+				return usage
+			}
+			if shares, err = parseShares(fs.Args()); err != nil {
+				return err
+			}
+		}
+		if err := data.shareExpense(id, strings.TrimSpace(*paidBy), shares); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "settle":
+		if len(args) == 0 {
+			return data.displaySettlement()
+		}
+		if args[0] != "pay" {
+			return fmt.Errorf("usage: settle | settle pay [--date YYYY-MM-DD] <from> <to> <amount>")
+		}
+		fs := flag.NewFlagSet("settle pay", flag.ContinueOnError)
+		dateStr := fs.String("date", data.today().Format("2006-01-02"), "day of the payment")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 3 {
+			return fmt.Errorf("usage: settle pay [--date YYYY-MM-DD] <from> <to> <amount>")
+		}
+		date, err := data.parseDateInput(*dateStr)
+		if err != nil {
+			return err
+		}
+		amount, err := parseAmount(fs.Arg(2))
+		if err != nil {
+			return err
+		}
+		if err := data.recordSettlement(Settlement{Date: date, From: fs.Arg(0), To: fs.Arg(1), Amount: amount}); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "mileage":
+		fs := flag.NewFlagSet("mileage", flag.ContinueOnError)
+		dateStr := fs.String("date", data.today().Format("2006-01-02"), "day of the trip")
+		rate := fs.Float64("rate", data.Settings.MileageRate, "amount per mile or kilometre, set a default with: config mileage-rate <amount>")
+		payer := fs.String("payer", "", "who pays the trip back")
+		account := fs.String("account", "", "account")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: mileage [--date YYYY-MM-DD] [--rate amount] [--payer name] <distance> <description>")
+		}
+		distance, err := parseAmount(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *rate <= 0 {
+			return fmt.Errorf("no mileage rate, pass --rate or set one with: config mileage-rate <amount>")
+		}
+		date, err := data.parseDateInput(*dateStr)
+		if err != nil {
+			return err
+		}
+		count := len(data.Transactions)
+		err = data.appendTransaction(Transaction{Date: date, Type: Expense, Category: mileageCategory, Amount: math.Round(*rate*distance*100) / 100,
+			Description: fmt.Sprintf("%s (%v at %v)", fs.Arg(1), distance, *rate), Account: *account, Reimbursable: *payer})
+		if err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		fmt.Printf("Transaction %d added successfully.\n", data.lastID)
+		data.raiseAlerts(count)
+
+	case "recurring":
+		usage := fmt.Errorf("usage: recurring list | recurring add [--every week|month|year] [--start YYYY-MM-DD] [--type Expense] --category c --amount n [--description d] [--account a] <name> | recurring remove <name>")
+		if len(args) == 0 || args[0] == "list" {
+			return data.displayRecurring()
+		}
+		switch args[0] {
+		case "add":
+			fs := flag.NewFlagSet("recurring add", flag.ContinueOnError)
+			every := fs.String("every", Month, "week, month or year")
+			startStr := fs.String("start", data.today().Format("2006-01-02"), "first occurrence")
+			transactionType := fs.String("type", Expense, "Income or Expense")
+			category := fs.String("category", "", "category")
+			amountStr := fs.String("amount", "", "amount")
+			description := fs.String("description", "", "description")
+			account := fs.String("account", "", "account")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 {
+				return usage
+			}
+			start, err := data.parseDateInput(*startStr)
+			if err != nil {
+				return err
+			}
+			amount, err := parseAmount(*amountStr)
+			if err != nil {
+				return err
+			}
+			if err := data.addRecurring(Recurring{Name: fs.Arg(0), Type: *transactionType, Category: *category, Amount: amount,
+				Description: *description, Account: *account, Every: *every, Start: start}); err != nil {
+				return err
+			}
+		case "remove":
+			if len(args) != 2 {
+				return usage
+			}
+			i, ok := data.findRecurring(args[1])
+			if !ok {
+				return fmt.Errorf("no recurring transaction named %q", args[1])
+			}
+			data.Recurring = slices.Delete(data.Recurring, i, i+1)
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "safetospend":
+		return data.displaySafeToSpend()
+
+	case "bills":
+		if len(args) > 0 && args[0] == "due" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("bills due", flag.ContinueOnError)
+		days := fs.Int("days", 14, "how many days ahead to look")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 || *days < 0 {
+			return fmt.Errorf("usage: bills due [--days 14]")
+		}
+		return data.displayBillsDue(*days)
+
+	case "reimbursements":
+		return data.displayReimbursements()
+
+	case "reimburse":
+		usage := fmt.Errorf("usage: reimburse mark <payer> <id>... | reimburse unmark <id>... | reimburse paid [--date YYYY-MM-DD] [--category name] [--account name] <payer> [<id>...]")
+		if len(args) < 2 {
+			return usage
+		}
+		switch args[0] {
+		case "mark", "unmark":
+			payer, idArgs := "", args[1:]
+			if args[0] == "mark" {
+				if len(args) < 3 {
+					return usage
+				}
+				payer, idArgs = args[1], args[2:]
+			}
+			ids, err := parseIDs(idArgs)
+			if err != nil {
+				return err
+			}
+			if err := data.markReimbursable(ids, payer); err != nil {
+				return err
+			}
+		case "paid":
+			fs := flag.NewFlagSet("reimburse paid", flag.ContinueOnError)
+			dateStr := fs.String("date", data.today().Format("2006-01-02"), "day the money came in")
+			category := fs.String("category", "Reimbursements", "income category")
+			account := fs.String("account", "", "account the money came into")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() == 0 {
+				return usage
+			}
+			date, err := data.parseDateInput(*dateStr)
+			if err != nil {
+				return err
+			}
+			ids, err := parseIDs(fs.Args()[1:])
+			if err != nil {
+				return err
+			}
+			income, err := data.reimburse(fs.Arg(0), ids, Transaction{Date: date, Category: *category, Account: *account})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Income %d of %s added for %s.\n", income.ID, data.formatAmount(income.Amount), income.Payee)
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "suggest":
+		fs := flag.NewFlagSet("suggest", flag.ContinueOnError)
+		payee := fs.String("payee", "", "payee")
+		n := fs.Int("n", 3, "number of categories to show")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			return fmt.Errorf("usage: suggest <id> | suggest [--payee name] <description>")
+		}
+		transaction := Transaction{Payee: *payee, Description: strings.Join(fs.Args(), " ")}
+		if id, err := strconv.Atoi(fs.Arg(0)); err == nil && fs.NArg() == 1 {
+			found, err := data.findTransaction(id)
+			if err != nil {
+				return err
+			}
+			transaction = *found
+		}
+		guesses := data.trainedClassifier().Rank(transaction)
+		guesses = guesses[:min(*n, len(guesses))]
+		if structuredOutput() {
+			rows := make([][]any, len(guesses))
+			for i, guess := range guesses {
+				rows[i] = []any{guess.Category, guess.Confidence}
+			}
+			return emit(guesses, []string{"category", "confidence"}, rows)
+		}
+		if len(guesses) == 0 {
+			fmt.Println("Nothing to go on, the classifier learns from categorized transactions with a description or payee.")
+			return nil
+		}
+		for _, guess := range guesses {
+			fmt.Printf("  %-20s %5.1f%%\n", guess.Category, guess.Confidence*100)
+		}
+
+	case "categorize":
+		fs := flag.NewFlagSet("categorize", flag.ContinueOnError)
+		threshold := fs.Float64("threshold", cmp.Or(data.Settings.AutoCategorize, 0.8), "confidence needed to file a transaction, between 0 and 1")
+		dryRun := fs.Bool("dry-run", false, "only show what would be filed")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if *threshold <= 0 || *threshold > 1 {
+			return fmt.Errorf("threshold must be above 0 and at most 1")
+		}
+		changed := data.autoCategorize(*threshold)
+		for _, id := range slices.Sorted(maps.Keys(changed)) {
+			transaction, _ := data.findTransaction(id)
+			fmt.Printf("  %d %s: %s (%.0f%%)\n", id, transaction.Description, changed[id].Category, changed[id].Confidence*100)
+		}
+		if *dryRun {
+			fmt.Printf("Would categorize %d transactions.\n", len(changed))
+			return nil
+		}
+		fmt.Printf("Categorized %d transactions.\n", len(changed))
+		if len(changed) == 0 {
+			return nil
+		}
+		return data.save(dataFile)
+
+	case "attach":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: attach <id> <file>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid transaction ID: %s", args[0])
+		}
+		if _, err := data.attach(dataFile, id, args[1]); err != nil {
+			return err
+		}
+		if total, ok, _ := data.checkReceipt(id, args[1]); !ok {
+			transaction, _ := data.findTransaction(id)
+			fmt.Printf("Warning: receipt total %s does not match the transaction amount %s\n", data.formatAmount(total), data.formatAmount(transaction.Amount))
+		}
+		return data.save(dataFile)
+
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: delete <id>...")
+		}
+		ids, err := parseIDs(args)
+		if err != nil {
+			return err
+		}
+		if err := data.trash(ids, data.now().UTC()); err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		fmt.Printf("Moved %d transactions to the trash, undo with: trash restore %s\n", len(ids), strings.Join(args, " "))
+
+	case "trash":
+		usage := fmt.Errorf("usage: trash list | trash restore <id>... | trash purge <id>... | trash purge --all [--older-than days]")
+		if len(args) == 0 {
+			return usage
+		}
+		switch args[0] {
+		case "list":
+			return data.displayTrash()
+		case "restore":
+			if len(args) < 2 {
+				return usage
+			}
+			ids, err := parseIDs(args[1:])
+			if err != nil {
+				return err
+			}
+			if err := data.untrash(ids); err != nil {
+				return err
+			}
+			return data.save(dataFile)
+		case "purge":
+			fs := flag.NewFlagSet("trash purge", flag.ContinueOnError)
+			all := fs.Bool("all", false, "purge everything in the trash")
+			olderThan := fs.Int("older-than", 0, "with --all, only what was deleted more than this many days ago")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if *all == (fs.NArg() > 0) || *olderThan < 0 {
+				return usage
+			}
+			ids, err := parseIDs(fs.Args())
+			if err != nil {
+				return err
+			}
+			count, err := data.purge(ids, data.now().UTC().AddDate(0, 0, -*olderThan))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Purged %d transactions.\n", count)
+			if count == 0 {
+				return nil
+			}
+			return data.save(dataFile)
+		default:
+			return fmt.Errorf("unknown trash command %q, use list, restore or purge", args[0])
+		}
+
+	case "batches":
+		usage := fmt.Errorf("usage: batches list | batches rollback <id>")
+		if len(args) == 0 || args[0] == "list" {
+			return data.displayBatches()
+		}
+		if args[0] != "rollback" || len(args) != 2 {
+			return usage
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return usage
+		}
+		count, err := data.rollbackBatch(id, data.now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		fmt.Printf("Moved the %d transactions of import batch %d to the trash, they can be restored one by one with trash restore <id>.\n", count, id)
+
+	case "annotate":
+		usage := fmt.Errorf("usage: annotate <id> [--notes text] [--meta key=value]..., an empty value removes the key")
+		if len(args) < 1 {
+			return usage
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid transaction ID: %s", args[0])
+		}
+		fs := flag.NewFlagSet("annotate", flag.ContinueOnError)
+		notes := fs.String("notes", "", "free-form notes replacing the current ones, \\n starts a new line")
+		metadata := metadataFlags{}
+		fs.Var(metadata, "meta", "set a metadata key, repeatable: invoice=INV-42, trip=Lisbon")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			return usage
+		}
+		var newNotes *string
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "notes" {
+				text := strings.ReplaceAll(*notes, `\n`, "\n")
+				newNotes = &text
+			}
+		})
+		if newNotes == nil && len(metadata) == 0 {
+			return data.displayAnnotations(id)
+		}
+		if err := data.annotate(id, newNotes, metadata); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "open-attachment":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: open-attachment <id>")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid transaction ID: %s", args[0])
+		}
+		return data.openAttachments(dataFile, id)
+
+	case "merge":
+		fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+		prefer := fs.String("prefer", "ask", "how to settle conflicts: ask, local or remote")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: merge [--prefer ask|local|remote] <other data file>")
+		}
+		resolve, err := preferSide(*prefer)
+		if err != nil {
+			return err
+		}
+		added, conflicts, err := data.mergeFile(fs.Arg(0), resolve)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Merged %s: %d added, %d conflicts resolved.\n", fs.Arg(0), added, conflicts)
+		return data.save(dataFile)
+
+	case "sync":
+		if len(args) >= 1 && args[0] == "conflicts" {
+			if len(args) == 2 && args[1] == "--clear" {
+				data.Conflicts = nil
+				return data.save(dataFile)
+			}
+			data.displayConflicts()
+			return nil
+		}
+		fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+		prefer := fs.String("prefer", "newer", "how to settle edits made on both sides: newer, ask, local or remote")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: sync [--prefer newer|ask|local|remote] <other copy of the data file> | sync conflicts [--clear]")
+		}
+		resolve := resolveNewer
+		if *prefer != "newer" {
+			var err error
+			if resolve, err = preferSide(*prefer); err != nil {
+				return err
+			}
+		}
+		result, err := data.syncFile(dataFile, fs.Arg(0), resolve)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Synced with %s: %d changes pulled, %d pushed, %d conflicts.\n", fs.Arg(0), result.Pulled, result.Pushed, result.Conflicts)
+		if result.SetAside > 0 {
+			fmt.Printf("%d versions were set aside, review them with: sync conflicts\n", result.SetAside)
+		}
+
+	case "diff":
+		switch len(args) {
+		case 1:
+			return data.displayDiff(args[0], dataFile)
+		case 2:
+			return data.displayDiff(args[0], args[1])
+		}
+		return fmt.Errorf("usage: diff <old file> [new file]")
+
+	case "anonymize-export":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: anonymize-export <output file>")
+		}
+		return data.anonymizeExport(args[0])
+
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ContinueOnError)
+		format := fs.String("format", "", "ledger (also read by hledger), beancount, xlsx or ical (recurring bills as a calendar), by default taken from the file extension")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() > 1 {
+			return fmt.Errorf("usage: export [--format ledger|beancount|xlsx|ical] [output file]")
+		}
+		if fs.NArg() == 0 {
+			if *format == "xlsx" {
+				return fmt.Errorf("xlsx exports need an output file")
+			}
+			if strings.EqualFold(*format, "ical") {
+				return data.exportICal(os.Stdout)
+			}
+			return data.exportLedger(os.Stdout, cmp.Or(strings.ToLower(*format), "ledger"))
+		}
+		return data.exportFile(fs.Arg(0), strings.ToLower(*format))
+
+	case "check":
+		if data.displayStaleWarnings(data.today()) > 0 {
+			return fmt.Errorf("stale accounts found")
+		}
+		fmt.Println("All accounts are up to date.")
+
+	case "note":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: note <category> [text]")
+		}
+		if err := data.setCategoryNote(args[0], strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "allocate":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: allocate <YYYY-MM> <category> <amount>")
+		}
+		amount, err := parseAmount(args[2])
+		if err != nil {
+			return err
+		}
+		if err := data.allocate(args[0], args[1], amount); err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		return data.displayEnvelopes(args[0])
+
+	case "envelopes":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: envelopes [YYYY-MM]")
+		}
+		return data.displayEnvelopes(cmp.Or(strings.Join(args, ""), data.today().Format("2006-01")))
+
+	case "loan":
+		usage := fmt.Errorf("usage: loan list | loan add --principal n --rate pct --term months [--start YYYY-MM-DD] <name> | loan link <name> <id>... | loan schedule|status [--extra n] <name> | loan remove <name>")
+		if len(args) == 0 || args[0] == "list" {
+			data.displayLoans()
+			return nil
+		}
+		switch args[0] {
+		case "add":
+			fs := flag.NewFlagSet("loan add", flag.ContinueOnError)
+			principal := fs.Float64("principal", 0, "amount borrowed")
+			rate := fs.Float64("rate", 0, "yearly interest rate in percent")
+			term := fs.Int("term", 0, "number of monthly payments")
+			dateStr := fs.String("start", data.today().Format("2006-01-02"), "date the money was borrowed, payments start a month later")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 {
+				return usage
+			}
+			start, err := data.parseDateInput(*dateStr)
+			if err != nil {
+				return err
+			}
+			if err := data.addLoan(Loan{Name: fs.Arg(0), Principal: *principal, Rate: *rate, Term: *term, Start: start}); err != nil {
+				return err
+			}
+		case "link":
+			if len(args) < 3 {
+				return usage
+			}
+			ids := make([]int, len(args)-2)
+			for i, arg := range args[2:] {
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("invalid transaction ID: %s", arg)
+				}
+				ids[i] = id
+			}
+			if err := data.linkLoanPayments(args[1], ids); err != nil {
+				return err
+			}
+		case "schedule", "status":
+			fs := flag.NewFlagSet("loan "+args[0], flag.ContinueOnError)
+			extra := fs.Float64("extra", 0, "pay this much more every month")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 || *extra < 0 {
+				return usage
+			}
+			loan, err := data.findLoan(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			if args[0] == "schedule" {
+				return data.displayLoanSchedule(loan, *extra)
+			}
+			return data.displayLoanStatus(loan, *extra)
+		case "remove":
+			if len(args) != 2 {
+				return usage
+			}
+			loan, err := data.findLoan(args[1])
+			if err != nil {
+				return err
+			}
+			data.Loans = slices.DeleteFunc(data.Loans, func(l Loan) bool { return l.Name == loan.Name })
+			for i := range data.Transactions {
+				if data.Transactions[i].Loan == loan.Name {
+					data.Transactions[i].Loan = ""
+				}
+			}
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "holding":
+		usage := fmt.Errorf("usage: holding set [--cost n] [--date YYYY-MM-DD] <account> <symbol> <units> | holding remove <account> <symbol>")
+		if len(args) == 0 {
+			return usage
+		}
+		switch args[0] {
+		case "set":
+			fs := flag.NewFlagSet("holding set", flag.ContinueOnError)
+			cost := fs.String("cost", "", "what was paid for all the units, the current value when empty")
+			dateStr := fs.String("date", data.today().Format("2006-01-02"), "when the units were bought")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 3 {
+				return usage
+			}
+			units, err := parseAmount(fs.Arg(2))
+			if err != nil {
+				return err
+			}
+			date, err := data.parseDateInput(*dateStr)
+			if err != nil {
+				return err
+			}
+			holding := Holding{Account: fs.Arg(0), Symbol: fs.Arg(1), Units: units, Acquired: date}
+			if *cost != "" {
+				if holding.CostBasis, err = parseAmount(*cost); err != nil {
+					return err
+				}
+			} else if price, ok := data.priceOn(strings.ToUpper(holding.Symbol), date); ok {
+				holding.CostBasis = units * price
+			}
+			if err := data.setHolding(holding); err != nil {
+				return err
+			}
+		case "remove":
+			if len(args) != 3 {
+				return usage
+			}
+			if err := data.setHolding(Holding{Account: args[1], Symbol: args[2]}); err != nil {
+				return err
+			}
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "price":
+		usage := fmt.Errorf("usage: price set [--date YYYY-MM-DD] <symbol> <price> | price update")
+		if len(args) == 0 {
+			return usage
+		}
+		switch args[0] {
+		case "set":
+			fs := flag.NewFlagSet("price set", flag.ContinueOnError)
+			dateStr := fs.String("date", data.today().Format("2006-01-02"), "day of the price")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 2 {
+				return usage
+			}
+			date, err := data.parseDateInput(*dateStr)
+			if err != nil {
+				return err
+			}
+			price, err := parseAmount(fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			if err := data.setPrice(fs.Arg(0), date, price); err != nil {
+				return err
+			}
+		case "update":
+			if data.Settings.QuoteURL == "" {
+				return fmt.Errorf("no quote service configured, set one with: config quote-url https://example.com/quote?symbol={symbol}")
+			}
+			updated, failed := data.updatePrices()
+			for _, symbol := range slices.Sorted(maps.Keys(failed)) {
+				fmt.Printf("Could not update %s: %v\n", symbol, failed[symbol])
+			}
+			fmt.Printf("Updated %d prices.\n", updated)
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "portfolio":
+		return data.displayPortfolio()
+
+	case "alert":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: alert list|add|remove")
+		}
+		switch strings.ToLower(args[0]) {
+		case "list":
+			data.displayAlerts()
+			return nil
+		case "add":
+			fs := flag.NewFlagSet("alert add", flag.ContinueOnError)
+			category := fs.String("category", "", "category to watch, all expenses when empty")
+			single := fs.Bool("single", false, "check each expense instead of the month's total")
+			notify := fs.Bool("notify", false, "also send the alert to the configured webhook or email")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 {
+				return fmt.Errorf("usage: alert add [--category name] [--single] [--notify] <limit>")
+			}
+			limit, err := parseAmount(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			if err := data.addAlert(AlertRule{Category: *category, Limit: limit, Single: *single, Notify: *notify}); err != nil {
+				return err
+			}
+		case "remove":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: alert remove <number>")
+			}
+			number, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid alert number: %s", args[1])
+			}
+			if err := data.removeAlert(number); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown alert command %q, use list, add or remove", args[0])
+		}
+		return data.save(dataFile)
+
+	case "rule":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: rule list|add|remove")
+		}
+		switch strings.ToLower(args[0]) {
+		case "list":
+			data.displayRules()
+			return nil
+		case "add":
+			if len(args) != 3 {
+				return fmt.Errorf("usage: rule add <text to match> <category>")
+			}
+			if err := data.addRule(CategoryRule{Match: args[1], Category: args[2]}); err != nil {
+				return err
+			}
+		case "remove":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: rule remove <number>")
+			}
+			number, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid rule number: %s", args[1])
+			}
+			if err := data.removeRule(number); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown rule command %q, use list, add or remove", args[0])
+		}
+		return data.save(dataFile)
+
+	case "bank":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: bank list|link|remove|sync|template")
+		}
+		switch strings.ToLower(args[0]) {
+		case "list":
+			data.displayBanks()
+			return nil
+		case "link":
+			fs := flag.NewFlagSet("bank link", flag.ContinueOnError)
+			provider := fs.String("provider", "plaid", "bank data provider: "+strings.Join(slices.Sorted(maps.Keys(bankConnectors)), ", "))
+			token := fs.String("token", "", "access token the provider issued for the linked bank")
+			account := fs.String("account", "", "account synced transactions are booked to")
+			environment := fs.String("environment", "", "provider environment, sandbox when empty")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 {
+				return fmt.Errorf("usage: bank link [--provider plaid] --token t [--account name] [--environment sandbox|production] <name>")
+			}
+			err := data.linkBank(BankConnection{Name: fs.Arg(0), Provider: *provider, AccessToken: *token, Account: *account, Environment: *environment})
+			if err != nil {
+				return err
+			}
+		case "remove":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: bank remove <name>")
+			}
+			i := slices.IndexFunc(data.Banks, func(connection BankConnection) bool { return connection.Name == args[1] })
+			if i < 0 {
+				return fmt.Errorf("no bank connection %s, see bank list", args[1])
+			}
+			data.Banks = slices.Delete(data.Banks, i, i+1)
+		case "sync":
+			if len(args) > 2 {
+				return fmt.Errorf("usage: bank sync [name]")
+			}
+			count := len(data.Transactions)
+			results, err := data.syncBanks(strings.Join(args[1:], ""))
+			displayBankSync(results)
+			if err != nil {
+				return err
+			}
+			if err := data.save(dataFile); err != nil {
+				return err
+			}
+			data.raiseAlerts(min(count, len(data.Transactions)))
+			return nil
+		case "template":
+			usage := fmt.Errorf("usage: bank template list <name> | bank template add <name> --from sender --amount pattern [--date pattern] [--merchant m] [--category c] | bank template remove <name> <from>")
+			if len(args) < 3 {
+				return usage
+			}
+			i := slices.IndexFunc(data.Banks, func(connection BankConnection) bool { return connection.Name == args[2] })
+			if i < 0 {
+				return fmt.Errorf("no bank connection %s, see bank list", args[2])
+			}
+			connection := &data.Banks[i]
+			switch args[1] {
+			case "list":
+				if len(connection.Templates) == 0 {
+					fmt.Println("No receipt templates, totals are looked for in every mail.")
+					return nil
+				}
+				table := newTable("From", "Amount", "Date", "Merchant", "Category")
+				for _, t := range connection.Templates {
+					table.addRow(plainCell(t.From), plainCell(t.Amount), plainCell(t.Date), plainCell(t.Merchant), plainCell(t.Category))
+				}
+				table.print()
+				return nil
+			case "add":
+				fs := flag.NewFlagSet("bank template add", flag.ContinueOnError)
+				var template ReceiptTemplate
+				fs.StringVar(&template.From, "from", "", "part of the sender address, e.g. amazon.com")
+				fs.StringVar(&template.Amount, "amount", "", "regular expression with the amount as its first group")
+				fs.StringVar(&template.Date, "date", "", "regular expression with the purchase date as its first group, the mail's date when empty")
+				fs.StringVar(&template.Merchant, "merchant", "", "payee, the sender's name when empty")
+				fs.StringVar(&template.Category, "category", "", "category when no rule applies")
+				if err := fs.Parse(args[3:]); err != nil {
+					return err
+				}
+				if fs.NArg() != 0 || template.Amount == "" {
+					return usage
+				}
+				if err := template.validate(); err != nil {
+					return err
+				}
+				connection.Templates = slices.DeleteFunc(connection.Templates, func(t ReceiptTemplate) bool { return strings.EqualFold(t.From, template.From) })
+				connection.Templates = append(connection.Templates, template)
+			case "remove":
+				if len(args) != 4 {
+					return usage
+				}
+				count := len(connection.Templates)
+				connection.Templates = slices.DeleteFunc(connection.Templates, func(t ReceiptTemplate) bool { return strings.EqualFold(t.From, args[3]) })
+				if len(connection.Templates) == count {
+					return fmt.Errorf("no receipt template for %s", args[3])
+				}
+			default:
+				return usage
+			}
+		default:
+			return fmt.Errorf("unknown bank command %q, use list, link, remove, sync or template", args[0])
+		}
+		return data.save(dataFile)
+
+	case "review":
+		usage := fmt.Errorf("usage: review [list | accept <id>...|all | reject <id>...]")
+		if len(args) == 0 {
+			if len(data.pendingTransactions()) == 0 {
+				fmt.Println("Nothing waits for review.")
+				return nil
+			}
+			accepted, rejected, err := data.reviewPending()
+			if err != nil {
+				return err
+			}
+			if err := data.save(dataFile); err != nil {
+				return err
+			}
+			fmt.Printf("%d accepted, %d rejected, %d still pending.\n", accepted, rejected, len(data.pendingTransactions()))
+			return nil
+		}
+		switch args[0] {
+		case "list":
+			return data.displayTransactions(data.pendingTransactions(), 0, 0)
+		case "accept", "reject":
+			var ids []int
+			if len(args) == 2 && args[0] == "accept" && args[1] == "all" {
+				for _, transaction := range data.pendingTransactions() {
+					ids = append(ids, transaction.ID)
+				}
+			} else {
+				var err error
+				if ids, err = parseIDs(args[1:]); err != nil {
+					return err
+				}
+			}
+			if len(ids) == 0 {
+				return usage
+			}
+			if args[0] == "accept" {
+				if err := data.acceptPending(ids); err != nil {
+					return err
+				}
+			} else {
+				for _, id := range ids {
+					if transaction, err := data.findTransaction(id); err != nil {
+						return err
+					} else if transaction.Status != Pending {
+						return fmt.Errorf("transaction %d is not pending", id)
+					}
+				}
+				if err := data.trash(ids, data.now().UTC()); err != nil {
+					return err
+				}
+			}
+			if err := data.save(dataFile); err != nil {
+				return err
+			}
+			fmt.Printf("%d transactions %sed.\n", len(ids), args[0])
+			return nil
+		default:
+			return usage
+		}
+
+	case "sms":
+		usage := fmt.Errorf(`usage: sms list | sms add <name> --pattern re [--type t] [--date-layout l] [--category c] [--account a] | sms remove <name> | sms test "text"`)
+		if len(args) < 1 {
+			return usage
+		}
+		switch args[0] {
+		case "list":
+			if len(data.Texts) == 0 {
+				fmt.Println("No text templates, alerts are read the generic way.")
+				return nil
+			}
+			table := newTable("Name", "Pattern", "Type", "Date layout", "Category", "Account")
+			for _, t := range data.Texts {
+				table.addRow(plainCell(t.Name), plainCell(t.Pattern), plainCell(t.Type), plainCell(t.DateLayout), plainCell(t.Category), plainCell(t.Account))
+			}
+			table.print()
+			return nil
+		case "add":
+			fs := flag.NewFlagSet("sms add", flag.ContinueOnError)
+			var template TextTemplate
+			fs.StringVar(&template.Pattern, "pattern", "", "regular expression naming the amount group, and payee, date, currency or account when present")
+			fs.StringVar(&template.Type, "type", "", "Income or Expense, told from the text when empty")
+			fs.StringVar(&template.DateLayout, "date-layout", "", "Go layout of the date, e.g. 01/02/06")
+			fs.StringVar(&template.Category, "category", "", "category when no rule applies")
+			fs.StringVar(&template.Account, "account", "", "account the transactions belong to")
+			if len(args) < 2 {
+				return usage
+			}
+			if err := fs.Parse(args[2:]); err != nil {
+				return err
+			}
+			template.Name = args[1]
+			if err := template.validate(); err != nil {
+				return err
+			}
+			data.Texts = slices.DeleteFunc(data.Texts, func(t TextTemplate) bool { return t.Name == template.Name })
+			data.Texts = append(data.Texts, template)
+		case "remove":
+			if len(args) != 2 {
+				return usage
+			}
+			count := len(data.Texts)
+			if data.Texts = slices.DeleteFunc(data.Texts, func(t TextTemplate) bool { return t.Name == args[1] }); len(data.Texts) == count {
+				return fmt.Errorf("no text template %s, see sms list", args[1])
+			}
+		case "test":
+			if len(args) != 2 {
+				return usage
+			}
+			transaction, err := data.parseNotification(args[1])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s", transaction.Date.Format("2006-01-02"), transaction.Type)
+			if transaction.Currency != "" {
+				fmt.Printf(" %s", data.Settings.formatAmount(transaction.Amount, transaction.Currency))
+			} else {
+				fmt.Printf(" %s", data.formatAmount(transaction.Amount))
+			}
+			fmt.Printf(" payee %q category %q account %q\n", transaction.Payee, transaction.Category, transaction.Account)
+			return nil
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "webhook":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: webhook list|add|remove|test")
+		}
+		switch strings.ToLower(args[0]) {
+		case "list":
+			data.displayWebhooks()
+			return nil
+		case "add":
+			fs := flag.NewFlagSet("webhook add", flag.ContinueOnError)
+			events := fs.String("events", "", "comma separated events to send, all when empty: "+strings.Join(webhookEvents, ", "))
+			secret := fs.String("secret", "", "key of the X-Finance-Signature HMAC, generated when empty")
+			if err := fs.Parse(args[1:]); err != nil {
+				return err
+			}
+			if fs.NArg() != 1 {
+				return fmt.Errorf("usage: webhook add [--events list] [--secret key] <url>")
+			}
+			hook := Webhook{URL: fs.Arg(0), Secret: *secret}
+			if *events != "" {
+				hook.Events = strings.Split(*events, ",")
+			}
+			if hook.Secret == "" {
+				hook.Secret = crand.Text()
+			}
+			if err := data.addWebhook(hook); err != nil {
+				return err
+			}
+			fmt.Println("Signing secret:", hook.Secret)
+		case "remove":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: webhook remove <number>")
+			}
+			number, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid webhook number: %s", args[1])
+			}
+			if err := data.removeWebhook(number); err != nil {
+				return err
+			}
+		case "test":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: webhook test <number>")
+			}
+			number, err := strconv.Atoi(args[1])
+			if err != nil || number < 1 || number > len(data.Webhooks) {
+				return fmt.Errorf("no webhook %s, see webhook list", args[1])
+			}
+			if err := data.Webhooks[number-1].deliver(webhookEvent{Event: "ping", At: data.now().UTC()}); err != nil {
+				return err
+			}
+			fmt.Println("Webhook answered the ping.")
+			return nil
+		default:
+			return fmt.Errorf("unknown webhook command %q, use list, add, remove or test", args[0])
+		}
+		return data.save(dataFile)
+
+	case "config":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: config <setting> <value>")
+		}
+		if err := data.setConfig(args[0], args[1]); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "add":
+		var transaction Transaction
+		quick := len(args) > 0 && (!strings.HasPrefix(args[0], "-") || len(args[0]) > 1 && unicode.IsDigit(rune(args[0][1])))
+		if len(args) > 0 && args[0] == "--" { // add -- -5 Food: what follows is the one-line form, flags or not
+			quick, args = true, args[1:]
+		}
+		if quick {
+			var err error
+			if transaction, err = data.parseQuickAdd(args); err != nil {
+				return err
+			}
+		} else {
+			fs := flag.NewFlagSet("add", flag.ContinueOnError)
+			dateStr := fs.String("date", data.today().Format("2006-01-02"), "date (YYYY-MM-DD)")
+			transactionType := fs.String("type", Expense, "Income or Expense")
+			category := fs.String("category", "", "category")
+			amountStr := fs.String("amount", "", "amount")
+			description := fs.String("description", "", "description")
+			payee := fs.String("payee", "", "payee")
+			account := fs.String("account", "", "account")
+			reimbursable := fs.String("reimbursable", "", "who pays the expense back")
+			fromText := fs.String("from-text", "", "bank SMS or notification text to read the transaction from")
+			if err := fs.Parse(args); err != nil {
+				return err
+			}
+			if fs.NArg() > 0 {
+				return fmt.Errorf("unexpected %q, give the amount with --amount or first as in: add <amount> <category>", fs.Arg(0))
+			}
+			if *fromText != "" {
+				added, err := data.addFromText(*fromText, func(t *Transaction) {
+					fs.Visit(func(f *flag.Flag) { // what is given on the command line wins over what the text says
+						switch f.Name {
+						case "category":
+							t.Category = *category
+						case "payee":
+							t.Payee = *payee
+						case "account":
+							t.Account = *account
+						case "description":
+							t.Description = *description
+						}
+					})
+				})
+				if err != nil {
+					return err
+				}
+				if err := data.save(dataFile); err != nil {
+					return err
+				}
+				fmt.Printf("Transaction %d waits for review: %s %s %s %s on %s.\n", added.ID, added.Type, data.formatAmount(added.Amount), cmp.Or(added.Payee, "-"),
+					added.Category, added.Date.Format("2006-01-02"))
+				data.noteForeignCurrency(added)
+				data.raiseAlerts(len(data.Transactions) - 1)
+				return nil
+			}
+			if *amountStr == "" {
+				return fmt.Errorf("an amount is needed, e.g. --amount 12.50")
+			}
+			date, err := data.parseDateInput(*dateStr)
+			if err != nil {
+				return err
+			}
+			amount, currency, err := data.parseTransactionAmount(*amountStr)
+			if err != nil {
+				return err
+			}
+			if *reimbursable != "" && *transactionType != Expense {
+				return fmt.Errorf("only expenses can be reimbursable")
+			}
+			transaction = Transaction{Date: date, Type: *transactionType, Category: *category, Amount: amount, Currency: currency, Description: *description, Payee: *payee,
+				Account: *account, Reimbursable: *reimbursable}
+		}
+		transaction.Category = data.confirmCategory(transaction.Category)
+		count := len(data.Transactions)
+		if err := data.appendTransaction(transaction); err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		fmt.Printf("Transaction %d added successfully.\n", data.lastID)
+		data.noteForeignCurrency(transaction)
+		data.raiseAlerts(count)
+
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ContinueOnError)
+		account := fs.String("account", "", "account the transactions belong to")
+		format := fs.String("format", "", "csv, mint, ynab, ofx, qif or json, detected from the file when empty")
+		source := fs.String("source", "", "same as --format, kept for older scripts")
+		strict := fs.Bool("strict", false, "abort on the first invalid row without importing anything")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		usage := fmt.Errorf("usage: import [--account name] [--format csv|mint|ynab|ofx|qif|json] [--strict] <file|->")
+		if fs.NArg() == 0 {
+			return usage
+		}
+		filename := fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil { // flags may follow the file too, as in import - --source mint
+			return err
+		}
+		if fs.NArg() != 0 {
+			return usage
+		}
+		data.backupBefore(dataFile, "pre-import")
+		count := len(data.Transactions)
+		result, err := data.importInteractively(filename, *account, cmp.Or(*format, *source), *strict)
+		if err != nil {
+			return err
+		}
+		displayImportResult(result)
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		data.raiseAlerts(count)
+
+	case "watch":
+		fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+		archive := fs.String("archive", "", "folder imported files are moved to, <dir>/imported when empty")
+		account := fs.String("account", "", "account the transactions belong to")
+		format := fs.String("format", "", "import format, detected from each file when empty")
+		interval := fs.Duration("interval", 10*time.Second, "how often to look for new files")
+		once := fs.Bool("once", false, "import what is there now and stop, for cron")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: watch [--archive dir] [--account name] [--format name] [--interval 10s] [--once] <dir>")
+		}
+		if err := data.writable(); err != nil {
+			return err
+		}
+		options := WatchOptions{Dir: fs.Arg(0), Archive: *archive, Account: *account, Format: *format, Interval: max(*interval, time.Second), Once: *once}
+		if !options.Once {
+			fmt.Printf("Watching %s for statements, press Ctrl+C to stop.\n", options.Dir)
+		}
+		ctx, done := interruptible()
+		defer done()
+		return data.watchFolder(ctx, options, func() error { return data.save(dataFile) })
+
+	case "bot":
+		fs := flag.NewFlagSet("bot", flag.ContinueOnError)
+		chats := fs.String("chat", "", "comma-separated IDs of the Telegram chats allowed to use the book")
+		api := fs.String("api", "https://api.telegram.org", "Telegram Bot API address")
+		usage := fmt.Errorf("usage: bot telegram [--chat id,...], with the bot token in FINANCE_TELEGRAM_TOKEN; Slack uses serve's POST /slack/command")
+		if len(args) == 0 || args[0] != "telegram" {
+			return usage
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 {
+			return usage
+		}
+		token := os.Getenv("FINANCE_TELEGRAM_TOKEN")
+		if token == "" {
+			return fmt.Errorf("set FINANCE_TELEGRAM_TOKEN to the token BotFather gave the bot")
+		}
+		bot := &telegramBot{api: strings.TrimSuffix(*api, "/") + "/bot" + token, client: &http.Client{Timeout: 70 * time.Second}}
+		for _, chat := range strings.FieldsFunc(*chats, func(r rune) bool { return r == ',' || r == ' ' }) {
+			id, err := strconv.ParseInt(chat, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid chat ID %q", chat)
+			}
+			bot.chats = append(bot.chats, id)
+		}
+		if len(bot.chats) == 0 {
+			fmt.Println("No --chat given: every chat is refused and told its ID, restart with --chat <id> to let it in.")
+		}
+		fmt.Println("The bot is answering on Telegram, press Ctrl+C to stop.")
+		logActivity()
+		ctx, done := interruptible()
+		defer done()
+		return data.runTelegramBot(ctx, bot, func() error { return data.save(dataFile) })
+
+	case "deductible":
+		usage := fmt.Errorf("usage: deductible list | deductible category <name> on|off | deductible transaction <id>... on|off|inherit")
+		if len(args) == 0 || args[0] == "list" {
+			if len(data.Deductible) == 0 {
+				fmt.Println("No deductible categories.")
+			}
+			for _, category := range data.Deductible {
+				fmt.Println(category)
+			}
+			return nil
+		}
+		if len(args) < 3 {
+			return usage
+		}
+		setting := args[len(args)-1]
+		switch {
+		case args[0] == "category" && len(args) == 3 && (setting == "on" || setting == "off"):
+			if err := data.setDeductible(args[1], setting == "on"); err != nil {
+				return err
+			}
+		case args[0] == "transaction" && (setting == "on" || setting == "off" || setting == "inherit"):
+			ids := make([]int, len(args)-2)
+			for i, arg := range args[1 : len(args)-1] {
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return fmt.Errorf("invalid transaction ID: %s", arg)
+				}
+				ids[i] = id
+			}
+			var deductible *bool
+			if setting != "inherit" {
+				on := setting == "on"
+				deductible = &on
+			}
+			if err := data.markDeductible(ids, deductible); err != nil {
+				return err
+			}
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "budget":
+		if len(args) == 3 && args[0] == "rollover" {
+			if args[2] != "on" && args[2] != "off" {
+				return fmt.Errorf("usage: budget rollover <category> on|off")
+			}
+			if err := data.setRollover(args[1], args[2] == "on"); err != nil {
+				return err
+			}
+			return data.save(dataFile)
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("usage: budget <category> <monthly amount> | budget rollover <category> on|off")
+		}
+		amount, err := parseAmount(args[1])
+		if err != nil {
+			return err
+		}
+		if err := data.setBudget(args[0], amount); err != nil {
+			return err
+		}
+		return data.save(dataFile)
+
+	case "anomalies":
+		fs := flag.NewFlagSet("anomalies", flag.ContinueOnError)
+		periodFlag := fs.String("period", All, "only check transactions in this period: all, week, month, year, YYYY-MM-DD, YYYY-MM or YYYY")
+		sigma := fs.Float64("sigma", 3, "standard deviations above the monthly mean that count as unusual")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if *sigma <= 0 {
+			return fmt.Errorf("sigma must be greater than zero")
+		}
+		period, periodValue, err := data.parsePeriodFlag(*periodFlag, false, data.today())
+		if err != nil {
+			return err
+		}
+		return data.displayAnomalies(period, periodValue, *sigma)
+
+	case "project":
+		if len(args) == 0 || args[0] != "fire" {
+			return fmt.Errorf("usage: project fire [--return 5] [--withdrawal 4] [--savings amount] [--expenses amount] [--net-worth amount] [--years 60]")
+		}
+		in, err := data.fireInputsFromHistory()
+		if err != nil {
+			return err
+		}
+		fs := flag.NewFlagSet("project fire", flag.ContinueOnError)
+		returnRate := fs.Float64("return", 5, "expected yearly return after inflation, in percent")
+		withdrawal := fs.Float64("withdrawal", 4, "share of the portfolio withdrawn each year in retirement, in percent")
+		fs.Float64Var(&in.MonthlySavings, "savings", in.MonthlySavings, "monthly savings, the trailing year's average by default")
+		fs.Float64Var(&in.AnnualExpenses, "expenses", in.AnnualExpenses, "yearly spending to cover, the trailing year's by default")
+		fs.Float64Var(&in.NetWorth, "net-worth", in.NetWorth, "starting net worth, today's by default")
+		maxYears := fs.Int("years", 60, "give up after this many years")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *withdrawal <= 0 || *maxYears <= 0 {
+			return fmt.Errorf("withdrawal rate and years must be greater than zero")
+		}
+		in.Return, in.Withdrawal = *returnRate/100, *withdrawal/100
+		return data.displayFire(in, *maxYears)
+
+	case "cpi":
+		usage := fmt.Errorf("usage: cpi list | cpi set <YYYY-MM> <index> | cpi import <file or URL>")
+		if len(args) == 0 || args[0] == "list" {
+			return data.displayCPI()
+		}
+		switch {
+		case args[0] == "set" && len(args) == 3:
+			month, err := time.Parse("2006-01", args[1])
+			if err != nil {
+				return fmt.Errorf("%w %q, use YYYY-MM", ErrInvalidDate, args[1])
+			}
+			index, err := parseFloat(args[2])
+			if err != nil {
+				return err
+			}
+			if err := data.setCPI(month, index); err != nil {
+				return err
+			}
+		case args[0] == "import" && len(args) == 2:
+			count, err := data.importCPI(args[1])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Read %d CPI values.\n", count)
+		default:
+			return usage
+		}
+		return data.save(dataFile)
+
+	case "predict":
+		fs := flag.NewFlagSet("predict", flag.ContinueOnError)
+		options := defaultForecast
+		fs.IntVar(&options.Months, "months", options.Months, "number of months to predict")
+		fs.IntVar(&options.History, "history", options.History, "past months the simulation draws from")
+		fs.IntVar(&options.Simulations, "simulations", options.Simulations, "number of simulated futures")
+		confidence := fs.Float64("band", options.Confidence*100, "percent of the simulations between low and high")
+		fs.BoolVar(&options.Seasonal, "seasonal", false, "apply per-calendar-month factors learned from at least two years of history")
+		var scenarios scenarioFlags
+		fs.Var(&scenarios, "scenario", `what-if applied to every month, repeatable: "income +5%", "expenses -200", "add 300/month rent increase"`)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if options.Months <= 0 || options.History <= 0 || options.Simulations <= 0 {
+			return fmt.Errorf("months, history and simulations must be greater than zero")
+		}
+		if *confidence <= 0 || *confidence >= 100 {
+			return fmt.Errorf("band must be between 0 and 100")
+		}
+		options.Confidence, options.Scenarios = *confidence/100, scenarios
+		return data.displayPredictions(options)
+
+	case "convert-currency":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: convert-currency <from> <to> <rates file>")
+		}
+		migration, err := data.migrateCurrency(args[0], args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if err := data.save(dataFile); err != nil {
+			return err
+		}
+		data.displayMigration(migration)
+
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+		addr := fs.String("addr", "localhost:8080", "address to listen on, use :8080 to reach it from the LAN")
+		token := fs.String("token", os.Getenv("FINANCE_API_TOKEN"), "API token clients send as a bearer token or basic auth password")
+		usersFile := fs.String("users", dataFile+".users.json", "users file managed with the user command, used when it exists")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		users, err := loadUsers(*usersFile)
+		if err != nil {
+			return err
+		}
+		s := &server{token: *token, users: users, dir: filepath.Dir(*usersFile), shared: ledger{
+			load:     func() (*Data, error) { return loadData(dataFile) },
+			view:     func() (*Data, error) { return viewData(dataFile) },
+			save:     func(d *Data) error { return d.save(dataFile) },
+			readOnly: data.readOnly,
+		}}
+		if len(data.books) > 0 {
+			s.shared = ledger{load: func() (*Data, error) { return loadConsolidated(data.books) }, readOnly: true}
+		}
+		if !data.asOf.IsZero() { // every request sees the book as of the same day
+			load := s.shared.load
+			s.shared = ledger{load: func() (*Data, error) {
+				d, err := load()
+				if err != nil {
+					return nil, err
+				}
+				d.showAsOf(data.asOf)
+				return d, nil
+			}, readOnly: true}
+		}
+		return serve(*addr, s)
+
+	case "user":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: user list|add|remove [flags]")
+		}
+		fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+		usersFile := fs.String("users", dataFile+".users.json", "users file read by serve")
+		role := fs.String("role", roleEditor, "editor or viewer, for add")
+		ledgerFile := fs.String("data", "", "own data file for an isolated ledger, for add; the served book is shared when empty")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		users, err := loadUsers(*usersFile)
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "list":
+			displayUsers(users)
+			return nil
+		case "add":
+			if fs.NArg() != 1 {
+				return fmt.Errorf("usage: user add [--role editor|viewer] [--data file] <name>")
+			}
+			users, token, err := addUser(users, fs.Arg(0), strings.ToLower(*role), *ledgerFile)
+			if err != nil {
+				return err
+			}
+			if err := saveUsers(*usersFile, users); err != nil {
+				return err
+			}
+			fmt.Printf("User %s added. Their token, shown only once: %s\n", fs.Arg(0), token)
+			fmt.Println("Send it as a bearer token, or as the password when the browser asks to sign in.")
+		case "remove":
+			if fs.NArg() != 1 {
+				return fmt.Errorf("usage: user remove <name>")
+			}
+			i := slices.IndexFunc(users, func(user apiUser) bool { return user.Name == fs.Arg(0) })
+			if i < 0 {
+				return fmt.Errorf("no user %s", fs.Arg(0))
+			}
+			return saveUsers(*usersFile, slices.Delete(users, i, i+1))
+		default:
+			return fmt.Errorf("unknown user command %q, use list, add or remove", args[0])
+		}
+
+	case "run":
+		fs := flag.NewFlagSet("run", flag.ContinueOnError)
+		keepGoing := fs.Bool("keep-going", false, "run the remaining commands after a failure")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		script := "-"
+		if fs.NArg() > 0 {
+			script = fs.Arg(0)
+		}
+		return data.runScript(dataFile, script, *keepGoing)
+
+	case "category":
+		usage := fmt.Errorf("usage: category list | category rename <old> <new> | category merge <from>... <into> | category allow|disallow <name>... | category archived | category unarchive <name>")
+		if len(args) == 0 {
+			return usage
+		}
+		switch args[0] {
+		case "list":
+			return data.displayCategoryList()
+		case "rename", "merge":
+			if len(args) < 3 || (args[0] == "rename" && len(args) != 3) {
+				return usage
+			}
+			from, to := args[1:len(args)-1], args[len(args)-1]
+			changed, err := data.moveCategories(from, to, args[0] == "merge")
+			if err != nil {
+				return err
+			}
+			if err := data.save(dataFile); err != nil {
+				return err
+			}
+			fmt.Printf("%d transactions moved to %s.\n", changed, to)
+			return nil
+		case "allow", "disallow":
+			if len(args) < 2 {
+				return usage
+			}
+			for _, name := range args[1:] {
+				if name = strings.TrimSpace(name); name == "" {
+					continue
+				}
+				i := slices.IndexFunc(data.Allowed, func(allowed string) bool { return strings.EqualFold(allowed, name) })
+				switch {
+				case args[0] == "allow" && i < 0:
+					used := slices.Collect(maps.Keys(mapsMerged(data.categoryLastUse(), data.Budgets)))
+					if j := slices.IndexFunc(used, func(category string) bool { return strings.EqualFold(category, name) }); j >= 0 {
+						name = used[j] // keep the spelling already in use
+					}
+					data.Allowed = append(data.Allowed, name)
+				case args[0] == "disallow" && i >= 0:
+					data.Allowed = slices.Delete(data.Allowed, i, i+1)
+				}
+			}
+			sort.Strings(data.Allowed)
+			return data.save(dataFile)
+		case "archived":
+			data.displayArchivedCategories(data.today())
+		case "unarchive":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: category unarchive <name>")
+			}
+			if err := data.unarchiveCategory(args[1], data.today()); err != nil {
+				return err
+			}
+			return data.save(dataFile)
+		default:
+			return usage
+		}
+
+	case "demo":
+		usage := fmt.Errorf("usage: demo generate [--months 24] [--tx-per-month 200] [--seed n] [--end YYYY-MM-DD] [--out file]")
+		if len(args) == 0 || args[0] != "generate" {
+			return usage
+		}
+		fs := flag.NewFlagSet("demo generate", flag.ContinueOnError)
+		months := fs.Int("months", 24, "months of history ending this month")
+		perMonth := fs.Int("tx-per-month", 200, "transactions per month, including bills and weekly groceries")
+		seed := fs.Uint64("seed", 0, "seed for the same transactions every time, random when 0")
+		endStr := fs.String("end", data.today().Format("2006-01-02"), "last day with transactions")
+		out := fs.String("out", "", "write a new demo book to this file instead of filling the current one")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 || *months <= 0 || *perMonth <= 0 {
+			return usage
+		}
+		end, err := data.parseDateInput(*endStr)
+		if err != nil {
+			return err
+		}
+		if *seed == 0 {
+			*seed = rand.Uint64()
+		}
+		target, file := data, dataFile
+		if *out != "" {
+			if _, err := os.Stat(*out); err == nil {
+				return fmt.Errorf("%s already exists, demo books are only written to new files", *out)
+			}
+			target, file = &Data{Currency: data.Currency, Settings: data.Settings, clock: data.clock}, *out
+		} else if len(data.Transactions) > 0 {
+			return fmt.Errorf("the book already has transactions, write the demo to a new file with --out demo.json")
+		}
+		for _, transaction := range GenerateDemo(DemoOptions{Months: *months, PerMonth: *perMonth, Seed: *seed, End: end}) {
+			if err := target.appendTransaction(transaction); err != nil {
+				return err
+			}
+		}
+		if err := target.save(file); err != nil {
+			return err
+		}
+		fmt.Printf("Generated %d transactions over %d months into %s with --seed %d.\n", len(target.Transactions), *months, file, *seed)
+
+	case "profile": // not in the help, for measuring how operations scale with the size of the book
+		usage := fmt.Errorf("usage: profile import|summary|search|forecast [--size 100000] [--iterations 5] [--out profile]")
+		if len(args) == 0 {
+			return usage
+		}
+		fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+		size := fs.Int("size", 100_000, "transactions in the synthetic book")
+		iterations := fs.Int("iterations", 5, "times to run the operation")
+		out := fs.String("out", "profile", "writes <out>.cpu.pprof and <out>.heap.pprof")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 || *size <= 0 || *iterations <= 0 || *out == "" {
+			return usage
+		}
+		return profileOperation(args[0], *size, *iterations, *out, data.today())
+
+	case "plugins":
+		displayPlugins()
+
+	case "backup":
+		if len(args) == 1 && args[0] == "list" {
+			return data.displayBackups(dataFile)
+		}
+		fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+		compress := fs.Bool("gzip", data.Settings.BackupGzip, "compress the backup")
+		keep := fs.Int("keep", cmp.Or(data.Settings.BackupKeep, defaultBackupKeep), "number of backups to keep, 0 keeps all")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		target, err := data.backup(dataFile, "", *compress, *keep)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			return fmt.Errorf("%s does not exist yet, nothing to back up", dataFile)
+		}
+		fmt.Printf("Backed up to %s.\n", target)
+
+	case "restore":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: restore <backup>, see backup list")
+		}
+		if err := data.restore(dataFile, args[0]); err != nil {
+			return err
+		}
+		restored, err := loadData(dataFile)
+		if err != nil {
+			return err
+		}
+		restored.readOnly, restored.lockedBy, restored.books = data.readOnly, data.lockedBy, data.books
+		*data = *restored // the interactive mode goes on with the restored book
+		fmt.Printf("Restored %s from %s.\n", dataFile, args[0])
+
+	case "completion":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: completion bash|zsh|fish")
+		}
+		return printCompletionScript(args[0])
+
+	case "help":
+		displayHelp()
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+// run the commands of a script file (or stdin for "-") one per line, blank lines and # comments are skipped;
+// stops at the first failing command unless keepGoing is set
+func (d *Data) runScript(dataFile string, script string, keepGoing bool) error {
+	input := io.Reader(os.Stdin)
+	if script != "-" {
+		file, err := os.Open(script)
+		if err != nil {
+			return fmt.Errorf("failed to open script: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	failed := 0
+	scanner := bufio.NewScanner(input)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args := splitArgs(line)
+		if strings.EqualFold(args[0], "run") {
+			return fmt.Errorf("line %d: scripts cannot run other scripts", lineNumber)
+		}
+		if err := runCommand(d, dataFile, args); err != nil {
+			if !keepGoing {
+				return fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			fmt.Fprintf(os.Stderr, "Error: line %d: %v\n", lineNumber, err)
+			failed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read script: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d commands failed", failed)
+	}
+	return nil
+}
+
+// copy of the book with amounts, descriptions and account names scrambled, safe to attach to bug reports
+func (d *Data) anonymized(rng *rand.Rand) *Data {
+	pseudonyms := make(map[string]string)
+	counts := make(map[string]int)
+	pseudonym := func(prefix, value string) string {
+		if value == "" {
+			return ""
+		}
+		key := prefix + "\x00" + value
+		if name, ok := pseudonyms[key]; ok {
+			return name
+		}
+		counts[prefix]++
+		pseudonyms[key] = fmt.Sprintf("%s-%d", prefix, counts[prefix])
+		return pseudonyms[key]
+	}
+	// amounts keep their magnitude so reports behave alike, but no value survives
+	scale := func(amount, factor float64) float64 {
+		return math.Round(amount*factor*100) / 100
+	}
+
+	out := &Data{Currency: d.Currency, Settings: d.Settings, clock: d.clock}
+	for _, transaction := range d.Transactions {
+		factor := 0.5 + rng.Float64()
+		transaction.Amount = scale(transaction.Amount, factor)
+		if len(transaction.Splits) > 0 {
+			// rebuild the line items so they still add up to the scrambled total
+			splits := make([]Split, len(transaction.Splits))
+			transaction.Amount = 0
+			for i, split := range transaction.Splits {
+				splits[i] = Split{Category: split.Category, Amount: scale(split.Amount, factor)}
+				transaction.Amount += splits[i].Amount
+			}
+			transaction.Splits = splits
+		}
+		transaction.Description = pseudonym("description", transaction.Description)
+		transaction.Account = pseudonym("account", transaction.Account)
+		transaction.Payee = pseudonym("payee", transaction.Payee)
+		transaction.Notes = pseudonym("notes", transaction.Notes)
+		for key, value := range transaction.Metadata {
+			transaction.Metadata[key] = pseudonym(key, value)
+		}
+		tags := make([]string, len(transaction.Tags))
+		for i, tag := range transaction.Tags {
+			tags[i] = pseudonym("tag", tag)
+		}
+		transaction.Tags, transaction.Attachments = tags, nil
+		out.Transactions = append(out.Transactions, transaction)
+	}
+	for category, amount := range d.Budgets {
+		out.setBudget(category, scale(amount, 0.5+rng.Float64()))
+	}
+	return out
+}
+
+func (d *Data) anonymizeExport(filename string) error {
+	if filename == "" {
+		return fmt.Errorf("output file must not be empty")
+	}
+	out := d.anonymized(rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	if err := out.save(filename); err != nil {
+		return err
+	}
+	fmt.Printf("Anonymized %d transactions to %s.\n", len(out.Transactions), filename)
+	return nil
+}
+
+// double-entry account for a book account, category or counterpart, e.g. Expenses:Eating-Out for beancount
+func ledgerAccount(root, name, format string) string {
+	if format != "beancount" {
+		return root + ":" + strings.Join(strings.Fields(name), " ") // two spaces would end the account in ledger
+	}
+	words := strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	if len(words) == 0 {
+		words = []string{"Unknown"}
+	}
+	return root + ":" + strings.Join(words, "-")
+}
+
+// the balance sheet side of a transaction, its account or Assets:Cash when it has none
+func (d *Data) ledgerFundingAccount(name, format string) string {
+	if name == "" {
+		return ledgerAccount("Assets", "Cash", format)
+	}
+	if account, ok := d.findAccount(name); ok && account.Kind == Liability {
+		return ledgerAccount("Liabilities", name, format)
+	}
+	return ledgerAccount("Assets", name, format)
+}
+
+type ledgerPosting struct {
+	Account string
+	Amount  float64
+}
+
+type ledgerEntry struct {
+	Date      time.Time
+	Payee     string
+	Narration string
+	ID        int
+	Notes     string
+	Metadata  map[string]string
+	Tags      []string
+	Postings  []ledgerPosting
+}
+
+// book contents as balanced double-entry transactions, opening balances against Equity:Opening-Balances
+func (d *Data) ledgerEntries(format string) []ledgerEntry {
+	entries := make([]ledgerEntry, 0, len(d.Transactions)+len(d.Accounts))
+	for _, account := range d.Accounts {
+		if account.OpeningBalance == 0 {
+			continue
+		}
+		balance := account.OpeningBalance
+		if account.Kind == Liability {
+			balance = -balance
+		}
+		entries = append(entries, ledgerEntry{Date: account.OpeningDate, Narration: "Opening balance", Postings: []ledgerPosting{
+			{d.ledgerFundingAccount(account.Name, format), balance},
+			{ledgerAccount("Equity", "Opening Balances", format), -balance},
+		}})
+	}
+	for _, transaction := range d.Transactions {
+		root, sign := "Expenses", 1.0
+		if transaction.Type == Income {
+			root, sign = "Income", -1.0
+		}
+		entry := ledgerEntry{Date: transaction.Date, Payee: transaction.Payee, Narration: transaction.Description, ID: transaction.ID,
+			Notes: transaction.Notes, Metadata: transaction.Metadata, Tags: transaction.Tags}
+		for _, split := range transaction.categoryAmounts() {
+			entry.Postings = append(entry.Postings, ledgerPosting{ledgerAccount(root, split.Category, format), sign * split.Amount})
+		}
+		entry.Postings = append(entry.Postings, ledgerPosting{d.ledgerFundingAccount(transaction.Account, format), -sign * transaction.Amount})
+		entries = append(entries, entry)
+	}
+	slices.SortStableFunc(entries, func(a, b ledgerEntry) int { return a.Date.Compare(b.Date) })
+	return entries
+}
+
+// write the book as a ledger/hledger journal or a beancount file
+func (d *Data) exportLedger(w io.Writer, format string) error {
+	if format != "ledger" && format != "beancount" {
+		return fmt.Errorf("unknown export format %q, use ledger or beancount", format)
+	}
+	currency := cmp.Or(d.Currency, "USD")
+	decimals := 2
+	if currencyDecimals, ok := currencyDecimals[currency]; ok {
+		decimals = currencyDecimals
+	}
+	entries := d.ledgerEntries(format)
+
+	if format == "beancount" {
+		fmt.Fprintf(w, "option \"operating_currency\" %q\n\n", currency)
+		opened := make(map[string]bool)
+		for _, entry := range entries {
+			for _, posting := range entry.Postings {
+				if !opened[posting.Account] {
+					opened[posting.Account] = true
+					fmt.Fprintf(w, "%s open %s\n", entry.Date.Format("2006-01-02"), posting.Account)
+				}
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	for _, entry := range entries {
+		if format == "beancount" {
+			tags := ""
+			for _, tag := range entry.Tags {
+				tags += " #" + tag
+			}
+			if entry.Payee != "" {
+				fmt.Fprintf(w, "%s * %q %q%s\n", entry.Date.Format("2006-01-02"), entry.Payee, entry.Narration, tags)
+			} else {
+				fmt.Fprintf(w, "%s * %q%s\n", entry.Date.Format("2006-01-02"), entry.Narration, tags)
+			}
+			if entry.ID != 0 {
+				fmt.Fprintf(w, "  id: \"%d\"\n", entry.ID)
+			}
+			if entry.Notes != "" {
+				fmt.Fprintf(w, "  notes: %q\n", entry.Notes)
+			}
+			for _, key := range slices.Sorted(maps.Keys(entry.Metadata)) {
+				fmt.Fprintf(w, "  %s: %q\n", key, entry.Metadata[key])
+			}
+		} else {
+			fmt.Fprintf(w, "%s %s\n", entry.Date.Format("2006-01-02"), cmp.Or(entry.Narration, entry.Payee, "-"))
+			if entry.Payee != "" {
+				fmt.Fprintf(w, "  ; Payee: %s\n", entry.Payee)
+			}
+			if entry.ID != 0 {
+				fmt.Fprintf(w, "  ; id: %d\n", entry.ID)
+			}
+			for line := range strings.Lines(entry.Notes) {
+				fmt.Fprintf(w, "  ; %s\n", strings.TrimRight(line, "\r\n"))
+			}
+			for _, key := range slices.Sorted(maps.Keys(entry.Metadata)) {
+				fmt.Fprintf(w, "  ; %s: %s\n", key, entry.Metadata[key])
+			}
+			if len(entry.Tags) > 0 {
+				fmt.Fprintf(w, "  ; :%s:\n", strings.Join(entry.Tags, ":"))
+			}
+		}
+		for _, posting := range entry.Postings {
+			fmt.Fprintf(w, "  %-40s %12s %s\n", posting.Account, strconv.FormatFloat(posting.Amount, 'f', decimals, 64), currency)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// export to a file, the format follows the extension when not given: .xlsx for Excel, ledger otherwise
+func (d *Data) exportFile(filename, format string) error {
+	if filename == "" {
+		return fmt.Errorf("output file must not be empty")
+	}
+	if format == "" {
+		format = "ledger"
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".xlsx":
+			format = "xlsx"
+		case ".ics":
+			format = "ical"
+		}
+	}
+	if format != "ledger" && format != "beancount" && format != "xlsx" && format != "ical" {
+		return fmt.Errorf("unknown export format %q, use ledger, beancount, xlsx or ical", format)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	switch format {
+	case "xlsx":
+		err = d.exportXLSX(file)
+	case "ical":
+		err = d.exportICal(file)
+	default:
+		err = d.exportLedger(file, format)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if format == "ical" {
+		fmt.Printf("Exported %d recurring transactions to %s, import it into your calendar.\n", len(d.Recurring), filename)
+		return nil
+	}
+	fmt.Printf("Exported %d transactions to %s.\n", len(d.Transactions), filename)
+	return nil
+}
+
+// Excel counts days from 1899-12-30, the time of day is the fraction
+func excelDate(serial float64) time.Time {
+	return time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(serial))
+}
+
+func excelSerial(date time.Time) int {
+	return int(civilDate(date).Sub(time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+type xlsxSharedString struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"` // rich text keeps its pieces in runs
+}
+
+// open a member of an xlsx archive and decode its XML
+func decodeXLSXPart(archive *zip.Reader, name string, value any) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return xml.NewDecoder(file).Decode(value)
+}
+
+// rows of the first sheet of an Excel workbook as text, numbers and dates stay in Excel's raw form
+func readXLSX(content []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var relationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeXLSXPart(archive, "xl/workbook.xml", &workbook); err != nil || len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("not an Excel workbook")
+	}
+	sheetPath := "xl/worksheets/sheet1.xml"
+	if err := decodeXLSXPart(archive, "xl/_rels/workbook.xml.rels", &relationships); err == nil {
+		for _, relationship := range relationships.Relationships {
+			if relationship.ID == workbook.Sheets[0].ID {
+				sheetPath = path.Join("xl", relationship.Target)
+				if strings.HasPrefix(relationship.Target, "/") {
+					sheetPath = strings.TrimPrefix(relationship.Target, "/")
+				}
+			}
+		}
+	}
+
+	var shared struct {
+		Strings []xlsxSharedString `xml:"si"`
+	}
+	decodeXLSXPart(archive, "xl/sharedStrings.xml", &shared) // workbooks with inline strings only have none
+	var sheet struct {
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeXLSXPart(archive, sheetPath, &sheet); err != nil {
+		return nil, fmt.Errorf("failed to read the first sheet: %w", err)
+	}
+
+	records := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		record := make([]string, 0, len(row.Cells))
+		for i, cell := range row.Cells {
+			column := i
+			if cell.Ref != "" {
+				column = xlsxColumn(cell.Ref)
+			}
+			for len(record) < column {
+				record = append(record, "") // empty cells are left out of the sheet
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				if index, err := strconv.Atoi(cell.Value); err == nil && index < len(shared.Strings) {
+					value = shared.Strings[index].Text + strings.Join(shared.Strings[index].Runs, "")
+				}
+			case "inlineStr":
+				value = cell.Inline
+			}
+			record = append(record, value)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// zero based column of a cell reference, C7 is 2
+func xlsxColumn(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+	}
+	return column - 1
+}
+
+func xlsxColumnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+// cell styles defined in xlsxStyles
+const (
+	xlsxPlain = iota
+	xlsxHeader
+	xlsxDate
+	xlsxAmount
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="#,##0.00"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+// one sheet of an exported workbook, cells are strings, ints, float64 amounts or time.Time dates
+type xlsxSheet struct {
+	name   string
+	widths []int
+	rows   [][]any
+}
+
+func (sheet xlsxSheet) xml() string {
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	out.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	out.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	out.WriteString("<cols>")
+	for i, width := range sheet.widths {
+		fmt.Fprintf(&out, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+	}
+	out.WriteString("</cols><sheetData>")
+	for r, row := range sheet.rows {
+		fmt.Fprintf(&out, `<row r="%d">`, r+1)
+		for c, value := range row {
+			ref := xlsxColumnName(c) + strconv.Itoa(r+1)
+			switch value := value.(type) {
+			case time.Time:
+				fmt.Fprintf(&out, `<c r="%s" s="%d"><v>%d</v></c>`, ref, xlsxDate, excelSerial(value))
+			case float64:
+				fmt.Fprintf(&out, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxAmount, strconv.FormatFloat(value, 'f', -1, 64))
+			case int:
+				fmt.Fprintf(&out, `<c r="%s"><v>%d</v></c>`, ref, value)
+			default:
+				style := xlsxPlain
+				if r == 0 {
+					style = xlsxHeader
+				}
+				var text strings.Builder
+				xml.EscapeText(&text, []byte(fmt.Sprint(value)))
+				fmt.Fprintf(&out, `<c r="%s" t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, text.String())
+			}
+		}
+		out.WriteString("</row>")
+	}
+	out.WriteString("</sheetData></worksheet>")
+	return out.String()
+}
+
+// write the recurring transactions as an iCalendar file: one repeating all-day event each, bills with a
+// reminder the day before, so they show up in Google or Apple calendars
+func (d *Data) exportICal(w io.Writer) error {
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	add("BEGIN:VCALENDAR")
+	add("VERSION:2.0")
+	add("PRODID:-//golang-GC//finance//EN")
+	add("CALSCALE:GREGORIAN")
+	add("X-WR-CALNAME:Bills")
+	stamp := d.now().UTC().Format("20060102T150405Z")
+	for _, r := range d.Recurring {
+		summary := fmt.Sprintf("%s due: %s", r.Name, d.formatAmount(r.Amount))
+		if r.Type == Income {
+			summary = fmt.Sprintf("%s: +%s", r.Name, d.formatAmount(r.Amount))
+		}
+		details := []string{r.Type + ", " + r.Category}
+		if r.Account != "" {
+			details = append(details, "Account: "+r.Account)
+		}
+		if r.Description != "" {
+			details = append(details, r.Description)
+		}
+		add("BEGIN:VEVENT")
+		add("UID:recurring-%s@finance", strings.Join(strings.Fields(strings.ToLower(r.Name)), "-"))
+		add("DTSTAMP:%s", stamp)
+		add("DTSTART;VALUE=DATE:%s", r.Start.Format("20060102"))
+		add("DURATION:P1D")
+		add("RRULE:%s", r.rrule())
+		add("SUMMARY:%s", icalText(summary))
+		add("DESCRIPTION:%s", icalText(strings.Join(details, "\n")))
+		add("CATEGORIES:%s", icalText(r.Category))
+		add("TRANSP:TRANSPARENT")
+		if r.Type == Expense {
+			add("BEGIN:VALARM")
+			add("ACTION:DISPLAY")
+			add("DESCRIPTION:%s", icalText(summary))
+			add("TRIGGER:-P1D")
+			add("END:VALARM")
+		}
+		add("END:VEVENT")
+	}
+	add("END:VCALENDAR")
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icalFold(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the repetition as an RRULE; days past the 28th fall on the month's last day when it is shorter,
+// as occurrence does, which BYSETPOS=-1 over the candidate days expresses
+func (r Recurring) rrule() string {
+	switch r.Every {
+	case Week:
+		return "FREQ=WEEKLY"
+	case Year:
+		if r.Start.Month() == time.February && r.Start.Day() == 29 {
+			return "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=28,29;BYSETPOS=-1"
+		}
+		return "FREQ=YEARLY"
+	}
+	if day := r.Start.Day(); day > 28 {
+		days := []string{}
+		for candidate := 28; candidate <= day; candidate++ {
+			days = append(days, strconv.Itoa(candidate))
+		}
+		return "FREQ=MONTHLY;BYMONTHDAY=" + strings.Join(days, ",") + ";BYSETPOS=-1"
+	}
+	return "FREQ=MONTHLY"
+}
+
+// escape a TEXT value
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// lines longer than 75 octets continue on the next line after a space, never inside a UTF-8 sequence
+func icalFold(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		if size := utf8.RuneLen(r); width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += utf8.RuneLen(r)
+	}
+	return folded.String()
+}
+
+// chat messages that add an expense or income, e.g. spent 14.20 on lunch or got 3000 salary
+var (
+	chatExpenseVerbs = []string{"spent", "spend", "paid", "pay", "bought"}
+	chatIncomeVerbs  = []string{"got", "earned", "received", "income"}
+)
+
+const chatHelp = `Send what you spent or earned:
+  spent 14.20 on lunch #work
+  paid 40 for Transport fuel @yesterday
+  got 3000 salary
+  12.50 Food "lunch" (the add one-liner)
+Commands: /summary [march | 2026-03 | last month], /bills [days], /help`
+
+// answer one chat message: a command or a transaction to add; changed tells whether the book must be saved
+func (d *Data) botReply(message string) (reply string, changed bool) {
+	words := splitArgs(strings.TrimSpace(message))
+	if len(words) == 0 {
+		return chatHelp, false
+	}
+	command, _, _ := strings.Cut(strings.ToLower(strings.TrimPrefix(words[0], "/")), "@") // /summary@MyBot in groups
+	switch command {
+	case "start", "help":
+		return chatHelp, false
+	case "summary":
+		month, err := chatMonth(words[1:], d.today())
+		if err != nil {
+			return "Sorry, " + err.Error(), false
+		}
+		statement, err := d.renderStatement(month, false)
+		if err != nil {
+			return "Sorry, " + err.Error(), false
+		}
+		return statement, false
+	case "bills":
+		days := 14
+		if len(words) > 1 {
+			var err error
+			if days, err = strconv.Atoi(words[1]); err != nil || days < 0 {
+				return "Sorry, /bills takes a number of days", false
+			}
+		}
+		today := d.today()
+		bills := d.upcomingBills(today.AddDate(0, 0, -billsLate), today.AddDate(0, 0, days+1))
+		if len(bills) == 0 {
+			return fmt.Sprintf("No bills due in the next %d days.", days), false
+		}
+		lines := make([]string, len(bills))
+		for i, b := range bills {
+			lines[i] = fmt.Sprintf("%s %s %s (%s)", b.Due.Format("Jan 2"), b.Name, d.formatAmount(b.Amount), dueIn(daysUntil(b.Due, today)))
+		}
+		return strings.Join(lines, "\n"), false
+	}
+	if err := d.writable(); err != nil {
+		return "Sorry, " + err.Error(), false
+	}
+	transaction, err := d.parseChatEntry(words)
+	if err != nil {
+		return "Sorry, " + err.Error() + " (send /help for examples)", false
+	}
+	if err := d.appendTransaction(transaction); err != nil {
+		return "Sorry, " + err.Error(), false
+	}
+	added := d.Transactions[len(d.Transactions)-1]
+	reply = fmt.Sprintf("Added #%d: %s %s %s", added.ID, added.Type, d.formatAmount(added.Amount), added.Category)
+	if added.Description != "" {
+		reply += ", " + added.Description
+	}
+	return reply + " on " + added.Date.Format("Jan 2") + ".", true
+}
+
+// spent 14.20 on lunch becomes the add one-liner 14.20 <category> lunch, the category being the first
+// word when it names one and otherwise what the rules and history make of the description
+func (d *Data) parseChatEntry(words []string) (Transaction, error) {
+	verb := strings.ToLower(words[0])
+	expense, income := slices.Contains(chatExpenseVerbs, verb), slices.Contains(chatIncomeVerbs, verb)
+	if !expense && !income {
+		return d.parseQuickAdd(words)
+	}
+	if len(words) < 2 {
+		return Transaction{}, fmt.Errorf("how much was it?")
+	}
+	amount, rest := words[1], words[2:]
+	if income {
+		amount = "+" + amount
+	}
+	if len(rest) > 0 && slices.Contains([]string{"on", "for", "at", "from", "in"}, strings.ToLower(rest[0])) {
+		rest = rest[1:]
+	}
+	var plain, marks []string
+	for _, word := range rest {
+		if len(word) > 1 && (word[0] == '@' || word[0] == '#') {
+			marks = append(marks, word)
+		} else {
+			plain = append(plain, word)
+		}
+	}
+	category := ""
+	if len(plain) > 0 {
+		if match, distance := MatchCategory(plain[0], d.categories()); distance == 0 {
+			category, plain = match, plain[1:]
+		}
+	}
+	description := strings.Join(plain, " ")
+	if category == "" {
+		category = d.categorize(Transaction{Description: description}, "")
+	}
+	return d.parseQuickAdd(slices.Concat([]string{amount, category, description}, marks))
+}
+
+// the month a summary asks for: this one when none is named, last month, 2026-03 or a month name with
+// an optional year, the latest such month not after today
+func chatMonth(words []string, today time.Time) (string, error) {
+	text := strings.ToLower(strings.Join(words, " "))
+	switch text {
+	case "", "this month":
+		return today.Format("2006-01"), nil
+	case "last month", "last":
+		return today.AddDate(0, 0, -today.Day()).Format("2006-01"), nil
+	}
+	if month, err := time.Parse("2006-01", text); err == nil {
+		return month.Format("2006-01"), nil
+	}
+	fields := strings.Fields(text)
+	month, ok := parseMonthName(fields[0])
+	if !ok || len(fields) > 2 {
+		return "", fmt.Errorf("%q is not a month, try march, march 2025 or 2025-03", text)
+	}
+	year := today.Year()
+	if len(fields) == 2 {
+		var err error
+		if year, err = strconv.Atoi(fields[1]); err != nil {
+			return "", fmt.Errorf("%q is not a year", fields[1])
+		}
+	} else if month > today.Month() {
+		year--
+	}
+	return fmt.Sprintf("%04d-%02d", year, month), nil
+}
+
+// a Telegram bot answering from the book; only the listed chats may use it, others are told their ID
+type telegramBot struct {
+	api    string // https://api.telegram.org/bot<token>
+	chats  []int64
+	client *http.Client
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+func (b *telegramBot) call(ctx context.Context, method string, params url.Values, result any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, b.api+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := b.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+	defer response.Body.Close()
+	var answer struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return fmt.Errorf("telegram: %s: %w", response.Status, err)
+	}
+	if !answer.OK {
+		return fmt.Errorf("telegram: %s", cmp.Or(answer.Description, response.Status))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(answer.Result, result)
+}
+
+// long-poll for messages until ctx ends, saving the book after every message that changed it
+func (d *Data) runTelegramBot(ctx context.Context, bot *telegramBot, save func() error) error {
+	var offset int64
+	for ctx.Err() == nil {
+		var updates []telegramUpdate
+		err := bot.call(ctx, "getUpdates", url.Values{"offset": {strconv.FormatInt(offset, 10)}, "timeout": {"50"}}, &updates)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			slog.Warn("could not fetch messages", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message == nil || update.Message.Text == "" {
+				continue
+			}
+			chat := update.Message.Chat.ID
+			reply, changed := fmt.Sprintf("This chat is not allowed to use the book, start the bot with --chat %d to allow it.", chat), false
+			if slices.Contains(bot.chats, chat) {
+				reply, changed = d.botReply(update.Message.Text)
+			} else {
+				slog.Warn("refused a message from a chat not allowed", "chat", chat)
+			}
+			if changed {
+				if err := save(); err != nil {
+					reply = "Sorry, the book could not be saved: " + err.Error()
+				}
+			}
+			slog.Info("message", "chat", chat, "changed", changed)
+			if err := bot.call(ctx, "sendMessage", url.Values{"chat_id": {strconv.FormatInt(chat, 10)}, "text": {reply}}, nil); err != nil {
+				slog.Warn("could not send a reply", "chat", chat, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+
+// write a workbook with the transactions, a monthly summary and a category breakdown
+func (d *Data) exportXLSX(w io.Writer) error {
+	transactions := xlsxSheet{name: "Transactions", widths: []int{8, 12, 10, 18, 14, 30, 20, 16, 30}}
+	header := []any{"ID", "Date", "Type", "Category", "Amount", "Description", "Payee", "Account", "Notes"}
+	keys := make(map[string]bool)
+	for _, transaction := range d.Transactions {
+		for key := range transaction.Metadata {
+			keys[key] = true
+		}
+	}
+	metadataKeys := slices.Sorted(maps.Keys(keys))
+	for _, key := range metadataKeys { // read back by import as metadata
+		header = append(header, "meta:"+key)
+		transactions.widths = append(transactions.widths, 16)
+	}
+	transactions.rows = append(transactions.rows, header)
+	sorted := slices.Clone(d.Transactions)
+	slices.SortStableFunc(sorted, func(a, b Transaction) int { return a.Date.Compare(b.Date) })
+	months := make(map[string][2]float64) // income and expenses
+	categories := make(map[string][2]float64)
+	for _, transaction := range sorted {
+		row := []any{transaction.ID, transaction.Date, transaction.Type, transaction.Category, transaction.Amount, transaction.Description, transaction.Payee, transaction.Account, transaction.Notes}
+		for _, key := range metadataKeys {
+			row = append(row, transaction.Metadata[key])
+		}
+		transactions.rows = append(transactions.rows, row)
+		if transaction.foreign() {
+			continue
+		}
+		side := 1
+		if transaction.Type == Income {
+			side = 0
+		}
+		month := months[transaction.Date.Format("2006-01")]
+		month[side] += transaction.Amount
+		months[transaction.Date.Format("2006-01")] = month
+		for _, split := range transaction.categoryAmounts() {
+			category := categories[split.Category]
+			category[side] += split.Amount
+			categories[split.Category] = category
+		}
+	}
+
+	summary := xlsxSheet{name: "Summary", widths: []int{10, 14, 14, 14}}
+	summary.rows = append(summary.rows, []any{"Month", "Income", "Expenses", "Net"})
+	for _, month := range slices.Sorted(maps.Keys(months)) {
+		totals := months[month]
+		summary.rows = append(summary.rows, []any{month, totals[0], totals[1], totals[0] - totals[1]})
+	}
+	breakdown := xlsxSheet{name: "Categories", widths: []int{20, 14, 14}}
+	breakdown.rows = append(breakdown.rows, []any{"Category", "Income", "Expenses"})
+	for _, category := range slices.Sorted(maps.Keys(categories)) {
+		totals := categories[category]
+		breakdown.rows = append(breakdown.rows, []any{category, totals[0], totals[1]})
+	}
+	sheets := []xlsxSheet{transactions, summary, breakdown}
+
+	var workbook, relationships, contentTypes strings.Builder
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	relationships.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	contentTypes.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, sheet.name, i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	workbook.WriteString("</sheets></workbook>")
+	fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(sheets)+1)
+	contentTypes.WriteString("</Types>")
+
+	parts := [][2]string{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", relationships.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, [2]string{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.xml()})
+	}
+	archive := zip.NewWriter(w)
+	for _, part := range parts {
+		file, err := archive.Create(part[0])
+		if err != nil {
+			return fmt.Errorf("failed to write workbook: %w", err)
+		}
+		if _, err := io.WriteString(file, part[1]); err != nil {
+			return fmt.Errorf("failed to write workbook: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write workbook: %w", err)
+	}
+	return nil
+}
+
+// create or update an account, asset balances grow with income while liabilities grow with expenses
+func (d *Data) setAccount(account Account) error {
+	if account.Name == "" {
+		return fmt.Errorf("account name must not be empty")
+	}
+	switch strings.ToLower(account.Kind) {
+	case "", "asset":
+		account.Kind = Asset
+	case "liability":
+		account.Kind = Liability
+	case "investment":
+		account.Kind = Investment
+	default:
+		return fmt.Errorf("invalid account kind: %s", account.Kind)
+	}
+	for i := range d.Accounts {
+		if d.Accounts[i].Name == account.Name {
+			d.Accounts[i] = account
+			return nil
+		}
+	}
+	d.Accounts = append(d.Accounts, account)
+	return nil
+}
+
+func (d *Data) findAccount(name string) (Account, bool) {
+	for _, account := range d.Accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+func (d *Data) displayAccounts() {
+	fmt.Println("Accounts:")
+	table := newTable("Name", "Kind", "Opening balance", "Opening date").alignRight(2)
+	for _, account := range d.Accounts {
+		table.addRow(plainCell(account.Name), plainCell(account.Kind), plainCell(d.formatAmount(account.OpeningBalance)), plainCell(account.OpeningDate.Format("2006-01-02")))
+	}
+	table.print()
+}
+
+// how a transaction changes the account's balance, liabilities grow with expenses
+func (account Account) effect(transaction Transaction) float64 {
+	if transaction.foreign() {
+		return 0
+	}
+	if (transaction.Type == Expense) != (account.Kind == Liability) {
+		return -transaction.Amount
+	}
+	return transaction.Amount
+}
+
+// mark transactions as cleared, or back as uncleared, reconciled ones stay as they are
+func (d *Data) setCleared(ids []int, cleared bool) error {
+	for _, id := range ids {
+		transaction, err := d.findTransaction(id)
+		if err != nil {
+			return err
+		}
+		if transaction.Status == Reconciled {
+			return fmt.Errorf("transaction %d is already reconciled", id)
+		}
+		transaction.Status = ""
+		if cleared {
+			transaction.Status = Cleared
+		}
+	}
+	return nil
+}
+
+// transactions that came in from a bank, a mail or a text and wait to be confirmed, oldest first
+func (d *Data) pendingTransactions() []Transaction {
+	var pending []Transaction
+	for _, transaction := range d.Transactions {
+		if transaction.Status == Pending {
+			pending = append(pending, transaction)
+		}
+	}
+	slices.SortStableFunc(pending, func(a, b Transaction) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) })
+	return pending
+}
+
+// confirm pending transactions, they count as entered by hand from then on
+func (d *Data) acceptPending(ids []int) error {
+	for _, id := range ids {
+		transaction, err := d.findTransaction(id)
+		if err != nil {
+			return err
+		}
+		if transaction.Status != Pending {
+			return fmt.Errorf("transaction %d is not pending", id)
+		}
+		transaction.Status = ""
+	}
+	return nil
+}
+
+// go through the pending transactions one by one: accept, edit then accept, reject to the trash, skip
+// or quit; what was decided is kept when the input ends
+func (d *Data) reviewPending() (accepted, rejected int, err error) {
+	pending := d.pendingTransactions()
+	for i, t := range pending {
+		fmt.Printf("\n%d of %d  #%d %s %s %s  %s  %s\n", i+1, len(pending), t.ID, t.Date.Format("2006-01-02"), t.Type, d.formatAmount(t.Amount),
+			cmp.Or(t.Payee, "-"), t.Category)
+		if t.Description != "" && t.Description != t.Payee {
+			fmt.Printf("  %s\n", t.Description)
+		}
+		fmt.Print("Accept, edit, reject, skip or quit (a/e/r/s/q) [a]: ")
+		answer, err := readLine()
+		if err != nil {
+			return accepted, rejected, nil
+		}
+		switch strings.ToLower(cmp.Or(answer, "a"))[0] {
+		case 'a':
+		case 'e':
+			transaction, err := d.findTransaction(t.ID)
+			if err != nil {
+				return accepted, rejected, err
+			}
+			transaction.Category = d.confirmCategory(editLine("Category", transaction.Category, d.categories()))
+			transaction.Payee = editLine("Payee", transaction.Payee, d.payees())
+			amount, currency, err := d.parseTransactionAmount(editLine("Amount", strconv.FormatFloat(transaction.Amount, 'f', -1, 64), nil))
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			transaction.Amount, transaction.Currency = amount, cmp.Or(currency, transaction.Currency)
+			d.edited()
+		case 'r':
+			if err := d.trash([]int{t.ID}, d.now().UTC()); err != nil {
+				return accepted, rejected, err
+			}
+			rejected++
+			continue
+		case 'q':
+			return accepted, rejected, nil
+		default:
+			continue
+		}
+		if err := d.acceptPending([]int{t.ID}); err != nil {
+			return accepted, rejected, err
+		}
+		accepted++
+	}
+	return accepted, rejected, nil
+}
+
+// the account's balance through a statement date counting only cleared and reconciled transactions,
+// together with the uncleared ones up to that date
+func (d *Data) clearedBalance(name string, through time.Time) (float64, []Transaction) {
+	account, _ := d.findAccount(name)
+	balance := account.OpeningBalance
+	var uncleared []Transaction
+	for _, transaction := range d.Transactions {
+		if transaction.Account != name || transaction.Date.After(through) {
+			continue
+		}
+		if transaction.Status == "" {
+			uncleared = append(uncleared, transaction)
+			continue
+		}
+		balance += account.effect(transaction)
+	}
+	slices.SortStableFunc(uncleared, func(a, b Transaction) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) })
+	return balance, uncleared
+}
+
+// compare the cleared balance with a statement's ending balance and list what is still uncleared,
+// returns the difference left to explain
+func (d *Data) displayReconciliation(name string, statement float64, through time.Time) (float64, error) {
+	cleared, uncleared := d.clearedBalance(name, through)
+	difference := math.Round((statement-cleared)*100) / 100
+	fmt.Printf("Reconciling %s through %s\n", name, through.Format("2006-01-02"))
+	fmt.Printf("  Statement balance: %s\n", d.formatAmount(statement))
+	fmt.Printf("  Cleared balance:   %s\n", d.formatAmount(cleared))
+	fmt.Printf("  Difference:        %s\n", d.formatAmount(difference))
+	if len(uncleared) == 0 {
+		fmt.Println("No uncleared transactions.")
+		return difference, nil
+	}
+	fmt.Println("Uncleared transactions:")
+	return difference, d.displayTransactions(uncleared, 0, 0)
+}
+
+// lock in a balanced statement: cleared transactions through its date become reconciled
+func (d *Data) finishReconciliation(name string, through time.Time) int {
+	count := 0
+	for i, transaction := range d.Transactions {
+		if transaction.Account == name && transaction.Status == Cleared && !transaction.Date.After(through) {
+			d.Transactions[i].Status = Reconciled
+			count++
+		}
+	}
+	return count
+}
+
+// walk through a statement: show the difference, let the user clear transactions until it is zero
+func (d *Data) reconcile(dataFile, name string, statement float64, through time.Time) error {
+	if _, ok := d.findAccount(name); !ok && !slices.ContainsFunc(d.Transactions, func(t Transaction) bool { return t.Account == name }) {
+		return fmt.Errorf("no account named %s", name)
+	}
+	for {
+		difference, err := d.displayReconciliation(name, statement, through)
+		if err != nil {
+			return err
+		}
+		if difference == 0 {
+			count := d.finishReconciliation(name, through)
+			if err := d.save(dataFile); err != nil {
+				return err
+			}
+			fmt.Printf("The books balance, %d transactions reconciled.\n", count)
+			return nil
+		}
+		input := prompt("IDs to clear (prefix with - to unclear, empty to stop)", "")
+		if input == "" {
+			if err := d.save(dataFile); err != nil {
+				return err
+			}
+			fmt.Printf("Stopped with a difference of %s, cleared transactions are kept for next time.\n", d.formatAmount(difference))
+			return nil
+		}
+		for _, field := range strings.Fields(input) {
+			id, err := strconv.Atoi(strings.TrimPrefix(field, "-"))
+			if err != nil {
+				fmt.Println("Error: invalid transaction ID:", field)
+			} else if err := d.setCleared([]int{id}, !strings.HasPrefix(field, "-")); err != nil {
+				fmt.Println("Error:", err)
+			}
+		}
+	}
+}
+
+// borrowed money repaid in equal monthly installments
+type Loan struct {
+	Name      string    `json:"name"`
+	Principal float64   `json:"principal"`
+	Rate      float64   `json:"rate"`  // yearly interest in percent
+	Term      int       `json:"term"`  // number of monthly payments
+	Start     time.Time `json:"start"` // the first payment is due a month later
+}
+
+// the installment that pays the loan off over its term
+func (l Loan) payment() float64 {
+	rate := l.Rate / 12 / 100
+	if rate == 0 {
+		return l.Principal / float64(l.Term)
+	}
+	return l.Principal * rate / (1 - math.Pow(1+rate, -float64(l.Term)))
+}
+
+type amortizationRow struct {
+	Number    int       `json:"number"`
+	Date      time.Time `json:"date"`
+	Payment   float64   `json:"payment"`
+	Interest  float64   `json:"interest"`
+	Principal float64   `json:"principal"`
+	Balance   float64   `json:"balance"`
+}
+
+// pay balance down from the month after from with payment plus extra each month until nothing is left,
+// giving up after a century
+func amortize(balance, yearlyRate, payment, extra float64, from time.Time) []amortizationRow {
+	rate := yearlyRate / 12 / 100
+	var rows []amortizationRow
+	for month := 1; balance > 0.005 && month <= 1200; month++ {
+		interest := balance * rate
+		if payment+extra <= interest {
+			break // never paid off, the installment does not even cover the interest
+		}
+		paid := min(payment+extra, balance+interest)
+		if balance+interest-paid < payment/100 {
+			paid = balance + interest // the last few cents are settled with the final installment
+		}
+		balance -= paid - interest
+		rows = append(rows, amortizationRow{Number: month, Date: from.AddDate(0, month, 0), Payment: paid, Interest: interest, Principal: paid - interest, Balance: max(balance, 0)})
+	}
+	return rows
+}
+
+func (l Loan) schedule(extra float64) []amortizationRow {
+	return amortize(l.Principal, l.Rate, l.payment(), extra, l.Start)
+}
+
+// where a loan stands after the payments linked to it, and when it is paid off at the regular
+// installment and with an extra amount on top
+type loanStatus struct {
+	Loan              Loan      `json:"loan"`
+	Payment           float64   `json:"payment"`
+	Payments          int       `json:"payments"`
+	PrincipalPaid     float64   `json:"principal_paid"`
+	InterestPaid      float64   `json:"interest_paid"`
+	Remaining         float64   `json:"remaining"`
+	Payoff            time.Time `json:"payoff,omitzero"`
+	RemainingInterest float64   `json:"remaining_interest"`
+	Extra             float64   `json:"extra,omitempty"`
+	ExtraPayoff       time.Time `json:"extra_payoff,omitzero"`
+	ExtraInterest     float64   `json:"extra_interest,omitempty"`
+}
+
+func (d *Data) findLoan(name string) (Loan, error) {
+	i := slices.IndexFunc(d.Loans, func(l Loan) bool { return strings.EqualFold(l.Name, name) })
+	if i < 0 {
+		return Loan{}, fmt.Errorf("no loan named %s, see loan list", name)
+	}
+	return d.Loans[i], nil
+}
+
+func (d *Data) addLoan(loan Loan) error {
+	switch {
+	case loan.Name == "":
+		return fmt.Errorf("a loan needs a name")
+	case loan.Principal <= 0 || loan.Term <= 0 || loan.Rate < 0:
+		return fmt.Errorf("a loan needs a positive principal and term and a rate of at least 0")
+	}
+	if _, err := d.findLoan(loan.Name); err == nil {
+		return fmt.Errorf("loan %s already exists", loan.Name)
+	}
+	d.Loans = append(d.Loans, loan)
+	return nil
+}
+
+// mark expenses as payments of a loan
+func (d *Data) linkLoanPayments(name string, ids []int) error {
+	loan, err := d.findLoan(name)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		transaction, err := d.findTransaction(id)
+		if err != nil {
+			return err
+		}
+		if transaction.Type != Expense {
+			return fmt.Errorf("transaction %d is not an expense and cannot pay a loan", id)
+		}
+		transaction.Loan = loan.Name
+	}
+	return nil
+}
+
+// replay the linked payments in date order, each one after a month of interest
+func (d *Data) calculateLoanStatus(loan Loan, extra float64) loanStatus {
+	status := loanStatus{Loan: loan, Payment: loan.payment(), Remaining: loan.Principal, Extra: extra}
+	var payments []Transaction
+	for _, transaction := range d.Transactions {
+		if transaction.Loan == loan.Name {
+			payments = append(payments, transaction)
+		}
+	}
+	slices.SortStableFunc(payments, func(a, b Transaction) int { return a.Date.Compare(b.Date) })
+	last := loan.Start
+	for _, payment := range payments {
+		interest := status.Remaining * loan.Rate / 12 / 100
+		status.InterestPaid += interest
+		status.PrincipalPaid += payment.Amount - interest
+		status.Remaining -= payment.Amount - interest
+		status.Payments++
+		last = payment.Date
+	}
+	status.Remaining = max(status.Remaining, 0)
+	project := func(extra float64) (time.Time, float64) {
+		rows := amortize(status.Remaining, loan.Rate, status.Payment, extra, last)
+		if len(rows) == 0 || rows[len(rows)-1].Balance > 0.005 {
+			return time.Time{}, 0
+		}
+		interest := 0.0
+		for _, row := range rows {
+			interest += row.Interest
+		}
+		return rows[len(rows)-1].Date, interest
+	}
+	status.Payoff, status.RemainingInterest = project(0)
+	if extra > 0 {
+		status.ExtraPayoff, status.ExtraInterest = project(extra)
+	}
+	return status
+}
+
+func (d *Data) displayLoans() {
+	if len(d.Loans) == 0 {
+		fmt.Println("No loans.")
+		return
+	}
+	table := newTable("Name", "Principal", "Rate", "Term", "Start", "Payment", "Remaining").alignRight(1, 2, 3, 5, 6)
+	for _, loan := range d.Loans {
+		status := d.calculateLoanStatus(loan, 0)
+		table.addRow(plainCell(loan.Name), plainCell(d.formatAmount(loan.Principal)), plainCell(fmt.Sprintf("%.2f%%", loan.Rate)),
+			plainCell(fmt.Sprintf("%d months", loan.Term)), plainCell(loan.Start.Format("2006-01-02")),
+			plainCell(d.formatAmount(status.Payment)), d.amountCell(status.Remaining, Expense))
+	}
+	table.print()
+}
+
+func (d *Data) displayLoanSchedule(loan Loan, extra float64) error {
+	rows := loan.schedule(extra)
+	if structuredOutput() {
+		records := make([][]any, len(rows))
+		for i, row := range rows {
+			records[i] = []any{row.Number, row.Date.Format("2006-01-02"), row.Payment, row.Interest, row.Principal, row.Balance}
+		}
+		return emit(rows, []string{"number", "date", "payment", "interest", "principal", "balance"}, records)
+	}
+	table := newTable("#", "Date", "Payment", "Interest", "Principal", "Balance").alignRight(0, 2, 3, 4, 5)
+	interest := 0.0
+	for _, row := range rows {
+		interest += row.Interest
+		table.addRow(plainCell(strconv.Itoa(row.Number)), plainCell(row.Date.Format("2006-01-02")), plainCell(d.formatAmount(row.Payment)),
+			plainCell(d.formatAmount(row.Interest)), plainCell(d.formatAmount(row.Principal)), plainCell(d.formatAmount(row.Balance)))
+	}
+	table.print()
+	fmt.Printf("%d payments, %s interest in total.\n", len(rows), d.formatAmount(interest))
+	return nil
+}
+
+func (d *Data) displayLoanStatus(loan Loan, extra float64) error {
+	status := d.calculateLoanStatus(loan, extra)
+	if structuredOutput() {
+		return emit(status, []string{"name", "payments", "principal_paid", "interest_paid", "remaining", "payoff"},
+			[][]any{{loan.Name, status.Payments, status.PrincipalPaid, status.InterestPaid, status.Remaining, status.Payoff.Format("2006-01-02")}})
+	}
+	payoff := func(date time.Time) string {
+		if date.IsZero() {
+			return "never, the payment does not cover the interest"
+		}
+		return date.Format("2006-01")
+	}
+	fmt.Printf("%s: %s at %.2f%% over %d months from %s\n", loan.Name, d.formatAmount(loan.Principal), loan.Rate, loan.Term, loan.Start.Format("2006-01-02"))
+	table := newTable("", "").alignRight(1)
+	table.addRow(plainCell("Payments made:"), plainCell(strconv.Itoa(status.Payments)))
+	table.addRow(plainCell("Principal paid:"), plainCell(d.formatAmount(status.PrincipalPaid)))
+	table.addRow(plainCell("Interest paid:"), plainCell(d.formatAmount(status.InterestPaid)))
+	table.addRow(plainCell("Remaining principal:"), d.amountCell(status.Remaining, Expense))
+	table.addRow(plainCell("Monthly payment:"), plainCell(d.formatAmount(status.Payment)))
+	table.addRow(plainCell("Projected payoff:"), plainCell(payoff(status.Payoff)))
+	table.addRow(plainCell("Interest still to pay:"), plainCell(d.formatAmount(status.RemainingInterest)))
+	table.print()
+	if extra > 0 {
+		fmt.Printf("With %s extra a month the loan is paid off in %s", d.formatAmount(extra), payoff(status.ExtraPayoff))
+		if !status.ExtraPayoff.IsZero() && !status.Payoff.IsZero() {
+			months := (status.Payoff.Year()-status.ExtraPayoff.Year())*12 + int(status.Payoff.Month()-status.ExtraPayoff.Month())
+			fmt.Printf(", %d months sooner, saving %s interest", months, d.formatAmount(status.RemainingInterest-status.ExtraInterest))
+		}
+		fmt.Println(".")
+	}
+	return nil
+}
+
+// a position in an investment account
+type Holding struct {
+	Account   string    `json:"account"`
+	Symbol    string    `json:"symbol"`
+	Units     float64   `json:"units"`
+	CostBasis float64   `json:"cost_basis"` // what was paid for all the units
+	Acquired  time.Time `json:"acquired"`
+}
+
+// the price of one unit of a symbol on a day
+type Quote struct {
+	Date  time.Time `json:"date"`
+	Price float64   `json:"price"`
+}
+
+// add or change a holding of an investment account, zero units sell it off
+func (d *Data) setHolding(holding Holding) error {
+	if account, ok := d.findAccount(holding.Account); !ok || account.Kind != Investment {
+		return fmt.Errorf("%s is not an investment account, add one with: account add <name> --kind investment", holding.Account)
+	}
+	holding.Symbol = strings.ToUpper(strings.TrimSpace(holding.Symbol))
+	if holding.Symbol == "" || holding.Units < 0 || holding.CostBasis < 0 {
+		return fmt.Errorf("a holding needs a symbol and units and cost basis of at least 0")
+	}
+	i := slices.IndexFunc(d.Holdings, func(h Holding) bool { return h.Account == holding.Account && h.Symbol == holding.Symbol })
+	switch {
+	case i >= 0 && holding.Units == 0:
+		d.Holdings = slices.Delete(d.Holdings, i, i+1)
+	case i >= 0:
+		d.Holdings[i] = holding
+	case holding.Units > 0:
+		d.Holdings = append(d.Holdings, holding)
+	}
+	return nil
+}
+
+// record a price, replacing one already recorded for the same day
+func (d *Data) setPrice(symbol string, date time.Time, price float64) error {
+	if price <= 0 {
+		return fmt.Errorf("%w %v: prices must be positive", ErrInvalidAmount, price)
+	}
+	symbol = strings.ToUpper(symbol)
+	if d.Prices == nil {
+		d.Prices = make(map[string][]Quote)
+	}
+	d.Prices[symbol] = withQuote(d.Prices[symbol], Quote{Date: date, Price: price})
+	return nil
+}
+
+// add a quote to a history sorted by date, replacing one of the same day
+func withQuote(quotes []Quote, quote Quote) []Quote {
+	i, found := slices.BinarySearchFunc(quotes, quote.Date, func(q Quote, date time.Time) int { return q.Date.Compare(date) })
+	if found {
+		quotes[i] = quote
+		return quotes
+	}
+	return slices.Insert(quotes, i, quote)
+}
+
+// the latest quote of a history on or before date
+func quoteOn(quotes []Quote, date time.Time) (float64, bool) {
+	i := sort.Search(len(quotes), func(i int) bool { return quotes[i].Date.After(date) })
+	if i == 0 {
+		return 0, false
+	}
+	return quotes[i-1].Price, true
+}
+
+// the latest price of a symbol on or before date
+func (d *Data) priceOn(symbol string, date time.Time) (float64, bool) {
+	return quoteOn(d.Prices[symbol], date)
+}
+
+// market value of a holding on date, its cost basis while no price is known
+func (d *Data) holdingValue(holding Holding, date time.Time) float64 {
+	if price, ok := d.priceOn(holding.Symbol, date); ok {
+		return holding.Units * price
+	}
+	return holding.CostBasis
+}
+
+// ask the configured quote service for a symbol's price; it may answer with a bare number
+// or with JSON holding a "price" field
+func fetchQuote(template, symbol string) (float64, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(strings.ReplaceAll(template, "{symbol}", url.QueryEscape(symbol)))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("quote service answered %s", response.Status)
+	}
+	if price, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64); err == nil {
+		return price, nil
+	}
+	var quote struct {
+		Price *float64 `json:"price"`
+	}
+	if err := json.Unmarshal(body, &quote); err != nil || quote.Price == nil {
+		return 0, fmt.Errorf("no price in the quote service's answer")
+	}
+	return *quote.Price, nil
+}
+
+// fetch today's price of every symbol held, returns the symbols that failed with their errors
+func (d *Data) updatePrices() (int, map[string]error) {
+	failed := make(map[string]error)
+	updated := 0
+	for _, symbol := range slices.Compact(slices.Sorted(func(yield func(string) bool) {
+		for _, holding := range d.Holdings {
+			if !yield(holding.Symbol) {
+				return
+			}
+		}
+	})) {
+		price, err := fetchQuote(d.Settings.QuoteURL, symbol)
+		if err == nil {
+			err = d.setPrice(symbol, d.today(), price)
+		}
+		if err != nil {
+			failed[symbol] = err
+			continue
+		}
+		updated++
+	}
+	return updated, failed
+}
+
+type portfolioLine struct {
+	Account   string  `json:"account"`
+	Symbol    string  `json:"symbol"`
+	Units     float64 `json:"units"`
+	Price     float64 `json:"price"` // 0 when no price is known
+	Value     float64 `json:"value"`
+	CostBasis float64 `json:"cost_basis"`
+	Gain      float64 `json:"unrealized_gain"`
+}
+
+func (d *Data) calculatePortfolio() ([]portfolioLine, portfolioLine) {
+	total := portfolioLine{Account: "Total"}
+	lines := make([]portfolioLine, 0, len(d.Holdings))
+	for _, holding := range d.Holdings {
+		line := portfolioLine{Account: holding.Account, Symbol: holding.Symbol, Units: holding.Units, CostBasis: holding.CostBasis}
+		line.Price, _ = d.priceOn(holding.Symbol, d.today())
+		line.Value = d.holdingValue(holding, d.today())
+		line.Gain = line.Value - line.CostBasis
+		lines = append(lines, line)
+		total.Value += line.Value
+		total.CostBasis += line.CostBasis
+		total.Gain += line.Gain
+	}
+	slices.SortFunc(lines, func(a, b portfolioLine) int {
+		return cmp.Or(strings.Compare(a.Account, b.Account), strings.Compare(a.Symbol, b.Symbol))
+	})
+	return lines, total
+}
+
+func (d *Data) displayPortfolio() error {
+	lines, total := d.calculatePortfolio()
+	if structuredOutput() {
+		rows := make([][]any, 0, len(lines)+1)
+		for _, line := range append(lines, total) {
+			rows = append(rows, []any{line.Account, line.Symbol, line.Units, line.Price, line.Value, line.CostBasis, line.Gain})
+		}
+		return emit(struct {
+			Holdings []portfolioLine `json:"holdings"`
+			Total    portfolioLine   `json:"total"`
+		}{lines, total}, []string{"account", "symbol", "units", "price", "value", "cost_basis", "unrealized_gain"}, rows)
+	}
+	if len(lines) == 0 {
+		fmt.Println("No holdings, add one with: holding set [--cost n] <account> <symbol> <units>")
+		return nil
+	}
+	gainText := func(line portfolioLine) string {
+		if line.CostBasis == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%+.1f%%", line.Gain/line.CostBasis*100)
+	}
+	table := newTable("Account", "Symbol", "Units", "Price", "Value", "Cost basis", "Gain", "%").alignRight(2, 3, 4, 5, 6, 7)
+	for _, line := range lines {
+		price := "-"
+		if line.Price > 0 {
+			price = d.formatAmount(line.Price)
+		}
+		table.addRow(plainCell(line.Account), plainCell(line.Symbol), plainCell(strconv.FormatFloat(line.Units, 'f', -1, 64)), plainCell(price),
+			plainCell(d.formatAmount(line.Value)), plainCell(d.formatAmount(line.CostBasis)), d.balanceCell(line.Gain), plainCell(gainText(line)))
+	}
+	table.addRow(plainCell(total.Account), plainCell(""), plainCell(""), plainCell(""), plainCell(d.formatAmount(total.Value)),
+		plainCell(d.formatAmount(total.CostBasis)), d.balanceCell(total.Gain), plainCell(gainText(total)))
+	table.print()
+	return nil
+}
+
+type rankedSpend struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Share  float64 `json:"share"` // percent of all expenses in the period
+}
+
+// categories ordered by how much was spent on them, largest first
+func (d *Data) calculateTopCategories(period string, periodValue string, n int) ([]rankedSpend, float64) {
+	filter := d.periodFilter(period, periodValue)
+	filter.Type = Expense
+	totals := make(map[string]float64)
+	total := 0.0
+	for transaction := range d.Query(filter) {
+		if transaction.foreign() {
+			continue
+		}
+		for _, split := range transaction.categoryAmounts() {
+			totals[split.Category] += split.Amount
+		}
+		total += transaction.Amount
+	}
+	return rankSpend(totals, total, n), total
+}
+
+func (d *Data) calculateTopPayees(period string, periodValue string, n int) []rankedSpend {
+	filter := d.periodFilter(period, periodValue)
+	filter.Type = Expense
+	totals := make(map[string]float64)
+	total := 0.0
+	for transaction := range d.Query(filter) {
+		if transaction.foreign() {
+			continue
+		}
+		if transaction.Payee != "" {
+			totals[transaction.Payee] += transaction.Amount
+		}
+		total += transaction.Amount
+	}
+	return rankSpend(totals, total, n)
+}
+
+func rankSpend(totals map[string]float64, total float64, n int) []rankedSpend {
+	ranked := make([]rankedSpend, 0, len(totals))
+	for name, amount := range totals {
+		share := 0.0
+		if total > 0 {
+			share = amount / total * 100
+		}
+		ranked = append(ranked, rankedSpend{Name: name, Amount: amount, Share: share})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Amount != ranked[j].Amount {
+			return ranked[i].Amount > ranked[j].Amount
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+func (d *Data) displayRanking(title string, ranked []rankedSpend) {
+	fmt.Println(title)
+	table := newTable("#", "Name", "Amount", "Share").alignRight(0, 2, 3)
+	for i, entry := range ranked {
+		table.addRow(plainCell(strconv.Itoa(i+1)), plainCell(entry.Name), d.amountCell(entry.Amount, Expense), plainCell(fmt.Sprintf("%.1f%%", entry.Share)))
+	}
+	table.print()
+}
+
+func (d *Data) displayTopReport(period string, periodValue string, n int) error {
+	categories, total := d.calculateTopCategories(period, periodValue, n)
+	payees := d.calculateTopPayees(period, periodValue, n)
+	if structuredOutput() {
+		rows := make([][]any, 0, len(categories)+len(payees))
+		for i, entry := range categories {
+			rows = append(rows, []any{"category", i + 1, entry.Name, entry.Amount, entry.Share})
+		}
+		for i, entry := range payees {
+			rows = append(rows, []any{"payee", i + 1, entry.Name, entry.Amount, entry.Share})
+		}
+		return emit(struct {
+			Total      float64       `json:"total_expenses"`
+			Categories []rankedSpend `json:"categories"`
+			Payees     []rankedSpend `json:"payees"`
+		}{total, categories, payees}, []string{"kind", "rank", "name", "amount", "share"}, rows)
+	}
+	fmt.Printf("Total expenses: %s\n", d.formatAmount(total))
+	d.displayRanking("Top categories:", categories)
+	if len(payees) > 0 {
+		d.displayRanking("Top payees:", payees)
+	}
+	filter := d.periodFilter(period, periodValue)
+	filter.Type = Expense
+	d.noteLeftOut(filter)
+	return nil
+}
+
+// how much single transactions of a category come to
+type categoryStats struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+	Mean     float64 `json:"mean"`
+	Median   float64 `json:"median"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+}
+
+type spendStats struct {
+	Type          string          `json:"type"`
+	From          time.Time       `json:"from"`
+	To            time.Time       `json:"to"` // exclusive
+	Days          int             `json:"days"`
+	Total         float64         `json:"total"`
+	DailyAverage  float64         `json:"daily_average"`
+	WeeklyAverage float64         `json:"weekly_average"`
+	Categories    []categoryStats `json:"categories"`
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Sorted(slices.Values(values))
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// per-category statistics of the expenses (or income) matching filter, split lines counted on their own,
+// averages run over the filtered dates up to today
+func (d *Data) calculateStats(filter Filter) spendStats {
+	if filter.Type == "" {
+		filter.Type = Expense
+	}
+	amounts := make(map[string][]float64)
+	var first, last time.Time
+	for transaction := range d.Query(filter) {
+		for _, split := range transaction.categoryAmounts() {
+			if filter.Category == "" || split.Category == filter.Category {
+				amounts[split.Category] = append(amounts[split.Category], split.Amount)
+			}
+		}
+		if first.IsZero() || transaction.Date.Before(first) {
+			first = transaction.Date
+		}
+		if transaction.Date.After(last) {
+			last = transaction.Date
+		}
+	}
+	stats := spendStats{Type: filter.Type, From: filter.From, To: filter.To}
+	if stats.From.IsZero() {
+		stats.From = first
+	}
+	if tomorrow := d.today().AddDate(0, 0, 1); stats.To.IsZero() || stats.To.After(tomorrow) {
+		stats.To = tomorrow
+		if afterLast := last.AddDate(0, 0, 1); afterLast.After(tomorrow) {
+			stats.To = afterLast
+		}
+	}
+	for category, values := range amounts {
+		entry := categoryStats{Category: category, Count: len(values), Min: slices.Min(values), Max: slices.Max(values), Median: median(values)}
+		for _, value := range values {
+			entry.Total += value
+		}
+		entry.Mean = entry.Total / float64(entry.Count)
+		stats.Total += entry.Total
+		stats.Categories = append(stats.Categories, entry)
+	}
+	slices.SortFunc(stats.Categories, func(a, b categoryStats) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), strings.Compare(a.Category, b.Category))
+	})
+	if len(stats.Categories) > 0 {
+		stats.Days = max(int(math.Round(stats.To.Sub(stats.From).Hours()/24)), 1)
+		stats.DailyAverage = stats.Total / float64(stats.Days)
+		stats.WeeklyAverage = stats.DailyAverage * 7
+	}
+	return stats
+}
+
+func (d *Data) displayStats(filter Filter) error {
+	stats := d.calculateStats(filter)
+	if structuredOutput() {
+		rows := make([][]any, len(stats.Categories))
+		for i, entry := range stats.Categories {
+			rows[i] = []any{entry.Category, entry.Count, entry.Total, entry.Mean, entry.Median, entry.Min, entry.Max}
+		}
+		return emit(stats, []string{"category", "count", "total", "mean", "median", "min", "max"}, rows)
+	}
+	if len(stats.Categories) == 0 {
+		fmt.Println("No transactions found.")
+		return nil
+	}
+	fmt.Printf("%s from %s to %s (%d days): %s\n", stats.Type, stats.From.Format("2006-01-02"),
+		stats.To.AddDate(0, 0, -1).Format("2006-01-02"), stats.Days, d.formatAmount(stats.Total))
+	fmt.Printf("  Daily average:  %s\n", d.formatAmount(stats.DailyAverage))
+	fmt.Printf("  Weekly average: %s\n", d.formatAmount(stats.WeeklyAverage))
+	table := newTable("Category", "Count", "Total", "Mean", "Median", "Min", "Max").alignRight(1, 2, 3, 4, 5, 6)
+	for _, entry := range stats.Categories {
+		table.addRow(plainCell(entry.Category), plainCell(strconv.Itoa(entry.Count)), plainCell(d.formatAmount(entry.Total)),
+			plainCell(d.formatAmount(entry.Mean)), plainCell(d.formatAmount(entry.Median)), plainCell(d.formatAmount(entry.Min)), plainCell(d.formatAmount(entry.Max)))
+	}
+	table.print()
+	return nil
+}
+
+// one row of a period comparison, Percent is nil when the first period had nothing
+type comparisonLine struct {
+	Type     string   `json:"type"`
+	Category string   `json:"category,omitempty"`
+	A        float64  `json:"a"`
+	B        float64  `json:"b"`
+	Change   float64  `json:"change"`
+	Percent  *float64 `json:"percent"`
+}
+
+func newComparisonLine(transactionType, category string, a, b float64) comparisonLine {
+	line := comparisonLine{Type: transactionType, Category: category, A: a, B: b, Change: b - a}
+	if a != 0 {
+		percent := line.Change / math.Abs(a) * 100
+		line.Percent = &percent
+	}
+	return line
+}
+
+// the change text colored by whether it is good news: less spent or more earned is green
+func (d *Data) changeCell(line comparisonLine) tableCell {
+	cell := plainCell(d.formatAmount(line.Change))
+	if line.Change > 0 {
+		cell.text = "+" + cell.text
+	}
+	if line.Change != 0 {
+		if (line.Change > 0) == (line.Type == Expense) {
+			cell.color = colorRed
+		} else {
+			cell.color = colorGreen
+		}
+	}
+	return cell
+}
+
+func (line comparisonLine) percentText() string {
+	if line.Percent == nil {
+		if line.B == 0 {
+			return ""
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", *line.Percent)
+}
+
+type comparison struct {
+	A          string           `json:"a"`
+	B          string           `json:"b"`
+	Income     comparisonLine   `json:"income"`
+	Expense    comparisonLine   `json:"expense"`
+	Net        comparisonLine   `json:"net"`
+	Categories []comparisonLine `json:"categories"` // largest change first
+}
+
+// totals per type and category of a period given like --period
+func (d *Data) periodTotals(value string, fiscal, adjust bool) (map[[2]string]float64, error) {
+	period, periodValue, err := d.parsePeriodFlag(value, fiscal, d.today())
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[[2]string]float64)
+	for transaction := range d.Query(d.periodFilter(period, periodValue)) {
+		if transaction.foreign() {
+			continue
+		}
+		factor := 1.0
+		if adjust {
+			if factor, err = d.inflationFactor(transaction.Date); err != nil {
+				return nil, err
+			}
+		}
+		for _, split := range transaction.categoryAmounts() {
+			totals[[2]string{transaction.Type, split.Category}] += split.Amount * factor
+		}
+	}
+	return totals, nil
+}
+
+// income, expenses and every category of period b next to period a, in today's money when adjust is set
+func (d *Data) calculateComparison(a, b string, fiscal, adjust bool) (comparison, error) {
+	totalsA, err := d.periodTotals(a, fiscal, adjust)
+	if err != nil {
+		return comparison{}, err
+	}
+	totalsB, err := d.periodTotals(b, fiscal, adjust)
+	if err != nil {
+		return comparison{}, err
+	}
+	result := comparison{A: a, B: b}
+	sums := map[string][2]float64{}
+	for key := range mapsMerged(totalsA, totalsB) {
+		line := newComparisonLine(key[0], key[1], totalsA[key], totalsB[key])
+		result.Categories = append(result.Categories, line)
+		sum := sums[key[0]]
+		sums[key[0]] = [2]float64{sum[0] + line.A, sum[1] + line.B}
+	}
+	slices.SortFunc(result.Categories, func(x, y comparisonLine) int {
+		return cmp.Or(cmp.Compare(math.Abs(y.Change), math.Abs(x.Change)), strings.Compare(x.Type, y.Type), strings.Compare(x.Category, y.Category))
+	})
+	result.Income = newComparisonLine(Income, "", sums[Income][0], sums[Income][1])
+	result.Expense = newComparisonLine(Expense, "", sums[Expense][0], sums[Expense][1])
+	result.Net = newComparisonLine("Net", "", result.Income.A-result.Expense.A, result.Income.B-result.Expense.B)
+	return result, nil
+}
+
+// keys present in either map
+func mapsMerged[K comparable, A, B any](a map[K]A, b map[K]B) map[K]struct{} {
+	keys := make(map[K]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func (d *Data) displayComparison(a, b string, fiscal, adjust bool, highlight int) error {
+	result, err := d.calculateComparison(a, b, fiscal, adjust)
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		rows := make([][]any, 0, len(result.Categories)+3)
+		for _, line := range append([]comparisonLine{result.Income, result.Expense, result.Net}, result.Categories...) {
+			var percent any = ""
+			if line.Percent != nil {
+				percent = *line.Percent
+			}
+			rows = append(rows, []any{line.Type, line.Category, line.A, line.B, line.Change, percent})
+		}
+		return emit(result, []string{"type", "category", "a", "b", "change", "percent"}, rows)
+	}
+	if adjust {
+		fmt.Println("Amounts in today's money:")
+	}
+	totals := newTable("", a, b, "Change", "%").alignRight(1, 2, 3, 4)
+	for _, row := range []struct {
+		label string
+		line  comparisonLine
+	}{{"Income", result.Income}, {"Expenses", result.Expense}, {"Net", result.Net}} {
+		totals.addRow(plainCell(row.label), plainCell(d.formatAmount(row.line.A)), plainCell(d.formatAmount(row.line.B)),
+			d.changeCell(row.line), plainCell(row.line.percentText()))
+	}
+	totals.print()
+	if len(result.Categories) == 0 {
+		return nil
+	}
+	fmt.Println()
+	table := newTable("Type", "Category", a, b, "Change", "%").alignRight(2, 3, 4, 5)
+	for _, line := range result.Categories {
+		table.addRow(plainCell(line.Type), plainCell(line.Category), plainCell(d.formatAmount(line.A)), plainCell(d.formatAmount(line.B)),
+			d.changeCell(line), plainCell(line.percentText()))
+	}
+	table.print()
+	if highlight = min(highlight, len(result.Categories)); highlight > 0 && result.Categories[0].Change != 0 {
+		fmt.Println("\nBiggest changes:")
+		for _, line := range result.Categories[:highlight] {
+			if line.Change == 0 {
+				break
+			}
+			cell := d.changeCell(line)
+			fmt.Printf("  %s (%s): %s", line.Category, strings.ToLower(line.Type), cell.text)
+			if percent := line.percentText(); percent != "" {
+				fmt.Printf(" (%s)", percent)
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// a transaction that looks out of line with the history of its category or payee
+type anomaly struct {
+	Transaction Transaction `json:"transaction"`
+	Reason      string      `json:"reason"`
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// flag expenses more than sigma standard deviations above their category's monthly mean, measured over
+// the other months, and first payments to a new payee that are among the largest tenth of all expenses
+func (d *Data) detectAnomalies(filter Filter, sigma float64) []anomaly {
+	monthly := make(map[string]map[string]float64) // category, month, total
+	amounts := make([]float64, 0, len(d.Transactions))
+	firstPayment := make(map[string]Transaction)
+	firstMonth := ""
+	for transaction := range d.Query(Filter{Type: Expense}) {
+		if transaction.foreign() {
+			continue
+		}
+		month := transaction.Date.Format("2006-01")
+		if firstMonth == "" || month < firstMonth {
+			firstMonth = month
+		}
+		for _, split := range transaction.categoryAmounts() {
+			if monthly[split.Category] == nil {
+				monthly[split.Category] = make(map[string]float64)
+			}
+			monthly[split.Category][month] += split.Amount
+		}
+		amounts = append(amounts, transaction.Amount)
+		if first, seen := firstPayment[transaction.Payee]; transaction.Payee != "" && (!seen || transaction.Date.Before(first.Date)) {
+			firstPayment[transaction.Payee] = transaction
+		}
+	}
+	slices.Sort(amounts)
+	large := math.Inf(1)
+	if len(amounts) >= 10 { // too little history to say what a large expense is
+		large = amounts[len(amounts)*9/10]
+	}
+
+	filter.Type = Expense
+	anomalies := make([]anomaly, 0)
+	for transaction := range d.Query(filter) {
+		month := transaction.Date.Format("2006-01")
+		reasons := make([]string, 0)
+		for _, split := range transaction.categoryAmounts() {
+			others := make([]float64, 0)
+			for otherMonth, total := range monthly[split.Category] {
+				if otherMonth != month {
+					others = append(others, total)
+				}
+			}
+			if len(others) < 3 {
+				continue
+			}
+			mean, deviation := meanStdDev(others)
+			if split.Amount > mean && split.Amount > mean+sigma*deviation {
+				reasons = append(reasons, fmt.Sprintf("%s is %s against a monthly mean of %s", split.Category, d.formatAmount(split.Amount), d.formatAmount(mean)))
+			}
+		}
+		// every payee is new in the first month of the book
+		if first, ok := firstPayment[transaction.Payee]; ok && first.ID == transaction.ID && month != firstMonth && transaction.Amount >= large {
+			reasons = append(reasons, fmt.Sprintf("first payment to %s", transaction.Payee))
+		}
+		if len(reasons) > 0 {
+			anomalies = append(anomalies, anomaly{Transaction: transaction, Reason: strings.Join(reasons, "; ")})
+		}
+	}
+	slices.SortStableFunc(anomalies, func(a, b anomaly) int { return a.Transaction.Date.Compare(b.Transaction.Date) })
+	return anomalies
+}
+
+func (d *Data) displayAnomalies(period string, periodValue string, sigma float64) error {
+	anomalies := d.detectAnomalies(d.periodFilter(period, periodValue), sigma)
+	if structuredOutput() {
+		rows := make([][]any, 0, len(anomalies))
+		for _, found := range anomalies {
+			transaction := found.Transaction
+			rows = append(rows, []any{transaction.ID, transaction.Date.Format("2006-01-02"), transaction.Category, transaction.Payee, transaction.Amount, found.Reason})
+		}
+		return emit(anomalies, []string{"id", "date", "category", "payee", "amount", "reason"}, rows)
+	}
+	if len(anomalies) == 0 {
+		fmt.Println("No unusual transactions found.")
+		return nil
+	}
+	fmt.Println("Unusual transactions:")
+	table := newTable("ID", "Date", "Category", "Payee", "Amount", "Reason").alignRight(0, 4)
+	for _, found := range anomalies {
+		transaction := found.Transaction
+		table.addRow(plainCell(strconv.Itoa(transaction.ID)), plainCell(transaction.Date.Format("2006-01-02")), plainCell(transaction.Category),
+			plainCell(transaction.Payee), d.amountCell(transaction.Amount, Expense), plainCell(found.Reason))
+	}
+	table.print()
+	return nil
+}
+
+// a spending limit checked whenever transactions are added or imported
+type AlertRule struct {
+	Category string  `json:"category,omitempty"` // empty for all expenses
+	Limit    float64 `json:"limit"`
+	Single   bool    `json:"single,omitempty"` // applies to each expense rather than the month's total
+	Notify   bool    `json:"notify,omitempty"` // also deliver through the configured webhook or email
+}
+
+func (d *Data) alertDescription(rule AlertRule) string {
+	subject := "any expense"
+	if rule.Category != "" {
+		subject = rule.Category
+	}
+	description := fmt.Sprintf("%s over %s in a month", subject, d.formatAmount(rule.Limit))
+	if rule.Single {
+		description = fmt.Sprintf("%s over %s in a single expense", subject, d.formatAmount(rule.Limit))
+	}
+	if rule.Notify {
+		description += ", notify"
+	}
+	return description
+}
+
+func (d *Data) addAlert(rule AlertRule) error {
+	if rule.Limit <= 0 {
+		return fmt.Errorf("alert limit must be greater than zero")
+	}
+	d.Alerts = append(d.Alerts, rule)
+	return nil
+}
+
+// remove an alert by its 1-based position in the list
+func (d *Data) removeAlert(number int) error {
+	if number < 1 || number > len(d.Alerts) {
+		return fmt.Errorf("no alert %d, see alert list", number)
+	}
+	d.Alerts = slices.Delete(d.Alerts, number-1, number)
+	return nil
+}
+
+func (d *Data) displayAlerts() {
+	if len(d.Alerts) == 0 {
+		fmt.Println("No alerts configured.")
+		return
+	}
+	fmt.Println("Alerts:")
+	for i, rule := range d.Alerts {
+		fmt.Printf("  %d. %s\n", i+1, d.alertDescription(rule))
+	}
+}
+
+// messages for the rules broken by the given new transactions, monthly rules fire once per month touched
+func (d *Data) evaluateAlerts(added []Transaction) (messages []string, notify []string) {
+	for _, rule := range d.Alerts {
+		months := make(map[string]bool)
+		for _, transaction := range added {
+			if transaction.Type != Expense {
+				continue
+			}
+			for _, split := range transaction.categoryAmounts() {
+				if rule.Category != "" && split.Category != rule.Category {
+					continue
+				}
+				var message string
+				if rule.Single && split.Amount > rule.Limit {
+					message = fmt.Sprintf("expense #%d of %s to %s is over the %s limit", transaction.ID, d.formatAmount(split.Amount), split.Category, d.formatAmount(rule.Limit))
+				}
+				if month := transaction.Date.Format("2006-01"); !rule.Single && !months[month] {
+					months[month] = true
+					filter := d.periodFilter(Month, month)
+					filter.Type = Expense
+					total := 0.0
+					for other := range d.Query(filter) {
+						if other.foreign() {
+							continue
+						}
+						for _, split := range other.categoryAmounts() {
+							if rule.Category == "" || split.Category == rule.Category {
+								total += split.Amount
+							}
+						}
+					}
+					if total > rule.Limit {
+						message = fmt.Sprintf("%s spending in %s is %s, over the %s limit", cmp.Or(rule.Category, "total"), month, d.formatAmount(total), d.formatAmount(rule.Limit))
+					}
+				}
+				if message == "" {
+					continue
+				}
+				messages = append(messages, message)
+				if rule.Notify {
+					notify = append(notify, message)
+				}
+			}
+		}
+	}
+	return messages, notify
+}
+
+// print the alerts raised by transactions added since the book held count transactions, and deliver
+// the ones marked for notification
+func (d *Data) raiseAlerts(count int) {
+	messages, notify := d.evaluateAlerts(d.Transactions[count:])
+	for _, message := range messages {
+		fmt.Println(colorize(colorRed, "Alert: "+message))
+	}
+	for _, message := range d.envelopeWarnings(d.Transactions[count:]) {
+		fmt.Println(colorize(colorRed, "Warning: "+message))
+	}
+	if len(notify) > 0 {
+		if err := d.Settings.Notify.send("Spending alert", strings.Join(notify, "\n")); err != nil {
+			slog.Warn("could not send alert notification", "error", err)
+		}
+	}
+}
+
+// deliver a message to the webhook and by email, whichever are configured
+func (n Notifications) send(subject, body string) error {
+	return n.deliver(subject, "text/plain", body)
+}
+
+// webhooks receive the body as "text" or, for text/html, as "html"
+func (n Notifications) deliver(subject, contentType, body string) error {
+	if n.Webhook == "" && n.SMTPHost == "" {
+		return fmt.Errorf("no webhook or mail server configured, see config notify-webhook and notify-smtp")
+	}
+	var errs []error
+	if n.Webhook != "" {
+		field := "text"
+		if contentType == "text/html" {
+			field = "html"
+		}
+		payload, _ := json.Marshal(map[string]string{"subject": subject, field: body})
+		client := &http.Client{Timeout: 10 * time.Second}
+		response, err := client.Post(n.Webhook, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode >= 300 {
+				err = fmt.Errorf("webhook answered %s", response.Status)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if n.SMTPHost != "" {
+		if err := n.sendMail(subject, contentType, body); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n Notifications) sendMail(subject, contentType, body string) error {
+	if n.From == "" || n.To == "" {
+		return fmt.Errorf("notify-from and notify-to must be set")
+	}
+	host, _, _ := net.SplitHostPort(n.SMTPHost)
+	var auth smtp.Auth
+	if n.SMTPUser != "" {
+		auth = smtp.PlainAuth("", n.SMTPUser, os.Getenv("FINANCE_SMTP_PASSWORD"), host)
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: %s; charset=utf-8\r\n\r\n%s",
+		n.From, n.To, subject, contentType, strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(n.SMTPHost, auth, n.From, strings.Split(n.To, ","), []byte(message))
+}
+
+const (
+	eventCreated        = "transaction.created"
+	eventUpdated        = "transaction.updated"
+	eventDeleted        = "transaction.deleted"
+	eventBudgetExceeded = "budget.exceeded"
+)
+
+var webhookEvents = []string{eventCreated, eventUpdated, eventDeleted, eventBudgetExceeded}
+
+// an outbound webhook, receivers check the X-Finance-Signature header against the shared secret
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"` // HMAC-SHA256 key of the signature
+	Events []string `json:"events,omitempty"` // every event when empty
+}
+
+// JSON payload of a webhook delivery
+type webhookEvent struct {
+	Event       string       `json:"event"`
+	At          time.Time    `json:"at"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Previous    *Transaction `json:"previous,omitempty"` // the version before an update
+	Category    string       `json:"category,omitempty"` // budget events only
+	Month       string       `json:"month,omitempty"`
+	Budget      float64      `json:"budget,omitempty"`
+	Spent       float64      `json:"spent,omitempty"`
+}
+
+func (w Webhook) wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+func (d *Data) addWebhook(hook Webhook) error {
+	if target, err := url.ParseRequestURI(hook.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return fmt.Errorf("webhook must be an http or https URL")
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q, use %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	d.Webhooks = append(d.Webhooks, hook)
+	return nil
+}
+
+// remove a webhook by its 1-based position in the list
+func (d *Data) removeWebhook(number int) error {
+	if number < 1 || number > len(d.Webhooks) {
+		return fmt.Errorf("no webhook %d, see webhook list", number)
+	}
+	d.Webhooks = slices.Delete(d.Webhooks, number-1, number)
+	return nil
+}
+
+func (d *Data) displayWebhooks() {
+	if len(d.Webhooks) == 0 {
+		fmt.Println("No webhooks configured.")
+		return
+	}
+	fmt.Println("Webhooks:")
+	for i, hook := range d.Webhooks {
+		events := "all events"
+		if len(hook.Events) > 0 {
+			events = strings.Join(hook.Events, ", ")
+		}
+		fmt.Printf("  %d. %s (%s)\n", i+1, hook.URL, events)
+	}
+}
+
+// expenses booked to category in month, counting split lines on their own
+func monthSpent(transactions []Transaction, category, month string) float64 {
+	total := 0.0
+	for _, transaction := range transactions {
+		if transaction.Type != Expense || transaction.Date.Format("2006-01") != month || transaction.foreign() {
+			continue
+		}
+		for _, split := range transaction.categoryAmounts() {
+			if split.Category == category {
+				total += split.Amount
+			}
+		}
+	}
+	return total
+}
+
+// events for the changes from previous to the current transactions; a budget is exceeded once,
+// by the change that takes the month's spending past it
+func (d *Data) changeEvents(previous []Transaction) []webhookEvent {
+	now := d.now().UTC()
+	diff := diffBooks(&Data{Transactions: previous}, d)
+	var events, budgetEvents []webhookEvent
+	exceeded := make(map[string]bool)
+	checkBudget := func(transaction Transaction) {
+		if transaction.Type != Expense {
+			return
+		}
+		month := transaction.Date.Format("2006-01")
+		for _, split := range transaction.categoryAmounts() {
+			budget, key := d.Budgets[split.Category], split.Category+"\x00"+month
+			if budget <= 0 || exceeded[key] {
+				continue
+			}
+			spent := monthSpent(d.Transactions, split.Category, month)
+			if spent > budget && monthSpent(previous, split.Category, month) <= budget {
+				exceeded[key] = true
+				budgetEvents = append(budgetEvents, webhookEvent{Event: eventBudgetExceeded, At: now, Transaction: &transaction,
+					Category: split.Category, Month: month, Budget: budget, Spent: spent})
+			}
+		}
+	}
+	for _, transaction := range diff.Added {
+		events = append(events, webhookEvent{Event: eventCreated, At: now, Transaction: &transaction})
+		checkBudget(transaction)
+	}
+	for _, change := range diff.Modified {
+		events = append(events, webhookEvent{Event: eventUpdated, At: now, Transaction: &change.Remote, Previous: &change.Local})
+		checkBudget(change.Remote)
+	}
+	for _, transaction := range diff.Removed {
+		events = append(events, webhookEvent{Event: eventDeleted, At: now, Transaction: &transaction})
+	}
+	return append(events, budgetEvents...)
+}
+
+// fire the webhooks for what changed since the book was loaded or last saved
+func (d *Data) publishChanges() {
+	previous := d.saved
+	d.saved = slices.Clone(d.Transactions)
+	if len(d.Webhooks) == 0 {
+		return
+	}
+	for _, event := range d.changeEvents(previous) {
+		for _, hook := range d.Webhooks {
+			if hook.wants(event.Event) {
+				webhookOutbox.enqueue(hook, event)
+			}
+		}
+	}
+}
+
+// events wait here for background senders, so saving never waits for a slow or unreachable receiver;
+// every receiver has its own queue and sender, so one that is down holds up only its own events
+type outbox struct {
+	mu        sync.Mutex
+	receivers map[string]*receiver // by URL
+	pending   sync.WaitGroup
+}
+
+// how a receiver that fails is retried: backoff doubles from the first delay up to the longest one, an
+// event is given up after outboxAttempts; a receiver keeps at most outboxLimit events waiting
+const (
+	outboxFirstDelay = time.Second
+	outboxMaxDelay   = 5 * time.Minute
+	outboxAttempts   = 10
+	outboxLimit      = 1000
+)
+
+type receiver struct {
+	queue []webhookDelivery
+	wake  chan struct{}
+}
+
+type webhookDelivery struct {
+	hook     Webhook
+	event    webhookEvent
+	id       string // X-Finance-Delivery, the same on every attempt so receivers can drop duplicates
+	attempts int
+}
+
+var webhookOutbox = &outbox{}
+
+func (o *outbox) enqueue(hook Webhook, event webhookEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.receivers == nil {
+		o.receivers = make(map[string]*receiver)
+	}
+	r := o.receivers[hook.URL]
+	if r == nil {
+		r = &receiver{wake: make(chan struct{}, 1)}
+		o.receivers[hook.URL] = r
+		go o.send(r)
+	}
+	if len(r.queue) == outboxLimit {
+		dropped := r.queue[0]
+		r.queue = r.queue[1:]
+		o.pending.Done()
+		slog.Warn("webhook event given up, too many are waiting for the receiver", "url", hook.URL, "event", dropped.event.Event, "waiting", outboxLimit)
+	}
+	r.queue = append(r.queue, webhookDelivery{hook: hook, event: event, id: crand.Text()})
+	o.pending.Add(1)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver one receiver's events in order, the first is retried with backoff until it goes out or is given up
+func (o *outbox) send(r *receiver) {
+	for range r.wake {
+		for {
+			o.mu.Lock()
+			if len(r.queue) == 0 {
+				o.mu.Unlock()
+				break
+			}
+			delivery := r.queue[0]
+			o.mu.Unlock()
+
+			retry, err := delivery.hook.post(delivery.event, delivery.id)
+			delivery.attempts++
+			if err != nil && retry && delivery.attempts < outboxAttempts {
+				o.mu.Lock()
+				if len(r.queue) > 0 && r.queue[0].id == delivery.id { // not pushed out by the limit meanwhile
+					r.queue[0] = delivery
+				}
+				o.mu.Unlock()
+				time.Sleep(min(outboxFirstDelay<<(delivery.attempts-1), outboxMaxDelay))
+				continue
+			}
+			if err != nil {
+				slog.Warn("webhook event given up", "url", delivery.hook.URL, "event", delivery.event.Event, "attempts", delivery.attempts, "error", err)
+			}
+			o.mu.Lock()
+			if len(r.queue) > 0 && r.queue[0].id == delivery.id {
+				r.queue = r.queue[1:]
+				o.pending.Done()
+			}
+			o.mu.Unlock()
+		}
+	}
+}
+
+// give queued events up to timeout to go out before the process exits
+func (o *outbox) flush(timeout time.Duration) {
+	sent := make(chan struct{})
+	go func() {
+		o.pending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(timeout):
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for url, r := range o.receivers {
+			if len(r.queue) > 0 {
+				slog.Warn("webhook events were not delivered before exiting", "url", url, "queued", len(r.queue))
+			}
+		}
+	}
+}
+
+// POST the event once; retry tells whether a later attempt may succeed
+func (w Webhook) post(event webhookEvent, delivery string) (retry bool, err error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode event: %w", err)
+	}
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Finance-Event", event.Event)
+	request.Header.Set("X-Finance-Delivery", delivery)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(payload)
+		request.Header.Set("X-Finance-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	response, err := (&http.Client{Timeout: 10 * time.Second}).Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 300 {
+		return false, nil
+	}
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, fmt.Errorf("webhook answered %s", response.Status)
+}
+
+// POST the event, retrying with backoff while the receiver is unreachable or failing
+func (w Webhook) deliver(event webhookEvent) error {
+	delivery := crand.Text()
+	const attempts = 4
+	for attempt := range attempts {
+		if attempt > 0 {
+			time.Sleep(time.Second << (attempt - 1))
+		}
+		retry, err := w.post(event, delivery)
+		if err == nil || !retry {
+			return err
+		}
+		if attempt == attempts-1 {
+			return fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+		}
+	}
+	return nil
+}
+
+// figures of a monthly statement, already formatted for display
+type statement struct {
+	Month      string
+	Income     string
+	Expenses   string
+	Net        string
+	Sources    [][2]string // income source and amount
+	Categories [][2]string // expense category and amount
+	Budget     [][5]string // category, budgeted, actual, variance and share used
+}
+
+var statementTemplate = template.Must(template.New("statement").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>Statement for {{.Month}}</h2>
+<table>
+<tr><td>Income</td><td align="right" style="color: green">{{.Income}}</td></tr>
+<tr><td>Expenses</td><td align="right" style="color: red">{{.Expenses}}</td></tr>
+<tr><td>Net balance</td><td align="right"><b>{{.Net}}</b></td></tr>
+</table>
+{{if .Sources}}<h3>Income by source</h3>
+<table>
+{{range .Sources}}<tr><td>{{index . 0}}</td><td align="right">{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}<h3>Expenses by category</h3>
+<table>
+{{range .Categories}}<tr><td>{{index . 0}}</td><td align="right">{{index . 1}}</td></tr>
+{{end}}</table>
+{{if .Budget}}<h3>Budget vs. actual</h3>
+<table>
+<tr><th align="left">Category</th><th>Budgeted</th><th>Actual</th><th>Variance</th><th>Used</th></tr>
+{{range .Budget}}<tr><td>{{index . 0}}</td><td align="right">{{index . 1}}</td><td align="right">{{index . 2}}</td><td align="right">{{index . 3}}</td><td align="right">{{index . 4}}</td></tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+func (d *Data) calculateStatement(month string) statement {
+	income, expenses, sources, categories := d.calculateSummary(Month, month)
+	result := statement{Month: month, Income: d.formatAmount(income), Expenses: d.formatAmount(expenses), Net: d.formatAmount(income - expenses)}
+	for _, source := range slices.Sorted(maps.Keys(sources)) {
+		result.Sources = append(result.Sources, [2]string{source, d.formatAmount(sources[source])})
+	}
+	for _, category := range slices.Sorted(maps.Keys(categories)) {
+		result.Categories = append(result.Categories, [2]string{category, d.formatAmount(categories[category])})
+	}
+	if lines, total, err := d.calculateBudgetReport(Month, month); err == nil && len(d.Budgets) > 0 {
+		for _, line := range append(lines, total) {
+			used, variance := "-", d.formatAmount(line.Variance)
+			if line.Budgeted > 0 {
+				used = fmt.Sprintf("%.0f%%", line.Percent)
+			}
+			if line.Unbudgeted {
+				used, variance = "unbudgeted", "-"
+			}
+			result.Budget = append(result.Budget, [5]string{line.Category, d.formatAmount(line.Budgeted), d.formatAmount(line.Actual), variance, used})
+		}
+	}
+	return result
+}
+
+// render the statement for a month as plain text or HTML
+func (d *Data) renderStatement(month string, html bool) (string, error) {
+	figures := d.calculateStatement(month)
+	var out strings.Builder
+	if html {
+		if err := statementTemplate.Execute(&out, figures); err != nil {
+			return "", fmt.Errorf("failed to render statement: %w", err)
+		}
+		return out.String(), nil
+	}
+	fmt.Fprintf(&out, "Statement for %s\n\n", figures.Month)
+	totals := newTable("", "").alignRight(1)
+	totals.addRow(plainCell("Income:"), plainCell(figures.Income))
+	totals.addRow(plainCell("Expenses:"), plainCell(figures.Expenses))
+	totals.addRow(plainCell("Net Balance:"), plainCell(figures.Net))
+	totals.write(&out, false)
+	if len(figures.Sources) > 0 {
+		fmt.Fprintln(&out, "\nIncome by source:")
+		sources := newTable("Source", "Amount").alignRight(1)
+		for _, line := range figures.Sources {
+			sources.addRow(plainCell(line[0]), plainCell(line[1]))
+		}
+		sources.write(&out, false)
+	}
+	fmt.Fprintln(&out, "\nExpenses by category:")
+	categories := newTable("Category", "Amount").alignRight(1)
+	for _, line := range figures.Categories {
+		categories.addRow(plainCell(line[0]), plainCell(line[1]))
+	}
+	categories.write(&out, false)
+	if len(figures.Budget) > 0 {
+		fmt.Fprintln(&out, "\nBudget vs. actual:")
+		budget := newTable("Category", "Budgeted", "Actual", "Variance", "Used").alignRight(1, 2, 3, 4)
+		for _, line := range figures.Budget {
+			budget.addRow(plainCell(line[0]), plainCell(line[1]), plainCell(line[2]), plainCell(line[3]), plainCell(line[4]))
+		}
+		budget.write(&out, false)
+	}
+	return out.String(), nil
+}
+
+// render a month's statement and deliver it through the configured webhook or email
+func (d *Data) sendStatement(month string, html bool) error {
+	body, err := d.renderStatement(month, html)
+	if err != nil {
+		return err
+	}
+	contentType := "text/plain"
+	if html {
+		contentType = "text/html"
+	}
+	return d.Settings.Notify.deliver("Finance statement for "+month, contentType, body)
+}
+
+// crontab line that sends last month's statement on the first of every month at 08:00
+func statementSchedule(dataFile string, html bool) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the program: %w", err)
+	}
+	dataFile, err = filepath.Abs(dataFile)
+	if err != nil {
+		return "", err
+	}
+	format := "text"
+	if html {
+		format = "html"
+	}
+	return fmt.Sprintf("0 8 1 * * %s -data %s report send --format %s", strconv.Quote(executable), strconv.Quote(dataFile), format), nil
+}
+
+// minimal PDF writer for A4 pages using the standard Helvetica and Courier fonts, enough for statements
+type pdfDocument struct {
+	pages []*strings.Builder // content stream of each page
+	y     float64            // baseline of the next line on the current page
+}
+
+const (
+	pdfWidth  = 595.0
+	pdfHeight = 842.0
+	pdfMargin = 50.0
+
+	pdfRegular = "F1"
+	pdfBold    = "F2"
+	pdfMono    = "F3" // Courier, every character is 0.6 of the font size wide
+)
+
+// start a new page when less than height is left on the current one
+func (p *pdfDocument) reserve(height float64) {
+	if len(p.pages) == 0 || p.y-height < pdfMargin {
+		p.pages = append(p.pages, &strings.Builder{})
+		p.y = pdfHeight - pdfMargin
+	}
+}
+
+// write a line of text at the left margin plus indent and move down by the line height
+func (p *pdfDocument) line(font string, size float64, indent float64, text string) {
+	p.reserve(size * 1.5)
+	p.y -= size * 1.5
+	p.text(pdfMargin+indent, p.y, font, size, text)
+}
+
+func (p *pdfDocument) text(x, y float64, font string, size float64, text string) {
+	fmt.Fprintf(p.pages[len(p.pages)-1], "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(text))
+}
+
+// fill a rectangle, colors are RGB components between 0 and 1
+func (p *pdfDocument) rect(x, y, width, height float64, r, g, b float64) {
+	fmt.Fprintf(p.pages[len(p.pages)-1], "%.2f %.2f %.2f rg %.2f %.2f %.2f %.2f re f 0 g\n", r, g, b, x, y, width, height)
+}
+
+// text as a PDF string in WinAnsiEncoding, characters the standard fonts cannot show become ?
+func pdfString(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '€':
+			out.WriteString("\\200")
+		case r == '\u202f' || r == '\u00a0':
+			out.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f:
+			out.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&out, "\\%03o", r)
+		default:
+			out.WriteByte('?')
+		}
+	}
+	return out.String()
+}
+
+func (p *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	offsets := make([]int, 0)
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n")
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	for _, font := range []string{"Helvetica", "Helvetica-Bold", "Courier"} {
+		object("<< /Type /Font /Subtype /Type1 /BaseFont /" + font + " /Encoding /WinAnsiEncoding >>")
+	}
+	for i, page := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>", pdfWidth, pdfHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// print a text table in Courier so its columns stay aligned
+func (p *pdfDocument) table(table *textTable) {
+	var out strings.Builder
+	table.write(&out, false)
+	for _, row := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		p.line(pdfMono, 9, 0, row)
+	}
+}
+
+// printable statement for a month: totals, categories, budget variance and a bar chart of spending
+func (d *Data) writeStatementPDF(month, filename string) error {
+	figures := d.calculateStatement(month)
+	var pdf pdfDocument
+	pdf.line(pdfBold, 18, 0, "Statement for "+month)
+	if len(d.books) > 0 {
+		pdf.line(pdfRegular, 9, 0, "Consolidated: "+strings.Join(d.books, ", "))
+	}
+	pdf.line(pdfRegular, 9, 0, "Generated "+d.today().Format("2006-01-02"))
+	pdf.y -= 8
+
+	totals := newTable("", "").alignRight(1)
+	totals.addRow(plainCell("Income:"), plainCell(figures.Income))
+	totals.addRow(plainCell("Expenses:"), plainCell(figures.Expenses))
+	totals.addRow(plainCell("Net Balance:"), plainCell(figures.Net))
+	pdf.table(totals)
+
+	if len(figures.Sources) > 0 {
+		pdf.y -= 8
+		pdf.line(pdfBold, 12, 0, "Income by source")
+		sources := newTable("Source", "Amount").alignRight(1)
+		for _, line := range figures.Sources {
+			sources.addRow(plainCell(line[0]), plainCell(line[1]))
+		}
+		pdf.table(sources)
+	}
+
+	pdf.y -= 8
+	pdf.line(pdfBold, 12, 0, "Expenses by category")
+	categories := newTable("Category", "Amount").alignRight(1)
+	for _, line := range figures.Categories {
+		categories.addRow(plainCell(line[0]), plainCell(line[1]))
+	}
+	pdf.table(categories)
+
+	if len(figures.Budget) > 0 {
+		pdf.y -= 8
+		pdf.line(pdfBold, 12, 0, "Budget vs. actual")
+		budget := newTable("Category", "Budgeted", "Actual", "Variance", "Used").alignRight(1, 2, 3, 4)
+		for _, line := range figures.Budget {
+			budget.addRow(plainCell(line[0]), plainCell(line[1]), plainCell(line[2]), plainCell(line[3]), plainCell(line[4]))
+		}
+		pdf.table(budget)
+	}
+
+	if spending, total := d.calculateTopCategories(Month, month, 0); total > 0 {
+		pdf.y -= 8
+		pdf.line(pdfBold, 12, 0, "Spending")
+		largest := spending[0].Amount
+		for _, entry := range spending {
+			pdf.reserve(16)
+			pdf.y -= 16
+			label := []rune(entry.Name)
+			if len(label) > 20 {
+				label = append(label[:19], '.')
+			}
+			pdf.text(pdfMargin, pdf.y, pdfRegular, 9, string(label))
+			width := (pdfWidth - 2*pdfMargin - 200) * entry.Amount / largest
+			pdf.rect(pdfMargin+110, pdf.y-2, width, 11, 0.85, 0.33, 0.31)
+			pdf.text(pdfMargin+115+width, pdf.y, pdfRegular, 9, d.formatAmount(entry.Amount))
+		}
+	}
+
+	if err := os.WriteFile(filename, pdf.bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return nil
+}
+
+// figures of the HTML dashboard, chart geometry is computed here so the page needs no scripts or network
+type dashboard struct {
+	Title     string
+	Generated string
+	Income    string
+	Expenses  string
+	Net       string
+	Slices    []pieSlice
+	Trend     []trendPoint
+	Budgets   []budgetBar
+}
+
+type pieSlice struct {
+	Path  string
+	Color string
+	Label string
+	Value string
+}
+
+type trendPoint struct {
+	X        float64
+	Income   float64 // y coordinates
+	Expenses float64
+	Month    string
+	Label    string
+}
+
+type budgetBar struct {
+	Category string
+	Label    string
+	Width    float64 // share of the budget used, capped at 100
+	Over     bool
+}
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222 }
+.cards { display: flex; gap: 1em } .card { border: 1px solid #ddd; border-radius: 6px; padding: 1em 1.5em }
+.card b { display: block; font-size: 1.4em } section { margin-top: 2em }
+svg .slice:hover, svg circle:hover { opacity: .7 } .legend span { display: inline-block; width: .8em; height: .8em; margin-right: .3em }
+.bar { background: #eee; width: 400px; height: 14px; display: inline-block; vertical-align: middle }
+.bar div { background: #59a14f; height: 100% } .bar div.over { background: #e15759 }
+td { padding: .2em .8em .2em 0 }
+</style></head><body>
+<h1>{{.Title}}</h1><p>Generated {{.Generated}}</p>
+<div class="cards"><div class="card">Income<b>{{.Income}}</b></div><div class="card">Expenses<b>{{.Expenses}}</b></div><div class="card">Net<b>{{.Net}}</b></div></div>
+{{if .Slices}}<section><h2>Spending by category</h2>
+<svg width="300" height="300" viewBox="0 0 300 300">{{range .Slices}}<path class="slice" d="{{.Path}}" fill="{{.Color}}"><title>{{.Label}}: {{.Value}}</title></path>{{end}}</svg>
+<div class="legend">{{range .Slices}}<div><span style="background: {{.Color}}"></span>{{.Label}} {{.Value}}</div>{{end}}</div></section>{{end}}
+{{if .Trend}}<section><h2>Monthly trend</h2>
+<svg width="640" height="240" viewBox="0 0 640 240">
+<line x1="20" y1="220" x2="620" y2="220" stroke="#999"/>
+<polyline fill="none" stroke="#59a14f" stroke-width="2" points="{{range .Trend}}{{.X}},{{.Income}} {{end}}"/>
+<polyline fill="none" stroke="#e15759" stroke-width="2" points="{{range .Trend}}{{.X}},{{.Expenses}} {{end}}"/>
+{{range .Trend}}<circle cx="{{.X}}" cy="{{.Income}}" r="4" fill="#59a14f"><title>{{.Month}} {{.Label}}</title></circle><circle cx="{{.X}}" cy="{{.Expenses}}" r="4" fill="#e15759"><title>{{.Month}} {{.Label}}</title></circle>
+<text x="{{.X}}" y="236" font-size="10" text-anchor="middle">{{.Month}}</text>{{end}}
+</svg><div class="legend"><span style="background: #59a14f"></span>Income <span style="background: #e15759"></span>Expenses</div></section>{{end}}
+{{if .Budgets}}<section><h2>Budgets</h2><table>
+{{range .Budgets}}<tr><td>{{.Category}}</td><td><div class="bar"><div{{if .Over}} class="over"{{end}} style="width: {{.Width}}%"></div></div></td><td>{{.Label}}</td></tr>
+{{end}}</table></section>{{end}}
+</body></html>
+`))
+
+// outline of a pie slice between two angles in radians, measured clockwise from twelve o'clock
+func pieSlicePath(start, end float64) string {
+	const cx, cy, r = 150.0, 150.0, 140.0
+	if end-start >= 2*math.Pi-1e-9 { // a full circle cannot be drawn as a single arc
+		return fmt.Sprintf("M %.2f %.2f A %.0f %.0f 0 1 1 %.2f %.2f A %.0f %.0f 0 1 1 %.2f %.2f Z", cx, cy-r, r, r, cx, cy+r, r, r, cx, cy-r)
+	}
+	large := 0
+	if end-start > math.Pi {
+		large = 1
+	}
+	return fmt.Sprintf("M %.0f %.0f L %.2f %.2f A %.0f %.0f 0 %d 1 %.2f %.2f Z", cx, cy,
+		cx+r*math.Sin(start), cy-r*math.Cos(start), r, r, large, cx+r*math.Sin(end), cy-r*math.Cos(end))
+}
+
+func (d *Data) calculateDashboard(period string, periodValue string) dashboard {
+	filter := d.periodFilter(period, periodValue)
+	income, expenses, _, _ := d.summarize(filter)
+	title := "Finances"
+	if periodValue != "" {
+		title += " " + periodValue
+	}
+	result := dashboard{Title: title, Generated: d.today().Format("2006-01-02"),
+		Income: d.formatAmount(income), Expenses: d.formatAmount(expenses), Net: d.formatAmount(income - expenses)}
+
+	spending, total := d.calculateTopCategories(period, periodValue, 0)
+	angle := 0.0
+	for i, entry := range spending {
+		if total <= 0 {
+			break
+		}
+		end := angle + 2*math.Pi*entry.Amount/total
+		result.Slices = append(result.Slices, pieSlice{Path: pieSlicePath(angle, end), Color: chartColors[i%len(chartColors)],
+			Label: entry.Name, Value: fmt.Sprintf("%s (%.1f%%)", d.formatAmount(entry.Amount), entry.Share)})
+		angle = end
+	}
+
+	months := make(map[string][2]float64)
+	for transaction := range d.Query(filter) {
+		if transaction.foreign() {
+			continue
+		}
+		totals := months[transaction.Date.Format("2006-01")]
+		if transaction.Type == Income {
+			totals[0] += transaction.Amount
+		} else {
+			totals[1] += transaction.Amount
+		}
+		months[transaction.Date.Format("2006-01")] = totals
+	}
+	keys := slices.Sorted(maps.Keys(months))
+	if len(keys) > 24 {
+		keys = keys[len(keys)-24:] // the chart stays readable with two years
+	}
+	largest := 0.0
+	for _, month := range keys {
+		largest = max(largest, months[month][0], months[month][1])
+	}
+	for i, month := range keys {
+		x := 320.0
+		if len(keys) > 1 {
+			x = 40 + 560*float64(i)/float64(len(keys)-1)
+		}
+		y := func(amount float64) float64 { return 220 - 200*amount/max(largest, 1) }
+		result.Trend = append(result.Trend, trendPoint{X: math.Round(x), Income: math.Round(y(months[month][0])), Expenses: math.Round(y(months[month][1])), Month: month,
+			Label: fmt.Sprintf("income %s, expenses %s", d.formatAmount(months[month][0]), d.formatAmount(months[month][1]))})
+	}
+
+	if lines, _, err := d.calculateBudgetReport(period, periodValue); err == nil {
+		for _, line := range lines {
+			if line.Budgeted <= 0 {
+				continue
+			}
+			result.Budgets = append(result.Budgets, budgetBar{Category: line.Category, Width: math.Round(min(line.Percent, 100)), Over: line.over(),
+				Label: fmt.Sprintf("%s of %s", d.formatAmount(line.Actual), d.formatAmount(line.Budgeted))})
+		}
+	}
+	return result
+}
+
+// write a standalone HTML dashboard that opens in any browser without a server
+func (d *Data) writeDashboard(period string, periodValue string, filename string) error {
+	var out bytes.Buffer
+	if err := dashboardTemplate.Execute(&out, d.calculateDashboard(period, periodValue)); err != nil {
+		return fmt.Errorf("failed to render dashboard: %w", err)
+	}
+	if err := os.WriteFile(filename, out.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write dashboard: %w", err)
+	}
+	return nil
+}
+
+//go:embed webui
+var webUI embed.FS
+
+// the REST API and web UI of serve mode; every request reads the data file again so changes made from
+// the command line show up, and writes are serialized so two household members cannot lose each other's
+type server struct {
+	mu     sync.Mutex
+	shared ledger
+	token  string    // shared API token, empty when unset
+	users  []apiUser // per-user access, empty when unset
+	dir    string    // user ledgers are relative to the users file
+
+	verifiedMu sync.Mutex
+	verified   map[[32]byte]*apiUser  // digests of credentials that passed the slow password check
+	rejected   map[[32]byte]time.Time // digests of credentials that failed it, refused without checking until then
+	failures   map[string][]time.Time // failed attempts per client address within authWindow, see throttled
+
+	requestsMu sync.Mutex
+	requests   map[requestCount]int64 // for /metrics
+}
+
+// a book as one client sees it: where it is read from and written to, and whether the client may write
+type ledger struct {
+	load     func() (*Data, error)
+	view     func() (*Data, error) // shared between requests that only read, load is used when nil
+	save     func(*Data) error
+	readOnly bool
+}
+
+// the book for a request that does not change it
+func (l ledger) read() (*Data, error) {
+	if l.view != nil {
+		return l.view()
+	}
+	return l.load()
+}
+
+// a book shared by read-only requests until its file changes
+type bookView struct {
+	stamp string // size and modification time of the file it was read from
+	data  *Data
+}
+
+var bookViews = struct {
+	sync.Mutex
+	views map[string]bookView
+}{views: make(map[string]bookView)}
+
+// the book in dataFile for requests that only read it, loaded again only once the file changed; its
+// date index and monthly totals are built before it is handed out, so concurrent readers never write to it
+func viewData(dataFile string) (*Data, error) {
+	stamp := ""
+	if info, err := os.Stat(dataFile); err == nil && !isRemote(dataFile) {
+		stamp = fmt.Sprint(info.Size(), info.ModTime().UnixNano())
+	}
+	bookViews.Lock()
+	defer bookViews.Unlock()
+	if view, ok := bookViews.views[dataFile]; ok && stamp != "" && view.stamp == stamp {
+		return view.data, nil
+	}
+	data, err := loadData(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	data.dateIndex()
+	data.monthTotals()
+	bookViews.views[dataFile] = bookView{stamp, data}
+	return data, nil
+}
+
+type ledgerKey struct{}
+
+// the ledger the authenticated client works on, the shared one when authentication is off
+func (s *server) ledger(r *http.Request) ledger {
+	if l, ok := r.Context().Value(ledgerKey{}).(ledger); ok {
+		return l
+	}
+	return s.shared
+}
+
+// a client of serve mode; with a data file of their own the user has an isolated ledger
+type apiUser struct {
+	Name string `json:"name"`
+	Salt string `json:"salt"`
+	Hash string `json:"hash"`           // PBKDF2-SHA256 of the user's token
+	Role string `json:"role"`           // editor or viewer
+	Data string `json:"data,omitempty"` // data file of an isolated ledger, the served one when empty
+}
+
+const (
+	roleEditor = "editor"
+	roleViewer = "viewer"
+)
+
+func hashSecret(secret, salt string) string {
+	key, _ := pbkdf2.Key(sha256.New, secret, []byte(salt), 100_000, 32)
+	return hex.EncodeToString(key)
+}
+
+func loadUsers(filename string) ([]apiUser, error) {
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+	var users []apiUser
+	if err := json.Unmarshal(content, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users file: %w", err)
+	}
+	return users, nil
+}
+
+func saveUsers(filename string, users []apiUser) error {
+	content, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode users: %w", err)
+	}
+	if err := os.WriteFile(filename, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+	return nil
+}
+
+// add a user with a freshly generated token, the token is only ever shown by the caller
+func addUser(users []apiUser, name, role, dataFile string) ([]apiUser, string, error) {
+	if name == "" || strings.ContainsAny(name, ":") {
+		return nil, "", fmt.Errorf("user name must not be empty or contain a colon")
+	}
+	if role != roleEditor && role != roleViewer {
+		return nil, "", fmt.Errorf("role must be %s or %s", roleEditor, roleViewer)
+	}
+	if slices.ContainsFunc(users, func(user apiUser) bool { return user.Name == name }) {
+		return nil, "", fmt.Errorf("user %s already exists", name)
+	}
+	token, salt := crand.Text(), crand.Text()
+	return append(users, apiUser{Name: name, Salt: salt, Hash: hashSecret(token, salt), Role: role, Data: dataFile}), token, nil
+}
+
+// how long credentials that failed are refused without checking, and how many failures a client
+// address may have within authWindow before it has to wait
+const (
+	authRejectedFor = 10 * time.Minute
+	authWindow      = time.Minute
+	authAttempts    = 10
+)
+
+// the user the credentials belong to; a name narrows the search to that user, bearer tokens come without one
+func (s *server) verify(name, secret string) *apiUser {
+	digest := sha256.Sum256([]byte(name + ":" + secret))
+	s.verifiedMu.Lock()
+	user, ok := s.verified[digest]
+	until, rejected := s.rejected[digest]
+	s.verifiedMu.Unlock()
+	if ok {
+		return user
+	}
+	if rejected && time.Now().Before(until) {
+		return nil
+	}
+	// the slow check runs without the lock, one client's bad guesses never hold up the others;
+	// the users do not change while serving
+	for i := range s.users {
+		if name != "" && s.users[i].Name != name {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hashSecret(secret, s.users[i].Salt)), []byte(s.users[i].Hash)) == 1 {
+			user = &s.users[i]
+			break
+		}
+	}
+	s.verifiedMu.Lock()
+	defer s.verifiedMu.Unlock()
+	if user != nil {
+		s.verified[digest] = user
+		delete(s.rejected, digest)
+		return user
+	}
+	if len(s.rejected) >= 10_000 { // guessing at random must not grow it without bound
+		clear(s.rejected)
+	}
+	s.rejected[digest] = time.Now().Add(authRejectedFor)
+	return nil
+}
+
+// whether the client address failed authentication too often lately, counting a failure when failed is set
+func (s *server) throttled(r *http.Request, failed bool) bool {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	now := time.Now()
+	s.verifiedMu.Lock()
+	defer s.verifiedMu.Unlock()
+	recent := slices.DeleteFunc(s.failures[address], func(at time.Time) bool { return now.Sub(at) > authWindow })
+	if failed {
+		recent = append(recent, now)
+	}
+	if len(recent) == 0 {
+		delete(s.failures, address)
+	} else {
+		s.failures[address] = recent
+	}
+	return len(recent) >= authAttempts
+}
+
+// the ledger the request's credentials open, false when they open none
+func (s *server) authorize(r *http.Request) (ledger, bool) {
+	name, secret, basic := r.BasicAuth()
+	if !basic {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || bearer == "" {
+			return ledger{}, false
+		}
+		name, secret = "", bearer
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.token)) == 1 {
+		return s.shared, true
+	}
+	user := s.verify(name, secret)
+	if user == nil {
+		return ledger{}, false
+	}
+	if user.Data == "" {
+		shared := s.shared
+		shared.readOnly = shared.readOnly || user.Role == roleViewer
+		return shared, true
+	}
+	dataFile := user.Data
+	if !filepath.IsAbs(dataFile) {
+		dataFile = filepath.Join(s.dir, dataFile)
+	}
+	return ledger{
+		load:     func() (*Data, error) { return loadData(dataFile) },
+		view:     func() (*Data, error) { return viewData(dataFile) },
+		save:     func(d *Data) error { return d.save(dataFile) },
+		readOnly: user.Role == roleViewer,
+	}, true
+}
+
+// require a token or user credentials once either is configured
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" && len(s.users) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.throttled(r, false) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(authWindow.Seconds())))
+			writeError(w, http.StatusTooManyRequests, fmt.Errorf("too many failed attempts, try again later"))
+			return
+		}
+		l, ok := s.authorize(r)
+		if !ok {
+			if r.Header.Get("Authorization") != "" {
+				s.throttled(r, true)
+			}
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Grpc-Status", strconv.Itoa(grpcUnauthenticated))
+				w.Header().Set("Grpc-Message", "authentication required")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="finance", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("authentication required"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ledgerKey{}, l)))
+	})
+}
+
+func displayUsers(users []apiUser) {
+	if len(users) == 0 {
+		fmt.Println("No users, serve is protected by --token only or open.")
+		return
+	}
+	fmt.Println("Users:")
+	for _, user := range users {
+		ledger := "shared ledger"
+		if user.Data != "" {
+			ledger = "own ledger " + user.Data
+		}
+		fmt.Printf("  %-16s %-7s %s\n", user.Name, user.Role, ledger)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		slog.Error("request failed", "status", status, "error", err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// filter from query parameters: from and to as YYYY-MM-DD with to exclusive, or a period as on the command line
+func (d *Data) filterFromQuery(query url.Values) (Filter, error) {
+	filter := Filter{Type: query.Get("type"), Category: query.Get("category"), Account: query.Get("account"), Payee: query.Get("payee"),
+		Text: query.Get("search"), Meta: query.Get("meta"), Tag: strings.TrimPrefix(query.Get("tag"), "#"), Status: strings.ToLower(query.Get("status"))}
+	if filter.Status != "" && !slices.Contains(statusFilters, filter.Status) {
+		return Filter{}, fmt.Errorf("unknown status %q, use %s", filter.Status, strings.Join(statusFilters, ", "))
+	}
+	if period := query.Get("period"); period != "" {
+		period, periodValue, err := d.parsePeriodFlag(period, query.Get("fiscal") == "true", d.today())
+		if err != nil {
+			return Filter{}, err
+		}
+		filter.From, filter.To = d.periodRange(period, periodValue)
+	}
+	for name, date := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := d.parseDateInput(value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s: %w", name, err)
+			}
+			*date = parsed
+		}
+	}
+	return filter, nil
+}
+
+func (s *server) handleListTransactions(w http.ResponseWriter, r *http.Request) {
+	data, err := s.ledger(r).read()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	filter, err := data.filterFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	transactions := slices.Collect(data.Query(filter))
+	slices.SortStableFunc(transactions, func(a, b Transaction) int { return b.Date.Compare(a.Date) })
+	writeJSON(w, http.StatusOK, transactions)
+}
+
+// store a transaction sent by a client, the returned status tells client errors from server ones;
+// one whose external ID is in the book already is refused
+func (s *server) add(l ledger, transaction Transaction) (Transaction, int, error) {
+	if l.readOnly {
+		return Transaction{}, http.StatusForbidden, fmt.Errorf("read-only access")
+	}
+	if transaction.Date.IsZero() {
+		return Transaction{}, http.StatusBadRequest, fmt.Errorf("date is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := l.load()
+	if err != nil {
+		return Transaction{}, http.StatusInternalServerError, err
+	}
+	// checked under the lock, so the same text posted twice at once is still added only once
+	if i := slices.IndexFunc(data.Transactions, func(t Transaction) bool { return t.ExternalID == transaction.ExternalID }); transaction.ExternalID != "" && i >= 0 {
+		return Transaction{}, http.StatusConflict, fmt.Errorf("already added as transaction %d", data.Transactions[i].ID)
+	}
+	transaction.ID = 0
+	transaction.Date = civilDate(transaction.Date)
+	if transaction.Currency == cmp.Or(data.Currency, "USD") {
+		transaction.Currency = ""
+	}
+	if err := data.appendTransaction(transaction); err != nil {
+		return Transaction{}, http.StatusBadRequest, err
+	}
+	if err := l.save(data); err != nil {
+		return Transaction{}, http.StatusInternalServerError, err
+	}
+	return data.Transactions[len(data.Transactions)-1], http.StatusCreated, nil
+}
+
+// the fields a client may set on a new transaction; the ID, trash, import batch, attachments, review status
+// and sync fields belong to the book, a request naming them is refused
+type transactionRequest struct {
+	Date         time.Time         `json:"date"`
+	Type         string            `json:"type"`
+	Category     string            `json:"category"`
+	Amount       float64           `json:"amount"`
+	Currency     string            `json:"currency,omitempty"`
+	Description  string            `json:"description"`
+	Account      string            `json:"account,omitempty"`
+	Payee        string            `json:"payee,omitempty"`
+	Splits       []Split           `json:"splits,omitempty"`
+	Notes        string            `json:"notes,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Loan         string            `json:"loan,omitempty"`
+	Deductible   *bool             `json:"deductible,omitempty"`
+	Shares       []Share           `json:"shares,omitempty"`
+	PaidBy       string            `json:"paid_by,omitempty"`
+	Reimbursable string            `json:"reimbursable,omitempty"`
+}
+
+func (r transactionRequest) transaction() Transaction {
+	return Transaction{Date: r.Date, Type: r.Type, Category: r.Category, Amount: r.Amount, Currency: r.Currency,
+		Description: r.Description, Account: r.Account, Payee: r.Payee, Splits: r.Splits, Notes: r.Notes, Metadata: r.Metadata,
+		Tags: r.Tags, Loan: r.Loan, Deductible: r.Deductible, Shares: r.Shares, PaidBy: r.PaidBy, Reimbursable: r.Reimbursable}
+}
+
+func (s *server) handleAddTransaction(w http.ResponseWriter, r *http.Request) {
+	// a form on another site can post url-encoded or plain text, but not JSON without the browser asking first
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("send the transaction as application/json"))
+		return
+	}
+	var request transactionRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction: %w", err))
+		return
+	}
+	added, status, err := s.add(s.ledger(r), request.transaction())
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, status, added)
+}
+
+// a forwarded bank alert, as {"text": "..."} or as the plain request body
+func (s *server) handleAddText(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	text := string(body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var request struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		text = request.Text
+	}
+	l := s.ledger(r)
+	data, err := l.read()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	transaction, err := data.parseNotification(text)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	added, status, err := s.add(l, transaction)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, status, added)
+}
+
+// a Slack slash command like /finance spent 14.20 on lunch, signed with the app's signing secret from
+// FINANCE_SLACK_SIGNING_SECRET instead of carrying the API token
+func (s *server) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("FINANCE_SLACK_SIGNING_SECRET")
+	if secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if err != nil || math.Abs(time.Since(time.Unix(sent, 0)).Minutes()) > 5 || !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid Slack signature"))
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply := ""
+	data, err := s.shared.load()
+	if err == nil {
+		var changed bool
+		if reply, changed = data.botReply(form.Get("text")); changed {
+			if s.shared.readOnly {
+				reply = "Sorry, the book is served read-only."
+			} else if err := s.shared.save(data); err != nil {
+				reply = "Sorry, the book could not be saved: " + err.Error()
+			}
+		}
+	} else {
+		reply = "Sorry, " + err.Error()
+	}
+	if strings.Contains(reply, "\n") {
+		reply = "```" + reply + "```" // keeps the statement's columns
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"response_type": "ephemeral", "text": reply})
+}
+
+func (s *server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	data, err := s.ledger(r).read()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	filter, err := data.filterFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	income, expenses, sources, categories := data.summarize(filter)
+	type month struct {
+		Month    string  `json:"month"`
+		Income   float64 `json:"income"`
+		Expenses float64 `json:"expenses"`
+	}
+	var months []month
+	if keys, totals, ok := data.wholeMonths(filter); ok {
+		months = make([]month, len(keys))
+		for i, key := range keys {
+			months[i] = month{key, fromThousandths(totals[key].income), fromThousandths(totals[key].expenses)}
+		}
+	} else {
+		byMonth := make(map[string]*month)
+		for transaction := range data.Query(filter) {
+			if transaction.foreign() {
+				continue
+			}
+			key := transaction.Date.Format("2006-01")
+			if byMonth[key] == nil {
+				byMonth[key] = &month{Month: key}
+			}
+			if transaction.Type == Income {
+				byMonth[key].Income += transaction.Amount
+			} else {
+				byMonth[key].Expenses += transaction.Amount
+			}
+		}
+		months = make([]month, 0, len(byMonth))
+		for _, key := range slices.Sorted(maps.Keys(byMonth)) {
+			months = append(months, *byMonth[key])
+		}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Income        float64            `json:"income"`
+		Expenses      float64            `json:"expenses"`
+		Net           float64            `json:"net_balance"`
+		IncomeSources map[string]float64 `json:"income_sources"`
+		Categories    map[string]float64 `json:"categories"`
+		Months        []month            `json:"months"`
+		Currency      string             `json:"currency,omitempty"`
+	}{income, expenses, income - expenses, sources, categories, months, data.Currency})
+}
+
+// names for the entry form's suggestions
+func (s *server) handleNames(w http.ResponseWriter, r *http.Request) {
+	data, err := s.ledger(r).read()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"categories": data.categories(), "payees": data.payees(), "accounts": data.accountNames()})
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, s.counted(pattern, handler))
+	}
+	handle(mux, "GET /api/transactions", s.handleListTransactions)
+	handle(mux, "POST /api/transactions", s.handleAddTransaction)
+	handle(mux, "POST /api/transactions/text", s.handleAddText)
+	handle(mux, "GET /api/summary", s.handleSummary)
+	handle(mux, "GET /api/names", s.handleNames)
+	handle(mux, "POST /finance.v1.Finance/{method}", s.handleGRPC)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	ui, _ := fs.Sub(webUI, "webui")
+	mux.Handle("GET /", http.FileServerFS(ui))
+	outer := http.NewServeMux()
+	handle(outer, "POST /slack/command", s.handleSlackCommand) // Slack signs its requests instead
+	// browsers send basic auth credentials along with requests other sites make, those that change the book are refused
+	crossOrigin := http.NewCrossOriginProtection()
+	crossOrigin.SetDenyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin requests may not change the book"))
+	}))
+	outer.Handle("/", crossOrigin.Handler(s.authenticate(mux)))
+	return logged(outer)
+}
+
+// one info line per request; the query is left out as it may carry search terms
+func logged(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		slog.Info("request", "method", r.Method, "path", r.URL.Path, "status", cmp.Or(recorder.status, http.StatusOK),
+			"duration", time.Since(start).Round(time.Microsecond), "remote", r.RemoteAddr)
+	})
+}
+
+// the status a handler answered with, for the request counters
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+type requestCount struct {
+	route  string
+	status int
+}
+
+// count the requests a route answers, by status
+func (s *server) counted(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		s.requestsMu.Lock()
+		defer s.requestsMu.Unlock()
+		if s.requests == nil {
+			s.requests = make(map[requestCount]int64)
+		}
+		s.requests[requestCount{route, cmp.Or(recorder.status, http.StatusOK)}]++
+	})
+}
+
+// this month's totals, spending per category, budget use and the API request counters in the
+// Prometheus text format, for graphing a book served from a home server
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	data, err := s.ledger(r).read()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	month := data.today().Format("2006-01")
+	income, expenses, _, categories := data.summarize(data.periodFilter(Month, month))
+	cents := func(amount float64) float64 { return math.Round(amount*100) / 100 }
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	sample := func(name string, value float64, labels ...string) {
+		b.WriteString(name)
+		for i := 0; i+1 < len(labels); i += 2 {
+			separator := ","
+			if i == 0 {
+				separator = "{"
+			}
+			fmt.Fprintf(&b, `%s%s="%s"`, separator, labels[i], metricLabel.Replace(labels[i+1]))
+		}
+		if len(labels) > 0 {
+			b.WriteString("}")
+		}
+		fmt.Fprintf(&b, " %s\n", strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	metric("finance_month_income", "gauge", "Income booked in the current month.")
+	sample("finance_month_income", cents(income))
+	metric("finance_month_expenses", "gauge", "Expenses booked in the current month.")
+	sample("finance_month_expenses", cents(expenses))
+	metric("finance_month_net", "gauge", "Income minus expenses in the current month.")
+	sample("finance_month_net", cents(income-expenses))
+	metric("finance_month_category_expenses", "gauge", "Expenses per category in the current month.")
+	for _, category := range slices.Sorted(maps.Keys(categories)) {
+		sample("finance_month_category_expenses", cents(categories[category]), "category", category)
+	}
+	if lines, _, err := data.calculateBudgetReport(Month, month); err == nil && len(data.Budgets) > 0 {
+		metric("finance_budget_available", "gauge", "Monthly budget per category including rollover.")
+		for _, line := range lines {
+			if _, ok := data.Budgets[line.Category]; ok {
+				sample("finance_budget_available", cents(line.Available), "category", line.Category)
+			}
+		}
+		metric("finance_budget_utilization_ratio", "gauge", "Share of the available budget spent, above 1 when over budget.")
+		for _, line := range lines {
+			if _, ok := data.Budgets[line.Category]; ok {
+				sample("finance_budget_utilization_ratio", math.Round(line.Percent*100)/1e4, "category", line.Category)
+			}
+		}
+	}
+	metric("finance_api_requests_total", "counter", "API requests answered, by route and status code.")
+	s.requestsMu.Lock()
+	counts := maps.Clone(s.requests)
+	s.requestsMu.Unlock()
+	for _, count := range slices.SortedFunc(maps.Keys(counts), func(a, b requestCount) int {
+		return cmp.Or(strings.Compare(a.route, b.route), a.status-b.status)
+	}) {
+		sample("finance_api_requests_total", float64(counts[count]), "route", count.route, "code", strconv.Itoa(count.status))
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// label values escape only backslashes, quotes and newlines
+var metricLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serve the REST API and the web UI on addr until the process is stopped
+func serve(addr string, s *server) error {
+	s.verified, s.rejected, s.failures = make(map[[32]byte]*apiUser), make(map[[32]byte]time.Time), make(map[string][]time.Time)
+	if s.token == "" && len(s.users) == 0 {
+		if host, _, _ := net.SplitHostPort(addr); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			slog.Warn("no --token or users configured, anyone who can reach this address can read and change the book", "addr", addr)
+		}
+	}
+	fmt.Printf("Serving on http://%s, press Ctrl+C to stop.\n", addr)
+	logActivity()
+	httpServer := &http.Server{Addr: addr, Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+	httpServer.Protocols = new(http.Protocols)
+	httpServer.Protocols.SetHTTP1(true)
+	httpServer.Protocols.SetUnencryptedHTTP2(true) // gRPC clients connect with plaintext HTTP/2
+	return httpServer.ListenAndServe()
+}
+
+// protobuf wire format, just enough of it for the messages in finance.proto
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+type protoField struct {
+	number int
+	wire   int
+	value  uint64 // varint and fixed values
+	bytes  []byte // length-delimited values
+}
+
+func protoFields(message []byte) ([]protoField, error) {
+	fields := make([]protoField, 0)
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed field tag")
+		}
+		message = message[n:]
+		field := protoField{number: int(tag >> 3), wire: int(tag & 7)}
+		switch field.wire {
+		case protoVarint:
+			if field.value, n = binary.Uvarint(message); n <= 0 {
+				return nil, fmt.Errorf("malformed varint in field %d", field.number)
+			}
+		case protoFixed64, protoFixed32:
+			n = 8
+			if field.wire == protoFixed32 {
+				n = 4
+			}
+			if len(message) < n {
+				return nil, fmt.Errorf("truncated field %d", field.number)
+			}
+			if n == 8 {
+				field.value = binary.LittleEndian.Uint64(message)
+			} else {
+				field.value = uint64(binary.LittleEndian.Uint32(message))
+			}
+		case protoBytes:
+			length, m := binary.Uvarint(message)
+			if m <= 0 || uint64(len(message)-m) < length {
+				return nil, fmt.Errorf("truncated field %d", field.number)
+			}
+			field.bytes = message[m : m+int(length)]
+			n = m + int(length)
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", field.wire, field.number)
+		}
+		message = message[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func protoAppendTag(b []byte, number, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(number<<3|wire))
+}
+
+// proto3 leaves fields with zero values out
+func protoAppendString(b []byte, number int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return protoAppendBytes(b, number, []byte(value))
+}
+
+func protoAppendBytes(b []byte, number int, value []byte) []byte {
+	b = protoAppendTag(b, number, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func protoAppendDouble(b []byte, number int, value float64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protoAppendTag(b, number, protoFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+}
+
+func protoAppendPackedDoubles(b []byte, number int, values []float64) []byte {
+	if len(values) == 0 {
+		return b
+	}
+	packed := make([]byte, 0, 8*len(values))
+	for _, value := range values {
+		packed = binary.LittleEndian.AppendUint64(packed, math.Float64bits(value))
+	}
+	return protoAppendBytes(b, number, packed)
+}
+
+func encodeTransactionProto(transaction Transaction, currency string) []byte {
+	b := make([]byte, 0, 64)
+	if transaction.ID != 0 {
+		b = protoAppendTag(b, 1, protoVarint)
+		b = binary.AppendUvarint(b, uint64(transaction.ID))
+	}
+	b = protoAppendString(b, 2, transaction.Date.Format("2006-01-02"))
+	b = protoAppendString(b, 3, transaction.Type)
+	b = protoAppendString(b, 4, transaction.Category)
+	b = protoAppendDouble(b, 5, transaction.Amount)
+	b = protoAppendString(b, 6, transaction.Description)
+	b = protoAppendString(b, 7, transaction.Account)
+	b = protoAppendString(b, 8, transaction.Payee)
+	return protoAppendString(b, 9, currency)
+}
+
+func decodeTransactionProto(message []byte) (Transaction, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return Transaction{}, err
+	}
+	var transaction Transaction
+	for _, field := range fields {
+		text := string(field.bytes)
+		switch field.number {
+		case 2:
+			if transaction.Date, err = parseDate(text); err != nil {
+				return Transaction{}, fmt.Errorf("%w %q, use YYYY-MM-DD", ErrInvalidDate, text)
+			}
+		case 3:
+			transaction.Type = text
+		case 4:
+			transaction.Category = text
+		case 5:
+			transaction.Amount = math.Float64frombits(field.value)
+		case 6:
+			transaction.Description = text
+		case 7:
+			transaction.Account = text
+		case 8:
+			transaction.Payee = text
+		case 9:
+			transaction.Currency = strings.ToUpper(text)
+		}
+	}
+	return transaction, nil
+}
+
+// a Filter message as the query parameters the REST API takes, so both share filterFromQuery
+func decodeFilterProto(message []byte) (url.Values, error) {
+	fields, err := protoFields(message)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{1: "from", 2: "to", 3: "type", 4: "category", 5: "account", 6: "payee", 7: "period"}
+	query := url.Values{}
+	for _, field := range fields {
+		if field.number > 0 && field.number < len(names) && field.wire == protoBytes {
+			query.Set(names[field.number], string(field.bytes))
+		}
+	}
+	return query, nil
+}
+
+// gRPC status codes used by the server
+const (
+	grpcOK               = 0
+	grpcCanceled         = 1
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnauthenticated  = 16
+)
+
+// a gRPC error carries its status code
+type grpcError struct {
+	code int
+	err  error
+}
+
+func (e grpcError) Error() string { return e.err.Error() }
+
+func grpcFail(code int, format string, args ...any) error {
+	return grpcError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// gRPC over HTTP/2: length-prefixed protobuf messages in the body and the status in trailers
+func (s *server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests need HTTP/2 and an application/grpc content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := s.callGRPC(w, r)
+	code := grpcOK
+	if err != nil {
+		code = grpcInternal
+		var grpcErr grpcError
+		if errors.As(err, &grpcErr) {
+			code = grpcErr.code
+		}
+		w.Header().Set("Grpc-Message", url.PathEscape(err.Error()))
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+}
+
+func (s *server) callGRPC(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if len(body) < 5 || binary.BigEndian.Uint32(body[1:5]) != uint32(len(body)-5) {
+		return grpcFail(grpcInvalidArgument, "expected a single length-prefixed message")
+	}
+	if body[0] != 0 {
+		return grpcFail(grpcUnimplemented, "compressed messages are not supported")
+	}
+	request := body[5:]
+	send := func(message []byte) {
+		w.Write(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message))))
+		w.Write(message)
+		http.NewResponseController(w).Flush()
+	}
+
+	switch r.PathValue("method") {
+	case "ListTransactions", "Summary":
+		if r.PathValue("method") == "Summary" { // SummaryRequest wraps the filter in field 1
+			fields, err := protoFields(request)
+			if err != nil {
+				return grpcFail(grpcInvalidArgument, "%v", err)
+			}
+			request = nil
+			for _, field := range fields {
+				if field.number == 1 {
+					request = field.bytes
+				}
+			}
+		}
+		query, err := decodeFilterProto(request)
+		if err != nil {
+			return grpcFail(grpcInvalidArgument, "%v", err)
+		}
+		data, err := s.ledger(r).read()
+		if err != nil {
+			return err
+		}
+		filter, err := data.filterFromQuery(query)
+		if err != nil {
+			return grpcFail(grpcInvalidArgument, "%v", err)
+		}
+		if r.PathValue("method") == "ListTransactions" {
+			for transaction := range data.Newest(filter) {
+				if r.Context().Err() != nil { // the client went away or gave up
+					return grpcFail(grpcCanceled, "%v", r.Context().Err())
+				}
+				send(encodeTransactionProto(transaction, data.currencyOf(transaction)))
+			}
+			return nil
+		}
+		income, expenses, sources, categories := data.summarize(filter)
+		response := protoAppendDouble(nil, 1, income)
+		response = protoAppendDouble(response, 2, expenses)
+		response = protoAppendDouble(response, 3, income-expenses)
+		for i, totals := range []map[string]float64{categories, sources} {
+			for _, category := range slices.Sorted(maps.Keys(totals)) {
+				entry := protoAppendDouble(protoAppendString(nil, 1, category), 2, totals[category])
+				response = protoAppendBytes(response, 4+i, entry)
+			}
+		}
+		send(response)
+
+	case "AddTransaction":
+		transaction, err := decodeTransactionProto(request)
+		if err != nil {
+			return grpcFail(grpcInvalidArgument, "%v", err)
+		}
+		added, status, err := s.add(s.ledger(r), transaction)
+		if status == http.StatusForbidden {
+			return grpcFail(grpcPermissionDenied, "%v", err)
+		}
+		if err != nil && status < http.StatusInternalServerError {
+			return grpcFail(grpcInvalidArgument, "%v", err)
+		}
+		if err != nil {
+			return err
+		}
+		data, err := s.ledger(r).read()
+		if err != nil {
+			return err
+		}
+		send(encodeTransactionProto(added, data.currencyOf(added)))
+
+	case "Forecast":
+		fields, err := protoFields(request)
+		if err != nil {
+			return grpcFail(grpcInvalidArgument, "%v", err)
+		}
+		months := 3
+		for _, field := range fields {
+			if field.number == 1 && field.wire == protoVarint {
+				months = int(int32(field.value))
+			}
+		}
+		if months <= 0 || months > 120 {
+			return grpcFail(grpcInvalidArgument, "months must be between 1 and 120")
+		}
+		data, err := s.ledger(r).read()
+		if err != nil {
+			return err
+		}
+		options := defaultForecast
+		options.Months = months
+		result, err := data.calculateForecast(options)
+		if err != nil {
+			return err
+		}
+		columns := make([][]float64, 6)
+		for _, month := range result.Months {
+			for i, value := range []float64{month.Expenses.Expected, month.Balance.Expected, month.Expenses.Low, month.Expenses.High, month.Balance.Low, month.Balance.High} {
+				columns[i] = append(columns[i], value)
+			}
+		}
+		var response []byte
+		for i, column := range columns {
+			response = protoAppendPackedDoubles(response, i+1, column)
+		}
+		send(response)
+
+	default:
+		return grpcFail(grpcUnimplemented, "unknown method %s", r.PathValue("method"))
+	}
+	return nil
+}
+
+type netWorthPoint struct {
+	Date        time.Time `json:"date"` // last day of the interval
+	Assets      float64   `json:"assets"`
+	Liabilities float64   `json:"liabilities"`
+}
+
+// balance of every account at the end of each month (or year) from the first transaction up to now,
+// transactions without an account count as cash
+func (d *Data) calculateNetWorth(interval string, now time.Time) ([]netWorthPoint, error) {
+	step := 0
+	switch interval {
+	case Month:
+		step = 1
+	case Year:
+		step = 12
+	default:
+		return nil, fmt.Errorf("invalid interval %q, use month or year", interval)
+	}
+
+	start := now
+	for _, account := range d.Accounts {
+		if !account.OpeningDate.IsZero() && account.OpeningDate.Before(start) {
+			start = account.OpeningDate
+		}
+	}
+	for _, transaction := range d.Transactions {
+		if transaction.Date.Before(start) {
+			start = transaction.Date
+		}
+	}
+	for _, holding := range d.Holdings {
+		if holding.Acquired.Before(start) {
+			start = holding.Acquired
+		}
+	}
+	if interval == Year {
+		start = time.Date(start.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	} else {
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	points := make([]netWorthPoint, 0)
+	for end := start.AddDate(0, step, 0); ; end = end.AddDate(0, step, 0) {
+		balances := make(map[string]float64)
+		for _, account := range d.Accounts {
+			if account.OpeningDate.Before(end) {
+				balances[account.Name] = account.OpeningBalance
+			}
+		}
+		for transaction := range d.Query(Filter{To: end}) {
+			account, _ := d.findAccount(transaction.Account)
+			balances[transaction.Account] += account.effect(transaction)
+		}
+
+		point := netWorthPoint{Date: end.AddDate(0, 0, -1)}
+		for _, holding := range d.Holdings {
+			if holding.Acquired.Before(end) {
+				balances[holding.Account] += d.holdingValue(holding, point.Date)
+			}
+		}
+		for name, balance := range balances {
+			if account, _ := d.findAccount(name); account.Kind == Liability {
+				point.Liabilities += balance
+			} else {
+				point.Assets += balance
+			}
+		}
+		points = append(points, point)
+		if end.After(now) {
+			break
+		}
+	}
+	return points, nil
+}
+
+func (d *Data) displayNetWorth(interval string, adjust bool) error {
+	points, err := d.calculateNetWorth(interval, d.today())
+	if err != nil {
+		return err
+	}
+	if adjust {
+		for i, point := range points {
+			factor, err := d.inflationFactor(point.Date)
+			if err != nil {
+				return err
+			}
+			points[i].Assets, points[i].Liabilities = point.Assets*factor, point.Liabilities*factor
+		}
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(points))
+		for i, point := range points {
+			rows[i] = []any{point.Date.Format("2006-01-02"), point.Assets, point.Liabilities, point.Assets - point.Liabilities}
+		}
+		return emit(points, []string{"date", "assets", "liabilities", "net_worth"}, rows)
+	}
+	if adjust {
+		fmt.Println("Net worth in today's money:")
+	} else {
+		fmt.Println("Net worth:")
+	}
+	table := newTable("Date", "Assets", "Liabilities", "Net worth").alignRight(1, 2, 3)
+	for _, point := range points {
+		table.addRow(plainCell(point.Date.Format("2006-01-02")), plainCell(d.formatAmount(point.Assets)),
+			plainCell(d.formatAmount(point.Liabilities)), d.balanceCell(point.Assets-point.Liabilities))
+	}
+	table.print()
+	d.noteLeftOut(Filter{})
+	return nil
+}
+
+// record the consumer price index of the month containing date
+func (d *Data) setCPI(date time.Time, index float64) error {
+	if !(index > 0) || math.IsInf(index, 0) {
+		return fmt.Errorf("invalid CPI %v, index values are positive", index)
+	}
+	d.CPI = withQuote(d.CPI, Quote{Date: time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC), Price: index})
+	return nil
+}
+
+// read a CPI table from a CSV file or URL with rows of YYYY-MM (or YYYY-MM-DD) and index, a header
+// row is skipped; returns the number of months read
+func (d *Data) importCPI(source string) (int, error) {
+	var reader io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		response, err := client.Get(source)
+		if err != nil {
+			return 0, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("CPI source answered %s", response.Status)
+		}
+		reader = response.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i, record := range records {
+		if len(record) < 2 {
+			return count, ParseError{Line: i + 1, Record: strings.Join(record, ","), Cause: fmt.Errorf("expected a month and an index")}
+		}
+		month, err := time.Parse("2006-01", strings.TrimSpace(record[0]))
+		if err != nil {
+			month, err = time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		}
+		if err != nil && i == 0 {
+			continue // header
+		}
+		if err != nil {
+			return count, ParseError{Line: i + 1, Field: "month", Record: strings.Join(record, ","), Cause: err}
+		}
+		index, err := parseFloat(strings.TrimSpace(record[1]))
+		if err == nil {
+			err = d.setCPI(month, index)
+		}
+		if err != nil {
+			return count, ParseError{Line: i + 1, Field: "index", Record: strings.Join(record, ","), Cause: err}
+		}
+		count++
+	}
+	return count, nil
+}
+
+// how much an amount from date has to grow to be worth the same today, from the CPI table
+func (d *Data) inflationFactor(date time.Time) (float64, error) {
+	now, ok := quoteOn(d.CPI, d.today())
+	if !ok {
+		return 0, fmt.Errorf("no CPI known, add it with: cpi set <YYYY-MM> <index> or cpi import <file or URL>")
+	}
+	then, ok := quoteOn(d.CPI, date)
+	if !ok {
+		return 0, fmt.Errorf("no CPI for %s, the table starts in %s", date.Format("2006-01"), d.CPI[0].Date.Format("2006-01"))
+	}
+	return now / then, nil
+}
+
+func (d *Data) displayCPI() error {
+	if structuredOutput() {
+		rows := make([][]any, len(d.CPI))
+		for i, quote := range d.CPI {
+			rows[i] = []any{quote.Date.Format("2006-01"), quote.Price}
+		}
+		return emit(d.CPI, []string{"month", "index"}, rows)
+	}
+	if len(d.CPI) == 0 {
+		fmt.Println("No CPI values, add them with: cpi set <YYYY-MM> <index> or cpi import <file or URL>")
+		return nil
+	}
+	table := newTable("Month", "Index").alignRight(1)
+	for _, quote := range d.CPI {
+		table.addRow(plainCell(quote.Date.Format("2006-01")), plainCell(strconv.FormatFloat(quote.Price, 'f', -1, 64)))
+	}
+	table.print()
+	return nil
+}
+
+// mark an expense category as tax-deductible or take the mark away
+func (d *Data) setDeductible(category string, deductible bool) error {
+	if category == "" {
+		return fmt.Errorf("category must not be empty")
+	}
+	i, found := slices.BinarySearch(d.Deductible, category)
+	switch {
+	case deductible && !found:
+		d.Deductible = slices.Insert(d.Deductible, i, category)
+	case !deductible && found:
+		d.Deductible = slices.Delete(d.Deductible, i, i+1)
+	}
+	return nil
+}
+
+// override the tax treatment of single transactions, nil goes back to their categories'
+func (d *Data) markDeductible(ids []int, deductible *bool) error {
+	for _, id := range ids {
+		transaction, err := d.findTransaction(id)
+		if err != nil {
+			return err
+		}
+		if transaction.Type != Expense {
+			return fmt.Errorf("transaction %d is not an expense", id)
+		}
+		transaction.Deductible = deductible
+	}
+	return nil
+}
+
+// the parts of a transaction that can be deducted, by category
+func (d *Data) deductibleAmounts(transaction Transaction) []Split {
+	if transaction.Type != Expense {
+		return nil
+	}
+	if transaction.Deductible != nil {
+		if *transaction.Deductible {
+			return transaction.categoryAmounts()
+		}
+		return nil
+	}
+	var amounts []Split
+	for _, split := range transaction.categoryAmounts() {
+		if _, found := slices.BinarySearch(d.Deductible, split.Category); found {
+			amounts = append(amounts, split)
+		}
+	}
+	return amounts
+}
+
+type taxLine struct {
+	Category string  `json:"category"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+}
+
+// one deductible expense as handed to the accountant
+type taxItem struct {
+	ID          int       `json:"id"`
+	Date        time.Time `json:"date"`
+	Category    string    `json:"category"`
+	Payee       string    `json:"payee,omitempty"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"`
+}
+
+// deductible expenses of a tax year by category, largest first, with the expenses behind them
+func (d *Data) calculateTaxReport(year Period) ([]taxLine, []taxItem, float64) {
+	totals := make(map[string]*taxLine)
+	items := make([]taxItem, 0)
+	var total float64
+	for transaction := range d.Query(Filter{From: year.From, To: year.To, Type: Expense}) {
+		if transaction.foreign() {
+			continue
+		}
+		for _, split := range d.deductibleAmounts(transaction) {
+			line, ok := totals[split.Category]
+			if !ok {
+				line = &taxLine{Category: split.Category}
+				totals[split.Category] = line
+			}
+			line.Count++
+			line.Total += split.Amount
+			total += split.Amount
+			items = append(items, taxItem{ID: transaction.ID, Date: transaction.Date, Category: split.Category, Payee: transaction.Payee,
+				Description: cmp.Or(split.Description, transaction.Description), Amount: split.Amount})
+		}
+	}
+	lines := make([]taxLine, 0, len(totals))
+	for _, line := range totals {
+		lines = append(lines, *line)
+	}
+	slices.SortFunc(lines, func(a, b taxLine) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), strings.Compare(a.Category, b.Category))
+	})
+	slices.SortFunc(items, func(a, b taxItem) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) })
+	return lines, items, total
+}
+
+// write the expenses behind a tax report as CSV for the accountant
+func writeTaxItems(filename string, items []taxItem) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create tax export: %w", err)
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"date", "id", "category", "payee", "description", "amount"})
+	for _, item := range items {
+		writer.Write([]string{item.Date.Format("2006-01-02"), strconv.Itoa(item.ID), item.Category, item.Payee, item.Description,
+			strconv.FormatFloat(item.Amount, 'f', 2, 64)})
+	}
+	writer.Flush()
+	if err := cmp.Or(writer.Error(), file.Close()); err != nil {
+		return fmt.Errorf("failed to write tax export: %w", err)
+	}
+	return nil
+}
+
+func (d *Data) displayTaxReport(year Period, out string) error {
+	lines, items, total := d.calculateTaxReport(year)
+	if out != "" {
+		if err := writeTaxItems(out, items); err != nil {
+			return err
+		}
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(lines))
+		for i, line := range lines {
+			rows[i] = []any{line.Category, line.Count, line.Total}
+		}
+		return emit(struct {
+			Year       string    `json:"year"`
+			Categories []taxLine `json:"categories"`
+			Total      float64   `json:"total"`
+			Items      []taxItem `json:"items"`
+		}{year.Value, lines, total, items}, []string{"category", "count", "total"}, rows)
+	}
+	label := "Tax year " + year.Value
+	if year.Kind == Fiscal {
+		label = fmt.Sprintf("Fiscal tax year %s (%s to %s)", year.Value, year.From.Format("2006-01-02"), year.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	fmt.Println(label + ":")
+	if len(lines) == 0 {
+		fmt.Println("No deductible expenses, mark categories with: deductible category <name> on")
+	} else {
+		table := newTable("Category", "Expenses", "Deductible").alignRight(1, 2)
+		for _, line := range lines {
+			table.addRow(plainCell(line.Category), plainCell(strconv.Itoa(line.Count)), plainCell(d.formatAmount(line.Total)))
+		}
+		table.addRow(plainCell("Total"), plainCell(strconv.Itoa(len(items))), plainCell(d.formatAmount(total)))
+		table.print()
+	}
+	if out != "" {
+		fmt.Printf("%d deductible expenses written to %s.\n", len(items), out)
+	}
+	return nil
+}
+
+// the book's owner among the people sharing expenses
+const owner = "me"
+
+// a person's part of a shared expense, parts are weighed against each other
+type Share struct {
+	Person string  `json:"person"`
+	Weight float64 `json:"weight"`
+}
+
+// a payment that settles shared expenses between two people
+type Settlement struct {
+	Date   time.Time `json:"date"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Amount float64   `json:"amount"`
+}
+
+// parse shares written as person or person:weight, a person without a weight counts once
+func parseShares(args []string) ([]Share, error) {
+	shares := make([]Share, 0, len(args))
+	for _, arg := range args {
+		person, weightText, hasWeight := strings.Cut(arg, ":")
+		share := Share{Person: strings.TrimSpace(person), Weight: 1}
+		if hasWeight {
+			weight, err := parseFloat(weightText)
+			if err != nil {
+				return nil, err
+			}
+			share.Weight = weight
+		}
+		if share.Person == "" || share.Weight <= 0 {
+			return nil, fmt.Errorf("invalid share %q, use person or person:weight with a positive weight", arg)
+		}
+		if slices.ContainsFunc(shares, func(s Share) bool { return strings.EqualFold(s.Person, share.Person) }) {
+			return nil, fmt.Errorf("%s is named twice", share.Person)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// share an expense between people, no shares make it the owner's alone again
+func (d *Data) shareExpense(id int, paidBy string, shares []Share) error {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return err
+	}
+	if transaction.Type != Expense {
+		return fmt.Errorf("transaction %d is not an expense", id)
+	}
+	if len(shares) == 1 && shares[0].Person == cmp.Or(paidBy, owner) {
+		return fmt.Errorf("an expense shared only with whoever paid it is not shared")
+	}
+	transaction.Shares = shares
+	transaction.PaidBy = ""
+	if len(shares) > 0 && paidBy != owner {
+		transaction.PaidBy = paidBy
+	}
+	return nil
+}
+
+func (d *Data) recordSettlement(settlement Settlement) error {
+	if settlement.From == "" || settlement.To == "" || settlement.From == settlement.To {
+		return fmt.Errorf("a settlement needs two different people")
+	}
+	if !(settlement.Amount > 0) || math.IsInf(settlement.Amount, 0) {
+		return fmt.Errorf("%w %v: settlements must be positive", ErrInvalidAmount, settlement.Amount)
+	}
+	d.Settlements = append(d.Settlements, settlement)
+	return nil
+}
+
+// what each person is owed (positive) or owes (negative) over all shared expenses and settlements
+func (d *Data) sharedBalances() map[string]float64 {
+	balances := make(map[string]float64)
+	for _, transaction := range d.Transactions {
+		if len(transaction.Shares) == 0 || transaction.foreign() {
+			continue
+		}
+		var weights float64
+		for _, share := range transaction.Shares {
+			weights += share.Weight
+		}
+		balances[cmp.Or(transaction.PaidBy, owner)] += transaction.Amount
+		for _, share := range transaction.Shares {
+			balances[share.Person] -= transaction.Amount * share.Weight / weights
+		}
+	}
+	for _, settlement := range d.Settlements {
+		balances[settlement.From] += settlement.Amount
+		balances[settlement.To] -= settlement.Amount
+	}
+	return balances
+}
+
+// a suggested payment that evens out shared balances
+type transfer struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// pay off balances with few transfers by always matching the largest debt with the largest credit
+func settleUp(balances map[string]float64) []transfer {
+	type position struct {
+		person string
+		cents  int64
+	}
+	var debtors, creditors []position
+	for _, person := range slices.Sorted(maps.Keys(balances)) {
+		cents := int64(math.Round(balances[person] * 100))
+		if cents < 0 {
+			debtors = append(debtors, position{person, -cents})
+		} else if cents > 0 {
+			creditors = append(creditors, position{person, cents})
+		}
+	}
+	largestFirst := func(a, b position) int { return cmp.Compare(b.cents, a.cents) }
+	var transfers []transfer
+	for len(debtors) > 0 && len(creditors) > 0 {
+		slices.SortStableFunc(debtors, largestFirst)
+		slices.SortStableFunc(creditors, largestFirst)
+		cents := min(debtors[0].cents, creditors[0].cents)
+		transfers = append(transfers, transfer{From: debtors[0].person, To: creditors[0].person, Amount: float64(cents) / 100})
+		debtors[0].cents -= cents
+		creditors[0].cents -= cents
+		if debtors[0].cents == 0 {
+			debtors = debtors[1:]
+		}
+		if creditors[0].cents == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return transfers
+}
+
+func (d *Data) displaySettlement() error {
+	balances := d.sharedBalances()
+	transfers := settleUp(balances)
+	if structuredOutput() {
+		rows := make([][]any, len(transfers))
+		for i, t := range transfers {
+			rows[i] = []any{t.From, t.To, t.Amount}
+		}
+		return emit(struct {
+			Balances  map[string]float64 `json:"balances"`
+			Transfers []transfer         `json:"transfers"`
+		}{balances, transfers}, []string{"from", "to", "amount"}, rows)
+	}
+	if len(balances) == 0 {
+		fmt.Println("No shared expenses, share one with: share <id> [--paid-by name] <person>[:weight]...")
+		return nil
+	}
+	fmt.Println("Balances:")
+	table := newTable("Person", "Balance").alignRight(1)
+	for _, person := range slices.Sorted(maps.Keys(balances)) {
+		table.addRow(plainCell(person), d.balanceCell(balances[person]))
+	}
+	table.print()
+	if len(transfers) == 0 {
+		fmt.Println("Everyone is settled up.")
+		return nil
+	}
+	fmt.Println("To settle up:")
+	for _, t := range transfers {
+		fmt.Printf("  %s pays %s %s\n", t.From, t.To, d.formatAmount(t.Amount))
+	}
+	return nil
+}
+
+const mileageCategory = "Mileage"
+
+// mark expenses as paid back by payer, an empty payer takes the mark away
+func (d *Data) markReimbursable(ids []int, payer string) error {
+	for _, id := range ids {
+		transaction, err := d.findTransaction(id)
+		if err != nil {
+			return err
+		}
+		if transaction.Type != Expense {
+			return fmt.Errorf("transaction %d is not an expense", id)
+		}
+		if transaction.ReimbursedBy != 0 {
+			return fmt.Errorf("transaction %d was already reimbursed by transaction %d", id, transaction.ReimbursedBy)
+		}
+		transaction.Reimbursable = payer
+	}
+	return nil
+}
+
+// reimbursable expenses not paid back yet by payer, oldest first
+func (d *Data) pendingReimbursements() map[string][]Transaction {
+	pending := make(map[string][]Transaction)
+	for _, transaction := range d.Transactions {
+		if transaction.Reimbursable != "" && transaction.ReimbursedBy == 0 {
+			pending[transaction.Reimbursable] = append(pending[transaction.Reimbursable], transaction)
+		}
+	}
+	for _, transactions := range pending {
+		slices.SortFunc(transactions, func(a, b Transaction) int { return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID)) })
+	}
+	return pending
+}
+
+// book the payer's repayment of a batch of expenses as income and link the expenses to it;
+// without ids every pending expense of the payer is in the batch
+func (d *Data) reimburse(payer string, ids []int, income Transaction) (Transaction, error) {
+	batch := d.pendingReimbursements()[payer]
+	if len(ids) > 0 {
+		batch = slices.DeleteFunc(batch, func(t Transaction) bool { return !slices.Contains(ids, t.ID) })
+		for _, id := range ids {
+			if !slices.ContainsFunc(batch, func(t Transaction) bool { return t.ID == id }) {
+				return Transaction{}, fmt.Errorf("transaction %d is not waiting to be reimbursed by %s", id, payer)
+			}
+		}
+	}
+	if len(batch) == 0 {
+		return Transaction{}, fmt.Errorf("nothing is waiting to be reimbursed by %s", payer)
+	}
+	income.Type = Income
+	income.Payee = payer
+	income.Amount = 0
+	for _, transaction := range batch {
+		income.Amount += transaction.Amount
+	}
+	if income.Description == "" {
+		income.Description = fmt.Sprintf("reimbursement of %d expenses", len(batch))
+	}
+	if err := d.appendTransaction(income); err != nil {
+		return Transaction{}, err
+	}
+	for _, transaction := range batch {
+		stored, _ := d.findTransaction(transaction.ID)
+		stored.ReimbursedBy = d.lastID
+	}
+	return d.Transactions[len(d.Transactions)-1], nil
+}
+
+func (d *Data) displayReimbursements() error {
+	pending := d.pendingReimbursements()
+	payers := slices.Sorted(maps.Keys(pending))
+	if structuredOutput() {
+		var rows [][]any
+		for _, payer := range payers {
+			for _, t := range pending[payer] {
+				rows = append(rows, []any{payer, t.ID, t.Date.Format("2006-01-02"), t.Category, t.Description, t.Amount})
+			}
+		}
+		return emit(pending, []string{"payer", "id", "date", "category", "description", "amount"}, rows)
+	}
+	if len(payers) == 0 {
+		fmt.Println("No expenses are waiting to be reimbursed.")
+		return nil
+	}
+	for _, payer := range payers {
+		fmt.Printf("%s:\n", payer)
+		table := newTable("ID", "Date", "Category", "Description", "Amount", "Age").alignRight(0, 4, 5)
+		var total float64
+		for _, t := range pending[payer] {
+			days := int(d.today().Sub(t.Date).Hours() / 24)
+			table.addRow(plainCell(strconv.Itoa(t.ID)), plainCell(t.Date.Format("2006-01-02")), plainCell(t.Category), plainCell(t.Description),
+				plainCell(d.formatAmount(t.Amount)), plainCell(fmt.Sprintf("%dd", days)))
+			total += t.Amount
+		}
+		table.addRow(plainCell(""), plainCell(""), plainCell("Total"), plainCell(""), plainCell(d.formatAmount(total)), plainCell(""))
+		table.print()
+	}
+	return nil
+}
+
+// a transaction that repeats on a schedule, like rent or a subscription
+type Recurring struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Category    string    `json:"category"`
+	Amount      float64   `json:"amount"`
+	Description string    `json:"description,omitempty"`
+	Account     string    `json:"account,omitempty"`
+	Every       string    `json:"every"` // Week, Month or Year
+	Start       time.Time `json:"start"` // first occurrence, later ones keep its weekday or day of the month
+}
+
+// the n-th occurrence, a day of the month the month does not have falls on its last day
+func (r Recurring) occurrence(n int) time.Time {
+	switch r.Every {
+	case Week:
+		return r.Start.AddDate(0, 0, 7*n)
+	case Year:
+		n *= 12
+	}
+	first := time.Date(r.Start.Year(), r.Start.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(r.Start.Day(), last)-1)
+}
+
+// occurrences from from up to to (exclusive)
+func (r Recurring) occurrences(from, to time.Time) []time.Time {
+	var dates []time.Time
+	for n := 0; ; n++ {
+		date := r.occurrence(n)
+		if !date.Before(to) {
+			return dates
+		}
+		if !date.Before(from) {
+			dates = append(dates, date)
+		}
+	}
+}
+
+func (d *Data) findRecurring(name string) (int, bool) {
+	i := slices.IndexFunc(d.Recurring, func(r Recurring) bool { return strings.EqualFold(r.Name, name) })
+	return i, i >= 0
+}
+
+func (d *Data) addRecurring(r Recurring) error {
+	if r.Name == "" || r.Category == "" {
+		return fmt.Errorf("a recurring transaction needs a name and a category")
+	}
+	if _, ok := d.findRecurring(r.Name); ok {
+		return fmt.Errorf("recurring transaction %q already exists", r.Name)
+	}
+	if r.Type != Income && r.Type != Expense {
+		return fmt.Errorf("%w: %s", ErrInvalidType, r.Type)
+	}
+	if err := validateAmount(Transaction{Type: r.Type, Amount: r.Amount}); err != nil {
+		return err
+	}
+	r.Every = strings.ToLower(r.Every)
+	if r.Every != Week && r.Every != Month && r.Every != Year {
+		return fmt.Errorf("invalid interval %q, use week, month or year", r.Every)
+	}
+	d.Recurring = append(d.Recurring, r)
+	return nil
+}
+
+// a recurring expense falling due
+type bill struct {
+	Name     string    `json:"name"`
+	Category string    `json:"category"`
+	Amount   float64   `json:"amount"`
+	Due      time.Time `json:"due"`
+}
+
+// whether an expense of the bill's category and amount was booked within a few days of its due date
+func (d *Data) billPaid(b bill) bool {
+	for transaction := range d.Query(Filter{From: b.Due.AddDate(0, 0, -3), To: b.Due.AddDate(0, 0, 4), Type: Expense, Category: b.Category}) {
+		if math.Abs(transaction.Amount-b.Amount) < 0.005 {
+			return true
+		}
+	}
+	return false
+}
+
+// recurring expenses due from from up to to (exclusive) that have not been paid yet, soonest first
+func (d *Data) upcomingBills(from, to time.Time) []bill {
+	bills := make([]bill, 0)
+	for _, r := range d.Recurring {
+		if r.Type != Expense {
+			continue
+		}
+		for _, due := range r.occurrences(from, to) {
+			if b := (bill{Name: r.Name, Category: r.Category, Amount: r.Amount, Due: due}); !d.billPaid(b) {
+				bills = append(bills, b)
+			}
+		}
+	}
+	slices.SortStableFunc(bills, func(a, b bill) int { return a.Due.Compare(b.Due) })
+	return bills
+}
+
+// unpaid bills up to this many days past their due date are still listed as due
+const billsLate = 7
+
+// days from today to a due date, negative once it has passed
+func daysUntil(due, today time.Time) int {
+	return int(math.Round(due.Sub(today).Hours() / 24))
+}
+
+func dueIn(days int) string {
+	switch {
+	case days < -1:
+		return fmt.Sprintf("%d days late", -days)
+	case days == -1:
+		return "1 day late"
+	case days == 0:
+		return "today"
+	case days == 1:
+		return "tomorrow"
+	}
+	return fmt.Sprintf("in %d days", days)
+}
+
+// the recurring expenses falling due in the next days, and unpaid ones from the past week
+func (d *Data) displayBillsDue(days int) error {
+	today := d.today()
+	bills := d.upcomingBills(today.AddDate(0, 0, -billsLate), today.AddDate(0, 0, days+1))
+	if structuredOutput() {
+		rows := make([][]any, len(bills))
+		for i, b := range bills {
+			rows[i] = []any{b.Name, b.Category, b.Amount, b.Due.Format("2006-01-02"), daysUntil(b.Due, today)}
+		}
+		return emit(bills, []string{"name", "category", "amount", "due", "days"}, rows)
+	}
+	if len(bills) == 0 {
+		fmt.Printf("No bills due in the next %d days.\n", days)
+		return nil
+	}
+	table := newTable("Due", "", "Name", "Category", "Amount").alignRight(4)
+	total := 0.0
+	for _, b := range bills {
+		when := plainCell(dueIn(daysUntil(b.Due, today)))
+		if b.Due.Before(today) {
+			when.color = colorRed
+		}
+		table.addRow(plainCell(b.Due.Format("2006-01-02")), when, plainCell(b.Name), plainCell(b.Category), plainCell(d.formatAmount(b.Amount)))
+		total += b.Amount
+	}
+	table.addRow(plainCell("Total"), plainCell(""), plainCell(""), plainCell(""), plainCell(d.formatAmount(total)))
+	table.print()
+	return nil
+}
+
+// a line naming the bills due in the next three days or overdue, nothing when there are none
+func (d *Data) displayBillReminder() {
+	today := d.today()
+	var due []string
+	for _, b := range d.upcomingBills(today.AddDate(0, 0, -billsLate), today.AddDate(0, 0, 4)) {
+		due = append(due, fmt.Sprintf("%s %s %s", b.Name, d.formatAmount(b.Amount), dueIn(daysUntil(b.Due, today))))
+	}
+	if len(due) > 0 {
+		fmt.Printf("Bills due: %s, see bills due\n", strings.Join(due, ", "))
+	}
+}
+
+func (d *Data) displayRecurring() error {
+	next := func(r Recurring) time.Time {
+		dates := r.occurrences(d.today(), d.today().AddDate(1, 0, 1))
+		if len(dates) == 0 {
+			return time.Time{}
+		}
+		return dates[0]
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(d.Recurring))
+		for i, r := range d.Recurring {
+			rows[i] = []any{r.Name, r.Type, r.Category, r.Amount, r.Every, next(r).Format("2006-01-02")}
+		}
+		return emit(d.Recurring, []string{"name", "type", "category", "amount", "every", "next"}, rows)
+	}
+	if len(d.Recurring) == 0 {
+		fmt.Println("No recurring transactions, add one with: recurring add --category c --amount n [--every month] <name>")
+		return nil
+	}
+	table := newTable("Name", "Type", "Category", "Amount", "Every", "Next").alignRight(3)
+	for _, r := range d.Recurring {
+		table.addRow(plainCell(r.Name), plainCell(r.Type), plainCell(r.Category), d.amountCell(r.Amount, r.Type), plainCell(r.Every), plainCell(next(r).Format("2006-01-02")))
+	}
+	table.print()
+	return nil
+}
+
+// what is left to spend in the rest of the month once unpaid bills are set aside
+type safeToSpend struct {
+	Month    string  `json:"month"`
+	DaysLeft int     `json:"days_left"` // including today
+	Left     float64 `json:"left"`      // remaining budget, or income minus expenses without budgets
+	Bills    []bill  `json:"bills"`
+	Safe     float64 `json:"safe"` // left minus the bills, never below zero
+	PerDay   float64 `json:"per_day"`
+	PerWeek  float64 `json:"per_week"`
+	Budgeted bool    `json:"budgeted"`
+}
+
+func (d *Data) calculateSafeToSpend() (safeToSpend, error) {
+	today := d.today()
+	month := today.Format("2006-01")
+	end := time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	result := safeToSpend{Month: month, DaysLeft: int(end.Sub(today).Hours() / 24), Budgeted: len(d.Budgets) > 0}
+	if result.Budgeted {
+		lines, _, err := d.calculateBudgetReport(Month, month)
+		if err != nil {
+			return safeToSpend{}, err
+		}
+		for _, line := range lines {
+			if _, ok := d.Budgets[line.Category]; ok {
+				result.Left += line.Variance
+			}
+		}
+	} else {
+		income, expenses, _, _ := d.calculateSummary(Month, month)
+		result.Left = income - expenses
+	}
+	result.Bills = d.upcomingBills(today, end)
+	result.Safe = result.Left
+	for _, b := range result.Bills {
+		result.Safe -= b.Amount
+	}
+	result.Safe = max(result.Safe, 0)
+	result.PerDay = math.Floor(result.Safe/float64(result.DaysLeft)*100) / 100
+	result.PerWeek = math.Floor(result.Safe/float64(result.DaysLeft)*float64(min(7, result.DaysLeft))*100) / 100
+	return result, nil
+}
+
+func (d *Data) displaySafeToSpend() error {
+	result, err := d.calculateSafeToSpend()
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return emit(result, []string{"month", "days_left", "left", "safe", "per_day", "per_week"},
+			[][]any{{result.Month, result.DaysLeft, result.Left, result.Safe, result.PerDay, result.PerWeek}})
+	}
+	source := "Budget left"
+	if !result.Budgeted {
+		source = "Income left (no budgets set)"
+	}
+	fmt.Printf("%s for %s: %s\n", source, result.Month, d.formatAmount(result.Left))
+	for _, b := range result.Bills {
+		fmt.Printf("  %s due %s: %s\n", b.Name, b.Due.Format("2006-01-02"), d.formatAmount(b.Amount))
+	}
+	days := "days"
+	if result.DaysLeft == 1 {
+		days = "day"
+	}
+	fmt.Printf("Safe to spend over the next %d %s: %s\n", result.DaysLeft, days, colorize(colorGreen, d.formatAmount(result.Safe)))
+	fmt.Printf("  %s a day, %s a week\n", d.formatAmount(result.PerDay), d.formatAmount(result.PerWeek))
+	return nil
+}
+
+// financial health figures of one month
+type healthPoint struct {
+	Month           string   `json:"month"`
+	Income          float64  `json:"income"`
+	Expenses        float64  `json:"expenses"`
+	SavingsRate     *float64 `json:"savings_rate"`          // income minus expenses over income, nil without income
+	ExpenseRatio    *float64 `json:"expense_to_income"`     // nil without income
+	Liquid          float64  `json:"liquid_balance"`        // cash and asset accounts at the end of the month
+	EmergencyMonths *float64 `json:"emergency_fund_months"` // liquid balance over the average monthly expenses of the trailing year, months before the first transaction left out
+}
+
+// balance of cash and asset accounts before date, transactions without an account count as cash
+func (d *Data) liquidBalance(before time.Time) float64 {
+	var balance float64
+	for _, account := range d.Accounts {
+		if account.Kind == Asset && account.OpeningDate.Before(before) {
+			balance += account.OpeningBalance
+		}
+	}
+	for transaction := range d.Query(Filter{To: before}) {
+		account, ok := d.findAccount(transaction.Account)
+		if !ok || account.Kind == Asset {
+			balance += account.effect(transaction)
+		}
+	}
+	return balance
+}
+
+// health figures for the past twelve months and the current one, oldest first
+func (d *Data) calculateHealth() []healthPoint {
+	thisMonth := d.today().AddDate(0, 0, 1-d.today().Day())
+	ratio := func(a, b float64) *float64 {
+		if b <= 0 {
+			return nil
+		}
+		value := math.Round(a/b*1000) / 1000
+		return &value
+	}
+	expensesOf := func(month time.Time) float64 {
+		_, expenses, _, _ := d.calculateSummary(Month, month.Format("2006-01"))
+		return expenses
+	}
+	var first time.Time
+	for _, transaction := range d.Transactions {
+		if first.IsZero() || transaction.Date.Before(first) {
+			first = transaction.Date
+		}
+	}
+	points := make([]healthPoint, 0, 13)
+	for i := 12; i >= 0; i-- {
+		month := thisMonth.AddDate(0, -i, 0)
+		income, expenses, _, _ := d.calculateSummary(Month, month.Format("2006-01"))
+		point := healthPoint{Month: month.Format("2006-01"), Income: income, Expenses: expenses,
+			Liquid: math.Round(d.liquidBalance(month.AddDate(0, 1, 0))*100) / 100}
+		point.SavingsRate = ratio(income-expenses, income)
+		point.ExpenseRatio = ratio(expenses, income)
+		var trailing float64
+		counted := 0
+		for j := range 12 {
+			if earlier := month.AddDate(0, -j, 0); earlier.AddDate(0, 1, 0).After(first) && !first.IsZero() {
+				trailing += expensesOf(earlier)
+				counted++
+			}
+		}
+		if counted > 0 {
+			point.EmergencyMonths = ratio(point.Liquid, trailing/float64(counted))
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// a one-line chart of values, gaps for missing ones
+func sparkline(values []*float64) string {
+	const ticks = "▁▂▃▄▅▆▇█"
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		if value != nil {
+			low, high = min(low, *value), max(high, *value)
+		}
+	}
+	var line strings.Builder
+	for _, value := range values {
+		switch {
+		case value == nil:
+			line.WriteRune(' ')
+		case high == low:
+			line.WriteRune('▄')
+		default:
+			line.WriteRune([]rune(ticks)[int((*value-low)/(high-low)*7)])
+		}
+	}
+	return line.String()
+}
+
+func (d *Data) displayHealth() error {
+	points := d.calculateHealth()
+	if structuredOutput() {
+		cell := func(value *float64) any {
+			if value == nil {
+				return ""
+			}
+			return *value
+		}
+		rows := make([][]any, len(points))
+		for i, p := range points {
+			rows[i] = []any{p.Month, p.Income, p.Expenses, cell(p.SavingsRate), cell(p.ExpenseRatio), p.Liquid, cell(p.EmergencyMonths)}
+		}
+		return emit(points, []string{"month", "income", "expenses", "savings_rate", "expense_to_income", "liquid_balance", "emergency_fund_months"}, rows)
+	}
+	percent := func(value *float64) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", *value*100)
+	}
+	months := func(value *float64) string {
+		if value == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", *value)
+	}
+	fmt.Println("Financial health over the past year:")
+	table := newTable("Month", "Income", "Expenses", "Savings rate", "Expenses/income", "Liquid", "Emergency fund (months)").alignRight(1, 2, 3, 4, 5, 6)
+	for _, p := range points {
+		table.addRow(plainCell(p.Month), d.amountCell(p.Income, Income), d.amountCell(p.Expenses, Expense), plainCell(percent(p.SavingsRate)),
+			plainCell(percent(p.ExpenseRatio)), d.balanceCell(p.Liquid), plainCell(months(p.EmergencyMonths)))
+	}
+	table.print()
+	trend := func(pick func(healthPoint) *float64) string {
+		values := make([]*float64, len(points))
+		for i, p := range points {
+			values[i] = pick(p)
+		}
+		return sparkline(values)
+	}
+	fmt.Println("Trends:")
+	fmt.Printf("  Savings rate      %s\n", trend(func(p healthPoint) *float64 { return p.SavingsRate }))
+	fmt.Printf("  Expenses/income   %s\n", trend(func(p healthPoint) *float64 { return p.ExpenseRatio }))
+	fmt.Printf("  Emergency fund    %s\n", trend(func(p healthPoint) *float64 { return p.EmergencyMonths }))
+	return nil
+}
+
+// assumptions of a financial independence projection, rates are yearly fractions
+type fireInputs struct {
+	NetWorth       float64 `json:"net_worth"`
+	MonthlySavings float64 `json:"monthly_savings"`
+	AnnualExpenses float64 `json:"annual_expenses"`
+	Return         float64 `json:"return_rate"`
+	Withdrawal     float64 `json:"withdrawal_rate"`
+}
+
+// the annual expenses a portfolio can pay for at the withdrawal rate
+func (in fireInputs) target() float64 {
+	return in.AnnualExpenses / in.Withdrawal
+}
+
+type fireYear struct {
+	Year          int     `json:"year"`
+	Contributions float64 `json:"contributions"`
+	Growth        float64 `json:"growth"`
+	NetWorth      float64 `json:"net_worth"` // at the end of the year
+	Progress      float64 `json:"progress"`  // share of the target reached
+}
+
+// grow net worth year by year with monthly savings and compounding until it reaches the target or
+// maxYears pass; returns the years and how many it took, -1 when the target is out of reach
+func (in fireInputs) project(maxYears int) ([]fireYear, int) {
+	target := in.target()
+	monthlyReturn := math.Pow(1+in.Return, 1.0/12) - 1
+	balance := in.NetWorth
+	var years []fireYear
+	reached := -1
+	if balance >= target {
+		reached = 0
+	}
+	for year := 1; year <= maxYears && reached < 0; year++ {
+		row := fireYear{Year: year}
+		for range 12 {
+			growth := balance * monthlyReturn
+			balance += growth + in.MonthlySavings
+			row.Growth += growth
+			row.Contributions += in.MonthlySavings
+		}
+		row.NetWorth = balance
+		row.Progress = balance / target
+		years = append(years, row)
+		if balance >= target {
+			reached = year
+		}
+	}
+	return years, reached
+}
+
+// net worth today and average monthly income and expenses over the trailing year, counting
+// only months since the first transaction
+func (d *Data) fireInputsFromHistory() (fireInputs, error) {
+	points, err := d.calculateNetWorth(Month, d.today())
+	if err != nil {
+		return fireInputs{}, err
+	}
+	var in fireInputs
+	if len(points) > 0 {
+		in.NetWorth = points[len(points)-1].Assets - points[len(points)-1].Liabilities
+	}
+	var first time.Time
+	for _, transaction := range d.Transactions {
+		if first.IsZero() || transaction.Date.Before(first) {
+			first = transaction.Date
+		}
+	}
+	thisMonth := d.today().AddDate(0, 0, 1-d.today().Day())
+	months := 0
+	var income, expenses float64
+	for i := 1; i <= 12; i++ {
+		month := thisMonth.AddDate(0, -i, 0)
+		if first.IsZero() || !month.AddDate(0, 1, 0).After(first) {
+			break
+		}
+		monthIncome, monthExpenses, _, _ := d.calculateSummary(Month, month.Format("2006-01"))
+		income += monthIncome
+		expenses += monthExpenses
+		months++
+	}
+	if months > 0 {
+		in.MonthlySavings = (income - expenses) / float64(months)
+		in.AnnualExpenses = expenses / float64(months) * 12
+	}
+	return in, nil
+}
+
+func (d *Data) displayFire(in fireInputs, maxYears int) error {
+	if in.AnnualExpenses <= 0 {
+		return fmt.Errorf("no expenses to plan for, pass --expenses with the yearly spending to cover")
+	}
+	years, reached := in.project(maxYears)
+	sensitivity := make(map[string]int)
+	for _, delta := range []float64{-0.02, -0.01, 0, 0.01, 0.02} {
+		varied := in
+		varied.Return += delta
+		_, sensitivity[fmt.Sprintf("%.1f%%", varied.Return*100)] = varied.project(maxYears)
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(years))
+		for i, y := range years {
+			rows[i] = []any{y.Year, y.Contributions, y.Growth, y.NetWorth, y.Progress}
+		}
+		return emit(struct {
+			fireInputs
+			Target      float64        `json:"target"`
+			Years       []fireYear     `json:"years"`
+			YearsToFire int            `json:"years_to_fire"` // -1 when not reached
+			Sensitivity map[string]int `json:"sensitivity"`   // years to FIRE by return rate
+		}{in, in.target(), years, reached, sensitivity}, []string{"year", "contributions", "growth", "net_worth", "progress"}, rows)
+	}
+	fmt.Printf("Net worth %s, saving %s a month, spending %s a year\n", d.formatAmount(in.NetWorth), d.formatAmount(in.MonthlySavings), d.formatAmount(in.AnnualExpenses))
+	fmt.Printf("Target at a %.1f%% withdrawal rate: %s, assuming a %.1f%% yearly return\n", in.Withdrawal*100, d.formatAmount(in.target()), in.Return*100)
+	if len(years) > 0 {
+		table := newTable("Year", "Contributions", "Growth", "Net worth", "Progress").alignRight(0, 1, 2, 3, 4)
+		for _, y := range years {
+			table.addRow(plainCell(strconv.Itoa(d.today().Year()+y.Year)), plainCell(d.formatAmount(y.Contributions)), plainCell(d.formatAmount(y.Growth)),
+				d.balanceCell(y.NetWorth), plainCell(fmt.Sprintf("%.0f%%", y.Progress*100)))
+		}
+		table.print()
+	}
+	yearsText := func(n int) string {
+		switch n {
+		case -1:
+			return fmt.Sprintf("not within %d years", maxYears)
+		case 0:
+			return "already"
+		case 1:
+			return "1 year"
+		}
+		return fmt.Sprintf("%d years", n)
+	}
+	if reached == 0 {
+		fmt.Println("Financially independent already.")
+	} else {
+		fmt.Println("Financially independent in", yearsText(reached))
+	}
+	fmt.Println("Sensitivity to the return rate:")
+	sensitivityTable := newTable("Return", "Years to FIRE").alignRight(0, 1)
+	for _, delta := range []float64{-0.02, -0.01, 0, 0.01, 0.02} {
+		rate := fmt.Sprintf("%.1f%%", (in.Return+delta)*100)
+		sensitivityTable.addRow(plainCell(rate), plainCell(yearsText(sensitivity[rate])))
+	}
+	sensitivityTable.print()
+	return nil
+}
+
+// last time each category was used by a transaction or brought back from the archive
+func (d *Data) categoryLastUse() map[string]time.Time {
+	last := make(map[string]time.Time)
+	for _, transaction := range d.Transactions {
+		for _, split := range transaction.categoryAmounts() {
+			if transaction.Date.After(last[split.Category]) {
+				last[split.Category] = transaction.Date
+			}
+		}
+	}
+	for category, at := range d.Unarchived {
+		if at.After(last[category]) {
+			last[category] = at
+		}
+	}
+	return last
+}
+
+// categories unused for longer than the archive-after setting, history stays untouched
+func (d *Data) archivedCategories(now time.Time) map[string]bool {
+	archived := make(map[string]bool)
+	if d.Settings.ArchiveAfter <= 0 {
+		return archived
+	}
+	cutoff := now.AddDate(0, -d.Settings.ArchiveAfter, 0)
+	for category, last := range d.categoryLastUse() {
+		if last.Before(cutoff) {
+			archived[category] = true
+		}
+	}
+	return archived
+}
+
+func (d *Data) unarchiveCategory(category string, now time.Time) error {
+	if !d.archivedCategories(now)[category] {
+		return fmt.Errorf("category %s is not archived", category)
+	}
+	if d.Unarchived == nil {
+		d.Unarchived = make(map[string]time.Time)
+	}
+	d.Unarchived[category] = now
+	return nil
+}
+
+func (d *Data) displayArchivedCategories(now time.Time) {
+	archived := d.archivedCategories(now)
+	if len(archived) == 0 {
+		fmt.Println("No archived categories.")
+		return
+	}
+	last := d.categoryLastUse()
+	names := make([]string, 0, len(archived))
+	for category := range archived {
+		names = append(names, category)
+	}
+	sort.Strings(names)
+	fmt.Println("Archived categories:")
+	for _, category := range names {
+		fmt.Printf("  %-20s last used %s\n", category, last[category].Format("2006-01-02"))
+	}
+}
+
+// whether a category is used by a transaction or has a budget
+func (d *Data) categoryInUse(category string) bool {
+	if _, ok := d.Budgets[category]; ok {
+		return true
+	}
+	_, ok := d.categoryLastUse()[category]
+	return ok
+}
+
+// replace the categories of a transaction by their allowed spelling, matched regardless of case;
+// with no allowed list every category goes
+func (d *Data) canonicalCategories(transaction *Transaction) error {
+	if len(d.Allowed) == 0 {
+		return nil
+	}
+	canonical := func(category string) (string, error) {
+		if i := slices.IndexFunc(d.Allowed, func(allowed string) bool { return strings.EqualFold(allowed, category) }); i >= 0 {
+			return d.Allowed[i], nil
+		}
+		return "", fmt.Errorf("category %q is not allowed, use one of: %s", category, strings.Join(d.Allowed, ", "))
+	}
+	var err error
+	if len(transaction.Splits) == 0 {
+		transaction.Category, err = canonical(transaction.Category)
+		return err
+	}
+	for i := range transaction.Splits {
+		if transaction.Splits[i].Category, err = canonical(transaction.Splits[i].Category); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move everything filed under the from categories to to: transactions and their line items, budgets,
+// rollover, envelopes, notes, tax marks, rules, alerts and recurring transactions; a rename needs a new
+// name while a merge goes into a category in use, adding up budgets and allocations; returns the number
+// of transactions changed
+func (d *Data) moveCategories(from []string, to string, merge bool) (int, error) {
+	to = strings.TrimSpace(to)
+	if to == "" || to == splitCategory {
+		return 0, fmt.Errorf("invalid category name %q", to)
+	}
+	for _, category := range from {
+		if category == to {
+			return 0, fmt.Errorf("cannot move %s into itself", category)
+		}
+		if !d.categoryInUse(category) {
+			return 0, fmt.Errorf("category %s is not in use", category)
+		}
+	}
+	if !merge && d.categoryInUse(to) {
+		return 0, fmt.Errorf("category %s already exists, use: category merge %s %s", to, strings.Join(from, " "), to)
+	}
+	moved := func(category string) bool { return slices.Contains(from, category) }
+
+	changed := 0
+	for i := range d.Transactions {
+		transaction := &d.Transactions[i]
+		touched := moved(transaction.Category)
+		if touched {
+			transaction.Category = to
+		}
+		for j := range transaction.Splits {
+			if moved(transaction.Splits[j].Category) {
+				transaction.Splits[j].Category = to
+				touched = true
+			}
+		}
+		if touched {
+			changed++
+			d.edited()
+		}
+	}
+	for _, category := range from {
+		if budget, ok := d.Budgets[category]; ok {
+			d.Budgets[to] += budget
+			delete(d.Budgets, category)
+		}
+		if since, ok := d.Rollover[category]; ok {
+			if current, exists := d.Rollover[to]; !exists || since < current {
+				d.Rollover[to] = since
+			}
+			delete(d.Rollover, category)
+		}
+		if note, ok := d.Notes[category]; ok {
+			if d.Notes[to] == "" {
+				d.Notes[to] = note
+			}
+			delete(d.Notes, category)
+		}
+		if _, found := slices.BinarySearch(d.Deductible, category); found {
+			d.setDeductible(category, false)
+			d.setDeductible(to, true)
+		}
+		if at, ok := d.Unarchived[category]; ok {
+			if at.After(d.Unarchived[to]) {
+				d.Unarchived[to] = at
+			}
+			delete(d.Unarchived, category)
+		}
+	}
+	allocations := d.Allocations[:0]
+	for _, allocation := range d.Allocations {
+		if moved(allocation.Category) {
+			allocation.Category = to
+		}
+		if i := slices.IndexFunc(allocations, func(a Allocation) bool { return a.Month == allocation.Month && a.Category == allocation.Category }); i >= 0 {
+			allocations[i].Amount += allocation.Amount
+			continue
+		}
+		allocations = append(allocations, allocation)
+	}
+	d.Allocations = allocations
+	for i := range d.Rules {
+		if moved(d.Rules[i].Category) {
+			d.Rules[i].Category = to
+		}
+	}
+	for i := range d.Alerts {
+		if moved(d.Alerts[i].Category) {
+			d.Alerts[i].Category = to
+		}
+	}
+	for i := range d.Recurring {
+		if moved(d.Recurring[i].Category) {
+			d.Recurring[i].Category = to
+		}
+	}
+	if len(d.Allowed) > 0 {
+		d.Allowed = slices.DeleteFunc(d.Allowed, moved)
+		if !slices.Contains(d.Allowed, to) {
+			d.Allowed = append(d.Allowed, to)
+			sort.Strings(d.Allowed)
+		}
+	}
+	return changed, nil
+}
+
+// every category with how often and when it was last used
+func (d *Data) displayCategoryList() error {
+	type categoryUse struct {
+		Category     string    `json:"category"`
+		Transactions int       `json:"transactions"`
+		LastUsed     time.Time `json:"last_used,omitzero"`
+		Budget       float64   `json:"budget,omitempty"`
+		Archived     bool      `json:"archived,omitempty"`
+	}
+	counts := make(map[string]int)
+	for _, transaction := range d.Transactions {
+		for _, split := range transaction.categoryAmounts() {
+			counts[split.Category]++
+		}
+	}
+	last := d.categoryLastUse()
+	archived := d.archivedCategories(d.today())
+	names := slices.Sorted(maps.Keys(mapsMerged(last, d.Budgets)))
+	uses := make([]categoryUse, 0, len(names))
+	for _, name := range names {
+		if name == "" || name == splitCategory {
+			continue
+		}
+		uses = append(uses, categoryUse{Category: name, Transactions: counts[name], LastUsed: last[name], Budget: d.Budgets[name], Archived: archived[name]})
+	}
+	if structuredOutput() {
+		rows := make([][]any, len(uses))
+		for i, use := range uses {
+			rows[i] = []any{use.Category, use.Transactions, use.LastUsed.Format("2006-01-02"), use.Budget, use.Archived}
+		}
+		return emit(uses, []string{"category", "transactions", "last_used", "budget", "archived"}, rows)
+	}
+	if len(d.Allowed) > 0 {
+		fmt.Println("Allowed categories:", strings.Join(d.Allowed, ", "))
+	}
+	table := newTable("Category", "Transactions", "Last used", "Budget", "").alignRight(1, 3)
+	for _, use := range uses {
+		lastUsed, budget, status := "-", "", ""
+		if !use.LastUsed.IsZero() {
+			lastUsed = use.LastUsed.Format("2006-01-02")
+		}
+		if _, ok := d.Budgets[use.Category]; ok {
+			budget = d.formatAmount(use.Budget)
+		}
+		if use.Archived {
+			status = "archived"
+		}
+		if len(d.Allowed) > 0 && !slices.Contains(d.Allowed, use.Category) {
+			status = strings.TrimSpace(status + " not allowed")
+		}
+		table.addRow(plainCell(use.Category), plainCell(strconv.Itoa(use.Transactions)), plainCell(lastUsed), plainCell(budget), plainCell(status))
+	}
+	table.print()
+	return nil
+}
+
+// edit distance between two strings ignoring case, a swap of neighbouring letters counts as one edit
+func categoryDistance(a, b string) int {
+	x, y := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	rows := make([][]int, len(x)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(y)+1)
+		rows[i][0] = i
+	}
+	for j := range y {
+		rows[0][j+1] = j + 1
+	}
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(x)][len(y)]
+}
+
+// MatchCategory finds the known category name is most likely meant to be: one differing only in case
+// (distance 0) or within one edit for names up to four letters and two edits for longer ones.
+// It returns "" and -1 when nothing is that close; importers can use it to fold spellings together.
+func MatchCategory(name string, known []string) (string, int) {
+	best, bestDistance := "", -1
+	limit := 2
+	if utf8.RuneCountInString(name) <= 4 {
+		limit = 1
+	}
+	for _, category := range known {
+		if distance := categoryDistance(name, category); distance <= limit && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = category, distance
+		}
+	}
+	return best, bestDistance
+}
+
+// the known category a new one is probably a typo of
+func (d *Data) suggestCategory(category string) (string, bool) {
+	known := append(d.categories(), d.Allowed...)
+	if category == "" || slices.Contains(known, category) {
+		return "", false
+	}
+	match, _ := MatchCategory(category, known)
+	return match, match != ""
+}
+
+// offer the known category a typed one is probably a typo of and return the one to use;
+// without a terminal to ask on it only warns
+func (d *Data) confirmCategory(category string) string {
+	suggestion, ok := d.suggestCategory(category)
+	if !ok {
+		return category
+	}
+	if !stdinIsTerminal() {
+		fmt.Printf("Warning: %s is a new category, did you mean %s?\n", category, suggestion)
+		return category
+	}
+	if answer := strings.ToLower(prompt(fmt.Sprintf("Did you mean %s? [Y/n]", suggestion), "")); answer == "" || answer == "y" || answer == "yes" {
+		return suggestion
+	}
+	return category
+}
+
+// every category used so far, including budgeted ones, sorted
+func (d *Data) categories() []string {
+	seen := d.archivedCategories(d.today())
+	categories := make([]string, 0)
+	add := func(category string) {
+		if category != "" && category != splitCategory && !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	for _, transaction := range d.Transactions {
+		for _, split := range transaction.categoryAmounts() {
+			add(split.Category)
+		}
+	}
+	for category := range d.Budgets {
+		add(category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+func (d *Data) accountNames() []string {
+	names := make([]string, 0, len(d.Accounts))
+	for _, account := range d.Accounts {
+		names = append(names, account.Name)
+	}
+	for _, transaction := range d.Transactions {
+		if transaction.Account != "" && !slices.Contains(names, transaction.Account) {
+			names = append(names, transaction.Account)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// every payee used so far, sorted
+func (d *Data) payees() []string {
+	seen := make(map[string]bool)
+	payees := make([]string, 0)
+	for _, transaction := range d.Transactions {
+		if transaction.Payee != "" && !seen[transaction.Payee] {
+			seen[transaction.Payee] = true
+			payees = append(payees, transaction.Payee)
+		}
+	}
+	sort.Strings(payees)
+	return payees
+}
+
+// known values starting with prefix, ignoring case
+func completions(prefix string, known []string) []string {
+	matches := make([]string, 0)
+	for _, value := range known {
+		if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			matches = append(matches, value)
+		}
+	}
+	return matches
+}
+
+// metadata keys are lowercase words so they carry over to beancount metadata and ledger tags as they are;
+// the keys the exports write themselves are reserved
+func metadataKey(key string) (string, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" {
+		return "", fmt.Errorf("metadata key must not be empty")
+	}
+	if key == "id" || key == "payee" || key == "notes" {
+		return "", fmt.Errorf("metadata key %q is reserved", key)
+	}
+	for i, r := range key {
+		if !('a' <= r && r <= 'z' || i > 0 && ('0' <= r && r <= '9' || r == '-' || r == '_')) {
+			return "", fmt.Errorf("invalid metadata key %q, use letters, digits, - and _ starting with a letter", key)
+		}
+	}
+	return key, nil
+}
+
+// collects repeated --meta key=value flags
+type metadataFlags map[string]string
+
+func (f metadataFlags) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, key := range slices.Sorted(maps.Keys(f)) {
+		pairs = append(pairs, key+"="+f[key])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (f metadataFlags) Set(text string) error {
+	key, value, ok := strings.Cut(text, "=")
+	if !ok {
+		return fmt.Errorf("invalid metadata %q, use key=value", text)
+	}
+	key, err := metadataKey(key)
+	if err != nil {
+		return err
+	}
+	f[key] = strings.TrimSpace(value)
+	return nil
+}
+
+// set a metadata key, an empty value removes it
+func (t *Transaction) setMetadata(key, value string) {
+	if value == "" {
+		delete(t.Metadata, key)
+		if len(t.Metadata) == 0 {
+			t.Metadata = nil
+		}
+		return
+	}
+	if t.Metadata == nil {
+		t.Metadata = make(map[string]string)
+	}
+	t.Metadata[key] = value
+}
+
+// whether text appears, ignoring case, in the description, payee, notes, line items or metadata values
+func (t Transaction) mentions(text string) bool {
+	fields := []string{t.Description, t.Payee, t.Notes}
+	for _, split := range t.Splits {
+		fields = append(fields, split.Description)
+	}
+	fields = slices.AppendSeq(fields, maps.Values(t.Metadata))
+	fields = append(fields, t.Tags...)
+	text = strings.ToLower(text)
+	return slices.ContainsFunc(fields, func(field string) bool { return strings.Contains(strings.ToLower(field), text) })
+}
+
+// replace the notes of a transaction unless notes is nil, and set its metadata keys, empty values remove them
+func (d *Data) annotate(id int, notes *string, metadata map[string]string) error {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return err
+	}
+	if notes != nil {
+		transaction.Notes = strings.TrimSpace(*notes)
+	}
+	for key, value := range metadata {
+		transaction.setMetadata(key, value)
+	}
+	return nil
+}
+
+func (d *Data) displayAnnotations(id int) error {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return err
+	}
+	keys := slices.Sorted(maps.Keys(transaction.Metadata))
+	if structuredOutput() {
+		metadata := make(map[string]string, len(keys))
+		rows := make([][]any, len(keys))
+		for i, key := range keys {
+			metadata[key] = transaction.Metadata[key]
+			rows[i] = []any{key, transaction.Metadata[key]}
+		}
+		return emit(struct {
+			ID       int               `json:"id"`
+			Notes    string            `json:"notes"`
+			Metadata map[string]string `json:"metadata"`
+		}{transaction.ID, transaction.Notes, metadata}, []string{"key", "value"}, rows)
+	}
+	fmt.Printf("Transaction %d, %s %s %s:\n", transaction.ID, transaction.Date.Format("2006-01-02"), transaction.Category, d.formatAmount(transaction.Amount))
+	if transaction.Notes == "" && len(keys) == 0 {
+		fmt.Println("No notes or metadata.")
+		return nil
+	}
+	for line := range strings.Lines(transaction.Notes) {
+		fmt.Println("  " + strings.TrimRight(line, "\r\n"))
+	}
+	if len(keys) > 0 {
+		table := newTable("Key", "Value")
+		for _, key := range keys {
+			table.addRow(plainCell(key), plainCell(transaction.Metadata[key]))
+		}
+		table.print()
+	}
+	return nil
+}
+
+// receipts are copied into a directory next to the data file, named after their content so duplicates are stored once
+func (d *Data) attach(dataFile string, id int, filename string) (string, error) {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read attachment: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	name := filepath.Join("attachments", hex.EncodeToString(sum[:])+strings.ToLower(filepath.Ext(filename)))
+	target := filepath.Join(filepath.Dir(dataFile), name)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return "", fmt.Errorf("failed to store attachment: %w", err)
+		}
+	}
+	if !slices.Contains(transaction.Attachments, name) {
+		transaction.Attachments = append(transaction.Attachments, name)
+	}
+	return target, nil
+}
+
+var receiptAmountPattern = regexp.MustCompile(`\d{1,3}(?:,\d{3})*(?:\.\d{2})|\d+\.\d{2}`)
+
+// OCR text of a receipt: text receipts are read as is, for images and PDFs the
+// output of an OCR tool is expected next to them as receipt.jpg.txt or receipt.txt
+func receiptText(filename string) (string, bool) {
+	if strings.EqualFold(filepath.Ext(filename), ".txt") {
+		content, err := os.ReadFile(filename)
+		return string(content), err == nil
+	}
+	for _, sidecar := range []string{filename + ".txt", strings.TrimSuffix(filename, filepath.Ext(filename)) + ".txt"} {
+		if content, err := os.ReadFile(sidecar); err == nil {
+			return string(content), true
+		}
+	}
+	return "", false
+}
+
+// the receipt total is the last amount on the last line mentioning a total (but not a subtotal)
+func receiptTotal(text string) (float64, bool) {
+	total, found := 0.0, false
+	for _, line := range strings.Split(text, "\n") {
+		lower := strings.ToLower(line)
+		if !strings.Contains(lower, "total") || strings.Contains(lower, "subtotal") || strings.Contains(lower, "sub total") {
+			continue
+		}
+		amounts := receiptAmountPattern.FindAllString(line, -1)
+		if len(amounts) == 0 {
+			continue
+		}
+		if amount, err := parseAmount(amounts[len(amounts)-1]); err == nil {
+			total, found = amount, true
+		}
+	}
+	return total, found
+}
+
+// compare the total read from a receipt with the transaction amount, mismatches are usually typos
+func (d *Data) checkReceipt(id int, filename string) (float64, bool, error) {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return 0, false, err
+	}
+	text, ok := receiptText(filename)
+	if !ok {
+		return 0, true, nil
+	}
+	total, ok := receiptTotal(text)
+	if !ok {
+		return 0, true, nil
+	}
+	return total, math.Abs(total-transaction.Amount) < 0.005, nil
+}
+
+// open the attachments of a transaction with the system's default viewer
+func (d *Data) openAttachments(dataFile string, id int) error {
+	transaction, err := d.findTransaction(id)
+	if err != nil {
+		return err
+	}
+	if len(transaction.Attachments) == 0 {
+		return fmt.Errorf("transaction %d has no attachments", id)
+	}
+	opener := []string{"xdg-open"}
+	switch runtime.GOOS {
+	case "darwin":
+		opener = []string{"open"}
+	case "windows":
+		opener = []string{"cmd", "/c", "start", ""}
+	}
+	for _, name := range transaction.Attachments {
+		path := filepath.Join(filepath.Dir(dataFile), name)
+		if err := exec.Command(opener[0], append(opener[1:], path)...).Start(); err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// two versions of the same transaction that differ
+type mergeConflict struct {
+	Local  Transaction
+	Remote Transaction
+}
+
+// resolves a conflict, returning the transactions to keep in place of the local one
+type conflictResolver func(conflict mergeConflict) []Transaction
+
+func sameTransaction(a, b Transaction) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	return string(left) == string(right)
+}
+
+// merge another copy of the book by transaction ID: unknown transactions are added unless they were
+// deleted here and differing versions are handed to resolve, returns the number added and the conflicts seen
+func (d *Data) mergeBook(other *Data, resolve conflictResolver) (int, int) {
+	index := make(map[int]int, len(d.Transactions))
+	for i, transaction := range d.Transactions {
+		index[transaction.ID] = i
+	}
+	added, conflicts := 0, 0
+	replaced := make(map[int][]Transaction)
+	for _, remote := range other.Transactions {
+		i, ok := index[remote.ID]
+		if !ok && slices.ContainsFunc(d.Trash, func(t Transaction) bool { return t.ID == remote.ID }) {
+			continue
+		}
+		if !ok {
+			d.Transactions = append(d.Transactions, remote)
+			d.lastID = max(d.lastID, remote.ID)
+			added++
+			continue
+		}
+		if sameTransaction(d.Transactions[i], remote) {
+			continue
+		}
+		conflicts++
+		replaced[i] = resolve(mergeConflict{Local: d.Transactions[i], Remote: remote})
+	}
+
+	merged := make([]Transaction, 0, len(d.Transactions))
+	for i, transaction := range d.Transactions {
+		keep, ok := replaced[i]
+		if !ok {
+			merged = append(merged, transaction)
+			continue
+		}
+		for _, kept := range keep {
+			if kept.ID != transaction.ID {
+				d.lastID++
+				kept.ID = d.lastID
+			}
+			merged = append(merged, kept)
+		}
+	}
+	d.Transactions = merged
+	d.reindex()
+	return added, conflicts
+}
+
+// the fields offered when merging a conflict field by field
+var mergeFields = []struct {
+	Name string
+	Show func(t Transaction) string
+	Take func(dst *Transaction, src Transaction)
+}{
+	{"date", func(t Transaction) string { return t.Date.Format("2006-01-02") }, func(dst *Transaction, src Transaction) { dst.Date = src.Date }},
+	{"type", func(t Transaction) string { return t.Type }, func(dst *Transaction, src Transaction) { dst.Type = src.Type }},
+	{"category", func(t Transaction) string { return t.Category }, func(dst *Transaction, src Transaction) { dst.Category = src.Category }},
+	{"amount", func(t Transaction) string { return strconv.FormatFloat(t.Amount, 'f', 2, 64) }, func(dst *Transaction, src Transaction) { dst.Amount, dst.Splits = src.Amount, src.Splits }},
+	{"description", func(t Transaction) string { return t.Description }, func(dst *Transaction, src Transaction) { dst.Description = src.Description }},
+	{"payee", func(t Transaction) string { return t.Payee }, func(dst *Transaction, src Transaction) { dst.Payee = src.Payee }},
+	{"account", func(t Transaction) string { return t.Account }, func(dst *Transaction, src Transaction) { dst.Account = src.Account }},
+}
+
+// ask the user how to settle each conflict
+func resolveInteractively(conflict mergeConflict) []Transaction {
+	local, remote := conflict.Local, conflict.Remote
+	fmt.Printf("\nConflict on transaction %d:\n", local.ID)
+	for _, field := range mergeFields {
+		marker := " "
+		if field.Show(local) != field.Show(remote) {
+			marker = "*"
+		}
+		fmt.Printf(" %s %-12s local: %-24s remote: %s\n", marker, field.Name, field.Show(local), field.Show(remote))
+	}
+	for {
+		switch strings.ToLower(prompt("Keep (l)ocal, (r)emote, (b)oth, or (m)erge fields", "l")) {
+		case "l", "local":
+			return []Transaction{local}
+		case "r", "remote":
+			return []Transaction{remote}
+		case "b", "both":
+			return []Transaction{local, remote}
+		case "m", "merge":
+			merged := local
+			for _, field := range mergeFields {
+				if field.Show(local) == field.Show(remote) {
+					continue
+				}
+				if strings.HasPrefix(strings.ToLower(prompt(fmt.Sprintf("  %s: (l)ocal %q or (r)emote %q", field.Name, field.Show(local), field.Show(remote)), "l")), "r") {
+					field.Take(&merged, remote)
+				}
+			}
+			for _, name := range remote.Attachments {
+				if !slices.Contains(merged.Attachments, name) {
+					merged.Attachments = append(merged.Attachments, name)
+				}
+			}
+			return []Transaction{merged}
+		}
+	}
+}
+
+func preferSide(side string) (conflictResolver, error) {
+	switch side {
+	case "ask":
+		return resolveInteractively, nil
+	case "local":
+		return func(conflict mergeConflict) []Transaction { return []Transaction{conflict.Local} }, nil
+	case "remote":
+		return func(conflict mergeConflict) []Transaction { return []Transaction{conflict.Remote} }, nil
+	}
+	return nil, fmt.Errorf("invalid --prefer %q, use ask, local or remote", side)
+}
+
+// a version of a transaction that lost a sync conflict
+type SyncConflict struct {
+	At        time.Time   `json:"at"`
+	Peer      string      `json:"peer"`
+	Kept      Transaction `json:"kept"`
+	Discarded Transaction `json:"discarded"`
+}
+
+// counts of one sync
+type syncResult struct {
+	Pulled    int // changes taken from the other copy
+	Pushed    int // changes the other copy did not have yet
+	Conflicts int
+	SetAside  int // losing versions kept in Data.Conflicts for review
+}
+
+// the newer edit wins, an edit without a time loses to one with a time and the local copy wins a tie
+func resolveNewer(conflict mergeConflict) []Transaction {
+	if conflict.Remote.Modified.After(conflict.Local.Modified) {
+		return []Transaction{conflict.Remote}
+	}
+	return []Transaction{conflict.Local}
+}
+
+// three-way merge of the local and remote transactions against base, the state both had at their last
+// sync. Changes made on one side only are taken over, including deletions; transactions both sides
+// added under the same ID are both kept, the remote one renumbered. Edits on both sides go to resolve
+// and every version not kept is recorded as a conflict; a deletion never wins over an edit. Without a
+// base, the first sync, every differing pair counts as a conflict.
+func syncTransactions(base []Transaction, haveBase bool, local, remote []Transaction, resolve conflictResolver) ([]Transaction, syncResult, []mergeConflict) {
+	index := func(transactions []Transaction) map[int]Transaction {
+		byID := make(map[int]Transaction, len(transactions))
+		for _, transaction := range transactions {
+			byID[transaction.ID] = transaction
+		}
+		return byID
+	}
+	baseByID, localByID, remoteByID := index(base), index(local), index(remote)
+	nextID := 0
+	for _, transaction := range slices.Concat(base, local, remote) {
+		nextID = max(nextID, transaction.ID)
+	}
+	renumber := func(transaction Transaction) Transaction {
+		nextID++
+		transaction.ID = nextID
+		return transaction
+	}
+
+	var result syncResult
+	var lost []mergeConflict
+	// settle a pair edited on both sides, whatever resolve does not keep is recorded as lost
+	settle := func(localVersion, remoteVersion Transaction) []Transaction {
+		result.Conflicts++
+		kept := resolve(mergeConflict{Local: localVersion, Remote: remoteVersion})
+		for _, version := range []Transaction{localVersion, remoteVersion} {
+			if !slices.ContainsFunc(kept, func(t Transaction) bool { return sameTransaction(t, version) }) {
+				lost = append(lost, mergeConflict{Local: kept[0], Remote: version})
+			}
+		}
+		for i := 1; i < len(kept); i++ {
+			kept[i] = renumber(kept[i])
+		}
+		return kept
+	}
+
+	merged := make([]Transaction, 0, max(len(local), len(remote)))
+	for _, mine := range local {
+		theirs, inRemote := remoteByID[mine.ID]
+		original, inBase := baseByID[mine.ID]
+		switch {
+		case inRemote && sameTransaction(mine, theirs):
+			merged = append(merged, mine)
+		case inRemote && !haveBase:
+			merged = append(merged, settle(mine, theirs)...)
+		case inRemote && !inBase: // both added a transaction under this ID
+			merged = append(merged, mine, renumber(theirs))
+			result.Pulled++
+			result.Pushed++
+		case inRemote && sameTransaction(original, theirs):
+			merged = append(merged, mine)
+			result.Pushed++
+		case inRemote && sameTransaction(original, mine):
+			merged = append(merged, theirs)
+			result.Pulled++
+		case inRemote:
+			merged = append(merged, settle(mine, theirs)...)
+		case haveBase && inBase && sameTransaction(original, mine): // deleted on the other side
+			result.Pulled++
+		case haveBase && inBase: // deleted there but edited here, the edit is kept
+			merged = append(merged, mine)
+			result.Conflicts++
+		default:
+			merged = append(merged, mine)
+			result.Pushed++
+		}
+	}
+	for _, theirs := range remote {
+		if _, inLocal := localByID[theirs.ID]; inLocal {
+			continue
+		}
+		original, inBase := baseByID[theirs.ID]
+		switch {
+		case haveBase && inBase && sameTransaction(original, theirs): // deleted here
+			result.Pushed++
+		case haveBase && inBase: // deleted here but edited there, the edit is kept
+			merged = append(merged, theirs)
+			result.Conflicts++
+		default:
+			merged = append(merged, theirs)
+			result.Pulled++
+		}
+	}
+	return merged, result, lost
+}
+
+// where the state of the last sync with peer is kept, next to the local data file
+func syncBaseFile(dataFile, peer string) string {
+	if absolute, err := filepath.Abs(peer); err == nil {
+		peer = absolute
+	}
+	digest := sha256.Sum256([]byte(peer))
+	return fmt.Sprintf("%s.sync-%x", dataFile, digest[:4])
+}
+
+// bring the book and another copy of it, e.g. in a shared folder, to the same state; both are written
+func (d *Data) syncFile(dataFile, peer string, resolve conflictResolver) (syncResult, error) {
+	other, err := loadData(peer)
+	if err != nil {
+		return syncResult{}, fmt.Errorf("%s: %w", peer, err)
+	}
+	var base []Transaction
+	baseFile := syncBaseFile(dataFile, peer)
+	content, err := os.ReadFile(baseFile)
+	haveBase := err == nil
+	if haveBase {
+		if err := json.Unmarshal(content, &base); err != nil {
+			return syncResult{}, fmt.Errorf("failed to read the last sync state: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return syncResult{}, fmt.Errorf("failed to read the last sync state: %w", err)
+	}
+
+	merged, result, lost := syncTransactions(base, haveBase, d.Transactions, other.Transactions, resolve)
+	now := d.now().UTC()
+	for _, conflict := range lost {
+		d.Conflicts = append(d.Conflicts, SyncConflict{At: now, Peer: peer, Kept: conflict.Local, Discarded: conflict.Remote})
+	}
+	d.Transactions = merged
+	d.reindex()
+	d.assignIDs()
+	for category, amount := range other.Budgets { // settings stay local, budgets and notes only set there are taken over
+		if _, ok := d.Budgets[category]; !ok {
+			d.setBudget(category, amount)
+		}
+	}
+	for category, note := range other.Notes {
+		if _, ok := d.Notes[category]; !ok {
+			d.setCategoryNote(category, note)
+		}
+	}
+
+	if err := d.save(dataFile); err != nil {
+		return syncResult{}, err
+	}
+	// the other copy gets the same content but is written directly, its webhooks and history belong to its own device
+	content, err = json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return syncResult{}, fmt.Errorf("failed to encode data: %w", err)
+	}
+	if err := openStorage(peer).Save(content, "Sync"); err != nil {
+		return syncResult{}, fmt.Errorf("%s: %w", peer, err)
+	}
+	state, _ := json.Marshal(d.Transactions)
+	if err := os.WriteFile(baseFile, state, 0o644); err != nil {
+		return syncResult{}, fmt.Errorf("failed to record the sync state: %w", err)
+	}
+	result.SetAside = len(lost)
+	return result, nil
+}
+
+func (d *Data) displayConflicts() {
+	if len(d.Conflicts) == 0 {
+		fmt.Println("No sync conflicts.")
+		return
+	}
+	for _, conflict := range d.Conflicts {
+		fmt.Printf("%s with %s:\n", conflict.At.In(d.Settings.location()).Format("2006-01-02 15:04"), conflict.Peer)
+		fmt.Println("  kept      " + d.transactionLine(conflict.Kept))
+		fmt.Println("  discarded " + d.transactionLine(conflict.Discarded))
+	}
+}
+
+// merge another data file, returns the number of transactions added and of conflicts resolved
+func (d *Data) mergeFile(filename string, resolve conflictResolver) (int, int, error) {
+	other, err := loadData(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	added, conflicts := d.mergeBook(other, resolve)
+	return added, conflicts, nil
+}
+
+type bookDiff struct {
+	Added    []Transaction
+	Removed  []Transaction
+	Modified []mergeConflict // Local is the old version, Remote the new one
+}
+
+// compare two versions of a book by transaction ID
+func diffBooks(older, newer *Data) bookDiff {
+	var diff bookDiff
+	before := make(map[int]Transaction, len(older.Transactions))
+	for _, transaction := range older.Transactions {
+		before[transaction.ID] = transaction
+	}
+	seen := make(map[int]bool, len(newer.Transactions))
+	for _, transaction := range newer.Transactions {
+		seen[transaction.ID] = true
+		previous, ok := before[transaction.ID]
+		if !ok {
+			diff.Added = append(diff.Added, transaction)
+		} else if !sameTransaction(previous, transaction) {
+			diff.Modified = append(diff.Modified, mergeConflict{Local: previous, Remote: transaction})
+		}
+	}
+	for _, transaction := range older.Transactions {
+		if !seen[transaction.ID] {
+			diff.Removed = append(diff.Removed, transaction)
+		}
+	}
+	return diff
+}
+
+func (d *Data) transactionLine(t Transaction) string {
+	return fmt.Sprintf("#%d %s %s %s %s %s", t.ID, t.Date.Format("2006-01-02"), t.Type, t.Category, d.formatAmount(t.Amount), t.Description)
+}
+
+func (d *Data) displayDiff(oldFile, newFile string) error {
+	older, err := loadData(oldFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", oldFile, err)
+	}
+	newer, err := loadData(newFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", newFile, err)
+	}
+	diff := diffBooks(older, newer)
+	fmt.Printf("Comparing %s with %s: %d added, %d removed, %d modified\n", oldFile, newFile, len(diff.Added), len(diff.Removed), len(diff.Modified))
+	for _, transaction := range diff.Added {
+		fmt.Println("+ " + d.transactionLine(transaction))
+	}
+	for _, transaction := range diff.Removed {
+		fmt.Println("- " + d.transactionLine(transaction))
+	}
+	for _, change := range diff.Modified {
+		fmt.Println("~ " + d.transactionLine(change.Remote))
+		shown := false
+		for _, field := range mergeFields {
+			if before, after := field.Show(change.Local), field.Show(change.Remote); before != after {
+				fmt.Printf("    %s: %q -> %q\n", field.Name, before, after)
+				shown = true
+			}
+		}
+		if !shown {
+			fmt.Println("    line items or attachments changed")
+		}
+	}
+	return nil
+}
+
+type staleAccount struct {
+	Account string
+	Last    time.Time
+	Cadence time.Duration // usual gap between two days with data
+}
+
+// accounts whose latest transaction is older than twice their usual cadence (and at least a week)
+func (d *Data) detectStaleAccounts(now time.Time) []staleAccount {
+	days := make(map[string][]time.Time)
+	for _, transaction := range d.Transactions {
+		if transaction.Account != "" {
+			days[transaction.Account] = append(days[transaction.Account], transaction.Date)
+		}
+	}
+
+	stale := make([]staleAccount, 0)
+	for account, dates := range days {
+		sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		gaps := make([]time.Duration, 0, len(dates))
+		for i := 1; i < len(dates); i++ {
+			if gap := dates[i].Sub(dates[i-1]); gap > 0 {
+				gaps = append(gaps, gap)
+			}
+		}
+		cadence := 7 * 24 * time.Hour
+		if len(gaps) > 0 {
+			sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+			cadence = max(cadence, gaps[len(gaps)/2])
+		}
+		last := dates[len(dates)-1]
+		if now.Sub(last) > 2*cadence {
+			stale = append(stale, staleAccount{Account: account, Last: last, Cadence: cadence})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Account < stale[j].Account })
+	return stale
+}
+
+// print a warning per stale account, returns how many were found
+func (d *Data) displayStaleWarnings(now time.Time) int {
+	stale := d.detectStaleAccounts(now)
+	for _, account := range stale {
+		fmt.Printf("Warning: no %s data since %s — did an import fail?\n", account.Account, account.Last.Format("January 2, 2006"))
+	}
+	return len(stale)
+}
+
+// all interactive input goes through one buffered reader so prompts never lose typed-ahead or piped lines
+var stdin = bufio.NewReader(os.Stdin)
+
+// read the next line from stdin without surrounding spaces, io.EOF once the input is exhausted
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSpace(line), err
+}
+
+// split a command line into words, quotes keep spaces inside a word
+func splitArgs(line string) []string {
+	args := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args
+}
+
+// values entered at each prompt during this session, most recent last
+var lineHistory = make(map[string][]string)
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// switch the terminal to raw mode, returns a function restoring the previous mode
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(saved) }, nil
+}
+
+func readRune() (rune, error) {
+	r, _, err := stdin.ReadRune()
+	return r, err
+}
+
+// edit a line on the terminal: tab completes from known, up/down walk the history of this label and ctrl-r
+// searches it, left/right and ctrl-a/ctrl-e move the cursor; falls back to reading a plain line when stdin is not a terminal
+func editLine(label string, def string, known []string) string {
+	var text string
+	var restore func()
+	raw := stdinIsTerminal()
+	if raw {
+		var err error
+		restore, err = rawTerminal()
+		raw = err == nil
+	}
+	if raw {
+		text, _ = editRaw(label, def, func(text string) (string, []string) { return "", completions(text, known) })
+		restore()
+	} else {
+		text = prompt(label, def)
+	}
+	if text != "" {
+		lineHistory[label] = append(lineHistory[label], text)
+	}
+	return text
+}
+
+// the line editor behind editLine and the command prompt; complete gives the text before the word being
+// completed and the candidates for it. Returns io.EOF for Ctrl+D on an empty line or when input ends
+func editRaw(label string, def string, complete func(text string) (string, []string)) (string, error) {
+	line := []rune(def)
+	pos := len(line)
+	history := lineHistory[label]
+	step := len(history)
+	redraw := func() {
+		fmt.Printf("\r%s: %s\x1b[K", label, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Printf("\x1b[%dD", back)
+		}
+	}
+	redraw()
+	for {
+		r, err := readRune()
+		if err != nil {
+			fmt.Print("\r\n")
+			return strings.TrimSpace(string(line)), io.EOF
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return strings.TrimSpace(string(line)), nil
+		case 3: // ctrl-c abandons the input
+			fmt.Print("^C\r\n")
+			return "", nil
+		case 4: // ctrl-d ends the input on an empty line and deletes under the cursor otherwise
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 1: // ctrl-a
+			pos = 0
+		case 5: // ctrl-e
+			pos = len(line)
+		case 11: // ctrl-k cuts to the end
+			line = line[:pos]
+		case 21: // ctrl-u cuts to the start
+			line, pos = line[pos:], 0
+		case 23: // ctrl-w cuts the word before the cursor
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line, pos = append(line[:start], line[pos:]...), start
+		case 18: // ctrl-r searches the history backwards
+			found, submit := searchHistory(history, string(line))
+			line = []rune(found)
+			pos = len(line)
+			if submit {
+				redraw()
+				fmt.Print("\r\n")
+				return strings.TrimSpace(found), nil
+			}
+		case 127, 8:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case '\t':
+			prefix, matches := complete(string(line))
+			word := []rune(string(line))[len([]rune(prefix)):]
+			if len(matches) == 1 {
+				line = []rune(prefix + matches[0])
+			} else if len(matches) > 1 {
+				common := []rune(matches[0])
+				for _, match := range matches[1:] {
+					m := []rune(match)
+					n := 0
+					for n < len(common) && n < len(m) && unicode.ToLower(common[n]) == unicode.ToLower(m[n]) {
+						n++
+					}
+					common = common[:n]
+				}
+				if len(common) > len(word) {
+					line = []rune(prefix + string(common))
+				} else {
+					for i, match := range matches {
+						matches[i] = strings.TrimSpace(match)
+					}
+					fmt.Print("\r\n" + strings.Join(matches, "  ") + "\r\n")
+				}
+			}
+			pos = len(line)
+		case 27: // escape sequences for the arrow, home, end and delete keys
+			if next, _ := readRune(); next != '[' {
+				continue
+			}
+			key, _ := readRune()
+			switch key {
+			case 'A':
+				if step > 0 {
+					step--
+					line = []rune(history[step])
+				}
+			case 'B':
+				if step < len(history)-1 {
+					step++
+					line = []rune(history[step])
+				} else {
+					step = len(history)
+					line = nil
+				}
+			case 'C':
+				pos = min(pos+1, len(line))
+			case 'D':
+				pos = max(pos-1, 0)
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3': // delete is ESC [ 3 ~
+				if next, _ := readRune(); next == '~' && pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+			if key == 'A' || key == 'B' {
+				pos = len(line)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// the history entry a ctrl-r search settles on, most recent first; ctrl-r again goes further back, enter runs
+// the match, ctrl-g gives the original line back and any other key keeps the match for editing
+func searchHistory(history []string, original string) (string, bool) {
+	var query []rune
+	found := -1
+	search := func(from int) int {
+		for i := min(from, len(history)-1); i >= 0; i-- {
+			if strings.Contains(strings.ToLower(history[i]), strings.ToLower(string(query))) {
+				return i
+			}
+		}
+		return -1
+	}
+	for {
+		match := ""
+		if found >= 0 {
+			match = history[found]
+		}
+		fmt.Printf("\r(reverse-i-search)'%s': %s\x1b[K", string(query), match)
+		r, err := readRune()
+		if err != nil {
+			return original, false
+		}
+		switch {
+		case r == 18:
+			if found < 0 {
+				found = search(len(history) - 1)
+			} else if next := search(found - 1); next >= 0 {
+				found = next
+			}
+		case r == 127 || r == 8:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				found = search(len(history) - 1)
+			}
+		case r == 7 || r == 3: // ctrl-g or ctrl-c
+			return original, false
+		case r == '\r' || r == '\n':
+			return cmp.Or(match, original), match != ""
+		case r == 27: // an arrow key ends the search, its sequence is not left behind as text
+			if next, _ := readRune(); next == '[' {
+				readRune()
+			}
+			return cmp.Or(match, original), false
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			from := found
+			if from < 0 {
+				from = len(history) - 1
+			}
+			if found = search(from); found < 0 {
+				found = search(len(history) - 1)
+			}
+		default:
+			return cmp.Or(match, original), false
+		}
+	}
+}
+
+const commandLabel = "Enter command"
+
+// commands entered in the interactive mode are kept across sessions, the oldest go past this many
+const historySize = 1000
+
+func historyFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "finance", "history"), nil
+}
+
+// the commands of earlier sessions, oldest first; the file is trimmed to historySize as it is read
+func loadCommandHistory() []string {
+	filename, err := historyFile()
+	if err != nil {
+		return nil
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	history := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+		os.WriteFile(filename, []byte(strings.Join(history, "\n")+"\n"), 0o600)
+	}
+	return slices.DeleteFunc(history, func(line string) bool { return line == "" })
+}
+
+func appendCommandHistory(line string) error {
+	filename, err := historyFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(file, line)
+	return cmp.Or(err, file.Close())
+}
+
+// the next command of the interactive mode, edited with history, ctrl-r search and tab completion on a
+// terminal and read as a plain line otherwise
+func (d *Data) readCommand() (string, error) {
+	raw := stdinIsTerminal()
+	var restore func()
+	if raw {
+		var err error
+		restore, err = rawTerminal()
+		raw = err == nil
+	}
+	if !raw {
+		fmt.Print(commandLabel + ": ")
+		return readLine()
+	}
+	line, err := editRaw(commandLabel, "", d.completeCommand)
+	restore()
+	if history := lineHistory[commandLabel]; line != "" && (len(history) == 0 || history[len(history)-1] != line) {
+		lineHistory[commandLabel] = append(history, line)
+		if err := appendCommandHistory(line); err != nil {
+			slog.Warn("could not save the command history", "error", err)
+		}
+	}
+	return line, err
+}
+
+// print a prompt and read a line, falling back to def when nothing is entered
+func prompt(label, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	if value, _ := readLine(); value != "" {
+		return value
+	}
+	return def
+}
+
+var starterCategories = []string{"Rent", "Groceries", "Utilities", "Transport", "Dining", "Entertainment"}
+
+// guided first-run setup, returns the data file the book should be saved to
+func runSetupWizard(data *Data, dataFile string) (string, error) {
+	fmt.Println("Let's set up your book. Press enter to accept the value in brackets.")
+
+	// ask until the answer is usable, the defaults always are
+	ask := func(label, def string, accept func(string) error) {
+		for {
+			err := accept(prompt(label, def))
+			if err == nil {
+				return
+			}
+			fmt.Println("  Error:", err)
+		}
+	}
+	ask("Currency", "USD", func(value string) (err error) {
+		data.Currency, err = parseCurrencyCode(value)
+		return err
+	})
+	ask("Locale", "en-US", func(value string) error { return data.setConfig("locale", value) })
+	ask("Data file location", dataFile, func(location string) error {
+		if location == dataFile || isRemote(location) {
+			dataFile = location
+			return nil
+		}
+		location, err := filepath.Abs(location)
+		if err != nil {
+			return err
+		}
+		if err := checkWritableDir(filepath.Dir(location)); err != nil {
+			return err
+		}
+		if err := rememberDataFile(location); err != nil {
+			slog.Warn("could not remember the data file location", "error", err)
+		}
+		dataFile = location
+		return nil
+	})
+
+	fmt.Println("Accounts (leave the name empty when done):")
+	for {
+		name := prompt("  Account name", "")
+		if name == "" {
+			break
+		}
+		kind := prompt("  Kind (asset/liability)", "asset")
+		balance, err := parseFloat(prompt("  Opening balance", "0"))
+		if err != nil {
+			fmt.Println("  Error:", err)
+			continue
+		}
+		if err := data.setAccount(Account{Name: name, Kind: kind, OpeningBalance: balance, OpeningDate: data.today()}); err != nil {
+			fmt.Println("  Error:", err)
+		}
+	}
+
+	if filename := prompt("CSV file to import (optional)", ""); filename != "" {
+		account := prompt("Account for the imported transactions (optional)", "")
+		if result, err := data.importInteractively(filename, account, "", false); err != nil {
+			fmt.Println("Error:", err)
+		} else {
+			displayImportResult(result)
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(prompt("Seed starter categories with monthly budgets? (y/n)", "y")), "y") {
+		for _, category := range starterCategories {
+			amountStr := prompt("  Monthly budget for "+category+" (empty skips)", "")
+			if amountStr == "" {
+				continue
+			}
+			amount, err := parseFloat(amountStr)
+			if err == nil {
+				err = data.setBudget(category, amount)
+			}
+			if err != nil {
+				fmt.Println("  Error:", err)
+			}
+		}
+	}
+
+	if err := data.save(dataFile); err != nil {
+		return dataFile, err
+	}
+	fmt.Println("Setup complete, your book is stored in", dataFile)
+	return dataFile, nil
+}
+
+type DemoOptions struct {
+	Months   int       // full months to cover, ending with the month of End
+	PerMonth int       // transactions per month, the fixed bills and weekly groceries count towards it
+	Seed     uint64    // the same seed gives the same transactions
+	End      time.Time // last day with transactions
+	Account  string
+}
+
+// a spending outlook that could be someone's: salary and rent every month, groceries every Saturday,
+// higher heating bills in winter, gifts in December and a summer holiday, filled up with everyday spending
+func GenerateDemo(options DemoOptions) []Transaction {
+	rng := rand.New(rand.NewPCG(options.Seed, options.Seed^0x9e3779b97f4a7c15))
+	end := civilDate(options.End)
+	start := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-max(options.Months, 1), 0)
+	cents := func(amount float64) float64 { return math.Round(max(amount, 0.5)*100) / 100 }
+	around := func(mean, spread float64) float64 { return cents(mean + rng.NormFloat64()*spread) }
+	between := func(low, high float64) float64 { return cents(low + rng.Float64()*(high-low)) }
+	pick := func(choices ...string) string { return choices[rng.IntN(len(choices))] }
+	everyday := []struct {
+		category string
+		weight   int
+		payees   []string
+		low      float64
+		high     float64
+	}{
+		{"Coffee", 6, []string{"Corner Café", "Bean There", "Daily Grind"}, 2.5, 6},
+		{"Eating Out", 4, []string{"Luigi's", "Noodle Bar", "The Green Fork", "Burger Barn"}, 12, 60},
+		{"Transport", 5, []string{"City Metro", "Rideshare", "Fuel Stop"}, 2.5, 45},
+		{"Shopping", 2, []string{"Online Store", "Bookshop", "Hardware Hub"}, 8, 120},
+		{"Entertainment", 2, []string{"Cinema", "Concert Hall", "Game Store"}, 10, 70},
+		{"Health", 1, []string{"Pharmacy", "Dentist", "Gym"}, 8, 110},
+	}
+	weights, typical := 0, 0.0
+	for _, kind := range everyday {
+		weights += kind.weight
+		typical += float64(kind.weight) * (kind.low + kind.high) / 2
+	}
+	// pay enough to cover the bills and the everyday spending with a little left over
+	salary := math.Round((1800+float64(max(options.PerMonth-10, 0))*typical/float64(weights))*1.15/100) * 100
+
+	var transactions []Transaction
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		var planned []Transaction
+		add := func(day int, transactionType, category string, amount float64, payee, description string) {
+			date := month.AddDate(0, 0, day-1)
+			if date.Month() == month.Month() && !date.After(end) {
+				planned = append(planned, Transaction{Date: date, Type: transactionType, Category: category, Amount: amount,
+					Payee: payee, Description: description, Account: options.Account})
+			}
+		}
+		years := float64(month.Year() - start.Year())
+		add(1, Income, "Salary", cents(salary*math.Pow(1.03, years)), "Acme Corp", "Salary "+month.Format("January"))
+		add(1, Expense, "Rent", cents(1250*math.Pow(1.02, years)), "Oak Street Properties", "Rent")
+		heating := 1.0
+		if month.Month() >= time.November || month.Month() <= time.February {
+			heating = 1.6
+		}
+		add(15, Expense, "Utilities", around(95*heating, 12), "City Power & Gas", "Energy bill")
+		add(20, Expense, "Utilities", 39.99, "Mobile One", "Phone plan")
+		add(8, Expense, "Subscriptions", 12.99, "StreamFlix", "Streaming")
+		for day := 1; day <= 31; day++ {
+			if month.AddDate(0, 0, day-1).Weekday() == time.Saturday {
+				add(day, Expense, "Groceries", around(85, 20), pick("FreshMart", "Green Grocer"), "Weekly shop")
+			}
+		}
+		switch month.Month() {
+		case time.December:
+			for range 3 + rng.IntN(4) {
+				add(1+rng.IntN(24), Expense, "Gifts", between(20, 150), pick("Toy Palace", "Bookshop", "Online Store"), "Christmas gift")
+			}
+		case time.July, time.August:
+			if rng.IntN(2) == 0 || month.Month() == time.August && !slices.ContainsFunc(transactions, func(t Transaction) bool {
+				return t.Category == "Travel" && t.Date.Year() == month.Year()
+			}) {
+				add(1+rng.IntN(28), Expense, "Travel", between(600, 1500), pick("SkyHigh Airlines", "Seaside Hotel"), "Summer holiday")
+			}
+		}
+		for range options.PerMonth - len(planned) {
+			roll := rng.IntN(weights)
+			for _, kind := range everyday {
+				if roll -= kind.weight; roll < 0 {
+					payee := kind.payees[rng.IntN(len(kind.payees))]
+					add(1+rng.IntN(31), Expense, kind.category, between(kind.low, kind.high), payee, payee)
+					break
+				}
+			}
+		}
+		slices.SortStableFunc(planned, func(a, b Transaction) int { return a.Date.Compare(b.Date) })
+		transactions = append(transactions, planned...)
 	}
-	return amount, nil
+	return transactions
 }
 
-// add a new transaction
-func (d *Data) addTransaction(date time.Time, transactionType, category string, amount float64, description string) error {
-	if transactionType != Income && transactionType != Expense {
-		return fmt.Errorf("invalid transaction type: %s", transactionType)
+// a demo book of n transactions over five years up to end, the same on every run; shared with the benchmarks
+func syntheticBook(n int, end time.Time) (*Data, error) {
+	d := &Data{clock: NewFakeClock(end)}
+	transactions := GenerateDemo(DemoOptions{Months: 60, PerMonth: max(n/50, 20), Seed: 1, End: end})
+	for _, transaction := range transactions[max(len(transactions)-n, 0):] { // a few more than needed, the oldest are left out
+		if err := d.appendTransaction(transaction); err != nil {
+			return nil, err
+		}
 	}
-	d.Transactions = append(d.Transactions, Transaction{Date: date, Type: transactionType, Category: category, Amount: amount, Description: description})
-	return nil
+	return d, nil
 }
 
-func (d *Data) importTransactions(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+// the book's transactions as a CSV statement import reads
+func writeSyntheticCSV(w io.Writer, d *Data) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Date", "Type", "Category", "Amount", "Description", "Payee"})
+	for _, t := range d.Transactions {
+		writer.Write([]string{t.Date.Format("2006-01-02"), t.Type, t.Category, strconv.FormatFloat(t.Amount, 'f', 2, 64), t.Description, t.Payee})
 	}
-	defer file.Close()
+	writer.Flush()
+	return writer.Error()
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV data: %w", err)
+// one run of an operation measured by profile and the benchmarks; import reads csvFile, written by writeSyntheticCSV
+func syntheticOperation(book *Data, operation, csvFile string) (func() error, error) {
+	switch operation {
+	case "import":
+		return func() error {
+			_, err := (&Data{clock: book.clock}).importTransactions(context.Background(), csvFile, "", "csv", false)
+			return err
+		}, nil
+	case "summary":
+		return func() error {
+			book.reindex() // count the book rather than read the totals kept from the last run
+			book.calculateSummary(All, "")
+			for month := range 12 {
+				book.calculateSummary(Month, book.today().AddDate(0, -month, 0).Format("2006-01"))
+			}
+			return nil
+		}, nil
+	case "search":
+		return func() error {
+			for _, text := range []string{"noodle", "city metro", "nothing like this"} {
+				for range book.Query(Filter{Text: text}) {
+				}
+			}
+			return nil
+		}, nil
+	case "forecast":
+		return func() error {
+			_, err := book.calculateForecast(defaultForecast)
+			return err
+		}, nil
 	}
+	return nil, fmt.Errorf("unknown operation %q, use import, summary, search or forecast", operation)
+}
 
-	if len(records) <= 1 {
-		return fmt.Errorf("empty or invalid CSV file")
+// run an operation on a synthetic book of the given size, report how long it takes and write CPU and heap
+// profiles for go tool pprof to <out>.cpu.pprof and <out>.heap.pprof
+func profileOperation(operation string, size, iterations int, out string, end time.Time) error {
+	book, err := syntheticBook(size, end)
+	if err != nil {
+		return err
 	}
-
-	for i, record := range records[1:] {
-		if len(record) != 5 {
-			fmt.Printf("Skipping record %d due to invalid number of fields: %v\n", i+2, record) 
-			continue
-		}
-		date, err := parseDate(record[0])
+	csvFile := ""
+	if operation == "import" {
+		file, err := os.CreateTemp("", "profile-*.csv")
 		if err != nil {
-			fmt.Printf("Skipping record %d due to invalid date: %v, error: %v\n", i+2, record, err)
-			continue
+			return err
 		}
-		transactionType := record[1]
-		category := record[2]
-		amount, err := parseFloat(record[3])
-		if err != nil {
-			fmt.Printf("Skipping record %d due to invalid amount: %v, error: %v\n", i+2, record, err)
-			continue
+		defer os.Remove(file.Name())
+		if err := cmp.Or(writeSyntheticCSV(file, book), file.Close()); err != nil {
+			return err
 		}
-		description := record[4]
+		csvFile = file.Name()
+	}
+	run, err := syntheticOperation(book, operation, csvFile)
+	if err != nil {
+		return err
+	}
 
-		err = d.addTransaction(date, transactionType, category, amount, description)
-		if err != nil {
-			fmt.Printf("Skipping record %d due to error: %v, error: %v \n", i+2, record, err)
-			continue
+	cpu, err := os.Create(out + ".cpu.pprof")
+	if err != nil {
+		return fmt.Errorf("failed to create CPU profile: %w", err)
+	}
+	defer cpu.Close()
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		return err
+	}
+	var timings []time.Duration
+	for range iterations {
+		start := time.Now()
+		if err := run(); err != nil {
+			pprof.StopCPUProfile()
+			return err
 		}
+		timings = append(timings, time.Since(start))
+	}
+	pprof.StopCPUProfile()
+	heap, err := os.Create(out + ".heap.pprof")
+	if err != nil {
+		return fmt.Errorf("failed to create heap profile: %w", err)
+	}
+	defer heap.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(heap); err != nil {
+		return err
+	}
+
+	slices.Sort(timings)
+	var total time.Duration
+	for _, timing := range timings {
+		total += timing
 	}
+	fmt.Printf("%s on %d transactions, %d runs: fastest %v, median %v, mean %v\n", operation, len(book.Transactions), iterations,
+		timings[0].Round(time.Microsecond), timings[len(timings)/2].Round(time.Microsecond), (total / time.Duration(iterations)).Round(time.Microsecond))
+	fmt.Printf("Profiles written to %s.cpu.pprof and %s.heap.pprof, see them with: go tool pprof %s.cpu.pprof\n", out, out, out)
 	return nil
 }
-func (d *Data) calculateSummary(period string, periodValue string) (float64, float64, map[string]float64) {
-	totalIncome := 0.0
-	totalExpenses := 0.0
-	categorySummary := make(map[string]float64)
 
-	for _, transaction := range d.Transactions {
-		include := false
-		switch period {
-		case Month:
-			inputTime, _ := time.Parse("2006-01", periodValue)
-			if transaction.Date.Year() == inputTime.Year() && transaction.Date.Month() == inputTime.Month() {
-				include = true
+// what shell completion offers after a command; flags ending in = take a value
+type completionSpec struct {
+	flags       []string
+	subcommands map[string][]string // the flags of each subcommand
+	args        string              // what the arguments are: category, account, command or a list of words; files when empty
+}
+
+var filterCompletions = []string{"period=", "from=", "to=", "type=", "category=", "account=", "payee=", "search=", "meta=", "tag=", "status=", "fiscal"}
+
+var globalCompletions = []string{"data=", "output=", "no-color", "books=", "read-only", "as-of=", "widget"}
+
+var configSettings = []string{"fiscal-year-start", "decimals", "compact", "locale", "timezone", "week-start", "notify-webhook", "notify-smtp",
+	"notify-smtp-user", "notify-from", "notify-to", "archive-after", "backup-keep", "git", "auto-categorize"}
+
+// kept in step with runCommand, commands only the interactive mode knows are left out
+var commandCompletions = map[string]completionSpec{
+	"add":              {flags: []string{"date=", "type=", "category=", "amount=", "description=", "payee=", "account=", "reimbursable=", "from-text="}},
+	"review":           {subcommands: map[string][]string{"list": nil, "accept": nil, "reject": nil}},
+	"sms":              {subcommands: map[string][]string{"list": nil, "add": {"pattern=", "type=", "date-layout=", "category=", "account="}, "remove": nil, "test": nil}},
+	"import":           {flags: []string{"account=", "format=", "strict"}},
+	"bot":              {subcommands: map[string][]string{"telegram": {"chat=", "api="}}},
+	"watch":            {flags: []string{"archive=", "account=", "format=", "interval=", "once"}},
+	"batches":          {subcommands: map[string][]string{"list": nil, "rollback": nil}},
+	"allocate":         {args: "-"},
+	"envelopes":        {args: "-"},
+	"budget":           {args: "category"},
+	"convert-currency": {},
+	"config":           {args: strings.Join(configSettings, " ")},
+	"backup":           {flags: []string{"gzip", "keep="}, subcommands: map[string][]string{"list": nil}},
+	"restore":          {},
+	"note":             {args: "category"},
+	"report": {subcommands: map[string][]string{
+		"budget": {"period=", "fiscal"}, "networth": {"interval=", "inflation-adjust"}, "top": {"period=", "fiscal", "n="},
+		"send": {"month=", "format=", "schedule"}, "pdf": {"period=", "out="}, "html": {"period=", "fiscal", "out="},
+		"tax": {"year=", "fiscal", "out="},
+	}},
+	"account":          {subcommands: map[string][]string{"list": nil, "add": {"kind=", "opening=", "date="}}},
+	"reconcile":        {flags: []string{"balance=", "date="}, args: "account"},
+	"loan":             {subcommands: map[string][]string{"list": nil, "add": {"principal=", "rate=", "term=", "start="}, "link": nil, "schedule": {"extra="}, "status": {"extra="}, "remove": nil}},
+	"deductible":       {subcommands: map[string][]string{"list": nil, "category": nil, "transaction": nil}},
+	"share":            {flags: []string{"paid-by="}, args: "-"},
+	"settle":           {subcommands: map[string][]string{"pay": {"date="}}},
+	"reimburse":        {subcommands: map[string][]string{"mark": nil, "unmark": nil, "paid": {"date=", "category=", "account="}}},
+	"reimbursements":   {args: "-"},
+	"mileage":          {flags: []string{"date=", "rate=", "payer=", "account="}, args: "-"},
+	"recurring":        {subcommands: map[string][]string{"list": nil, "add": {"every=", "start=", "type=", "category=", "amount=", "description=", "account="}, "remove": nil}},
+	"safetospend":      {args: "-"},
+	"bills":            {subcommands: map[string][]string{"due": {"days="}}},
+	"suggest":          {flags: []string{"payee=", "n="}, args: "-"},
+	"categorize":       {flags: []string{"dry-run", "threshold="}, args: "-"},
+	"holding":          {subcommands: map[string][]string{"set": {"cost=", "date="}, "remove": nil}},
+	"price":            {subcommands: map[string][]string{"set": {"date="}, "update": nil}},
+	"portfolio":        {args: "-"},
+	"check":            {args: "-"},
+	"export":           {flags: []string{"format="}},
+	"anonymize-export": {},
+	"demo":             {subcommands: map[string][]string{"generate": {"months=", "tx-per-month=", "seed=", "end=", "out="}}},
+	"attach":           {},
+	"open-attachment":  {args: "-"},
+	"delete":           {args: "-"},
+	"trash":            {subcommands: map[string][]string{"list": nil, "restore": nil, "purge": {"all", "older-than="}}},
+	"annotate":         {flags: []string{"notes=", "meta="}, args: "-"},
+	"merge":            {flags: []string{"prefer="}},
+	"sync":             {flags: []string{"prefer="}},
+	"diff":             {},
+	"list":             {flags: append([]string{"sort=", "desc", "limit=", "offset="}, filterCompletions...), args: "-"},
+	"compare":          {flags: []string{"a=", "b=", "fiscal", "top=", "inflation-adjust"}, args: "-"},
+	"stats":            {flags: filterCompletions, args: "-"},
+	"summary":          {flags: []string{"period=", "fiscal", "payee=", "status="}, args: "-"},
+	"project":          {subcommands: map[string][]string{"fire": {"return=", "withdrawal=", "savings=", "expenses=", "net-worth=", "years="}}},
+	"cpi":              {subcommands: map[string][]string{"list": nil, "set": nil, "import": nil}},
+	"predict":          {flags: []string{"months=", "history=", "simulations=", "band=", "seasonal", "scenario="}, args: "-"},
+	"alert":            {subcommands: map[string][]string{"list": nil, "add": {"category=", "single", "notify"}, "remove": nil}},
+	"webhook":          {subcommands: map[string][]string{"list": nil, "add": {"events=", "secret="}, "remove": nil, "test": nil}},
+	"bank":             {subcommands: map[string][]string{"list": nil, "link": {"provider=", "token=", "account=", "environment="}, "remove": nil, "sync": nil, "template": {"from=", "amount=", "date=", "merchant=", "category="}}},
+	"rule":             {subcommands: map[string][]string{"list": nil, "add": nil, "remove": nil}},
+	"plugins":          {args: "-"},
+	"anomalies":        {flags: []string{"period=", "sigma="}, args: "-"},
+	"category":         {subcommands: map[string][]string{"list": nil, "rename": nil, "merge": nil, "allow": nil, "disallow": nil, "archived": nil, "unarchive": nil}, args: "category"},
+	"serve":            {flags: []string{"addr=", "token=", "users="}, args: "-"},
+	"user":             {subcommands: map[string][]string{"list": {"users="}, "add": {"users=", "role=", "data="}, "remove": {"users="}}},
+	"run":              {flags: []string{"keep-going"}},
+	"completion":       {args: "bash zsh fish"},
+	"help":             {args: "-"},
+}
+
+// the values a flag can take, the shell offers files for the others
+func (d *Data) flagCompletions(flag string) []string {
+	switch flag {
+	case "category":
+		return d.categories()
+	case "account":
+		return d.accountNames()
+	case "payee":
+		return d.payees()
+	case "type":
+		return []string{Income, Expense}
+	case "period", "interval":
+		return []string{Week, Month, Year}
+	case "every":
+		return []string{"week", "month", "year"}
+	case "output":
+		return []string{"table", "json", "csv"}
+	case "prefer":
+		return []string{"newer", "ask", "local", "remote"}
+	case "kind":
+		return []string{"asset", "liability", "investment"}
+	case "role":
+		return []string{roleEditor, roleViewer}
+	case "status":
+		return statusFilters
+	}
+	return nil
+}
+
+// the candidates for the last of words, the command line after the program name; book is asked for the
+// book named by -data, "" when none was given, to complete categories and accounts
+func completeWords(words []string, book func(dataFile string) *Data) []string {
+	current := words[len(words)-1]
+	var command, subcommand, dataFile, valueOf string
+	var spec completionSpec
+	for _, word := range words[:len(words)-1] {
+		switch {
+		case valueOf != "":
+			if valueOf == "data" && command == "" {
+				dataFile = word
 			}
-		case Year:
-			inputTime, _ := time.Parse("2006", periodValue)
-			if transaction.Date.Year() == inputTime.Year() {
-				include = true
+			valueOf = ""
+		case strings.HasPrefix(word, "-"):
+			name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if name == "data" && hasValue && command == "" {
+				dataFile = value
 			}
-		case All:
-			include = true
-		}
-
-		if include {
-			if transaction.Type == Income {
-				totalIncome += transaction.Amount
-			} else if transaction.Type == Expense {
-				totalExpenses += transaction.Amount
+			flags := globalCompletions
+			if command != "" {
+				flags = slices.Concat(spec.flags, spec.subcommands[subcommand])
 			}
-			categorySummary[transaction.Category] += transaction.Amount
+			if !hasValue && slices.Contains(flags, name+"=") {
+				valueOf = name
+			}
+		case command == "":
+			command, spec = word, commandCompletions[word]
+		case subcommand == "" && spec.subcommands != nil:
+			subcommand = word
 		}
 	}
-	return totalIncome, totalExpenses, categorySummary
-}
-func (d *Data) displaySummary(period string, periodValue string) {
-	totalIncome, totalExpenses, categorySummary := d.calculateSummary(period, periodValue)
-	fmt.Printf("Income: %.2f\n", totalIncome)
-	fmt.Printf("Expenses: %.2f\n", totalExpenses)
-	fmt.Printf("Net Balance: %.2f\n", totalIncome-totalExpenses)
-	fmt.Println("Category Summary:")
-	for category, amount := range categorySummary {
-		fmt.Printf("  %s: %.2f\n", category, amount)
+	var candidates []string
+	switch {
+	case valueOf != "":
+		candidates = book(dataFile).flagCompletions(valueOf)
+	case strings.HasPrefix(current, "-"):
+		flags := globalCompletions
+		if command != "" {
+			flags = slices.Concat(spec.flags, spec.subcommands[subcommand])
+		}
+		for _, flag := range flags {
+			candidates = append(candidates, "--"+strings.TrimSuffix(flag, "="))
+		}
+	case command == "" || command == "help":
+		candidates = slices.Sorted(maps.Keys(commandCompletions))
+	case subcommand == "" && spec.subcommands != nil:
+		candidates = slices.Sorted(maps.Keys(spec.subcommands))
+	case spec.args == "category":
+		candidates = book(dataFile).categories()
+	case spec.args == "account":
+		candidates = book(dataFile).accountNames()
+	case spec.args != "" && spec.args != "-":
+		candidates = strings.Fields(spec.args)
 	}
+	return completions(current, candidates)
 }
-func (d *Data) predictExpenses(months int) ([]float64, []float64) {
-	expenses := make([]float64, 0)
-	for _, transaction := range d.Transactions {
-		if transaction.Type == Expense {
-			expenses = append(expenses, transaction.Amount)
+
+// completion in the interactive mode: the line up to the word being completed and the candidates for that word,
+// quoted when they hold spaces and followed by a space
+func (d *Data) completeCommand(text string) (string, []string) {
+	start := strings.LastIndexAny(text, " \t") + 1
+	words := append(splitArgs(text[:start]), strings.TrimPrefix(text[start:], `"`))
+	candidates := completeWords(words, func(string) *Data { return d })
+	if len(words) == 1 {
+		candidates = slices.Sorted(slices.Values(append(candidates, completions(words[0], []string{"split", "setup", "exit"})...)))
+	}
+	for i, candidate := range candidates {
+		if strings.ContainsAny(candidate, " \t") {
+			candidate = strconv.Quote(candidate)
 		}
+		candidates[i] = candidate + " "
 	}
+	return text[:start], candidates
+}
 
-	predictedExpenses := make([]float64, months)
-	predictedNetBalance := make([]float64, months)
-    totalIncome, _, _ := d.calculateSummary(All, "") 
-	if len(expenses) > 0 {
-		lastExpense := expenses[len(expenses)-1]
-		for i := 0; i < months; i++ {
-			predictedExpenses[i] = lastExpense * (1 + 0.1*float64(i+1)) // 10% increase per month
-            predictedNetBalance[i] = totalIncome - predictedExpenses[i]
-		}
-	} else {
-        for i := 0; i < months; i++{
-            predictedExpenses[i] = 0
-            predictedNetBalance[i] = totalIncome
-        }
-    }
-	return predictedExpenses, predictedNetBalance
+// scripts that ask the program itself for the candidates, so they know the book's categories and accounts
+const (
+	bashCompletion = `# bash completion for {{program}}, load with: source <({{program}} completion bash)
+_{{function}}() {
+	local IFS=$'\n'
+	COMPREPLY=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | while read -r word; do printf '%q\n' "$word"; done))
 }
-func (d *Data) displayPredictions(months int) {
-	predictedExpenses, predictedNetBalance := d.predictExpenses(months)
-	fmt.Println("Predicted Expenses for the next", months, "months:")
-	for i, expense := range predictedExpenses {
-		fmt.Printf("  Month %d: %.2f\n", i+1, expense)
-	}
-    fmt.Println("Predicted Net Balance for the next", months, "months:")
-	for i, balance := range predictedNetBalance{
-		fmt.Printf("  Month %d: %.2f\n", i+1, balance)
+complete -o default -F _{{function}} {{program}}
+`
+	zshCompletion = `#compdef {{program}}
+# zsh completion for {{program}}, load with: source <({{program}} completion zsh)
+_{{function}}() {
+	local -a candidates
+	candidates=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _{{function}} {{program}}
+`
+	fishCompletion = `# fish completion for {{program}}, load with: {{program}} completion fish | source
+function __{{function}}_complete
+	set -l tokens (commandline -opc) (commandline -ct)
+	$tokens[1] __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c {{program}} -a '(__{{function}}_complete)'
+`
+)
+
+func printCompletionScript(shell string) error {
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	script, ok := scripts[shell]
+	if !ok {
+		return fmt.Errorf("usage: completion bash|zsh|fish")
 	}
+	program := filepath.Base(os.Args[0])
+	function := regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(program, "_")
+	_, err := strings.NewReplacer("{{program}}", program, "{{function}}", function).WriteString(os.Stdout, script)
+	return err
 }
 
 //display
 func displayHelp() {
 	fmt.Println("Available commands:")
-	fmt.Println("  add    Add a new transaction")
-	fmt.Println("  import Import transactions from a CSV file")
+	fmt.Println("  add    Add a new transaction, or in one line: add 12.50 Food \"lunch\" @2024-03-05 #work (+ before the amount for income);\n         amounts may be sums like 12.99+4.50*2 and start with a currency like €30 or US$45, $ alone only in a dollar book")
+	fmt.Println("  split  Add a transaction split across several categories")
+	fmt.Println("  import Import transactions from CSV, .xlsx, OFX, QIF or JSON, or a Mint or YNAB export; the format is detected or set with --format, --strict stops at the first bad row;\n         import - reads pasted or piped rows from stdin")
+	fmt.Println("  watch  Import new CSV, OFX, QIF or Excel files dropped into a folder and move them to <dir>/imported, --once for a single pass")
+	fmt.Println("  batches List the imports (batches list) or undo a whole one (batches rollback <id>)")
+	fmt.Println("  allocate Put income into a category envelope for a month (allocate 2024-03 Food 500), envelopes [YYYY-MM] shows what is left")
+	fmt.Println("  budget Set the monthly budget for a category, budget rollover <category> on carries what is left into the next month")
+	fmt.Println("  convert-currency Convert the whole book to another base currency using historical rates")
+	fmt.Println("  config Change a setting (fiscal-year-start, decimals, compact, locale, timezone, week-start, archive-after, notify-*, backup-*, git to commit every change)")
+	fmt.Println("  backup Copy the data file to the backups directory (backup [--gzip] [--keep n], backup list)")
+	fmt.Println("  restore Replace the data file with a backup, imports are backed up automatically (restore <backup>)")
+	fmt.Println("  note   Attach a note or target to a category")
+	fmt.Println("  report Display a budget vs. actual, net worth or top spending report, send last month's statement (report send [--format html] [--schedule]), write it as a PDF (report pdf [--period YYYY-MM] [--out file]) or an HTML dashboard (report html [--period year] [--out file])")
+	fmt.Println("  account Add an account with its opening balance")
+	fmt.Println("  reconcile Match an account against a bank statement (reconcile <account> [--balance amount] [--date d], reconcile clear|unclear <id>...)")
+	fmt.Println("  loan   Track loans and their payments (loan add, loan link <name> <id>..., loan schedule|status [--extra n] <name>)")
+	fmt.Println("  deductible Mark categories or single expenses as tax-deductible (deductible category <name> on|off, deductible transaction <id>... on|off|inherit)")
+	fmt.Println("  report health Show savings rate, expenses over income and emergency fund months for the past year with trendlines")
+	fmt.Println("  report tax Total the deductible expenses of a tax year (report tax [--year YYYY] [--fiscal] [--out file.csv])")
+	fmt.Println("  share  Share an expense between people by weight, me is the book's owner (share <id> [--paid-by name] <person>[:weight]..., share <id> off)")
+	fmt.Println("  settle Show who owes whom for shared expenses and how to settle up (settle pay <from> <to> <amount> records a payment)")
+	fmt.Println("  reimbursements Show expenses waiting to be paid back by payer (add --reimbursable <payer>, reimburse mark <payer> <id>..., reimburse paid <payer> [<id>...])")
+	fmt.Println("  mileage Add a trip as an expense at the mileage rate (mileage [--rate amount] [--payer name] <distance> <description>)")
+	fmt.Println("  recurring Schedule repeating bills and income (recurring add [--every month] --category c --amount n <name>, recurring remove <name>)")
+	fmt.Println("  bills  List the recurring bills due in the next days and the unpaid ones from last week (bills due [--days 14])")
+	fmt.Println("  safetospend Show how much can be spent per day and week for the rest of the month after unpaid bills")
+	fmt.Println("  suggest Rank the likely categories of a transaction learned from your history (suggest <id> | suggest [--payee p] <description>)")
+	fmt.Println("  categorize File uncategorized transactions the classifier is sure about (categorize [--threshold 0.8] [--dry-run]), config auto-categorize 0.9 does it on import")
+	fmt.Println("  portfolio Show holdings at market value with unrealized gains (holding set [--cost n] <account> <symbol> <units>, price set <symbol> <price>, price update)")
+	fmt.Println("  check  Warn about accounts that have not received data for longer than usual")
+	fmt.Println("  export Write the book as a ledger/hledger journal, beancount file or Excel workbook (export [--format ledger|beancount|xlsx] [file]);\n         export bills.ics writes the recurring bills as a calendar")
+	fmt.Println("  anonymize-export Write a scrambled copy of the book for bug reports")
+	fmt.Println("  setup  Run the guided setup again")
+	fmt.Println("  demo   Fill an empty book, or a new one with --out, with realistic sample data to explore (demo generate [--months 24] [--tx-per-month 200] [--seed n])")
+	fmt.Println("  attach Link a receipt file to a transaction")
+	fmt.Println("  open-attachment Open the receipts linked to a transaction")
+	fmt.Println("  delete Move transactions to the trash (delete <id>...), trash list|restore <id>...|purge <id>... or purge --all [--older-than days]")
+	fmt.Println("  annotate Show or change the notes and metadata of a transaction (annotate <id> [--notes text] [--meta invoice=INV-42]...), list --search and --meta find them")
+	fmt.Println("  merge  Merge another copy of the book, resolving conflicting edits")
+	fmt.Println("  sync   Keep two copies of the book in step, e.g. through a shared folder (sync [--prefer newer] <file>, sync conflicts)")
+	fmt.Println("  diff   Show transactions added, removed or modified between two data files")
+	fmt.Println("  list   List transactions (list [--sort date|amount|category] [--desc] [--limit n] [--offset n] [--period p] [--category c] ...)")
+	fmt.Println("  compare Compare income, expenses and categories of two periods (compare --a 2024-02 --b 2024-03, compare --a 2023 --b 2024)")
+	fmt.Println("  stats  Show the count, total, mean, median, min and max spend per category and the daily and weekly average (stats [--period p] ...)")
 	fmt.Println("  summary Display a summary of income, expenses, and net balance")
-	fmt.Println("  predict Display predicted expenses and net balance")
+	fmt.Println("  project fire Project the years to financial independence from net worth and savings (project fire [--return 5] [--withdrawal 4] [--savings n] [--expenses n])")
+	fmt.Println("  cpi    Keep a consumer price index table for --inflation-adjust on compare and report networth (cpi set <YYYY-MM> <index>, cpi import <file or URL>)")
+	fmt.Println("  predict Display predicted expenses and net balance with a low to high band (predict [--months 3] [--band 80] [--seasonal] [--scenario \"income +5%\"]...)")
+	fmt.Println("  alert  List, add or remove spending alerts (alert add [--category name] [--single] [--notify] <limit>)")
+	fmt.Println("  review Confirm, edit or reject the pending transactions bank sync, mail and texts brought in (review list|accept|reject)")
+	fmt.Println("         reports count them unless given --status posted")
+	fmt.Println("  sms    Read bank alert texts: add --from-text \"...\" adds one, sms add <name> --pattern re teaches a bank's format,")
+	fmt.Println("         sms test \"...\" shows how a text is read; serve takes them at POST /api/transactions/text")
+	fmt.Println("  bot    Add transactions and ask for summaries from chat: bot telegram --chat <id> with FINANCE_TELEGRAM_TOKEN set,")
+	fmt.Println("         or a Slack slash command pointed at serve's /slack/command with FINANCE_SLACK_SIGNING_SECRET set")
+	fmt.Println("  webhook  List, add, remove or test webhooks fired on transaction and budget events (webhook add [--events list] <url>)")
+	fmt.Println("  bank   Link banks and sync their transactions (bank link --token t [--account name] <name>, bank sync [name])")
+	fmt.Println("         e-receipts: bank link --provider imap --environment imaps://me@host/Receipts --token password <name>, then")
+	fmt.Println("         bank template add <name> --from sender --amount 'Total: ([\\d.,]+)' per shop; synced transactions wait in review")
+	fmt.Println("  rule   Categorize synced transactions by payee or description (rule add <text> <category>)")
+	fmt.Println("  plugins  List the finance-<name> programs on PATH that add import formats, reports (report <name>) or bank providers")
+	fmt.Println("  anomalies Flag expenses far above their category's usual month and large first payments to new payees")
+	fmt.Println("  category List, rename or merge categories, limit new transactions to allowed ones, or bring archived ones back (category list|rename|merge|allow|disallow|archived|unarchive)")
+	fmt.Println("  serve  Serve the web UI, REST API and gRPC API (finance.proto) for the data file (serve [--addr host:port] [--token t])")
+	fmt.Println("         Prometheus can scrape this month's totals, category spending and budget use from /metrics")
+	fmt.Println("  user   Manage who may use serve mode (user list|add|remove, user add [--role viewer] [--data file] <name>)")
+	fmt.Println("  run    Run the commands of a script file, or stdin with -")
+	fmt.Println("  completion Print a completion script for bash, zsh or fish, e.g. source <(finance completion bash)")
 	fmt.Println("  help   Display this help message")
 	fmt.Println("  exit   Exit the application")
 }
 
 func main() {
-	data := Data{}
+	dataFile := flag.String("data", rememberedDataFile(), "path of the data file")
+	widget := flag.Bool("widget", false, "print a single status line with remaining budget and month-to-date spend")
+	books := flag.String("books", "", "comma separated data files to report on together, read-only")
+	readOnly := flag.Bool("read-only", false, "open the book without taking its lock, changes are not saved")
+	asOf := flag.String("as-of", "", "report as if run at the end of this day `YYYY-MM-DD`, later transactions are hidden and changes are not saved")
+	flag.StringVar(&outputFormat, "output", "table", "report format: table, json or csv")
+	flag.BoolVar(&noColor, "no-color", false, "disable colored output")
+	verbose := flag.Bool("verbose", false, "log diagnostics such as skipped import rows")
+	quiet := flag.Bool("quiet", false, "log errors only, no warnings")
+	logFormat := flag.String("log-format", "text", "diagnostics format on stderr: text or json")
+	flag.Parse()
+
+	if outputFormat != "table" && outputFormat != "json" && outputFormat != "csv" {
+		fmt.Fprintln(os.Stderr, "Error: --output must be table, json or csv")
+		os.Exit(2)
+	}
+	if err := setupLogging(*verbose, *quiet, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+
+	if flag.Arg(0) == "__complete" { // asked by the completion scripts, see completion
+		words := flag.Args()[1:]
+		if len(words) == 0 {
+			words = []string{""}
+		}
+		book := func(dataFile string) *Data {
+			if d, err := loadData(cmp.Or(dataFile, rememberedDataFile())); err == nil {
+				return d
+			}
+			return &Data{}
+		}
+		for _, candidate := range completeWords(words, book) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+
+	if *widget {
+		if err := displayWidget(*dataFile, *asOf); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	_, statErr := os.Stat(*dataFile)
+	firstRun := errors.Is(statErr, os.ErrNotExist) && *books == ""
+
+	var data *Data
+	var err error
+	if *books != "" {
+		data, err = loadConsolidated(strings.Split(*books, ","))
+	} else {
+		data, err = loadData(*dataFile)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if *asOf != "" {
+		date, err := data.parseDateInput(*asOf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: --as-of:", err)
+			os.Exit(2)
+		}
+		data.showAsOf(date)
+	}
+	// a book another process has open is still readable, only saving it fails
+	var lock *fileLock
+	switch {
+	case *readOnly || *asOf != "":
+		data.readOnly = true
+	case *books == "" && !isRemote(*dataFile):
+		if lock, err = lockDataFile(*dataFile); err != nil {
+			data.readOnly, data.lockedBy = true, err
+		}
+	}
+	// stopping serve or the interactive mode with Ctrl+C must not leave the lock behind,
+	// a long command like import stops on its own instead when it asked to
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for received := range stop {
+			if received != os.Interrupt || !interruptCommand() {
+				lock.release()
+				os.Exit(130)
+			}
+		}
+	}()
+	if flag.NArg() > 0 {
+		err := runCommand(data, *dataFile, flag.Args())
+		lock.release()
+		webhookOutbox.flush(15 * time.Second)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	defer lock.release()
+	defer webhookOutbox.flush(15 * time.Second)
 	fmt.Println("Welcome to Personal Finance Tracker!")
+	if data.lockedBy != nil {
+		fmt.Printf("Warning: %v\nThe book is open read-only, changes will not be saved.\n", data.lockedBy)
+	}
+	if !data.asOf.IsZero() {
+		fmt.Printf("Showing the book as of %s, later transactions are hidden and changes will not be saved.\n", data.asOf.Format("2006-01-02"))
+	}
+	// the wizard needs someone to answer it, piped commands would be taken as its answers
+	switch {
+	case firstRun && stdinIsTerminal():
+		if *dataFile, err = runSetupWizard(data, *dataFile); err != nil {
+			fmt.Println("Error:", err)
+		}
+	case firstRun:
+		if err := data.save(*dataFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}
+	data.displayStaleWarnings(data.today())
+	data.displayBillReminder()
+	if pending := len(data.pendingTransactions()); pending > 0 {
+		fmt.Printf("%d transactions wait for review, see them with: review\n", pending)
+	}
 	displayHelp()
 
+	lineHistory[commandLabel] = loadCommandHistory()
 	for {
-		fmt.Print("\nEnter command: ")
-		var command string
-		fmt.Scanln(&command)
-
-		command = strings.ToLower(command) 
+		fmt.Println()
+		line, err := data.readCommand()
+		if err != nil && line == "" {
+			fmt.Println("\nExiting...")
+			return
+		}
+		args := splitArgs(line)
+		if len(args) == 0 {
+			continue
+		}
+		command := strings.ToLower(args[0])
+		// commands given with arguments behave exactly like on the command line
+		if len(args) > 1 {
+			if err := runCommand(data, *dataFile, args); err != nil {
+				fmt.Println("Error:", err)
+			}
+			continue
+		}
+		if err := data.refuseInView(command, nil); err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
 
 		switch command {
 		case "add":
-			var dateStr, transactionType, category, description string
-			var amountStr string
-
-			fmt.Print("Date (YYYY-MM-DD): ")
-			fmt.Scanln(&dateStr)
-			date, err := parseDate(dateStr)
+			date, err := data.parseDateInput(editLine("Date (YYYY-MM-DD)", data.today().Format("2006-01-02"), nil))
 			if err != nil {
 				fmt.Println("Error:", err)
 				break
 			}
 
-			fmt.Print("Type (Income/Expense): ")
-			fmt.Scanln(&transactionType)
+			transactionType := editLine("Type (Income/Expense)", Expense, []string{Income, Expense})
+			if matches := completions(transactionType, []string{Income, Expense}); len(matches) == 1 {
+				transactionType = matches[0]
+			}
 
-			fmt.Print("Category: ")
-			fmt.Scanln(&category)
+			category := data.confirmCategory(editLine("Category", "", data.categories()))
 
-			fmt.Print("Amount: ")
-			fmt.Scanln(&amountStr)
-			amount, err := parseFloat(amountStr)
+			amount, currency, err := data.parseTransactionAmount(editLine("Amount", "", nil))
 			if err != nil {
 				fmt.Println("Error:", err)
 				break
 			}
 
-			fmt.Print("Description: ")
-			fmt.Scanln(&description)
+			description := editLine("Description", "", nil)
+			payee := editLine("Payee (optional)", "", data.payees())
+			account := editLine("Account (optional)", "", data.accountNames())
+
+			count := len(data.Transactions)
+			transaction := Transaction{Date: date, Type: transactionType, Category: category, Amount: amount, Currency: currency, Description: description,
+				Account: account, Payee: payee}
+			err = data.appendTransaction(transaction)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Printf("Transaction %d added successfully.\n", data.lastID)
+				data.noteForeignCurrency(transaction)
+				data.raiseAlerts(count)
+			}
 
-			err = data.addTransaction(date, transactionType, category, amount, description)
+		case "split":
+			date, err := data.parseDateInput(prompt("Date (YYYY-MM-DD)", ""))
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			transaction := Transaction{Date: date, Category: splitCategory}
+			transaction.Type = prompt("Type (Income/Expense)", Expense)
+			if transaction.Amount, err = parseFloat(prompt("Total amount", "")); err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			transaction.Description = prompt("Description", "")
+			transaction.Payee = prompt("Payee (optional)", "")
+			transaction.Account = prompt("Account (optional)", "")
+			remaining := transaction.Amount
+			for math.Abs(remaining) >= 0.005 {
+				fmt.Printf("Line item, %s left to assign\n", data.formatAmount(remaining))
+				category := prompt("  Category (empty cancels)", "")
+				if category == "" {
+					break
+				}
+				amount, err := parseFloat(prompt("  Amount", strconv.FormatFloat(remaining, 'f', 2, 64)))
+				if err != nil {
+					fmt.Println("  Error:", err)
+					continue
+				}
+				transaction.Splits = append(transaction.Splits, Split{Category: category, Amount: amount})
+				remaining -= amount
+			}
+			count := len(data.Transactions)
+			err = data.appendTransaction(transaction)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
 			if err != nil {
 				fmt.Println("Error:", err)
 			} else {
-				fmt.Println("Transaction added successfully.")
+				fmt.Printf("Split transaction %d added successfully.\n", data.lastID)
+				data.raiseAlerts(count)
 			}
 
 		case "import":
-			var filename string
-			fmt.Print("Enter CSV filename: ")
-			fmt.Scanln(&filename)
-			err := data.importTransactions(filename)
+			filename := prompt("Enter filename", "")
+			account := prompt("Account (optional)", "")
+			format := prompt("Format (csv/mint/ynab/ofx/qif/json, empty to detect)", "")
+			data.backupBefore(*dataFile, "pre-import")
+			count := len(data.Transactions)
+			result, err := data.importInteractively(filename, account, format, false)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
 			if err != nil {
 				fmt.Println("Error:", err)
 			} else {
-				fmt.Println("Transactions imported successfully.")
+				displayImportResult(result)
+				data.raiseAlerts(count)
 			}
 
-		case "summary":
-			var period, periodValue string
-			fmt.Print("Time period (month/year/all): ")
-			fmt.Scanln(&period)
-			period = strings.ToLower(period) //forgiving input
+		case "budget":
+			category := prompt("Category", "")
+			amountStr := prompt("Monthly budget (0 removes it)", "")
+			amount, err := parseFloat(amountStr)
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			err = data.setBudget(category, amount)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Println("Budget saved.")
+			}
+
+		case "convert-currency":
+			from := prompt("From currency", "")
+			to := prompt("To currency", "")
+			ratesFile := prompt("Rates CSV filename (date,from,to,rate)", "")
+			migration, err := data.migrateCurrency(from, to, ratesFile)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			data.displayMigration(migration)
 
+		case "summary":
+			period := strings.ToLower(prompt("Time period (week/month/year/fiscal/all)", "")) //forgiving input
+			var periodValue string
 			switch period {
+			case Week:
+				periodValue = prompt(fmt.Sprintf("Any day of the week starting %s (YYYY-MM-DD)", data.Settings.WeekStart), data.today().Format("2006-01-02"))
 			case Month:
-				fmt.Print("Month (YYYY-MM): ")
-				fmt.Scanln(&periodValue)
-				if _, err := time.Parse("2006-01", periodValue); err != nil {
-					fmt.Println("Error: Invalid month format. Please use YYYY-MM.")
-					break
-				}
+				periodValue = prompt("Month (YYYY-MM)", "")
 			case Year:
-				fmt.Print("Year (YYYY): ")
-				fmt.Scanln(&periodValue)
-				if _, err := time.Parse("2006", periodValue); err != nil {
-					fmt.Println("Error: Invalid year format. Please use YYYY.")
-					break
+				periodValue = prompt("Year (YYYY)", "")
+			case Fiscal:
+				periodValue = prompt(fmt.Sprintf("Fiscal year starting %s (YYYY)", data.Settings.fiscalYearStart()), "")
+			}
+			parsed, err := data.ParsePeriod(period, periodValue)
+			if err == nil {
+				err = data.displaySummary(parsed.Kind, parsed.Value, Filter{})
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "config":
+			key := prompt("Setting", "")
+			value := prompt("Value", "")
+			err := data.setConfig(key, value)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Println("Setting saved.")
+			}
+
+		case "account":
+			name := prompt("Account name", "")
+			kind := prompt("Kind (asset/liability)", "")
+			balanceStr := prompt("Opening balance", "")
+			balance, err := parseFloat(balanceStr)
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			dateStr := prompt("Opening date (YYYY-MM-DD)", "")
+			date, err := data.parseDateInput(dateStr)
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+			err = data.setAccount(Account{Name: name, Kind: kind, OpeningBalance: balance, OpeningDate: date})
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Println("Account saved.")
+			}
+
+		case "attach", "open-attachment":
+			id, err := strconv.Atoi(prompt("Transaction ID", ""))
+			if err != nil {
+				fmt.Println("Error: invalid transaction ID")
+				break
+			}
+			if command == "open-attachment" {
+				if err := data.openAttachments(*dataFile, id); err != nil {
+					fmt.Println("Error:", err)
+				}
+				break
+			}
+			filename := prompt("File", "")
+			stored, err := data.attach(*dataFile, id, filename)
+			if total, ok, _ := data.checkReceipt(id, filename); err == nil && !ok {
+				transaction, _ := data.findTransaction(id)
+				fmt.Printf("Warning: receipt total %s does not match the transaction amount %s\n", data.formatAmount(total), data.formatAmount(transaction.Amount))
+				if strings.HasPrefix(strings.ToLower(prompt("Use the receipt total as the amount? (y/n)", "n")), "y") {
+					if len(transaction.Splits) > 0 {
+						fmt.Println("Error: split transactions have to be corrected line by line")
+					} else {
+						transaction.Amount = total
+						data.edited()
+					}
+				}
+			}
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Println("Attached as", stored)
+			}
+
+		case "merge":
+			filename := prompt("Other data file", "")
+			added, conflicts, err := data.mergeFile(filename, resolveInteractively)
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Printf("Merged %s: %d added, %d conflicts resolved.\n", filename, added, conflicts)
+			}
+
+		case "diff":
+			oldFile := prompt("Old data file", "")
+			if err := data.displayDiff(oldFile, prompt("New data file", *dataFile)); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "setup":
+			chosen, err := runSetupWizard(data, *dataFile)
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+			*dataFile = chosen
+
+		case "export":
+			filename := prompt("Output filename (.xlsx for Excel)", "")
+			format := ""
+			if !strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+				format = strings.ToLower(prompt("Format (ledger/beancount)", "ledger"))
+			}
+			if err := data.exportFile(filename, format); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "anonymize-export":
+			filename := prompt("Output filename", "")
+			if err := data.anonymizeExport(filename); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "check":
+			if data.displayStaleWarnings(data.today()) == 0 {
+				fmt.Println("All accounts are up to date.")
+			}
+
+		case "report":
+			kind := prompt("Report (budget/networth/top)", "")
+			kind = strings.ToLower(kind)
+			if kind == "networth" {
+				if err := data.displayNetWorth(Month, false); err != nil {
+					fmt.Println("Error:", err)
 				}
-			case All:
-				periodValue = ""
-			default:
-				fmt.Println("Error: Invalid time period. Please use month, year, or all.")
 				break
 			}
-			data.displaySummary(period, periodValue)
+			month := prompt("Month (YYYY-MM)", "")
+			if _, err := time.Parse("2006-01", month); err != nil {
+				fmt.Println("Error: Invalid month format. Please use YYYY-MM.")
+				break
+			}
+			var err error
+			if kind == "top" {
+				err = data.displayTopReport(Month, month, 10)
+			} else {
+				err = data.displayBudgetReport(Month, month)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "alert":
+			data.displayAlerts()
+
+		case "webhook":
+			data.displayWebhooks()
+
+		case "envelopes":
+			if err := data.displayEnvelopes(data.today().Format("2006-01")); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "safetospend":
+			if err := data.displaySafeToSpend(); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "note":
+			category := prompt("Category", "")
+			err := data.setCategoryNote(category, prompt("Note (empty removes it)", ""))
+			if err == nil {
+				err = data.save(*dataFile)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			} else {
+				fmt.Println("Note saved.")
+			}
+
+		case "stats":
+			if err := data.displayStats(data.periodFilter(Month, data.today().Format("2006-01"))); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "list":
+			transactions, _ := data.sortedTransactions(Filter{}, "date", true)
+			if err := data.displayTransactions(transactions, 20, 0); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "anomalies":
+			if err := data.displayAnomalies(All, "", 3); err != nil {
+				fmt.Println("Error:", err)
+			}
 
 		case "predict":
-			var months int
-			fmt.Print("Prediction period (months): ")
-			fmt.Scanln(&months)
-			if months <= 0 {
+			months, err := strconv.Atoi(prompt("Prediction period (months)", ""))
+			if err != nil || months <= 0 {
 				fmt.Println("Error: Number of months must be greater than zero.")
 				break
 			}
-			data.displayPredictions(months)
+			options := defaultForecast
+			options.Months = months
+			if err := data.displayPredictions(options); err != nil {
+				fmt.Println("Error:", err)
+			}
 
 		case "help":
 			displayHelp()
@@ -0,0 +1,812 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the day synthetic books end on, so every run measures the same book
+var benchmarkToday = time.Date(2026, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+// book sizes the benchmarks run at: go test transaction.go transaction_test.go -bench . -benchmem
+var benchmarkSizes = []struct {
+	name string
+	n    int
+}{
+	{"10k", 10_000},
+	{"100k", 100_000},
+	{"1M", 1_000_000},
+}
+
+var syntheticBooks = make(map[int]*Data)
+
+// the synthetic book of n transactions up to benchmarkToday, built once per size
+func benchmarkBook(b *testing.B, n int) *Data {
+	b.Helper()
+	if d, ok := syntheticBooks[n]; ok {
+		return d
+	}
+	d, err := syntheticBook(n, benchmarkToday)
+	if err != nil {
+		b.Fatal(err)
+	}
+	syntheticBooks[n] = d
+	return d
+}
+
+// benchmark an operation profile also measures at every size
+func benchmarkOperation(b *testing.B, operation string) {
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			book := benchmarkBook(b, size.n)
+			csvFile := ""
+			if operation == "import" {
+				var content bytes.Buffer
+				if err := writeSyntheticCSV(&content, book); err != nil {
+					b.Fatal(err)
+				}
+				csvFile = filepath.Join(b.TempDir(), "book.csv")
+				if err := os.WriteFile(csvFile, content.Bytes(), 0o644); err != nil {
+					b.Fatal(err)
+				}
+			}
+			run, err := syntheticOperation(book, operation, csvFile)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for range b.N {
+				if err := run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkImport(b *testing.B)   { benchmarkOperation(b, "import") }
+func BenchmarkSummary(b *testing.B)  { benchmarkOperation(b, "summary") }
+func BenchmarkSearch(b *testing.B)   { benchmarkOperation(b, "search") }
+func BenchmarkForecast(b *testing.B) { benchmarkOperation(b, "forecast") }
+
+func BenchmarkPeriodQuery(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			d := benchmarkBook(b, size.n)
+			b.ResetTimer()
+			for i := range b.N {
+				from := benchmarkToday.AddDate(0, -(i % 60), 0)
+				for range d.Query(Filter{From: from, To: from.AddDate(0, 1, 0)}) {
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlexibleDate(t *testing.T) {
+	now := time.Date(2026, time.March, 11, 15, 30, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		input string
+		want  string // YYYY-MM-DD, empty when the input must be refused
+	}{
+		{"2026-03-01", "2026-03-01"},
+		{"today", "2026-03-11"},
+		{"yesterday", "2026-03-10"},
+		{"tomorrow", "2026-03-12"},
+		{"3 days ago", "2026-03-08"},
+		{"a week ago", "2026-03-04"},
+		{"1 month ago", "2026-02-11"},
+		{"2 years ago", "2024-03-11"},
+		{"wednesday", "2026-03-11"},
+		{"friday", "2026-03-06"},
+		{"last wednesday", "2026-03-04"},
+		{"next monday", "2026-03-16"},
+
+		// month names never land in the future without a year
+		{"mar 5", "2026-03-05"},
+		{"5 march", "2026-03-05"},
+		{"March 5th, 2024", "2024-03-05"},
+		{"dec 25", "2025-12-25"},
+		{"feb 29", "2024-02-29"},
+		{"feb 30", ""},
+
+		// two-digit years
+		{"mar 5 24", "2024-03-05"},
+		{"mar 5 99", "1999-03-05"},
+		{"mar 5 123", ""},
+		{"13/02/24", "2024-02-13"},
+		{"2/13/24", "2024-02-13"},
+
+		// numeric dates are read whichever way makes a date
+		{"13/02", "2026-02-13"},
+		{"02/13", "2026-02-13"},
+		{"25/12", "2025-12-25"},
+		{"5/5", "2025-05-05"},
+		{"2024/03/05", "2024-03-05"},
+		{"31/02", ""},
+		{"13/13", ""},
+		{"someday", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		date, err := ParseFlexibleDate(test.input, now)
+		if test.want == "" {
+			if !errors.Is(err, ErrInvalidDate) {
+				t.Errorf("ParseFlexibleDate(%q) = %v, %v; want ErrInvalidDate", test.input, date, err)
+			}
+			continue
+		}
+		if err != nil || date.Format("2006-01-02") != test.want {
+			t.Errorf("ParseFlexibleDate(%q) = %v, %v; want %s", test.input, date.Format("2006-01-02"), err, test.want)
+		}
+	}
+}
+
+func TestParseFlexibleDateAmbiguous(t *testing.T) {
+	now := time.Date(2026, time.March, 11, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input    string
+		readings []string
+	}{
+		{"01/02", []string{"Feb 1 2026", "Jan 2 2026"}},
+		{"02/01", []string{"Jan 2 2026", "Feb 1 2026"}},
+		{"01/02/24", []string{"Feb 1 2024", "Jan 2 2024"}},
+		{"3/5", []string{"May 3 2025", "Mar 5 2026"}},
+	}
+	for _, test := range tests {
+		_, err := ParseFlexibleDate(test.input, now)
+		if !errors.Is(err, ErrInvalidDate) || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("ParseFlexibleDate(%q) error = %v, want it refused as ambiguous", test.input, err)
+			continue
+		}
+		for _, reading := range test.readings {
+			if !strings.Contains(err.Error(), reading) {
+				t.Errorf("ParseFlexibleDate(%q) error = %v, want it to offer %s", test.input, err, reading)
+			}
+		}
+	}
+}
+
+func TestDateIndexFollowsEdits(t *testing.T) {
+	d := &Data{clock: NewFakeClock(benchmarkToday)}
+	for day := 1; day <= 3; day++ {
+		if err := d.appendTransaction(Transaction{Date: time.Date(2026, time.May, day, 0, 0, 0, 0, time.UTC), Type: Expense, Category: "Food", Amount: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	june := Filter{From: time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC)}
+	count := func() int {
+		n := 0
+		for range d.Query(june) {
+			n++
+		}
+		return n
+	}
+	_, expensesBefore, _, _ := d.summarize(june)
+	if n := count(); n != 0 || expensesBefore != 0 {
+		t.Fatalf("before the edit June has %d transactions and %.2f expenses, want none", n, expensesBefore)
+	}
+	transaction, err := d.findTransaction(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transaction.Date = time.Date(2026, time.June, 15, 0, 0, 0, 0, time.UTC)
+	d.edited()
+	if _, expenses, _, _ := d.summarize(june); count() != 1 || expenses != 10 {
+		t.Errorf("after moving a transaction into June it has %d transactions and %.2f expenses, want 1 and 10.00", count(), expenses)
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	history := []string{"coffee 3", "tea 2", "cookies 5", "cola 1"}
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"co\r", "cola 1"},
+		{"coo\r", "cookies 5"},
+		{"co\x12\r", "cookies 5"},
+		{"co\x12\x12\r", "coffee 3"},
+		{"co\x12\x12\x12\r", "coffee 3"}, // the oldest match stays once there is nothing older
+		{"\x12\r", "cola 1"},
+		{"cof\x7fo\r", "cookies 5"},
+		{"xyz\r", "original"},
+	}
+	defer func(saved *bufio.Reader) { stdin = saved }(stdin)
+	for _, test := range tests {
+		stdin = bufio.NewReader(strings.NewReader(test.keys))
+		if got, _ := searchHistory(history, "original"); got != test.want {
+			t.Errorf("searchHistory after %q = %q, want %q", test.keys, got, test.want)
+		}
+	}
+}
+
+func TestForeignCurrencyLeftOutOfTotals(t *testing.T) {
+	d := &Data{Currency: "USD", Accounts: []Account{{Name: "Cash", Kind: Asset, OpeningBalance: 100}}, clock: NewFakeClock(benchmarkToday)}
+	for _, amount := range []string{"20", "EUR15"} {
+		transaction, err := d.parseQuickAdd([]string{amount, "Food"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transaction.Account = "Cash"
+		if err := d.appendTransaction(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	month := d.periodFilter(Month, benchmarkToday.Format("2006-01"))
+	if _, expenses, _, categories := d.summarize(month); expenses != 20 || categories["Food"] != 20 {
+		t.Errorf("month expenses = %.2f with Food %.2f, want 20.00 without the EUR expense", expenses, categories["Food"])
+	}
+	if _, expenses, _, _ := d.summarize(Filter{Category: "Food"}); expenses != 20 {
+		t.Errorf("Food expenses = %.2f, want 20.00 without the EUR expense", expenses)
+	}
+	if balance := d.runningBalances()[2]; balance != 80 {
+		t.Errorf("Cash balance after the EUR expense = %.2f, want it unchanged at 80.00", balance)
+	}
+}
+
+func TestQuickAddReportsTheAmountTyped(t *testing.T) {
+	d := &Data{clock: NewFakeClock(benchmarkToday)}
+	for _, args := range [][]string{{"-5"}, {"-5", "Food"}, {"abc", "Food"}} {
+		if _, err := d.parseQuickAdd(args); !errors.Is(err, ErrInvalidAmount) || !strings.Contains(err.Error(), strings.TrimPrefix(args[0], "-")) {
+			t.Errorf("parseQuickAdd(%q) error = %v, want an invalid amount naming %s", args, err, args[0])
+		}
+	}
+}
+
+func TestQuickAddDollarSign(t *testing.T) {
+	tests := []struct {
+		book     string
+		currency string // kept with the transaction, empty for the book's own
+		refused  bool
+	}{
+		{"USD", "", false},
+		{"CAD", "", false},
+		{"", "", true},
+		{"EUR", "", true},
+	}
+	for _, tt := range tests {
+		d := &Data{Currency: tt.book, clock: NewFakeClock(benchmarkToday)}
+		transaction, err := d.parseQuickAdd([]string{"$45", "Food"})
+		if tt.refused {
+			if !errors.Is(err, ErrInvalidAmount) || !strings.Contains(err.Error(), "USD45") {
+				t.Errorf("book in %q: $45 gave %v, want it refused asking for the currency", tt.book, err)
+			}
+			continue
+		}
+		if err != nil || transaction.Amount != 45 || transaction.Currency != tt.currency {
+			t.Errorf("book in %q: $45 gave %.2f %q, %v, want 45.00 in the book's currency", tt.book, transaction.Amount, transaction.Currency, err)
+		}
+	}
+	d := &Data{Currency: "EUR", clock: NewFakeClock(benchmarkToday)}
+	if transaction, err := d.parseQuickAdd([]string{"US$45", "Food"}); err != nil || transaction.Currency != "USD" {
+		t.Errorf("US$45 on a book in EUR gave %q, %v, want it kept in USD", transaction.Currency, err)
+	}
+}
+
+func TestIMAPRefusesCleartextLogin(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "* OK IMAP4rev1 ready\r\n")
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+			tag, _, _ := strings.Cut(scanner.Text(), " ")
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+		received <- lines
+	}()
+	connector, err := newIMAPConnector(BankConnection{Environment: "imap://me@" + listener.Addr().String() + "/Receipts", AccessToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connector.Fetch(""); err == nil || !strings.Contains(err.Error(), "imaps://") {
+		t.Errorf("Fetch without STARTTLS error = %v, want it refused with a pointer to imaps://", err)
+	}
+	for _, line := range <-received {
+		if strings.Contains(line, "secret") {
+			t.Errorf("the password went out in the clear: %q", line)
+		}
+	}
+}
+
+func TestBillsDueFollowTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.February, 25, 9, 0, 0, 0, time.UTC))
+	d := &Data{Settings: Settings{Timezone: "UTC"}, clock: clock}
+	if err := d.addRecurring(Recurring{Name: "Rent", Type: Expense, Category: "Housing", Amount: 900, Every: Month,
+		Start: time.Date(2026, time.January, 31, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+	due := func() []string {
+		var dates []string
+		today := d.today()
+		for _, b := range d.upcomingBills(today.AddDate(0, 0, -billsLate), today.AddDate(0, 0, 4)) {
+			dates = append(dates, b.Due.Format("2006-01-02"))
+		}
+		return dates
+	}
+	steps := []struct {
+		advance time.Duration
+		pay     bool // book the rent due before checking
+		want    []string
+	}{
+		{0, false, []string{"2026-02-28"}}, // the 31st falls on the last day of February
+		{5 * 24 * time.Hour, false, []string{"2026-02-28"}},
+		{0, true, nil},
+		{21 * 24 * time.Hour, false, nil},
+		{5 * 24 * time.Hour, false, []string{"2026-03-31"}},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if step.pay {
+			if err := d.appendTransaction(Transaction{Date: d.today(), Type: Expense, Category: "Housing", Amount: 900}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := due(); !slices.Equal(got, step.want) {
+			t.Errorf("step %d on %s: bills due %v, want %v", i, d.today().Format("2006-01-02"), got, step.want)
+		}
+	}
+}
+
+func TestRolloverFollowsTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.January, 15, 9, 0, 0, 0, time.UTC))
+	d := &Data{Budgets: map[string]float64{"Food": 100}, Settings: Settings{Timezone: "UTC"}, clock: clock}
+	if err := d.setRollover("Food", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		spend    float64 // spent in the month before looking at the next
+		rollover float64 // carried into the next month
+	}{
+		{60, 40},
+		{150, -10},
+		{0, 90},
+	} {
+		if err := d.appendTransaction(Transaction{Date: d.today(), Type: Expense, Category: "Food", Amount: step.spend}); err != nil {
+			t.Fatal(err)
+		}
+		next := d.today().AddDate(0, 1, 0)
+		clock.Set(next.Add(9 * time.Hour))
+		month := d.today().Format("2006-01")
+		lines, _, err := d.calculateBudgetReport(Month, month)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := slices.IndexFunc(lines, func(line budgetLine) bool { return line.Category == "Food" })
+		if i < 0 || lines[i].Rollover != step.rollover || lines[i].Available != 100+step.rollover {
+			t.Errorf("%s: Food lines %+v, want %.2f carried over", month, lines, step.rollover)
+		}
+	}
+	if since := d.Rollover["Food"]; since != "2026-01" {
+		t.Errorf("rollover started in %s, want the month the clock stood in when it was turned on, 2026-01", since)
+	}
+}
+
+func TestUnbudgetedSpendingStaysOutOfTheTotal(t *testing.T) {
+	d := &Data{Budgets: map[string]float64{"Food": 100}, Settings: Settings{Timezone: "UTC"}, clock: NewFakeClock(benchmarkToday)}
+	for _, spend := range []Transaction{{Category: "Food", Amount: 80}, {Category: "Gifts", Amount: 40}} {
+		spend.Date, spend.Type = d.today(), Expense
+		if err := d.appendTransaction(spend); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines, total, err := d.calculateBudgetReport(Month, benchmarkToday.Format("2006-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if want := line.Category == "Gifts"; line.Unbudgeted != want || line.over() {
+			t.Errorf("%s: unbudgeted %v, over %v, want unbudgeted %v and never over", line.Category, line.Unbudgeted, line.over(), want)
+		}
+	}
+	if total.Actual != 80 || total.Variance != 20 {
+		t.Errorf("total actual %.2f, variance %.2f, want 80.00 and 20.00 without the Gifts spending", total.Actual, total.Variance)
+	}
+}
+
+func TestParseCurrencyCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // empty when the input must be refused
+	}{
+		{"USD", "USD"},
+		{"eur", "EUR"},
+		{" inr ", "INR"},
+		{"ADD", ""},
+		{"US", ""},
+		{"EURO", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		code, err := parseCurrencyCode(test.input)
+		if test.want == "" && err == nil || test.want != "" && code != test.want {
+			t.Errorf("parseCurrencyCode(%q) = %q, %v; want %q", test.input, code, err, test.want)
+		}
+	}
+}
+
+func TestSeasonalForecastKeepsItsBand(t *testing.T) {
+	d, err := syntheticBook(3000, benchmarkToday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := defaultForecast
+	options.Seasonal = true
+	result, err := d.calculateForecast(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range result.Months {
+		if m.Expenses.Low >= m.Expenses.High || m.Income.Low >= m.Income.High {
+			t.Errorf("%s: expenses %+v and income %+v have no spread", m.Month, m.Expenses, m.Income)
+		}
+	}
+
+	young := &Data{clock: NewFakeClock(benchmarkToday)}
+	for _, transaction := range GenerateDemo(DemoOptions{Months: 13, PerMonth: 20, Seed: 1, End: benchmarkToday}) {
+		if err := young.appendTransaction(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := young.calculateForecast(options); err == nil {
+		t.Error("a seasonal forecast from a year of history succeeded, want it refused")
+	}
+}
+
+func TestOutboxRetriesPerReceiver(t *testing.T) {
+	start := time.Now()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(3 * time.Second)
+	}))
+	defer slow.Close()
+	var mu sync.Mutex
+	var deliveries []string
+	var delivered time.Duration
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, r.Header.Get("X-Finance-Delivery"))
+		if len(deliveries) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered = time.Since(start)
+	}))
+	defer flaky.Close()
+
+	o := &outbox{}
+	o.enqueue(Webhook{URL: slow.URL}, webhookEvent{Event: "transaction.created"})
+	o.enqueue(Webhook{URL: flaky.URL}, webhookEvent{Event: "transaction.created"})
+	o.flush(10 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 2 || deliveries[0] != deliveries[1] {
+		t.Fatalf("the failing receiver got deliveries %q, want the event retried once under the same delivery ID", deliveries)
+	}
+	if delivered >= 3*time.Second {
+		t.Errorf("the retried event went out after %v, want it not to wait for the slow receiver", delivered)
+	}
+}
+
+// a server over d kept in memory, with the maps serve sets up
+func testServer(d *Data) *server {
+	return &server{
+		shared:   ledger{load: func() (*Data, error) { return d, nil }, save: func(*Data) error { return nil }},
+		verified: make(map[[32]byte]*apiUser), rejected: make(map[[32]byte]time.Time), failures: make(map[string][]time.Time),
+	}
+}
+
+func TestGRPCListStreamsNewestFirst(t *testing.T) {
+	d := &Data{Currency: "GBP", clock: NewFakeClock(benchmarkToday)}
+	for i, transaction := range []Transaction{{Amount: 10}, {Amount: 20, Currency: "EUR"}, {Amount: 30}} {
+		transaction.Date, transaction.Type, transaction.Category = benchmarkToday.AddDate(0, 0, []int{-2, 0, -1}[i]), Expense, "Food"
+		if err := d.appendTransaction(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := httptest.NewUnstartedServer(testServer(d).routes())
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{Protocols: ts.Config.Protocols}}
+	response, err := client.Post(ts.URL+"/finance.v1.Finance/ListTransactions", "application/grpc", bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := response.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("grpc-status %q: %s", status, response.Trailer.Get("Grpc-Message"))
+	}
+	var got []string
+	for len(body) >= 5 {
+		size := 5 + int(binary.BigEndian.Uint32(body[1:5]))
+		fields, err := protoFields(body[5:size])
+		if err != nil {
+			t.Fatal(err)
+		}
+		transaction, _ := decodeTransactionProto(body[5:size])
+		currency := ""
+		for _, field := range fields {
+			if field.number == 9 {
+				currency = string(field.bytes)
+			}
+		}
+		got = append(got, fmt.Sprintf("%s %.0f %s", transaction.Date.Format("01-02"), transaction.Amount, currency))
+		body = body[size:]
+	}
+	if want := []string{"06-30 20 EUR", "06-29 30 GBP", "06-28 10 GBP"}; !slices.Equal(got, want) {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+}
+
+func TestFileStorageSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "finance.json")
+	storage := fileStorage{path: path}
+	for _, content := range []string{`{"transactions":[]}`, `{"transactions":[{"id":1}]}`, ``} {
+		if err := storage.Save([]byte(content), "test"); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := storage.Load()
+		if err != nil || string(loaded) != content {
+			t.Errorf("saved %q, loaded %q, %v", content, loaded, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o644 {
+			t.Errorf("saved book: %v, %v, want it readable with mode 0644", info, err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("the directory holds %d files, want the book without temporary files", len(entries))
+	}
+	if err := (fileStorage{path: filepath.Join(path, "below-a-file.json")}).Save([]byte("{}"), "test"); err == nil {
+		t.Error("saving below a file succeeded, want an error")
+	}
+}
+
+func TestCheckFooter(t *testing.T) {
+	book := `{"transactions":[{"id":1,"amount":12.5}]}`
+	saved := string(withFooter([]byte(book)))
+	footer := saved[len(book):]
+	tests := []struct {
+		name    string
+		content string
+		want    string // the book that is read, empty when it is refused
+		corrupt bool
+	}{
+		{"no footer", book, book, false},
+		{"intact", saved, book, false},
+		{"crlf after the footer", saved + "\r\n", book, false},
+		{"edited by hand", `{"transactions":[]}` + footer, `{"transactions":[]}`, false},
+		{"cut short", book[:20] + footer, "", true},
+		{"garbled", strings.Replace(book, "}", "]", 1) + footer, "", true},
+		{"unreadable footer", book + "\n" + footerPrefix + "many\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkFooter([]byte(tt.content))
+			if errors.Is(err, ErrCorrupt) != tt.corrupt || string(got) != tt.want {
+				t.Errorf("checkFooter = %q, %v, want %q, corrupt %v", got, err, tt.want, tt.corrupt)
+			}
+		})
+	}
+}
+
+func TestLoadDataFallsBackToBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(path string) error
+		backup  bool
+		want    int // transactions loaded
+		corrupt bool
+	}{
+		{"missing", os.Remove, false, 0, false},
+		{"intact", func(string) error { return nil }, true, 2, false},
+		{"cut short with a backup", truncateHalf, true, 1, false},
+		{"cut short without a backup", truncateHalf, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "finance.json")
+			d := &Data{clock: NewFakeClock(benchmarkToday)}
+			for i, amount := range []float64{10, 20} {
+				if err := d.appendTransaction(Transaction{Date: benchmarkToday, Type: Expense, Category: "Food", Amount: amount}); err != nil {
+					t.Fatal(err)
+				}
+				if err := d.save(path); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 && tt.backup {
+					if _, err := d.backup(path, "", false, 0); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := tt.damage(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := loadData(path)
+			if errors.Is(err, ErrCorrupt) != tt.corrupt {
+				t.Fatalf("loadData error = %v, want corrupt %v", err, tt.corrupt)
+			}
+			if err == nil && len(loaded.Transactions) != tt.want {
+				t.Errorf("loaded %d transactions, want %d", len(loaded.Transactions), tt.want)
+			}
+			if _, err := os.Stat(path + ".damaged"); (err == nil) != (tt.backup && tt.want == 1) {
+				t.Errorf("damaged copy: %v, want one only when a backup was loaded instead", err)
+			}
+		})
+	}
+}
+
+func truncateHalf(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, info.Size()/2)
+}
+
+func TestLockDataFile(t *testing.T) {
+	host, _ := os.Hostname()
+	holder := func(pid int, host string) string {
+		content, _ := json.Marshal(lockHolder{PID: pid, Host: host, Since: benchmarkToday})
+		return string(content)
+	}
+	tests := []struct {
+		name   string
+		held   string // content of a lock file found in place, none when empty
+		locked bool
+	}{
+		{"free", "", false},
+		{"held by this process", holder(os.Getpid(), host), true},
+		{"held on another host", holder(1, "elsewhere.example"), true},
+		{"left by a process that is gone", holder(1<<30, host), false},
+		{"unreadable", "{", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "finance.json")
+			if tt.held != "" {
+				if err := os.WriteFile(path+".lock", []byte(tt.held), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lock, err := lockDataFile(path)
+			if errors.Is(err, ErrLocked) != tt.locked || err != nil && !tt.locked {
+				t.Fatalf("lockDataFile error = %v, want locked %v", err, tt.locked)
+			}
+			if tt.locked {
+				return
+			}
+			if _, err := lockDataFile(path); !errors.Is(err, ErrLocked) {
+				t.Errorf("locking again: %v, want ErrLocked while the lock is held", err)
+			}
+			lock.release()
+			if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("lock file after release: %v, want it removed", err)
+			}
+		})
+	}
+}
+
+func TestServerAuthentication(t *testing.T) {
+	users, alice, err := addUser(nil, "alice", roleEditor, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, bob, err := addUser(users, "bob", roleViewer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testServer(&Data{clock: NewFakeClock(benchmarkToday)})
+	s.token, s.users = "shared-token", users
+	handler := s.routes()
+	add := `{"date":"2026-06-30T00:00:00Z","type":"Expense","category":"Food","amount":5}`
+	tests := []struct {
+		name         string
+		method       string
+		user, secret string // basic credentials with a user, a bearer token without
+		want         int
+	}{
+		{"no credentials", "GET", "", "", http.StatusUnauthorized},
+		{"shared token", "GET", "", "shared-token", http.StatusOK},
+		{"wrong token", "GET", "", "guess", http.StatusUnauthorized},
+		{"user's token as bearer", "GET", "", alice, http.StatusOK},
+		{"user", "GET", "alice", alice, http.StatusOK},
+		{"another user's token", "GET", "bob", alice, http.StatusUnauthorized},
+		{"editor adds", "POST", "alice", alice, http.StatusCreated},
+		{"viewer reads", "GET", "bob", bob, http.StatusOK},
+		{"viewer adds", "POST", "bob", bob, http.StatusForbidden},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request
+			if tt.method == "POST" {
+				r = httptest.NewRequest("POST", "/api/transactions", strings.NewReader(add))
+				r.Header.Set("Content-Type", "application/json")
+			} else {
+				r = httptest.NewRequest("GET", "/api/summary", nil)
+			}
+			r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+			switch {
+			case tt.user != "":
+				r.SetBasicAuth(tt.user, tt.secret)
+			case tt.secret != "":
+				r.Header.Set("Authorization", "Bearer "+tt.secret)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestServerThrottlesFailedLogins(t *testing.T) {
+	s := testServer(&Data{clock: NewFakeClock(benchmarkToday)})
+	s.token = "shared-token"
+	handler := s.routes()
+	request := func(address, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/summary", nil)
+		r.RemoteAddr = address + ":1234"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for range 2 * authAttempts { // asking without credentials is not a failed attempt
+		request("192.0.2.1", "")
+	}
+	if w := request("192.0.2.1", "shared-token"); w.Code != http.StatusOK {
+		t.Fatalf("after requests without credentials: status %d, want 200", w.Code)
+	}
+	for i := range authAttempts {
+		if w := request("192.0.2.1", fmt.Sprintf("guess-%d", i)); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d, want 401", i, w.Code)
+		}
+	}
+	if w := request("192.0.2.1", "shared-token"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("after %d failures: status %d, Retry-After %q, want 429 with a Retry-After", authAttempts, w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := request("192.0.2.2", "shared-token"); w.Code != http.StatusOK {
+		t.Errorf("another address: status %d, want 200", w.Code)
+	}
+	if len(s.rejected) != authAttempts {
+		t.Errorf("%d rejected credentials remembered, want %d", len(s.rejected), authAttempts)
+	}
+}